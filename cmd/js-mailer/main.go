@@ -56,7 +56,11 @@ func main() {
 	log := logger.New(conf.Log.Level, logger.Opts{Format: conf.Log.Format, DontLogIP: conf.Log.DontLogIP})
 
 	// Initialize server instance
-	srv := server.New(conf, log, version)
+	srv, err := server.New(conf, log, version)
+	if err != nil {
+		log.Error("failed to initialize server", logger.Err(err))
+		os.Exit(1)
+	}
 
 	// Start server
 	log.Info("starting js-mailer service", slog.String("version", version),
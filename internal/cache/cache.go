@@ -15,6 +15,9 @@ type Cache interface {
 	Set(string, *forms.Form, ItemParams) error
 	Get(string) (*forms.Form, ItemParams, error)
 	Remove(string) error
+	// SetNX stores the key for the given duration, unless the key is already present. It returns
+	// true if the key was stored.
+	SetNX(string, time.Duration) (bool, error)
 	Stop()
 }
 
@@ -23,4 +26,7 @@ type ItemParams struct {
 	TokenExpiresAt   time.Time
 	RandomFieldName  string
 	RandomFieldValue string
+	AltchaChallenge  string
+	ClientIP         string
+	UserAgent        string
 }
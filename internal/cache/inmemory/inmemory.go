@@ -22,6 +22,7 @@ type item struct {
 type InMemory struct {
 	mu    sync.RWMutex
 	items map[string]*item
+	keys  map[string]time.Time
 	ttl   time.Duration
 	stop  chan struct{}
 }
@@ -35,6 +36,7 @@ var (
 func New(cleanupInterval time.Duration) *InMemory {
 	return &InMemory{
 		items: make(map[string]*item),
+		keys:  make(map[string]time.Time),
 		ttl:   cleanupInterval,
 		stop:  make(chan struct{}),
 	}
@@ -44,13 +46,18 @@ func (i *InMemory) Start() {
 	go i.cleanupLoop(i.ttl)
 }
 
-// Set stores a value without TTL.
+// Set stores a value. The item expires with the token or, if the token has no expiration time,
+// after the cache lifetime.
 func (i *InMemory) Set(key string, form *forms.Form, params cache.ItemParams) error {
+	expiration := params.TokenExpiresAt
+	if expiration.IsZero() {
+		expiration = time.Now().Add(i.ttl)
+	}
 	i.mu.Lock()
 	i.items[key] = &item{
 		form:       form,
 		params:     params,
-		expiration: time.Now().Add(i.ttl),
+		expiration: expiration,
 	}
 	i.mu.Unlock()
 	return nil
@@ -79,10 +86,24 @@ func (i *InMemory) Get(key string) (*forms.Form, cache.ItemParams, error) {
 func (i *InMemory) Remove(key string) error {
 	i.mu.Lock()
 	delete(i.items, key)
+	delete(i.keys, key)
 	i.mu.Unlock()
 	return nil
 }
 
+// SetNX stores the key for the given duration, unless the key is already present and not expired.
+func (i *InMemory) SetNX(key string, ttl time.Duration) (bool, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := time.Now()
+	if expiration, ok := i.keys[key]; ok && now.Before(expiration) {
+		return false, nil
+	}
+	i.keys[key] = now.Add(ttl)
+	return true, nil
+}
+
 // Stop shuts down the cleanup goroutine.
 func (i *InMemory) Stop() {
 	close(i.stop)
@@ -102,6 +123,11 @@ func (i *InMemory) cleanupLoop(interval time.Duration) {
 					delete(i.items, k)
 				}
 			}
+			for k, expiration := range i.keys {
+				if now.After(expiration) {
+					delete(i.keys, k)
+				}
+			}
 			i.mu.Unlock()
 
 		case <-i.stop:
@@ -5,6 +5,7 @@
 package inmemory
 
 import (
+	"errors"
 	"testing"
 	"testing/synctest"
 	"time"
@@ -32,6 +33,9 @@ func TestNew(t *testing.T) {
 		if inmem.items == nil {
 			t.Error("expected items to be non-nil")
 		}
+		if inmem.keys == nil {
+			t.Error("expected keys to be non-nil")
+		}
 	})
 }
 
@@ -124,6 +128,36 @@ func TestCache_Get(t *testing.T) {
 			}
 		})
 	})
+	t.Run("in-memory cache item expires with the token", func(t *testing.T) {
+		inmem := New(time.Hour)
+		if err := inmem.Set("key", testForm, cache.ItemParams{
+			TokenCreatedAt: time.Now().Add(-time.Minute),
+			TokenExpiresAt: time.Now().Add(-time.Second),
+		}); err != nil {
+			t.Errorf("failed to set item in in-memory cache: %s", err)
+		}
+		_, _, err := inmem.Get("key")
+		if !errors.Is(err, ErrItemExpired) {
+			t.Errorf("expected error to be %s, got: %s", ErrItemExpired, err)
+		}
+	})
+	t.Run("in-memory cache item outlives the cache lifetime with the token", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			inmem := New(time.Second)
+			inmem.Stop()
+			if err := inmem.Set("key", testForm, cache.ItemParams{
+				TokenCreatedAt: time.Now(),
+				TokenExpiresAt: time.Now().Add(time.Minute),
+			}); err != nil {
+				t.Errorf("failed to set item in in-memory cache: %s", err)
+			}
+			time.Sleep(time.Second * 2)
+			synctest.Wait()
+			if _, _, err := inmem.Get("key"); err != nil {
+				t.Errorf("expected item to be valid until the token expires, got: %s", err)
+			}
+		})
+	})
 }
 
 func TestCache_Remove(t *testing.T) {
@@ -150,6 +184,50 @@ func TestCache_Remove(t *testing.T) {
 	})
 }
 
+func TestCache_SetNX(t *testing.T) {
+	t.Run("set a key only once", func(t *testing.T) {
+		inmem := New(time.Minute)
+		stored, err := inmem.SetNX("key", time.Minute)
+		if err != nil {
+			t.Fatalf("failed to set key in in-memory cache: %s", err)
+		}
+		if !stored {
+			t.Error("expected key to be stored")
+		}
+		stored, err = inmem.SetNX("key", time.Minute)
+		if err != nil {
+			t.Fatalf("failed to set key in in-memory cache: %s", err)
+		}
+		if stored {
+			t.Error("expected key not to be stored twice")
+		}
+	})
+	t.Run("expired key is stored again", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			inmem := New(time.Minute)
+			if stored, _ := inmem.SetNX("key", time.Second); !stored {
+				t.Fatal("expected key to be stored")
+			}
+			time.Sleep(time.Second * 2)
+			if stored, _ := inmem.SetNX("key", time.Second); !stored {
+				t.Error("expected expired key to be stored again")
+			}
+		})
+	})
+	t.Run("removed key is stored again", func(t *testing.T) {
+		inmem := New(time.Minute)
+		if stored, _ := inmem.SetNX("key", time.Minute); !stored {
+			t.Fatal("expected key to be stored")
+		}
+		if err := inmem.Remove("key"); err != nil {
+			t.Fatalf("failed to remove key from in-memory cache: %s", err)
+		}
+		if stored, _ := inmem.SetNX("key", time.Minute); !stored {
+			t.Error("expected removed key to be stored again")
+		}
+	})
+}
+
 func TestCache_cleanupLoop(t *testing.T) {
 	synctest.Test(t, func(t *testing.T) {
 		interval := time.Second
@@ -170,4 +248,22 @@ func TestCache_cleanupLoop(t *testing.T) {
 			t.Error("item was expected to be expired")
 		}
 	})
+	synctest.Test(t, func(t *testing.T) {
+		interval := time.Second
+		inmem := New(interval)
+		inmem.Start()
+		t.Cleanup(inmem.Stop)
+
+		if _, err := inmem.SetNX("key", interval); err != nil {
+			t.Errorf("failed to set key in in-memory cache: %s", err)
+		}
+		time.Sleep(interval * 2)
+		synctest.Wait()
+		inmem.mu.RLock()
+		_, ok := inmem.keys["key"]
+		inmem.mu.RUnlock()
+		if ok {
+			t.Error("key was expected to be removed")
+		}
+	})
 }
@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+// Package clamav implements a client for the clamd INSTREAM protocol
+package clamav
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTimeout is the default timeout for a clamd scan
+	DefaultTimeout = time.Second * 30
+
+	// chunkSize is the maximum size of a chunk that is streamed to clamd
+	chunkSize = 32 << 10
+)
+
+var (
+	// ErrUnavailable is returned if clamd could not be reached or the connection failed or timed out
+	ErrUnavailable = errors.New("clamd is unavailable")
+
+	// ErrScanFailed is returned if clamd responded with an error or an invalid response
+	ErrScanFailed = errors.New("clamd scan failed")
+)
+
+// Client is a clamd client
+type Client struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// Result is the result of a clamd scan
+type Result struct {
+	Infected  bool
+	Signature string
+}
+
+// New returns a new clamd client for the given network ("tcp" or "unix") and address
+func New(network, address string, timeout time.Duration) *Client {
+	if network == "" {
+		network = "tcp"
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Client{network: network, address: address, timeout: timeout}
+}
+
+// Scan streams the content of the reader to clamd using the INSTREAM command and returns the
+// scan result.
+func (c *Client) Scan(ctx context.Context, reader io.Reader) (*Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			return nil, fmt.Errorf("failed to set connection deadline: %w", err)
+		}
+	}
+
+	if _, err = io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return nil, fmt.Errorf("%w: failed to send command: %w", ErrUnavailable, err)
+	}
+	if err = writeChunks(conn, reader); err != nil {
+		if !errors.Is(err, ErrUnavailable) {
+			return nil, err
+		}
+		// clamd answers with an error and closes the connection if it rejects the stream, e.g.
+		// because the size limit is exceeded
+		if response, readErr := readResponse(conn); readErr == nil && response != "" {
+			if _, parseErr := parseResponse(response); parseErr != nil {
+				return nil, parseErr
+			}
+		}
+		return nil, err
+	}
+
+	response, err := readResponse(conn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read response: %w", ErrUnavailable, err)
+	}
+	if response == "" {
+		return nil, fmt.Errorf("%w: connection closed without response", ErrUnavailable)
+	}
+	return parseResponse(response)
+}
+
+// readResponse reads the null-terminated response of clamd
+func readResponse(conn net.Conn) (string, error) {
+	response, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimRight(response, "\x00\n"), nil
+}
+
+// writeChunks streams the content of the reader as length-prefixed chunks, terminated by a zero
+// length chunk.
+func writeChunks(conn net.Conn, reader io.Reader) error {
+	buf := make([]byte, chunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n)) // #nosec G115 -- n is at most chunkSize
+			if _, writeErr := conn.Write(size); writeErr != nil {
+				return fmt.Errorf("%w: failed to send chunk: %w", ErrUnavailable, writeErr)
+			}
+			if _, writeErr := conn.Write(buf[:n]); writeErr != nil {
+				return fmt.Errorf("%w: failed to send chunk: %w", ErrUnavailable, writeErr)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read content: %w", err)
+		}
+	}
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return fmt.Errorf("%w: failed to send final chunk: %w", ErrUnavailable, err)
+	}
+	return nil
+}
+
+// parseResponse parses the clamd response, e.g. "stream: OK" or "stream: Eicar-Signature FOUND"
+func parseResponse(response string) (*Result, error) {
+	_, status, found := strings.Cut(response, ": ")
+	if !found {
+		return nil, fmt.Errorf("%w: invalid response %q", ErrScanFailed, response)
+	}
+	switch {
+	case status == "OK":
+		return &Result{}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return &Result{Infected: true, Signature: strings.TrimSuffix(status, " FOUND")}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrScanFailed, status)
+	}
+}
@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package clamav
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wneessen/js-mailer/internal/testhelper"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("new sets defaults", func(t *testing.T) {
+		client := New("", "127.0.0.1:3310", 0)
+		if client.network != "tcp" {
+			t.Errorf("expected network to be tcp, got %s", client.network)
+		}
+		if client.timeout != DefaultTimeout {
+			t.Errorf("expected timeout to be %s, got %s", DefaultTimeout, client.timeout)
+		}
+	})
+}
+
+func TestClient_Scan(t *testing.T) {
+	t.Run("clean content via TCP", func(t *testing.T) {
+		addr := testClamd(t, "tcp", "127.0.0.1:0")
+		result, err := New("tcp", addr, time.Second).Scan(t.Context(), strings.NewReader("clean content"))
+		if err != nil {
+			t.Fatalf("failed to scan content: %s", err)
+		}
+		if result.Infected {
+			t.Error("expected content not to be infected")
+		}
+	})
+	t.Run("infected content via unix socket", func(t *testing.T) {
+		addr := testClamd(t, "unix", filepath.Join(t.TempDir(), "clamd.sock"))
+		result, err := New("unix", addr, time.Second).Scan(t.Context(), strings.NewReader("prefix "+testhelper.EICAR))
+		if err != nil {
+			t.Fatalf("failed to scan content: %s", err)
+		}
+		if !result.Infected {
+			t.Error("expected content to be infected")
+		}
+		if result.Signature != "Eicar-Test-Signature" {
+			t.Errorf("expected signature to be Eicar-Test-Signature, got %s", result.Signature)
+		}
+	})
+	t.Run("content larger than a chunk", func(t *testing.T) {
+		addr := testClamd(t, "tcp", "127.0.0.1:0")
+		content := bytes.Repeat([]byte("a"), chunkSize*3+17)
+		content = append(content, testhelper.EICAR...)
+		result, err := New("tcp", addr, time.Second).Scan(t.Context(), bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("failed to scan content: %s", err)
+		}
+		if !result.Infected {
+			t.Error("expected content to be infected")
+		}
+	})
+	t.Run("clamd is unavailable", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %s", err)
+		}
+		addr := listener.Addr().String()
+		_ = listener.Close()
+		_, err = New("tcp", addr, time.Second).Scan(t.Context(), strings.NewReader("content"))
+		if !errors.Is(err, ErrUnavailable) {
+			t.Errorf("expected error to be %s, got %s", ErrUnavailable, err)
+		}
+	})
+	t.Run("clamd times out", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %s", err)
+		}
+		t.Cleanup(func() { _ = listener.Close() })
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { _ = conn.Close() })
+		}()
+		_, err = New("tcp", listener.Addr().String(), time.Millisecond*100).Scan(t.Context(),
+			strings.NewReader("content"))
+		if !errors.Is(err, ErrUnavailable) {
+			t.Errorf("expected error to be %s, got %s", ErrUnavailable, err)
+		}
+	})
+	t.Run("clamd answers with an error", func(t *testing.T) {
+		addr := testhelper.ClamdServer(t, "tcp", "127.0.0.1:0", func([]byte) string {
+			return "INSTREAM size limit exceeded. ERROR"
+		})
+		_, err := New("tcp", addr, time.Second).Scan(t.Context(), strings.NewReader("content"))
+		if !errors.Is(err, ErrScanFailed) || errors.Is(err, ErrUnavailable) {
+			t.Errorf("expected error to be %s, got %s", ErrScanFailed, err)
+		}
+	})
+}
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		infected bool
+		fails    bool
+	}{
+		{"clean", "stream: OK", false, false},
+		{"infected", "stream: Win.Test.EICAR_HDB-1 FOUND", true, false},
+		{"size limit", "INSTREAM size limit exceeded. ERROR", false, true},
+		{"error", "stream: Can't allocate memory ERROR", false, true},
+		{"empty", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseResponse(tt.response)
+			if tt.fails {
+				if !errors.Is(err, ErrScanFailed) {
+					t.Errorf("expected error to be %s, got %s", ErrScanFailed, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to parse response: %s", err)
+			}
+			if result.Infected != tt.infected {
+				t.Errorf("expected infected to be %t, got %t", tt.infected, result.Infected)
+			}
+		})
+	}
+}
+
+// testClamd starts a clamd stand-in that reports content containing the EICAR test string as
+// infected and returns its address.
+func testClamd(t *testing.T, network, address string) string {
+	t.Helper()
+	return testhelper.ClamdServer(t, network, address, func(content []byte) string {
+		if bytes.Contains(content, []byte(testhelper.EICAR)) {
+			return "stream: Eicar-Test-Signature FOUND"
+		}
+		return "stream: OK"
+	})
+}
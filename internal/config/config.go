@@ -9,6 +9,7 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/kkyr/fig"
@@ -16,17 +17,82 @@ import (
 
 const configEnv = "JSMAILER"
 
+const (
+	// ModeProduction is the default server mode
+	ModeProduction = "production"
+
+	// ModeDevelopment enables features that must not be used in production, like the mock captcha
+	ModeDevelopment = "development"
+)
+
 // Config represents the global config object struct
 type Config struct {
+	Access struct {
+		Allow []string `fig:"allow"`
+		Deny  []string `fig:"deny"`
+		DNSBL struct {
+			Zones    []string      `fig:"zones"`
+			Resolver string        `fig:"resolver"`
+			Timeout  time.Duration `fig:"timeout" default:"2s"`
+			CacheTTL time.Duration `fig:"cache_ttl" default:"1h"`
+		} `fig:"dnsbl"`
+	} `fig:"access"`
 	Cache struct {
 		Type     string        `fig:"type" default:"inmemory"`
 		Lifetime time.Duration `fig:"lifetime" default:"10m"`
 	}
+	// DKIM holds the DKIM signing configurations for the sender domains. Forms can override them
+	// with their own DKIM configuration.
+	DKIM []struct {
+		Domain     string   `fig:"domain"`
+		Selector   string   `fig:"selector"`
+		PrivateKey string   `fig:"private_key"`
+		Headers    []string `fig:"headers"`
+	} `fig:"dkim"`
+	GeoIP struct {
+		CountryDB      string        `fig:"country_db"`
+		ASNDB          string        `fig:"asn_db"`
+		ReloadInterval time.Duration `fig:"reload_interval" default:"1m"`
+	} `fig:"geoip"`
 	Log struct {
 		Level     slog.Level `fig:"level" default:"0"`
 		Format    string     `fig:"format" default:"json"`
 		DontLogIP bool       `fig:"dont_log_ip"`
 	}
+	// PGP holds the server key that encrypted form mails are signed with, if the form enables signing
+	PGP struct {
+		SigningKey string `fig:"signing_key"`
+		Passphrase string `fig:"passphrase"`
+	} `fig:"pgp"`
+
+	// Admin enables the admin endpoints, that require the token as bearer token
+	Admin struct {
+		Token string `fig:"token"`
+	} `fig:"admin"`
+
+	// DryRun controls what happens to the mails of forms in dry-run mode. The rendered mails are
+	// written as .eml files into the directory (if set) and the last mails are kept in memory for
+	// the admin endpoints.
+	DryRun struct {
+		Directory string `fig:"directory"`
+		Keep      int    `fig:"keep" default:"50"`
+	} `fig:"dry_run"`
+
+	// DevInbox replaces the delivery of all mails with an in-memory mailbox that is served as web
+	// UI and JSON API under /dev/inbox. It is meant for local development, so the server refuses to
+	// start with a non-loopback bind address, unless allow_non_loopback is set.
+	DevInbox struct {
+		Enabled          bool `fig:"enabled"`
+		Keep             int  `fig:"keep" default:"200"`
+		AllowNonLoopback bool `fig:"allow_non_loopback"`
+	} `fig:"dev_inbox"`
+
+	Deliverability struct {
+		Resolver          string        `fig:"resolver"`
+		Timeout           time.Duration `fig:"timeout" default:"5s"`
+		CacheTTL          time.Duration `fig:"cache_ttl" default:"1h"`
+		DisposableDomains string        `fig:"disposable_domains"`
+	} `fig:"deliverability"`
 
 	Forms struct {
 		Path              string        `fig:"path" validate:"required"`
@@ -34,10 +100,62 @@ type Config struct {
 	} `fig:"forms"`
 
 	Server struct {
-		BindAddress string        `fig:"address" default:"127.0.0.1"`
-		BindPort    string        `fig:"port" default:"8765"`
-		Timeout     time.Duration `fig:"timeout" default:"15s"`
+		BindAddress      string        `fig:"address" default:"127.0.0.1"`
+		BindPort         string        `fig:"port" default:"8765"`
+		Timeout          time.Duration `fig:"timeout" default:"15s"`
+		ProblemDetails   bool          `fig:"problem_details"`
+		Mode             string        `fig:"mode" default:"production"`
+		AllowMockCaptcha bool          `fig:"allow_mock_captcha"`
 	} `fig:"server"`
+
+	// SMTP holds named SMTP server profiles that forms reference by name instead of configuring their
+	// own mail server. The relays of a profile are tried in order until one accepts the mail. With the
+	// round-robin strategy, every mail starts with the next relay.
+	SMTP []struct {
+		Name     string `fig:"name"`
+		Strategy string `fig:"strategy" default:"failover"`
+		Relays   []struct {
+			Host              string        `fig:"host"`
+			Port              int           `fig:"port" default:"25"`
+			Username          string        `fig:"username"`
+			Password          string        `fig:"password"`
+			ForceTLS          bool          `fig:"force_tls"`
+			SSL               bool          `fig:"ssl"`
+			CABundle          string        `fig:"ca_bundle"`
+			ClientCertificate string        `fig:"client_certificate"`
+			ClientKey         string        `fig:"client_key"`
+			AuthMechanism     string        `fig:"auth_mechanism"`
+			SkipAuth          bool          `fig:"skip_auth"`
+			HELO              string        `fig:"helo"`
+			Timeout           time.Duration `fig:"timeout"`
+		} `fig:"relays"`
+	} `fig:"smtp"`
+
+	// SMTPPool controls the idle SMTP sessions that are kept per relay and reused by subsequent
+	// submissions. Sessions that were idle longer than the idle timeout are closed.
+	SMTPPool struct {
+		MaxIdle     int           `fig:"max_idle" default:"2"`
+		IdleTimeout time.Duration `fig:"idle_timeout" default:"30s"`
+		Disable     bool          `fig:"disable"`
+	} `fig:"smtp_pool"`
+
+	Spamd struct {
+		Network string        `fig:"network" default:"tcp"`
+		Address string        `fig:"address"`
+		Timeout time.Duration `fig:"timeout" default:"10s"`
+	} `fig:"spamd"`
+
+	ClamAV struct {
+		Network string        `fig:"network" default:"tcp"`
+		Address string        `fig:"address"`
+		Timeout time.Duration `fig:"timeout" default:"30s"`
+	} `fig:"clamav"`
+}
+
+// IsProduction returns true if the server runs in production mode. Any mode other than
+// development is considered production.
+func (c *Config) IsProduction() bool {
+	return !strings.EqualFold(c.Server.Mode, ModeDevelopment)
 }
 
 // New returns a new Config. It tries to load the config from the default location
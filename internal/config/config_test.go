@@ -60,6 +60,23 @@ func TestNew(t *testing.T) {
 		if config.Server.Timeout != testServerTimeout {
 			t.Errorf("expected server timeout to be %s, got %s", testServerTimeout, config.Server.Timeout)
 		}
+		if config.Server.Mode != ModeProduction {
+			t.Errorf("expected server mode to be %s, got %s", ModeProduction, config.Server.Mode)
+		}
+		if !config.IsProduction() {
+			t.Error("expected server to run in production mode by default")
+		}
+		if config.DevInbox.Enabled || config.DevInbox.Keep != 200 {
+			t.Errorf("expected disabled development inbox keeping 200 mails by default, got %t and %d",
+				config.DevInbox.Enabled, config.DevInbox.Keep)
+		}
+		if config.DryRun.Keep != 50 {
+			t.Errorf("expected dry-run to keep 50 mails by default, got %d", config.DryRun.Keep)
+		}
+		if config.SMTPPool.MaxIdle != 2 || config.SMTPPool.IdleTimeout != time.Second*30 {
+			t.Errorf("expected SMTP pool defaults of 2 idle sessions for 30s, got %d for %s",
+				config.SMTPPool.MaxIdle, config.SMTPPool.IdleTimeout)
+		}
 	})
 	t.Run("config without a home directory", func(t *testing.T) {
 		t.Setenv("HOME", "")
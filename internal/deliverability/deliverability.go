@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+// Package deliverability implements checks whether an email address is likely to be deliverable:
+// DNS lookups of the mail hosts of a domain, a blocklist of disposable mail domains and a list of
+// role accounts.
+package deliverability
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/wneessen/js-mailer/internal/lookup"
+)
+
+const (
+	// DefaultTimeout is the default timeout for the DNS lookups of a domain
+	DefaultTimeout = time.Second * 5
+
+	// DefaultCacheTTL is the default lifetime of cached lookup results
+	DefaultCacheTTL = time.Hour
+)
+
+// ErrLookupFailed is returned if the mail hosts of a domain could not be looked up
+var ErrLookupFailed = errors.New("mail host lookup failed")
+
+// roleAccounts are local parts that address a role or a mailing list instead of a person
+var roleAccounts = map[string]struct{}{
+	"abuse": {}, "admin": {}, "administrator": {}, "billing": {}, "contact": {}, "devnull": {},
+	"dns": {}, "ftp": {}, "help": {}, "hostmaster": {}, "info": {}, "mail": {}, "mailer-daemon": {},
+	"marketing": {}, "no-reply": {}, "noc": {}, "noreply": {}, "null": {}, "office": {},
+	"postmaster": {}, "privacy": {}, "root": {}, "sales": {}, "security": {}, "spam": {},
+	"support": {}, "sysadmin": {}, "usenet": {}, "uucp": {}, "webmaster": {}, "www": {},
+}
+
+// Checker checks the deliverability of email addresses and caches the DNS lookup results
+type Checker struct {
+	resolver   *net.Resolver
+	timeout    time.Duration
+	cache      *lookup.Cache[bool]
+	disposable map[string]struct{}
+}
+
+// New returns a new deliverability checker. If resolver is empty, the system resolver is used,
+// otherwise all queries are sent to the resolver at the given host:port.
+func New(resolver string, timeout, ttl time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &Checker{
+		resolver:   lookup.NewResolver(resolver),
+		timeout:    timeout,
+		cache:      lookup.NewCache[bool](ttl),
+		disposable: make(map[string]struct{}),
+	}
+}
+
+// LoadDisposableDomains loads the blocklist of disposable mail domains from a file with one domain
+// per line. Empty lines and lines starting with # are ignored.
+func (c *Checker) LoadDisposableDomains(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open disposable domains list: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	domains := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains[normalizeDomain(line)] = struct{}{}
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read disposable domains list: %w", err)
+	}
+	c.disposable = domains
+	return nil
+}
+
+// HasMailHost returns true if the domain has MX records or, if it has none, A or AAAA records. A
+// domain that publishes a null MX record (RFC 7505) does not accept mail.
+func (c *Checker) HasMailHost(ctx context.Context, domain string) (bool, error) {
+	domain = normalizeDomain(domain)
+	if hasMailHost, ok := c.cache.Get(domain); ok {
+		return hasMailHost, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	hasMailHost, err := c.lookupMailHost(ctx, domain)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s: %w", ErrLookupFailed, domain, err)
+	}
+	c.cache.Set(domain, hasMailHost)
+	return hasMailHost, nil
+}
+
+// IsDisposable returns true if the domain or one of its parent domains is on the blocklist of
+// disposable mail domains.
+func (c *Checker) IsDisposable(domain string) bool {
+	domain = normalizeDomain(domain)
+	for domain != "" {
+		if _, ok := c.disposable[domain]; ok {
+			return true
+		}
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			break
+		}
+		domain = parent
+	}
+	return false
+}
+
+// IsRoleAccount returns true if the local part of an address addresses a role instead of a person,
+// e.g. postmaster or info. Sub-addresses (info+forms) are ignored.
+func IsRoleAccount(localPart string) bool {
+	localPart, _, _ = strings.Cut(strings.ToLower(localPart), "+")
+	_, ok := roleAccounts[localPart]
+	return ok
+}
+
+// lookupMailHost looks up the MX records of the domain and falls back to the address records
+func (c *Checker) lookupMailHost(ctx context.Context, domain string) (bool, error) {
+	records, err := c.resolver.LookupMX(ctx, domain+".")
+	if err != nil && !lookup.IsNotFound(err) {
+		return false, err
+	}
+	if len(records) == 1 && records[0].Host == "." {
+		return false, nil
+	}
+	if len(records) > 0 {
+		return true, nil
+	}
+
+	addrs, err := c.resolver.LookupNetIP(ctx, "ip", domain+".")
+	if err != nil {
+		if lookup.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return len(addrs) > 0, nil
+}
+
+func normalizeDomain(domain string) string {
+	return strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package deliverability
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wneessen/js-mailer/internal/testhelper"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("new sets defaults", func(t *testing.T) {
+		checker := New("", 0, 0)
+		if checker.timeout != DefaultTimeout {
+			t.Errorf("expected timeout to be %s, got %s", DefaultTimeout, checker.timeout)
+		}
+		if checker.cache.TTL() != DefaultCacheTTL {
+			t.Errorf("expected cache TTL to be %s, got %s", DefaultCacheTTL, checker.cache.TTL())
+		}
+	})
+}
+
+func TestChecker_HasMailHost(t *testing.T) {
+	resolver := testhelper.DNSServer(t, map[string]string{
+		"mx.example.":      "MX mail.mx.example.",
+		"a.example.":       "192.0.2.1",
+		"nullmx.example.":  "MX .",
+		"failing.example.": testhelper.DNSServFail,
+	})
+	tests := []struct {
+		name    string
+		domain  string
+		want    bool
+		wantErr bool
+	}{
+		{"domain with MX record", "mx.example", true, false},
+		{"domain with MX record in upper case", "MX.Example.", true, false},
+		{"domain with A record only", "a.example", true, false},
+		{"domain with null MX record", "nullmx.example", false, false},
+		{"non-existing domain", "asdfgh.invalid", false, false},
+		{"failing lookup", "failing.example", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := New(resolver, time.Second, time.Minute)
+			hasMailHost, err := checker.HasMailHost(t.Context(), tt.domain)
+			if tt.wantErr {
+				if !errors.Is(err, ErrLookupFailed) {
+					t.Errorf("expected error to be %s, got: %s", ErrLookupFailed, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("lookup failed: %s", err)
+			}
+			if hasMailHost != tt.want {
+				t.Errorf("expected has mail host to be %t, got %t", tt.want, hasMailHost)
+			}
+		})
+	}
+	t.Run("results are cached", func(t *testing.T) {
+		checker := New(resolver, time.Second, time.Minute)
+		if _, err := checker.HasMailHost(t.Context(), "asdfgh.invalid"); err != nil {
+			t.Fatalf("lookup failed: %s", err)
+		}
+		if checker.cache.Len() != 1 {
+			t.Errorf("expected 1 cached result, got %d", checker.cache.Len())
+		}
+		checker.cache.Set("asdfgh.invalid", true)
+		hasMailHost, err := checker.HasMailHost(t.Context(), "asdfgh.invalid")
+		if err != nil {
+			t.Fatalf("lookup failed: %s", err)
+		}
+		if !hasMailHost {
+			t.Error("expected cached result to be used")
+		}
+	})
+	t.Run("failed lookups are not cached", func(t *testing.T) {
+		checker := New(resolver, time.Second, time.Minute)
+		_, _ = checker.HasMailHost(t.Context(), "failing.example")
+		if checker.cache.Len() != 0 {
+			t.Errorf("expected no cached results, got %d", checker.cache.Len())
+		}
+	})
+}
+
+func TestChecker_IsDisposable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disposable.txt")
+	list := "# disposable mail domains\n\nmailinator.com\n  Trashmail.EXAMPLE  \n"
+	if err := os.WriteFile(path, []byte(list), 0o600); err != nil {
+		t.Fatalf("failed to write disposable domains list: %s", err)
+	}
+	checker := New("", 0, 0)
+	if err := checker.LoadDisposableDomains(path); err != nil {
+		t.Fatalf("failed to load disposable domains list: %s", err)
+	}
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"mailinator.com", true},
+		{"MAILINATOR.COM", true},
+		{"sub.mailinator.com", true},
+		{"trashmail.example", true},
+		{"example.com", false},
+		{"notmailinator.com", false},
+		{"com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			if got := checker.IsDisposable(tt.domain); got != tt.want {
+				t.Errorf("expected disposable to be %t, got %t", tt.want, got)
+			}
+		})
+	}
+	t.Run("missing list fails", func(t *testing.T) {
+		if err := New("", 0, 0).LoadDisposableDomains(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+			t.Error("expected loading a missing list to fail")
+		}
+	})
+}
+
+func TestIsRoleAccount(t *testing.T) {
+	tests := []struct {
+		localPart string
+		want      bool
+	}{
+		{"postmaster", true},
+		{"Info", true},
+		{"info+forms", true},
+		{"no-reply", true},
+		{"jane.doe", false},
+		{"information", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.localPart, func(t *testing.T) {
+			if got := IsRoleAccount(tt.localPart); got != tt.want {
+				t.Errorf("expected role account to be %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
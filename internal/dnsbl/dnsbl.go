@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+// Package dnsbl implements cached lookups of client addresses in DNS-based blocklists
+package dnsbl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/wneessen/js-mailer/internal/lookup"
+)
+
+const (
+	// DefaultTimeout is the default timeout for a single DNSBL lookup
+	DefaultTimeout = time.Second * 2
+
+	// DefaultCacheTTL is the default lifetime of cached DNSBL results
+	DefaultCacheTTL = time.Hour
+)
+
+// ErrLookupFailed is returned if a DNSBL could not be queried
+var ErrLookupFailed = errors.New("dnsbl lookup failed")
+
+// listedPrefix is the range of addresses that DNSBLs return for listed entries (RFC 5782). Some
+// lists return addresses in errorPrefix to signal errors, e.g. for queries via public resolvers.
+var (
+	listedPrefix = netip.MustParsePrefix("127.0.0.0/8")
+	errorPrefix  = netip.MustParsePrefix("127.255.255.0/24")
+)
+
+// Checker looks up addresses in DNSBL zones and caches the results
+type Checker struct {
+	resolver *net.Resolver
+	timeout  time.Duration
+	cache    *lookup.Cache[bool]
+}
+
+// Result is the result of a DNSBL lookup
+type Result struct {
+	Listed bool
+	Zone   string
+}
+
+// New returns a new DNSBL checker. If resolver is empty, the system resolver is used, otherwise
+// all queries are sent to the resolver at the given host:port.
+func New(resolver string, timeout, ttl time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &Checker{
+		resolver: lookup.NewResolver(resolver),
+		timeout:  timeout,
+		cache:    lookup.NewCache[bool](ttl),
+	}
+}
+
+// Lookup looks up the address in the given DNSBL zones and returns the first zone that lists it.
+func (c *Checker) Lookup(ctx context.Context, addr netip.Addr, zones []string) (*Result, error) {
+	addr = addr.Unmap()
+	for _, zone := range zones {
+		zone = strings.Trim(zone, ".")
+		if zone == "" {
+			continue
+		}
+		query := queryName(addr, zone)
+		listed, ok := c.cache.Get(query)
+		if !ok {
+			var err error
+			if listed, err = c.query(ctx, query); err != nil {
+				return nil, fmt.Errorf("%w: %s: %w", ErrLookupFailed, zone, err)
+			}
+			c.cache.Set(query, listed)
+		}
+		if listed {
+			return &Result{Listed: true, Zone: zone}, nil
+		}
+	}
+	return &Result{}, nil
+}
+
+// query resolves the DNSBL query name. A NXDOMAIN response means the address is not listed.
+func (c *Checker) query(ctx context.Context, name string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	ips, err := c.resolver.LookupNetIP(ctx, "ip4", name)
+	if err != nil {
+		if lookup.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, ip := range ips {
+		ip = ip.Unmap()
+		if errorPrefix.Contains(ip) {
+			return false, fmt.Errorf("dnsbl returned error code %s", ip)
+		}
+		if listedPrefix.Contains(ip) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// queryName returns the DNSBL query name for the address, e.g. 2.0.0.127.zen.example. for
+// 127.0.0.2. IPv6 addresses are queried with their reversed nibbles.
+func queryName(addr netip.Addr, zone string) string {
+	parts := make([]string, 0, 32)
+	if addr.Is4() {
+		octets := addr.As4()
+		for i := len(octets) - 1; i >= 0; i-- {
+			parts = append(parts, fmt.Sprintf("%d", octets[i]))
+		}
+	} else {
+		octets := addr.As16()
+		for i := len(octets) - 1; i >= 0; i-- {
+			parts = append(parts, fmt.Sprintf("%x", octets[i]&0x0f), fmt.Sprintf("%x", octets[i]>>4))
+		}
+	}
+	return strings.Join(parts, ".") + "." + zone + "."
+}
@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package dnsbl
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/wneessen/js-mailer/internal/testhelper"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("new sets defaults", func(t *testing.T) {
+		checker := New("", 0, 0)
+		if checker.timeout != DefaultTimeout {
+			t.Errorf("expected timeout to be %s, got %s", DefaultTimeout, checker.timeout)
+		}
+		if checker.cache.TTL() != DefaultCacheTTL {
+			t.Errorf("expected cache TTL to be %s, got %s", DefaultCacheTTL, checker.cache.TTL())
+		}
+	})
+}
+
+func TestChecker_Lookup(t *testing.T) {
+	resolver := testhelper.DNSServer(t, map[string]string{
+		"2.0.0.127.zen.example.":        "127.0.0.2",
+		"3.0.0.127.other.example.":      "127.0.0.4",
+		"4.0.0.127.zen.example.":        "127.255.255.254",
+		"5.0.0.127.zen.example.":        testhelper.DNSServFail,
+		"2.0.0.127.nonlisting.example.": "10.0.0.1",
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.zen.example.": "127.0.0.2",
+	})
+	zones := []string{"zen.example", "other.example."}
+
+	tests := []struct {
+		name    string
+		addr    string
+		zones   []string
+		listed  bool
+		zone    string
+		wantErr bool
+	}{
+		{"listed address", "127.0.0.2", zones, true, "zen.example", false},
+		{"address listed in second zone", "127.0.0.3", zones, true, "other.example", false},
+		{"not listed address", "127.0.0.10", zones, false, "", false},
+		{"IPv4-mapped IPv6 address", "::ffff:127.0.0.2", zones, true, "zen.example", false},
+		{"listed IPv6 address", "2001:db8::1", zones, true, "zen.example", false},
+		{"answer outside of 127/8 is not a listing", "127.0.0.2", []string{"nonlisting.example"}, false, "", false},
+		{"error code answer", "127.0.0.4", zones, false, "", true},
+		{"server failure", "127.0.0.5", zones, false, "", true},
+		{"no zones", "127.0.0.2", nil, false, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := New(resolver, time.Second, time.Minute)
+			result, err := checker.Lookup(t.Context(), netip.MustParseAddr(tt.addr), tt.zones)
+			if tt.wantErr {
+				if !errors.Is(err, ErrLookupFailed) {
+					t.Errorf("expected error to be %s, got: %s", ErrLookupFailed, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("lookup failed: %s", err)
+			}
+			if result.Listed != tt.listed {
+				t.Errorf("expected listed to be %t, got %t", tt.listed, result.Listed)
+			}
+			if result.Zone != tt.zone {
+				t.Errorf("expected zone to be %q, got %q", tt.zone, result.Zone)
+			}
+		})
+	}
+	t.Run("results are cached", func(t *testing.T) {
+		checker := New(resolver, time.Second, time.Minute)
+		addr := netip.MustParseAddr("127.0.0.10")
+		if _, err := checker.Lookup(t.Context(), addr, zones); err != nil {
+			t.Fatalf("lookup failed: %s", err)
+		}
+		if checker.cache.Len() != 2 {
+			t.Errorf("expected 2 cached results, got %d", checker.cache.Len())
+		}
+		checker.cache.Set(queryName(addr, "zen.example"), true)
+		result, err := checker.Lookup(t.Context(), addr, zones)
+		if err != nil {
+			t.Fatalf("lookup failed: %s", err)
+		}
+		if !result.Listed {
+			t.Error("expected cached result to be used")
+		}
+	})
+	t.Run("expired results are queried again", func(t *testing.T) {
+		checker := New(resolver, time.Second, time.Millisecond)
+		addr := netip.MustParseAddr("127.0.0.10")
+		checker.cache.Set(queryName(addr, "zen.example"), true)
+		time.Sleep(time.Millisecond * 5)
+		result, err := checker.Lookup(t.Context(), addr, zones)
+		if err != nil {
+			t.Fatalf("lookup failed: %s", err)
+		}
+		if result.Listed {
+			t.Error("expected expired result to be ignored")
+		}
+	})
+}
+
+func TestQueryName(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"192.0.2.1", "1.2.0.192.zen.example."},
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.zen.example."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := queryName(netip.MustParseAddr(tt.addr), "zen.example"); got != tt.want {
+				t.Errorf("expected query name %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
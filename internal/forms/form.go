@@ -8,14 +8,29 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/kkyr/fig"
 )
 
-var ErrFormNotFound = errors.New("form not found")
+var (
+	ErrFormNotFound           = errors.New("form not found")
+	ErrInvalidAltchaMaxNumber = errors.New("ALTCHA max_number must be at least 1")
+	ErrNoMailServer           = errors.New("form has no mail server host, SMTP profile or local transport")
+	ErrConfirmationTransport  = errors.New("confirmation mails can't be sent with a maildir or mbox transport")
+	ErrInvalidSpamPattern     = errors.New("invalid spam pattern")
+	ErrInvalidRoutingPattern  = errors.New("invalid routing pattern")
+	ErrInvalidRecaptchaURL    = errors.New("reCaptcha enterprise endpoint must contain exactly one %s placeholder")
+	ErrUnknownSpamScript      = errors.New("unknown Unicode script")
+)
 
 // Form is the configuration struct for a form
 type Form struct {
+	Access  AccessConfig `fig:"access"`
 	Content struct {
 		Subject string
 		Fields  []string
@@ -26,45 +41,127 @@ type Form struct {
 		Subject        string `fig:"subject"`
 		Content        string `fig:"content"`
 	}
-	Domains    []string `fig:"domains" validate:"required"`
-	AttachCSV  bool     `fig:"attach_csv"`
-	ID         string   `fig:"id" validate:"required"`
-	Recipients []string `fig:"recipients" validate:"required"`
+	Delivery   DeliveryConfig `fig:"delivery"`
+	DKIM       DKIMConfig     `fig:"dkim"`
+	Domains    []string       `fig:"domains" validate:"required"`
+	AttachCSV  bool           `fig:"attach_csv"`
+	GeoIP      GeoIPConfig    `fig:"geoip"`
+	ID         string         `fig:"id" validate:"required"`
+	PGP        PGPConfig      `fig:"pgp"`
+	Recipients []string       `fig:"recipients" validate:"required"`
 	ReplyTo    struct {
 		Field string `json:"field"`
 	}
-	Secret string `fig:"secret" validate:"required"`
-	Sender string `fig:"sender" validate:"required"`
-	Server struct {
-		Host     string `fig:"host" validate:"required"`
-		Port     int    `fig:"port" default:"25"`
-		Username string
-		Password string
-		ForceTLS bool `fig:"force_tls"`
-		DryRun   bool `fig:"dry_run"`
+	Routing   RoutingConfig   `fig:"routing"`
+	Secret    string          `fig:"secret" validate:"required"`
+	Sender    string          `fig:"sender" validate:"required"`
+	SMIME     SMIMEConfig     `fig:"smime"`
+	Transport TransportConfig `fig:"transport"`
+	Server    struct {
+		Profile           string `fig:"profile"`
+		Host              string `fig:"host"`
+		Port              int    `fig:"port" default:"25"`
+		Username          string
+		Password          string
+		ForceTLS          bool          `fig:"force_tls"`
+		SSL               bool          `fig:"ssl"`
+		CABundle          string        `fig:"ca_bundle"`
+		ClientCertificate string        `fig:"client_certificate"`
+		ClientKey         string        `fig:"client_key"`
+		AuthMechanism     string        `fig:"auth_mechanism"`
+		SkipAuth          bool          `fig:"skip_auth"`
+		HELO              string        `fig:"helo"`
+		Timeout           time.Duration `fig:"timeout"`
+		DryRun            bool          `fig:"dry_run"`
 	}
 	Validation struct {
 		DisableSubmissionSpeedCheck bool              `fig:"disable_submission_speed_check"`
+		TokenLifetime               time.Duration     `fig:"token_lifetime"`
+		MinFillTime                 time.Duration     `fig:"min_fill_time"`
+		MaxFillTime                 time.Duration     `fig:"max_fill_time"`
 		RandomAntiSpamField         bool              `fig:"random_anti_spam_field"`
 		Fields                      []ValidationField `fig:"fields"`
-		Hcaptcha                    struct {
-			Enabled   bool   `fig:"enabled"`
-			SecretKey string `fig:"secret_key"`
+		MaxChallengeAge             time.Duration     `fig:"max_challenge_age"`
+		CaptchaPolicy               string            `fig:"captcha_policy" default:"all"`
+		Spam                        SpamConfig        `fig:"spam"`
+		Spamd                       struct {
+			Enabled         bool    `fig:"enabled"`
+			RejectScore     float64 `fig:"reject_score"`
+			QuarantineScore float64 `fig:"quarantine_score"`
+			FailOpen        bool    `fig:"fail_open"`
+		} `fig:"spamd"`
+		ClamAV struct {
+			Enabled  bool `fig:"enabled"`
+			FailOpen bool `fig:"fail_open"`
+		} `fig:"clamav"`
+		TokenBinding struct {
+			IP        string `fig:"ip"`
+			UserAgent bool   `fig:"user_agent"`
+		} `fig:"token_binding"`
+		Duplicates struct {
+			Enabled bool          `fig:"enabled"`
+			Fields  []string      `fig:"fields"`
+			Window  time.Duration `fig:"window" default:"10m"`
+			Action  string        `fig:"action" default:"reject"`
+		} `fig:"duplicates"`
+		Hcaptcha struct {
+			Enabled              bool    `fig:"enabled"`
+			SecretKey            string  `fig:"secret_key"`
+			Endpoint             string  `fig:"endpoint"`
+			SiteKey              string  `fig:"site_key"`
+			MaxScore             float64 `fig:"max_score"`
+			DisableHostnameCheck bool    `fig:"disable_hostname_check"`
 		}
 		Honeypot  string `fig:"honeypot"`
 		Recaptcha struct {
-			Enabled   bool   `fig:"enabled"`
-			SecretKey string `fig:"secret_key"`
+			Enabled              bool    `fig:"enabled"`
+			SecretKey            string  `fig:"secret_key"`
+			Endpoint             string  `fig:"endpoint"`
+			EnterpriseEndpoint   string  `fig:"enterprise_endpoint"`
+			MinScore             float64 `fig:"min_score"`
+			FlagScore            float64 `fig:"flag_score"`
+			Action               string  `fig:"action"`
+			DisableHostnameCheck bool    `fig:"disable_hostname_check"`
+			ProjectID            string  `fig:"project_id"`
+			APIKey               string  `fig:"api_key"`
+			SiteKey              string  `fig:"site_key"`
 		}
+		SpamTag   string `fig:"spam_tag" default:"[SPAM]"`
 		Turnstile struct {
-			Enabled   bool   `fig:"enabled"`
-			SecretKey string `fig:"secret_key"`
+			Enabled              bool   `fig:"enabled"`
+			SecretKey            string `fig:"secret_key"`
+			Endpoint             string `fig:"endpoint"`
+			DisableHostnameCheck bool   `fig:"disable_hostname_check"`
+			DisableActionCheck   bool   `fig:"disable_action_check"`
 		}
 		PrivateCaptcha struct {
-			Host    string `fig:"host"`
-			Enabled bool   `fig:"enabled"`
-			APIKey  string `fig:"api_key"`
+			Host                 string `fig:"host"`
+			Enabled              bool   `fig:"enabled"`
+			APIKey               string `fig:"api_key"`
+			Endpoint             string `fig:"endpoint"`
+			DisableHostnameCheck bool   `fig:"disable_hostname_check"`
 		} `fig:"private_captcha"`
+		Altcha struct {
+			Enabled   bool  `fig:"enabled"`
+			MaxNumber int64 `fig:"max_number" default:"100000"`
+		} `fig:"altcha"`
+		Mock struct {
+			Enabled bool `fig:"enabled"`
+		} `fig:"mock"`
+		FriendlyCaptcha struct {
+			Enabled              bool   `fig:"enabled"`
+			APIKey               string `fig:"api_key"`
+			SiteKey              string `fig:"site_key"`
+			Endpoint             string `fig:"endpoint"`
+			DisableHostnameCheck bool   `fig:"disable_hostname_check"`
+		} `fig:"friendly_captcha"`
+		MCaptcha struct {
+			Enabled   bool   `fig:"enabled"`
+			Host      string `fig:"host"`
+			SiteKey   string `fig:"site_key"`
+			SecretKey string `fig:"secret_key"`
+			Endpoint  string `fig:"endpoint"`
+		} `fig:"mcaptcha"`
 	}
 }
 
@@ -74,6 +171,210 @@ type ValidationField struct {
 	Required bool   `fig:"required"`
 	Type     string `fig:"type"`
 	Value    string `fig:"value"`
+
+	// Deliverability checks for fields of type email
+	CheckMX            bool `fig:"check_mx"`
+	RejectDisposable   bool `fig:"reject_disposable"`
+	RejectRoleAccounts bool `fig:"reject_role_accounts"`
+}
+
+// AccessConfig reflects the struct for the client access restrictions of a form. The lists are
+// checked in addition to the global access lists of the server. Addresses that match an allow
+// entry are exempt from the deny lists and DNSBL checks.
+type AccessConfig struct {
+	Allow        []string `fig:"allow"`
+	Deny         []string `fig:"deny"`
+	DNSBLZones   []string `fig:"dnsbl_zones"`
+	DisableDNSBL bool     `fig:"disable_dnsbl"`
+}
+
+// DeliveryConfig reflects the struct for the delivery of the form mail and the confirmation mail.
+// Both mails are sent over the same SMTP session in the configured order ("confirmation-first" or
+// "notification-first"). A "mandatory" confirmation fails the submission if it can't be delivered,
+// a "best-effort" confirmation is only logged.
+type DeliveryConfig struct {
+	Order        string `fig:"order" default:"confirmation-first"`
+	Confirmation string `fig:"confirmation" default:"mandatory"`
+}
+
+// DKIMConfig reflects the struct for the DKIM signing configuration of a form. The signing domain
+// defaults to the domain of the sender address. The algorithm (RSA or Ed25519) is derived from the
+// private key. If no headers are set, the default header list of go-mail is signed.
+type DKIMConfig struct {
+	Domain     string   `fig:"domain"`
+	Selector   string   `fig:"selector"`
+	PrivateKey string   `fig:"private_key"`
+	Headers    []string `fig:"headers"`
+}
+
+// GeoIPConfig reflects the struct for the country and ASN restrictions of a form. If an allow list
+// is set, only clients from the listed countries (ISO 3166-1 alpha-2 codes) or ASNs are accepted.
+type GeoIPConfig struct {
+	AllowedCountries []string `fig:"allowed_countries"`
+	BlockedCountries []string `fig:"blocked_countries"`
+	AllowedASNs      []uint   `fig:"allowed_asns"`
+	BlockedASNs      []uint   `fig:"blocked_asns"`
+	IncludeMetadata  bool     `fig:"include_metadata"`
+	FailOpen         bool     `fig:"fail_open"`
+}
+
+// PGPConfig reflects the struct for the PGP/MIME configuration of a form. The form mail is
+// encrypted if recipient keys (ASCII armored public key files) are set. Every recipient of the form
+// mail needs a key with a user ID for its address. If sign is set, the encrypted form mail is signed
+// with the PGP signing key of the server.
+type PGPConfig struct {
+	RecipientKeys []string `fig:"recipient_keys"`
+	Sign          bool     `fig:"sign"`
+}
+
+// RoutingConfig reflects the struct for the routing of the form mail based on submitted values.
+// The rules are checked in order and the first matching rule decides the recipients, CC and BCC of
+// the form mail. If no rule matches, the default applies. Recipients that are not set in the
+// matching rule or the default fall back to the recipients of the form. All addresses come from
+// the form configuration, submitted values only select between them.
+type RoutingConfig struct {
+	Rules   []RoutingRule `fig:"rules"`
+	Default RoutingTarget `fig:"default"`
+}
+
+// RoutingRule reflects the struct for a routing rule. The submitted value of the field is matched
+// "exact" (default) against value, against the "regex" in value, or against the list of values
+// ("in"). Exact and list matches ignore case and surrounding whitespace.
+type RoutingRule struct {
+	Field      string   `fig:"field" validate:"required"`
+	Match      string   `fig:"match" default:"exact"`
+	Value      string   `fig:"value"`
+	Values     []string `fig:"values"`
+	Recipients []string `fig:"recipients"`
+	CC         []string `fig:"cc"`
+	BCC        []string `fig:"bcc"`
+
+	// pattern holds the compiled regex of Value, compiled holds the Value it was compiled from
+	pattern  *regexp.Regexp
+	compiled string
+}
+
+// Pattern returns the compiled regular expression of a "regex" rule. The pattern is compiled once
+// when the form is loaded, it is only compiled again if Value was changed.
+func (r RoutingRule) Pattern() (*regexp.Regexp, error) {
+	if r.pattern != nil && r.compiled == r.Value {
+		return r.pattern, nil
+	}
+	pattern, err := regexp.Compile(r.Value)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidRoutingPattern, r.Value, err)
+	}
+	return pattern, nil
+}
+
+// compile compiles the pattern of a "regex" rule
+func (r *RoutingRule) compile() error {
+	if !strings.EqualFold(r.Match, "regex") {
+		return nil
+	}
+	pattern, err := r.Pattern()
+	if err != nil {
+		return err
+	}
+	r.pattern, r.compiled = pattern, r.Value
+	return nil
+}
+
+// RoutingTarget reflects the struct for the recipients, CC and BCC of the form mail if no routing
+// rule matches
+type RoutingTarget struct {
+	Recipients []string `fig:"recipients"`
+	CC         []string `fig:"cc"`
+	BCC        []string `fig:"bcc"`
+}
+
+// SMIMEConfig reflects the struct for the S/MIME configuration of a form. The form mail is signed
+// if a certificate and private key are set and encrypted if recipient certificates are set. Every
+// recipient of the form mail needs a certificate that is issued for its address.
+type SMIMEConfig struct {
+	Certificate           string   `fig:"certificate"`
+	PrivateKey            string   `fig:"private_key"`
+	RecipientCertificates []string `fig:"recipient_certificates"`
+}
+
+// TransportConfig reflects the struct for the transport of the form and confirmation mails. Besides
+// the default "smtp" transport via the mail server of the form, mails can be piped to a sendmail
+// compatible binary ("sendmail"), delivered via LMTP over a Unix socket ("lmtp") or written into a
+// Maildir ("maildir") or mbox file ("mbox"). Path is the binary (defaults to /usr/sbin/sendmail),
+// the socket, the Maildir or the mbox file. Maildir and mbox only hold the form mails, they can't
+// be used with confirmation mails.
+type TransportConfig struct {
+	Type string `fig:"type" default:"smtp"`
+	Path string `fig:"path"`
+}
+
+// SpamConfig reflects the struct for the content-based spam scoring of a form. Every rule adds its
+// score to the total score of a submission, a rule with a score of 0 is disabled. The thresholds
+// decide if a submission is tagged, routed to the quarantine recipients or rejected. A threshold of
+// 0 is disabled.
+type SpamConfig struct {
+	Enabled              bool     `fig:"enabled"`
+	Fields               []string `fig:"fields"`
+	EmailField           string   `fig:"email_field"`
+	TagScore             float64  `fig:"tag_score"`
+	QuarantineScore      float64  `fig:"quarantine_score"`
+	RejectScore          float64  `fig:"reject_score"`
+	QuarantineRecipients []string `fig:"quarantine_recipients"`
+
+	MaxLinks            int      `fig:"max_links"`
+	LinkScore           float64  `fig:"link_score"`
+	Shorteners          []string `fig:"shorteners"`
+	ShortenerScore      float64  `fig:"shortener_score"`
+	Keywords            []string `fig:"keywords"`
+	KeywordScore        float64  `fig:"keyword_score"`
+	Patterns            []string `fig:"patterns"`
+	PatternScore        float64  `fig:"pattern_score"`
+	MaxCapsRatio        float64  `fig:"max_caps_ratio"`
+	CapsScore           float64  `fig:"caps_score"`
+	AllowedScripts      []string `fig:"allowed_scripts"`
+	ScriptScore         float64  `fig:"script_score"`
+	MaxRepeatedChars    int      `fig:"max_repeated_chars"`
+	RepeatScore         float64  `fig:"repeat_score"`
+	DomainMismatchScore float64  `fig:"domain_mismatch_score"`
+
+	// patterns holds the compiled Patterns, compiled holds the Patterns they were compiled from
+	patterns []*regexp.Regexp
+	compiled []string
+}
+
+// CompiledPatterns returns the compiled regular expressions of Patterns. The patterns of a form are
+// compiled once when the form is loaded, they are only compiled again if Patterns was changed.
+func (c SpamConfig) CompiledPatterns() ([]*regexp.Regexp, error) {
+	if c.compiled != nil && slices.Equal(c.compiled, c.Patterns) {
+		return c.patterns, nil
+	}
+	patterns := make([]*regexp.Regexp, 0, len(c.Patterns))
+	for _, pattern := range c.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %w", ErrInvalidSpamPattern, pattern, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// compile compiles the patterns and checks the allowed scripts of the spam configuration
+func (c *SpamConfig) compile() error {
+	patterns, err := c.CompiledPatterns()
+	if err != nil {
+		return err
+	}
+	c.patterns, c.compiled = patterns, slices.Clone(c.Patterns)
+	if c.compiled == nil {
+		c.compiled = []string{}
+	}
+	for _, name := range c.AllowedScripts {
+		if _, ok := unicode.Scripts[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownSpamScript, name)
+		}
+	}
+	return nil
 }
 
 func New(path, formID string) (*Form, error) {
@@ -99,6 +400,39 @@ func New(path, formID string) (*Form, error) {
 	if err = fig.Load(form, fig.File(formFile), fig.Dirs(root.Name())); err != nil {
 		return form, fmt.Errorf("failed parse form config: %w", err)
 	}
+	if err = form.validate(); err != nil {
+		return form, fmt.Errorf("invalid form config: %w", err)
+	}
 
 	return form, nil
 }
+
+// validate checks settings that the config loader cannot express as struct tags.
+func (f *Form) validate() error {
+	transport := strings.ToLower(f.Transport.Type)
+	if (transport == "" || transport == "smtp") && f.Server.Host == "" && f.Server.Profile == "" {
+		return ErrNoMailServer
+	}
+	// Maildir and mbox ignore the envelope recipients, the confirmation mail would end up in the
+	// mailbox of the operator instead of the visitor
+	if (transport == "maildir" || transport == "mbox") && f.Confirmation.Enabled {
+		return fmt.Errorf("%w: %s", ErrConfirmationTransport, transport)
+	}
+	for i := range f.Routing.Rules {
+		if err := f.Routing.Rules[i].compile(); err != nil {
+			return fmt.Errorf("routing rule %d: %w", i+1, err)
+		}
+	}
+	// The enterprise endpoint is a format string for the project ID, any other verb breaks the URL
+	if endpoint := f.Validation.Recaptcha.EnterpriseEndpoint; endpoint != "" &&
+		(strings.Count(endpoint, "%s") != 1 || strings.Count(endpoint, "%") != 1) {
+		return fmt.Errorf("%w: %s", ErrInvalidRecaptchaURL, endpoint)
+	}
+	if err := f.Validation.Spam.compile(); err != nil {
+		return err
+	}
+	if f.Validation.Altcha.MaxNumber < 1 {
+		return fmt.Errorf("%w: %d", ErrInvalidAltchaMaxNumber, f.Validation.Altcha.MaxNumber)
+	}
+	return nil
+}
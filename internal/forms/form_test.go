@@ -5,8 +5,13 @@
 package forms
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -24,6 +29,7 @@ const (
 	testFormServerUsername        = "smtp-user"
 	testFormServerPassword        = "smtp-password"
 	testFormServerForceTLS        = true
+	testFormServerTimeout         = time.Second * 10
 )
 
 var (
@@ -104,6 +110,17 @@ func TestNew(t *testing.T) {
 					t.Errorf("expected form server force TLS to be %t, got %t", testFormServerForceTLS,
 						config.Server.ForceTLS)
 				}
+				if config.Server.Timeout != testFormServerTimeout {
+					t.Errorf("expected form server timeout to be %s, got %s", testFormServerTimeout,
+						config.Server.Timeout)
+				}
+				if config.Delivery.Order != "confirmation-first" || config.Delivery.Confirmation != "mandatory" {
+					t.Errorf("expected default delivery confirmation-first/mandatory, got %s/%s",
+						config.Delivery.Order, config.Delivery.Confirmation)
+				}
+				if config.Transport.Type != "smtp" {
+					t.Errorf("expected default transport smtp, got %s", config.Transport.Type)
+				}
 				if config.Confirmation.Enabled != testFormConfirmationEnabled {
 					t.Errorf("expected form confirmation to be %t, got %t", testFormConfirmationEnabled,
 						config.Confirmation.Enabled)
@@ -173,4 +190,249 @@ func TestNew(t *testing.T) {
 			t.Fatal("expected error when reading incomplete form")
 		}
 	})
+	t.Run("reading form fails due to invalid ALTCHA max number", func(t *testing.T) {
+		dir := t.TempDir()
+		content := `id = "altcha"
+domains = ["example.com"]
+recipients = ["contact@example.com"]
+secret = "secret"
+sender = "no-reply@example.com"
+
+[server]
+host = "smtp.example.com"
+
+[validation.altcha]
+enabled = true
+max_number = -1
+`
+		if err := os.WriteFile(filepath.Join(dir, "altcha.toml"), []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write form: %s", err)
+		}
+		_, err := New(dir, "altcha")
+		if !errors.Is(err, ErrInvalidAltchaMaxNumber) {
+			t.Fatalf("expected error %s, got: %s", ErrInvalidAltchaMaxNumber, err)
+		}
+	})
+	t.Run("spam patterns are compiled when the form is read", func(t *testing.T) {
+		dir := t.TempDir()
+		content := `id = "spam"
+domains = ["example.com"]
+recipients = ["contact@example.com"]
+secret = "secret"
+sender = "no-reply@example.com"
+
+[server]
+host = "smtp.example.com"
+
+[validation.spam]
+patterns = ['(?i)casino']
+`
+		if err := os.WriteFile(filepath.Join(dir, "spam.toml"), []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write form: %s", err)
+		}
+		form, err := New(dir, "spam")
+		if err != nil {
+			t.Fatalf("failed to read form: %s", err)
+		}
+		first, err := form.Validation.Spam.CompiledPatterns()
+		if err != nil || len(first) != 1 {
+			t.Fatalf("expected 1 compiled pattern, got: %d, %v", len(first), err)
+		}
+		second, err := form.Validation.Spam.CompiledPatterns()
+		if err != nil || second[0] != first[0] {
+			t.Error("expected compiled patterns to be reused")
+		}
+
+		content = strings.Replace(content, "(?i)casino", "(", 1)
+		if err = os.WriteFile(filepath.Join(dir, "spam.toml"), []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write form: %s", err)
+		}
+		if _, err = New(dir, "spam"); !errors.Is(err, ErrInvalidSpamPattern) {
+			t.Errorf("expected error %s, got: %s", ErrInvalidSpamPattern, err)
+		}
+	})
+	t.Run("reading form fails with unknown spam script", func(t *testing.T) {
+		dir := t.TempDir()
+		content := `id = "spam"
+domains = ["example.com"]
+recipients = ["contact@example.com"]
+secret = "secret"
+sender = "no-reply@example.com"
+
+[server]
+host = "smtp.example.com"
+
+[validation.spam]
+allowed_scripts = ["Klingon"]
+`
+		if err := os.WriteFile(filepath.Join(dir, "spam.toml"), []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write form: %s", err)
+		}
+		if _, err := New(dir, "spam"); !errors.Is(err, ErrUnknownSpamScript) {
+			t.Errorf("expected error %s, got: %s", ErrUnknownSpamScript, err)
+		}
+	})
+	t.Run("reading form fails with invalid reCaptcha enterprise endpoint", func(t *testing.T) {
+		endpoints := []string{
+			"https://recaptcha.example.com/v1/assessments",
+			"https://recaptcha.example.com/v1/projects/%s/%s/assessments",
+			"https://recaptcha.example.com/v1/projects/%d/assessments",
+			"https://recaptcha.example.com/v1/projects/%s/assessments%2F",
+		}
+		for _, endpoint := range endpoints {
+			dir := t.TempDir()
+			content := `id = "recaptcha"
+domains = ["example.com"]
+recipients = ["contact@example.com"]
+secret = "secret"
+sender = "no-reply@example.com"
+
+[server]
+host = "smtp.example.com"
+
+[validation.recaptcha]
+enterprise_endpoint = "` + endpoint + `"
+`
+			if err := os.WriteFile(filepath.Join(dir, "recaptcha.toml"), []byte(content), 0o600); err != nil {
+				t.Fatalf("failed to write form: %s", err)
+			}
+			if _, err := New(dir, "recaptcha"); !errors.Is(err, ErrInvalidRecaptchaURL) {
+				t.Errorf("expected error %s for %s, got: %s", ErrInvalidRecaptchaURL, endpoint, err)
+			}
+		}
+	})
+	t.Run("reading form fails without mail server", func(t *testing.T) {
+		dir := t.TempDir()
+		content := `id = "nomailserver"
+domains = ["example.com"]
+recipients = ["contact@example.com"]
+secret = "secret"
+sender = "no-reply@example.com"
+`
+		if err := os.WriteFile(filepath.Join(dir, "nomailserver.toml"), []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write form: %s", err)
+		}
+		if _, err := New(dir, "nomailserver"); !errors.Is(err, ErrNoMailServer) {
+			t.Errorf("expected error %s, got: %s", ErrNoMailServer, err)
+		}
+		content += "\n[transport]\ntype = \"maildir\"\npath = \"/var/mail/forms\"\n"
+		if err := os.WriteFile(filepath.Join(dir, "nomailserver.toml"), []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write form: %s", err)
+		}
+		if _, err := New(dir, "nomailserver"); err != nil {
+			t.Errorf("expected form with local transport to be valid, got: %s", err)
+		}
+	})
+	t.Run("reading form fails with confirmation and maildir or mbox transport", func(t *testing.T) {
+		for _, transport := range []string{"maildir", "mbox"} {
+			dir := t.TempDir()
+			content := `id = "confirmation"
+domains = ["example.com"]
+recipients = ["contact@example.com"]
+secret = "secret"
+sender = "no-reply@example.com"
+
+[confirmation]
+enabled = true
+rcpt_field = "email"
+
+[transport]
+type = "` + transport + `"
+path = "/var/mail/forms"
+`
+			if err := os.WriteFile(filepath.Join(dir, "confirmation.toml"), []byte(content), 0o600); err != nil {
+				t.Fatalf("failed to write form: %s", err)
+			}
+			if _, err := New(dir, "confirmation"); !errors.Is(err, ErrConfirmationTransport) {
+				t.Errorf("expected error %s for %s transport, got: %s", ErrConfirmationTransport, transport, err)
+			}
+		}
+	})
+	t.Run("routing rules are read", func(t *testing.T) {
+		dir := t.TempDir()
+		content := `id = "routing"
+domains = ["example.com"]
+recipients = ["contact@example.com"]
+secret = "secret"
+sender = "no-reply@example.com"
+
+[server]
+host = "smtp.example.com"
+
+[[routing.rules]]
+field = "department"
+value = "sales"
+recipients = ["sales@example.com"]
+
+[[routing.rules]]
+field = "department"
+match = "in"
+values = ["billing", "accounting"]
+cc = ["billing@example.com"]
+
+[routing.default]
+recipients = ["office@example.com"]
+bcc = ["archive@example.com"]
+`
+		if err := os.WriteFile(filepath.Join(dir, "routing.toml"), []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write form: %s", err)
+		}
+		config, err := New(dir, "routing")
+		if err != nil {
+			t.Fatalf("failed to read form: %s", err)
+		}
+		rules := config.Routing.Rules
+		if len(rules) != 2 {
+			t.Fatalf("expected 2 routing rules, got %d", len(rules))
+		}
+		if rules[0].Match != "exact" || rules[0].Value != "sales" || len(rules[0].Recipients) != 1 {
+			t.Errorf("expected exact routing rule for sales, got %+v", rules[0])
+		}
+		if rules[1].Match != "in" || len(rules[1].Values) != 2 || len(rules[1].CC) != 1 {
+			t.Errorf("expected in-list routing rule with CC, got %+v", rules[1])
+		}
+		if len(config.Routing.Default.Recipients) != 1 || len(config.Routing.Default.BCC) != 1 {
+			t.Errorf("expected default routing target with BCC, got %+v", config.Routing.Default)
+		}
+	})
+	t.Run("routing patterns are compiled when the form is read", func(t *testing.T) {
+		dir := t.TempDir()
+		content := `id = "routing"
+domains = ["example.com"]
+recipients = ["contact@example.com"]
+secret = "secret"
+sender = "no-reply@example.com"
+
+[server]
+host = "smtp.example.com"
+
+[[routing.rules]]
+field = "department"
+match = "regex"
+value = "^(sales|billing)$"
+`
+		if err := os.WriteFile(filepath.Join(dir, "routing.toml"), []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write form: %s", err)
+		}
+		config, err := New(dir, "routing")
+		if err != nil {
+			t.Fatalf("failed to read form: %s", err)
+		}
+		first, err := config.Routing.Rules[0].Pattern()
+		if err != nil {
+			t.Fatalf("failed to get routing pattern: %s", err)
+		}
+		second, err := config.Routing.Rules[0].Pattern()
+		if err != nil || second != first {
+			t.Error("expected compiled routing pattern to be reused")
+		}
+
+		content = strings.Replace(content, "^(sales|billing)$", "(", 1)
+		if err = os.WriteFile(filepath.Join(dir, "routing.toml"), []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write form: %s", err)
+		}
+		if _, err = New(dir, "routing"); !errors.Is(err, ErrInvalidRoutingPattern) {
+			t.Errorf("expected error %s, got: %s", ErrInvalidRoutingPattern, err)
+		}
+	})
 }
@@ -0,0 +1,195 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+// Package geoip implements lookups of client addresses in local MaxMind databases (e.g. GeoLite2
+// Country and ASN). The databases are reloaded when the files change.
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/wneessen/js-mailer/internal/logger"
+)
+
+// DefaultReloadInterval is the default interval in which the database files are checked for changes
+const DefaultReloadInterval = time.Minute
+
+var (
+	// ErrNoDatabase is returned if no database file was configured
+	ErrNoDatabase = errors.New("no geoip database configured")
+
+	// ErrNotLoaded is returned by lookups if none of the database files could be loaded yet
+	ErrNotLoaded = errors.New("no geoip database loaded")
+)
+
+// Reader looks up addresses in one or more MaxMind databases
+type Reader struct {
+	databases []*database
+	interval  time.Duration
+	log       *slog.Logger
+	stop      chan struct{}
+}
+
+// Record is the result of a lookup. Fields are empty if the databases have no data for them.
+type Record struct {
+	Country        string
+	ASN            uint
+	ASOrganization string
+}
+
+// database is a single MaxMind database file
+type database struct {
+	path    string
+	mu      sync.RWMutex
+	reader  *maxminddb.Reader
+	modTime time.Time
+	size    int64
+}
+
+// mmdbRecord holds the fields of the GeoLite2/GeoIP2 Country and ASN databases
+type mmdbRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+// New opens the database files. Empty paths are ignored. Database files that can't be loaded are
+// logged and retried independently of each other by the reload goroutine.
+func New(interval time.Duration, log *slog.Logger, paths ...string) (*Reader, error) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	reader := &Reader{interval: interval, log: log, stop: make(chan struct{})}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		db := &database{path: path}
+		if _, err := db.reload(); err != nil && log != nil {
+			log.Error("failed to load geoip database, retrying on reload", slog.String("path", path),
+				logger.Err(err))
+		}
+		reader.databases = append(reader.databases, db)
+	}
+	if len(reader.databases) == 0 {
+		return nil, ErrNoDatabase
+	}
+	return reader, nil
+}
+
+// Start starts the goroutine that reloads changed database files.
+func (r *Reader) Start() {
+	go r.reloadLoop()
+}
+
+// Stop stops the reload goroutine.
+func (r *Reader) Stop() {
+	close(r.stop)
+}
+
+// Lookup looks up the address in all loaded databases and merges the results. Databases that
+// could not be loaded yet are skipped.
+func (r *Reader) Lookup(addr netip.Addr) (*Record, error) {
+	record := &Record{}
+	ip := net.IP(addr.Unmap().AsSlice())
+	loaded := false
+	for _, db := range r.databases {
+		var result mmdbRecord
+		db.mu.RLock()
+		if db.reader == nil {
+			db.mu.RUnlock()
+			continue
+		}
+		loaded = true
+		err := db.reader.Lookup(ip, &result)
+		db.mu.RUnlock()
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up address in %s: %w", db.path, err)
+		}
+		if result.Country.ISOCode != "" {
+			record.Country = result.Country.ISOCode
+		}
+		if result.AutonomousSystemNumber != 0 {
+			record.ASN = result.AutonomousSystemNumber
+			record.ASOrganization = result.AutonomousSystemOrganization
+		}
+	}
+	if !loaded {
+		return nil, ErrNotLoaded
+	}
+	return record, nil
+}
+
+// Reload reloads all database files that changed since they were loaded.
+func (r *Reader) Reload() error {
+	var errs []error
+	for _, db := range r.databases {
+		reloaded, err := db.reload()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if reloaded && r.log != nil {
+			r.log.Info("geoip database reloaded", slog.String("path", db.path))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Reader) reloadLoop() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Reload(); err != nil && r.log != nil {
+				r.log.Error("failed to reload geoip database", logger.Err(err))
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// reload loads the database file if it changed since it was loaded. The previous database stays
+// in use if the new file can't be loaded.
+func (d *database) reload() (bool, error) {
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat geoip database: %w", err)
+	}
+	d.mu.RLock()
+	unchanged := d.reader != nil && info.ModTime().Equal(d.modTime) && info.Size() == d.size
+	d.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	buf, err := os.ReadFile(d.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read geoip database: %w", err)
+	}
+	reader, err := maxminddb.FromBytes(buf)
+	if err != nil {
+		return false, fmt.Errorf("failed to open geoip database %s: %w", d.path, err)
+	}
+
+	d.mu.Lock()
+	d.reader = reader
+	d.modTime = info.ModTime()
+	d.size = info.Size()
+	d.mu.Unlock()
+	return true, nil
+}
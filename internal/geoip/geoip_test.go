@@ -0,0 +1,198 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package geoip
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wneessen/js-mailer/internal/testhelper"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("new opens databases", func(t *testing.T) {
+		countryDB, asnDB := testDatabases(t)
+		reader, err := New(0, nil, countryDB, "", asnDB)
+		if err != nil {
+			t.Fatalf("failed to open databases: %s", err)
+		}
+		if len(reader.databases) != 2 {
+			t.Errorf("expected 2 databases, got %d", len(reader.databases))
+		}
+		if reader.interval != DefaultReloadInterval {
+			t.Errorf("expected reload interval to be %s, got %s", DefaultReloadInterval, reader.interval)
+		}
+	})
+	t.Run("new without databases fails", func(t *testing.T) {
+		_, err := New(0, nil, "", "")
+		if !errors.Is(err, ErrNoDatabase) {
+			t.Errorf("expected error to be %s, got: %s", ErrNoDatabase, err)
+		}
+	})
+	t.Run("new with missing database retries loading it", func(t *testing.T) {
+		countryDB, _ := testDatabases(t)
+		missing := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+		reader, err := New(0, nil, missing)
+		if err != nil {
+			t.Fatalf("failed to create reader: %s", err)
+		}
+		if _, err = reader.Lookup(netip.MustParseAddr("192.0.2.10")); !errors.Is(err, ErrNotLoaded) {
+			t.Errorf("expected error to be %s, got: %v", ErrNotLoaded, err)
+		}
+		content, err := os.ReadFile(countryDB)
+		if err != nil {
+			t.Fatalf("failed to read database: %s", err)
+		}
+		if err = os.WriteFile(missing, content, 0o600); err != nil {
+			t.Fatalf("failed to write database: %s", err)
+		}
+		if err = reader.Reload(); err != nil {
+			t.Fatalf("failed to reload database: %s", err)
+		}
+		record, err := reader.Lookup(netip.MustParseAddr("192.0.2.10"))
+		if err != nil || record.Country != "DE" {
+			t.Errorf("expected country DE after reload, got: %+v, %v", record, err)
+		}
+	})
+	t.Run("new with invalid database uses the other databases", func(t *testing.T) {
+		countryDB, _ := testDatabases(t)
+		path := filepath.Join(t.TempDir(), "invalid.mmdb")
+		if err := os.WriteFile(path, []byte("invalid"), 0o600); err != nil {
+			t.Fatalf("failed to write database: %s", err)
+		}
+		reader, err := New(0, nil, countryDB, path)
+		if err != nil {
+			t.Fatalf("failed to create reader: %s", err)
+		}
+		record, err := reader.Lookup(netip.MustParseAddr("192.0.2.10"))
+		if err != nil || record.Country != "DE" {
+			t.Errorf("expected country DE from the valid database, got: %+v, %v", record, err)
+		}
+		if err = reader.Reload(); err == nil {
+			t.Error("expected reload of the invalid database to fail")
+		}
+	})
+}
+
+func TestReader_Lookup(t *testing.T) {
+	countryDB, asnDB := testDatabases(t)
+	reader, err := New(0, nil, countryDB, asnDB)
+	if err != nil {
+		t.Fatalf("failed to open databases: %s", err)
+	}
+	tests := []struct {
+		name string
+		addr string
+		want Record
+	}{
+		{"address with country and ASN", "192.0.2.10", Record{"DE", 64500, "Example Hosting"}},
+		{"address with country only", "198.51.100.1", Record{Country: "AT"}},
+		{"IPv4-mapped address", "::ffff:192.0.2.10", Record{"DE", 64500, "Example Hosting"}},
+		{"unknown address", "203.0.113.1", Record{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := reader.Lookup(netip.MustParseAddr(tt.addr))
+			if err != nil {
+				t.Fatalf("lookup failed: %s", err)
+			}
+			if *record != tt.want {
+				t.Errorf("expected record %+v, got %+v", tt.want, *record)
+			}
+		})
+	}
+}
+
+func TestReader_Reload(t *testing.T) {
+	t.Run("changed database is reloaded", func(t *testing.T) {
+		countryDB, _ := testDatabases(t)
+		buf := bytes.NewBuffer(nil)
+		log := slog.New(slog.NewTextHandler(buf, nil))
+		reader, err := New(time.Millisecond*10, log, countryDB)
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err)
+		}
+		reader.Start()
+		defer reader.Stop()
+
+		testhelper.WriteMMDB(t, countryDB, "GeoLite2-Country", map[string]testhelper.MMDBRecord{
+			"192.0.2.0/24": {Country: "CH"},
+		})
+		modTime := time.Now().Add(time.Second)
+		if err = os.Chtimes(countryDB, modTime, modTime); err != nil {
+			t.Fatalf("failed to change modification time: %s", err)
+		}
+
+		deadline := time.Now().Add(time.Second * 5)
+		for time.Now().Before(deadline) {
+			record, err := reader.Lookup(netip.MustParseAddr("192.0.2.10"))
+			if err != nil {
+				t.Fatalf("lookup failed: %s", err)
+			}
+			if record.Country == "CH" {
+				return
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		t.Error("expected database to be reloaded")
+	})
+	t.Run("invalid database keeps the previous database", func(t *testing.T) {
+		countryDB, _ := testDatabases(t)
+		reader, err := New(0, nil, countryDB)
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err)
+		}
+		if err = os.WriteFile(countryDB, []byte("invalid"), 0o600); err != nil {
+			t.Fatalf("failed to write database: %s", err)
+		}
+		if err = reader.Reload(); err == nil || !strings.Contains(err.Error(), "failed to open geoip database") {
+			t.Errorf("expected reload to fail, got: %s", err)
+		}
+		record, err := reader.Lookup(netip.MustParseAddr("192.0.2.10"))
+		if err != nil {
+			t.Fatalf("lookup failed: %s", err)
+		}
+		if record.Country != "DE" {
+			t.Errorf("expected previous database to be used, got country %q", record.Country)
+		}
+	})
+	t.Run("unchanged database is not reloaded", func(t *testing.T) {
+		countryDB, _ := testDatabases(t)
+		reader, err := New(0, nil, countryDB)
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err)
+		}
+		reloaded, err := reader.databases[0].reload()
+		if err != nil {
+			t.Fatalf("reload failed: %s", err)
+		}
+		if reloaded {
+			t.Error("expected unchanged database not to be reloaded")
+		}
+	})
+}
+
+// testDatabases writes a country and an ASN test database and returns their paths
+func testDatabases(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	countryDB := filepath.Join(dir, "GeoLite2-Country.mmdb")
+	asnDB := filepath.Join(dir, "GeoLite2-ASN.mmdb")
+	testhelper.WriteMMDB(t, countryDB, "GeoLite2-Country", map[string]testhelper.MMDBRecord{
+		"192.0.2.0/24":    {Country: "DE"},
+		"198.51.100.0/24": {Country: "AT"},
+	})
+	testhelper.WriteMMDB(t, asnDB, "GeoLite2-ASN", map[string]testhelper.MMDBRecord{
+		"192.0.2.0/25": {ASN: 64500, Organization: "Example Hosting"},
+	})
+	return countryDB, asnDB
+}
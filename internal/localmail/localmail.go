@@ -0,0 +1,195 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+// Package localmail implements the delivery of mails to local targets without an SMTP submission
+// server: an LMTP server on a Unix socket (e.g. Dovecot), a Maildir or an mbox file.
+package localmail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrLMTP is returned if the LMTP server rejected a command or the mail for a recipient
+	ErrLMTP = errors.New("LMTP delivery failed")
+
+	// ErrNoRecipients is returned if a mail should be delivered via LMTP without any recipient
+	ErrNoRecipients = errors.New("no recipients")
+)
+
+var (
+	// maildirCounter makes the names of Maildir files unique within the process
+	maildirCounter atomic.Uint64
+
+	// mboxLock serializes the writes to mbox files within the process
+	mboxLock sync.Mutex
+
+	// fromLine matches the lines of a message that need to be quoted in an mbox file (mboxrd)
+	fromLine = regexp.MustCompile(`(?m)^(>*From )`)
+)
+
+// DeliverLMTP delivers the message to the recipients via the LMTP server on the Unix socket and
+// returns the last response of the server. The delivery fails if the server rejects the mail for
+// any of the recipients.
+func DeliverLMTP(ctx context.Context, socket, lhlo, from string, recipients []string, message []byte) (string, error) {
+	if len(recipients) == 0 {
+		return "", ErrNoRecipients
+	}
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to LMTP socket: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			return "", fmt.Errorf("failed to set LMTP connection deadline: %w", err)
+		}
+	}
+
+	text := textproto.NewConn(conn)
+	if _, _, err = text.ReadResponse(220); err != nil {
+		return "", fmt.Errorf("%w: greeting: %w", ErrLMTP, err)
+	}
+	if err = lmtpCommand(text, 250, "LHLO %s", lhlo); err != nil {
+		return "", err
+	}
+	if err = lmtpCommand(text, 250, "MAIL FROM:<%s>", from); err != nil {
+		return "", err
+	}
+	for _, recipient := range recipients {
+		if err = lmtpCommand(text, 250, "RCPT TO:<%s>", recipient); err != nil {
+			return "", err
+		}
+	}
+	if err = lmtpCommand(text, 354, "DATA"); err != nil {
+		return "", err
+	}
+	writer := text.DotWriter()
+	if _, err = writer.Write(message); err != nil {
+		return "", fmt.Errorf("failed to write message: %w", err)
+	}
+	if err = writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to finish message: %w", err)
+	}
+
+	// LMTP returns a response for every recipient after the data
+	var response string
+	var errs []error
+	for _, recipient := range recipients {
+		_, message, err := text.ReadResponse(250)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w for %s: %w", ErrLMTP, recipient, err))
+			continue
+		}
+		response = message
+	}
+	_ = lmtpCommand(text, 221, "QUIT")
+	return response, errors.Join(errs...)
+}
+
+// lmtpCommand sends the command and reads the response, that is expected to have the code
+func lmtpCommand(text *textproto.Conn, code int, format string, args ...any) error {
+	id, err := text.Cmd(format, args...)
+	if err != nil {
+		return fmt.Errorf("failed to send LMTP command: %w", err)
+	}
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+	if _, _, err = text.ReadResponse(code); err != nil {
+		command, _, _ := strings.Cut(format, " ")
+		return fmt.Errorf("%w: %s: %w", ErrLMTP, command, err)
+	}
+	return nil
+}
+
+// DeliverMaildir writes the message into the new directory of the Maildir and returns the path of
+// the message file. The Maildir is created if it doesn't exist.
+func DeliverMaildir(dir string, message []byte) (string, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return "", fmt.Errorf("failed to create Maildir: %w", err)
+		}
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	hostname = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(hostname)
+	now := time.Now()
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(),
+		maildirCounter.Add(1), hostname)
+
+	tmpPath := filepath.Join(dir, "tmp", name)
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create Maildir file: %w", err)
+	}
+	_, err = file.Write(unixLineEndings(message))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to write Maildir file: %w", err)
+	}
+	newPath := filepath.Join(dir, "new", name)
+	if err = os.Rename(tmpPath, newPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to move Maildir file to new: %w", err)
+	}
+	return newPath, nil
+}
+
+// DeliverMbox appends the message to the mbox file in mboxrd format. The file is created if it
+// doesn't exist. Writes are only serialized within the process, so the mbox file must not be
+// modified by other programs while js-mailer delivers to it.
+func DeliverMbox(path, from string, message []byte) error {
+	if from == "" {
+		from = "MAILER-DAEMON"
+	}
+	buf := bytes.NewBufferString(fmt.Sprintf("From %s %s\n", from, time.Now().UTC().Format(time.ANSIC)))
+	body := fromLine.ReplaceAll(unixLineEndings(message), []byte(">$1"))
+	buf.Write(body)
+	if !bytes.HasSuffix(body, []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+
+	mboxLock.Lock()
+	defer mboxLock.Unlock()
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open mbox file: %w", err)
+	}
+	_, err = file.Write(buf.Bytes())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write mbox file: %w", err)
+	}
+	return nil
+}
+
+// unixLineEndings returns the message with LF instead of CRLF line endings, as they are expected
+// in Maildir and mbox files
+func unixLineEndings(message []byte) []byte {
+	return bytes.ReplaceAll(message, []byte("\r\n"), []byte("\n"))
+}
@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package localmail
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testMessage = "From: no-reply@example.com\r\nTo: test@example.com\r\nSubject: Test\r\n\r\n" +
+	"This is a test message\r\nFrom here on it is quoted\r\n.\r\n"
+
+func TestDeliverLMTP(t *testing.T) {
+	t.Run("message is delivered to all recipients", func(t *testing.T) {
+		socket, received := lmtpServer(t, nil)
+		response, err := DeliverLMTP(context.Background(), socket, "forms.example.com", "no-reply@example.com",
+			[]string{"first@example.com", "second@example.com"}, []byte(testMessage))
+		if err != nil {
+			t.Fatalf("failed to deliver message: %s", err)
+		}
+		if !strings.Contains(response, "second@example.com") {
+			t.Errorf("expected response for the last recipient, got: %s", response)
+		}
+		data := <-received
+		if data != testMessage {
+			t.Errorf("expected message %q, got %q", testMessage, data)
+		}
+	})
+	t.Run("rejected recipient fails the delivery", func(t *testing.T) {
+		socket, _ := lmtpServer(t, []string{"second@example.com"})
+		_, err := DeliverLMTP(context.Background(), socket, "forms.example.com", "no-reply@example.com",
+			[]string{"first@example.com", "second@example.com"}, []byte(testMessage))
+		if !errors.Is(err, ErrLMTP) {
+			t.Errorf("expected error to be %s, got: %s", ErrLMTP, err)
+		}
+	})
+	t.Run("missing socket fails", func(t *testing.T) {
+		_, err := DeliverLMTP(context.Background(), filepath.Join(t.TempDir(), "missing"), "localhost",
+			"no-reply@example.com", []string{"test@example.com"}, []byte(testMessage))
+		if err == nil {
+			t.Error("expected delivery to a missing socket to fail")
+		}
+	})
+	t.Run("delivery without recipients fails", func(t *testing.T) {
+		_, err := DeliverLMTP(context.Background(), "unused", "localhost", "no-reply@example.com", nil,
+			[]byte(testMessage))
+		if !errors.Is(err, ErrNoRecipients) {
+			t.Errorf("expected error to be %s, got: %s", ErrNoRecipients, err)
+		}
+	})
+}
+
+func TestDeliverMaildir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Maildir")
+	first, err := DeliverMaildir(dir, []byte(testMessage))
+	if err != nil {
+		t.Fatalf("failed to deliver message: %s", err)
+	}
+	second, err := DeliverMaildir(dir, []byte(testMessage))
+	if err != nil {
+		t.Fatalf("failed to deliver message: %s", err)
+	}
+	if first == second {
+		t.Errorf("expected unique message files, got %s twice", first)
+	}
+	if filepath.Dir(first) != filepath.Join(dir, "new") {
+		t.Errorf("expected message file in new directory, got %s", first)
+	}
+	content, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatalf("failed to read message file: %s", err)
+	}
+	if string(content) != strings.ReplaceAll(testMessage, "\r\n", "\n") {
+		t.Errorf("expected message with LF line endings, got %q", content)
+	}
+	for _, sub := range []string{"tmp", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			t.Fatalf("failed to read Maildir %s directory: %s", sub, err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("expected empty %s directory, got %d entries", sub, len(entries))
+		}
+	}
+}
+
+func TestDeliverMbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	for _, from := range []string{"no-reply@example.com", ""} {
+		if err := DeliverMbox(path, from, []byte(testMessage)); err != nil {
+			t.Fatalf("failed to deliver message: %s", err)
+		}
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read mbox file: %s", err)
+	}
+	var separators []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "From ") {
+			separators = append(separators, strings.Fields(line)[1])
+		}
+	}
+	if !slices.Equal(separators, []string{"no-reply@example.com", "MAILER-DAEMON"}) {
+		t.Errorf("expected 2 messages from no-reply@example.com and MAILER-DAEMON, got %v", separators)
+	}
+	if strings.Count(string(content), "\n>From here on it is quoted\n") != 2 {
+		t.Errorf("expected From lines in the body to be quoted, got: %s", content)
+	}
+	if strings.Contains(string(content), "\r") {
+		t.Error("expected mbox file with LF line endings")
+	}
+}
+
+// lmtpServer starts an LMTP stand-in on a Unix socket that rejects the given recipients after the
+// data and returns the socket path and a channel with the received message data
+func lmtpServer(t *testing.T, reject []string) (string, <-chan string) {
+	t.Helper()
+	// Unix socket paths are limited in length, so the socket is not placed in t.TempDir
+	dir, err := os.MkdirTemp("", "lmtp")
+	if err != nil {
+		t.Fatalf("failed to create socket directory: %s", err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "lmtp.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen on socket: %s", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		text := textproto.NewConn(conn)
+		_ = text.PrintfLine("220 localhost LMTP stand-in")
+		var recipients []string
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			command, argument, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(command) {
+			case "LHLO":
+				_ = text.PrintfLine("250-localhost\r\n250 PIPELINING")
+			case "MAIL":
+				_ = text.PrintfLine("250 2.1.0 ok")
+			case "RCPT":
+				_, path, _ := strings.Cut(argument, ":")
+				recipients = append(recipients, strings.Trim(path, "<>"))
+				_ = text.PrintfLine("250 2.1.5 ok")
+			case "DATA":
+				_ = text.PrintfLine("354 go ahead")
+				data, err := text.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				received <- strings.ReplaceAll(string(data), "\n", "\r\n")
+				for _, recipient := range recipients {
+					if slices.Contains(reject, recipient) {
+						_ = text.PrintfLine("550 5.1.1 <%s> mailbox unavailable", recipient)
+						continue
+					}
+					_ = text.PrintfLine("250 2.0.0 <%s> saved", recipient)
+				}
+			case "QUIT":
+				_ = text.PrintfLine("221 2.0.0 bye")
+				return
+			}
+		}
+	}()
+	return socket, received
+}
@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+// Package lookup implements the DNS resolver setup and the result cache that are shared by the
+// DNS based checks
+package lookup
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxCacheEntries is the cache size at which expired entries are purged
+const maxCacheEntries = 10000
+
+// Cache caches lookup results for a fixed time to live
+type Cache[V any] struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry[V]
+}
+
+type cacheEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// NewResolver returns the system resolver if address is empty, otherwise a resolver that sends
+// all queries to the resolver at the given host:port.
+func NewResolver(address string) *net.Resolver {
+	if address == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			dialer := net.Dialer{}
+			return dialer.DialContext(ctx, network, address)
+		},
+	}
+}
+
+// IsNotFound returns true if the error is a DNS error for a non-existing domain or record
+func IsNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// NewCache returns a new cache whose entries expire after the given time to live
+func NewCache[V any](ttl time.Duration) *Cache[V] {
+	return &Cache[V]{ttl: ttl, entries: make(map[string]cacheEntry[V])}
+}
+
+// TTL returns the time to live of the cache entries
+func (c *Cache[V]) TTL() time.Duration {
+	return c.ttl
+}
+
+// Len returns the number of cached entries, including expired entries that were not purged yet
+func (c *Cache[V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Get returns the cached value for the key and true if it exists and has not expired
+func (c *Cache[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var value V
+	entry, ok := c.entries[key]
+	if !ok {
+		return value, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return value, false
+	}
+	return entry.value, true
+}
+
+// Set caches the value for the key and purges expired entries if the cache grows large
+func (c *Cache[V]) Set(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= maxCacheEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = cacheEntry[V]{value: value, expires: now.Add(c.ttl)}
+}
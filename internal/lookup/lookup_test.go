@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package lookup
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/wneessen/js-mailer/internal/testhelper"
+)
+
+func TestNewResolver(t *testing.T) {
+	t.Run("empty address uses the system resolver", func(t *testing.T) {
+		if resolver := NewResolver(""); resolver != net.DefaultResolver {
+			t.Error("expected system resolver")
+		}
+	})
+	t.Run("queries are sent to the given resolver", func(t *testing.T) {
+		address := testhelper.DNSServer(t, map[string]string{"host.example.": "192.0.2.1"})
+		addrs, err := NewResolver(address).LookupHost(t.Context(), "host.example.")
+		if err != nil {
+			t.Fatalf("lookup failed: %s", err)
+		}
+		if len(addrs) != 1 || addrs[0] != "192.0.2.1" {
+			t.Errorf("expected address 192.0.2.1, got %v", addrs)
+		}
+	})
+}
+
+func TestIsNotFound(t *testing.T) {
+	if !IsNotFound(&net.DNSError{Err: "no such host", IsNotFound: true}) {
+		t.Error("expected not found DNS error to be detected")
+	}
+	if IsNotFound(&net.DNSError{Err: "server misbehaving"}) {
+		t.Error("expected other DNS error not to be detected")
+	}
+	if IsNotFound(errors.New("failed")) {
+		t.Error("expected other error not to be detected")
+	}
+}
+
+func TestCache(t *testing.T) {
+	t.Run("cached values are returned", func(t *testing.T) {
+		cache := NewCache[bool](time.Minute)
+		if _, ok := cache.Get("key"); ok {
+			t.Error("expected missing key not to be cached")
+		}
+		cache.Set("key", true)
+		value, ok := cache.Get("key")
+		if !ok || !value {
+			t.Errorf("expected cached value true, got %t (cached: %t)", value, ok)
+		}
+		if cache.Len() != 1 {
+			t.Errorf("expected 1 cached entry, got %d", cache.Len())
+		}
+	})
+	t.Run("expired values are removed", func(t *testing.T) {
+		cache := NewCache[bool](time.Millisecond)
+		cache.Set("key", true)
+		time.Sleep(time.Millisecond * 5)
+		if _, ok := cache.Get("key"); ok {
+			t.Error("expected expired value not to be returned")
+		}
+		if cache.Len() != 0 {
+			t.Errorf("expected expired entry to be removed, got %d entries", cache.Len())
+		}
+	})
+	t.Run("expired values are purged when the cache grows large", func(t *testing.T) {
+		cache := NewCache[int](time.Millisecond)
+		for i := range maxCacheEntries {
+			cache.Set(strconv.Itoa(i), i)
+		}
+		time.Sleep(time.Millisecond * 5)
+		cache.Set("key", 1)
+		if cache.Len() != 1 {
+			t.Errorf("expected expired entries to be purged, got %d entries", cache.Len())
+		}
+	})
+}
@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+// Package pgp implements the encryption of MIME entities to the OpenPGP public keys of the
+// recipients for PGP/MIME (RFC 3156). The encrypted entity can optionally be signed with a
+// private key.
+package pgp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// messageType is the armor block type of an OpenPGP message
+const messageType = "PGP MESSAGE"
+
+var (
+	// ErrNoRecipients is returned if the content should be encrypted without any recipient
+	ErrNoRecipients = errors.New("no recipient keys")
+
+	// ErrNoEncryptionKey is returned if a recipient key can't be used for encryption
+	ErrNoEncryptionKey = errors.New("no valid encryption key")
+
+	// ErrNoPrivateKey is returned if a key file contains no private key
+	ErrNoPrivateKey = errors.New("no private key found")
+)
+
+// Encrypt encrypts the MIME entity (headers and body) to the public keys of the recipients and
+// returns the ASCII armored OpenPGP message. If signer is not nil, the entity is signed with its
+// private key as well.
+func Encrypt(entity []byte, recipients openpgp.EntityList, signer *openpgp.Entity) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+	now := time.Now()
+	for _, recipient := range recipients {
+		if _, ok := recipient.EncryptionKey(now); !ok {
+			return nil, fmt.Errorf("%w: %X", ErrNoEncryptionKey, recipient.PrimaryKey.Fingerprint)
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	armored, err := armor.Encode(buf, messageType, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create armor encoder: %w", err)
+	}
+	plaintext, err := openpgp.Encrypt(armored, recipients, signer, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt entity: %w", err)
+	}
+	if _, err = plaintext.Write(entity); err != nil {
+		return nil, fmt.Errorf("failed to write entity: %w", err)
+	}
+	if err = plaintext.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish encryption: %w", err)
+	}
+	if err = armored.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish armor encoding: %w", err)
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// LoadPublicKeys loads all public keys from the ASCII armored key files.
+func LoadPublicKeys(paths ...string) (openpgp.EntityList, error) {
+	var keys openpgp.EntityList
+	for _, path := range paths {
+		entities, err := readKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, entities...)
+	}
+	return keys, nil
+}
+
+// LoadPrivateKey loads the first private key from the ASCII armored key file. If the private key
+// is protected, it is decrypted with the passphrase.
+func LoadPrivateKey(path, passphrase string) (*openpgp.Entity, error) {
+	entities, err := readKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, entity := range entities {
+		if entity.PrivateKey == nil {
+			continue
+		}
+		if err = entity.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt private key in %s: %w", path, err)
+		}
+		return entity, nil
+	}
+	return nil, fmt.Errorf("%w in %s", ErrNoPrivateKey, path)
+}
+
+// KeyFor returns the first key with a user ID for the email address.
+func KeyFor(keys openpgp.EntityList, address string) (*openpgp.Entity, bool) {
+	for _, key := range keys {
+		for _, identity := range key.Identities {
+			if identity.UserId != nil && strings.EqualFold(identity.UserId.Email, address) {
+				return key, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// readKeyFile reads all keys from the ASCII armored key file
+func readKeyFile(path string) (openpgp.EntityList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+	entities, err := openpgp.ReadArmoredKeyRing(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys in %s: %w", path, err)
+	}
+	return entities, nil
+}
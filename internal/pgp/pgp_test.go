@@ -0,0 +1,174 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package pgp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+
+	"github.com/wneessen/js-mailer/internal/testhelper"
+)
+
+const testEntity = "Content-Type: text/plain; charset=UTF-8\r\n\r\nThis is a test message\r\n"
+
+func TestEncrypt(t *testing.T) {
+	dir := t.TempDir()
+	firstPublic, firstPrivate := testhelper.WritePGPKey(t, dir, "first@example.com", "")
+	secondPublic, secondPrivate := testhelper.WritePGPKey(t, dir, "second@example.com", "")
+	_, signerPrivate := testhelper.WritePGPKey(t, dir, "signer@example.com", "secret")
+	keys, err := LoadPublicKeys(firstPublic, secondPublic)
+	if err != nil {
+		t.Fatalf("failed to load public keys: %s", err)
+	}
+	signer, err := LoadPrivateKey(signerPrivate, "secret")
+	if err != nil {
+		t.Fatalf("failed to load signing key: %s", err)
+	}
+
+	t.Run("every recipient can decrypt the entity", func(t *testing.T) {
+		armored, err := Encrypt([]byte(testEntity), keys, nil)
+		if err != nil {
+			t.Fatalf("failed to encrypt entity: %s", err)
+		}
+		if !bytes.HasPrefix(armored, []byte("-----BEGIN PGP MESSAGE-----")) {
+			t.Errorf("expected ASCII armored message, got: %s", armored)
+		}
+		for _, path := range []string{firstPrivate, secondPrivate} {
+			key, err := LoadPrivateKey(path, "")
+			if err != nil {
+				t.Fatalf("failed to load private key: %s", err)
+			}
+			details, content, err := decrypt(armored, openpgp.EntityList{key})
+			if err != nil {
+				t.Fatalf("failed to decrypt entity: %s", err)
+			}
+			if details.IsSigned {
+				t.Error("expected message not to be signed")
+			}
+			if !bytes.Equal(content, []byte(testEntity)) {
+				t.Errorf("expected decrypted entity %q, got %q", testEntity, content)
+			}
+		}
+	})
+	t.Run("signed entity is verified", func(t *testing.T) {
+		armored, err := Encrypt([]byte(testEntity), keys[:1], signer)
+		if err != nil {
+			t.Fatalf("failed to encrypt entity: %s", err)
+		}
+		key, err := LoadPrivateKey(firstPrivate, "")
+		if err != nil {
+			t.Fatalf("failed to load private key: %s", err)
+		}
+		details, content, err := decrypt(armored, openpgp.EntityList{key, signer})
+		if err != nil {
+			t.Fatalf("failed to decrypt entity: %s", err)
+		}
+		if !bytes.Equal(content, []byte(testEntity)) {
+			t.Errorf("expected decrypted entity %q, got %q", testEntity, content)
+		}
+		if !details.IsSigned || details.SignedBy == nil {
+			t.Fatal("expected message to be signed by a known key")
+		}
+		if details.SignatureError != nil {
+			t.Errorf("signature verification failed: %s", details.SignatureError)
+		}
+	})
+	t.Run("encryption without recipients fails", func(t *testing.T) {
+		if _, err := Encrypt([]byte(testEntity), nil, nil); !errors.Is(err, ErrNoRecipients) {
+			t.Errorf("expected error to be %s, got: %s", ErrNoRecipients, err)
+		}
+	})
+}
+
+func TestLoadPublicKeys(t *testing.T) {
+	dir := t.TempDir()
+	publicFile, _ := testhelper.WritePGPKey(t, dir, "rcpt@example.com", "")
+	t.Run("public key is loaded", func(t *testing.T) {
+		keys, err := LoadPublicKeys(publicFile)
+		if err != nil {
+			t.Fatalf("failed to load public keys: %s", err)
+		}
+		if len(keys) != 1 {
+			t.Errorf("expected 1 key, got %d", len(keys))
+		}
+	})
+	t.Run("missing file fails", func(t *testing.T) {
+		if _, err := LoadPublicKeys(filepath.Join(dir, "missing.asc")); err == nil {
+			t.Error("expected loading a missing key file to fail")
+		}
+	})
+	t.Run("invalid key file fails", func(t *testing.T) {
+		path := filepath.Join(dir, "invalid.asc")
+		if err := os.WriteFile(path, []byte("not a key"), 0o600); err != nil {
+			t.Fatalf("failed to write key file: %s", err)
+		}
+		if _, err := LoadPublicKeys(path); err == nil {
+			t.Error("expected loading an invalid key file to fail")
+		}
+	})
+}
+
+func TestLoadPrivateKey(t *testing.T) {
+	dir := t.TempDir()
+	publicFile, privateFile := testhelper.WritePGPKey(t, dir, "signer@example.com", "secret")
+	t.Run("protected private key is loaded", func(t *testing.T) {
+		key, err := LoadPrivateKey(privateFile, "secret")
+		if err != nil {
+			t.Fatalf("failed to load private key: %s", err)
+		}
+		if key.PrivateKey.Encrypted {
+			t.Error("expected private key to be decrypted")
+		}
+	})
+	t.Run("wrong passphrase fails", func(t *testing.T) {
+		if _, err := LoadPrivateKey(privateFile, "wrong"); err == nil {
+			t.Error("expected loading the private key with a wrong passphrase to fail")
+		}
+	})
+	t.Run("public key file fails", func(t *testing.T) {
+		if _, err := LoadPrivateKey(publicFile, ""); !errors.Is(err, ErrNoPrivateKey) {
+			t.Errorf("expected error to be %s, got: %s", ErrNoPrivateKey, err)
+		}
+	})
+}
+
+func TestKeyFor(t *testing.T) {
+	publicFile, _ := testhelper.WritePGPKey(t, t.TempDir(), "rcpt@example.com", "")
+	keys, err := LoadPublicKeys(publicFile)
+	if err != nil {
+		t.Fatalf("failed to load public keys: %s", err)
+	}
+	if _, ok := KeyFor(keys, "RCPT@example.com"); !ok {
+		t.Error("expected key for rcpt@example.com")
+	}
+	if _, ok := KeyFor(keys, "other@example.com"); ok {
+		t.Error("expected no key for other@example.com")
+	}
+}
+
+// decrypt decrypts the ASCII armored message with the keyring and returns the message details and
+// the decrypted content
+func decrypt(armored []byte, keyring openpgp.EntityList) (*openpgp.MessageDetails, []byte, error) {
+	block, err := armor.Decode(bytes.NewReader(armored))
+	if err != nil {
+		return nil, nil, err
+	}
+	details, err := openpgp.ReadMessage(block.Body, keyring, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := io.ReadAll(details.UnverifiedBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	return details, content, nil
+}
@@ -0,0 +1,178 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+	"github.com/wneessen/js-mailer/internal/logger"
+)
+
+const (
+	altchaSolutionField = "altcha"
+	altchaAlgorithm     = "SHA-256"
+	altchaSaltLen       = 12
+)
+
+var (
+	ErrAltchaFailed           = errors.New("ALTCHA validation failed")
+	ErrAltchaInvalidPayload   = errors.New("invalid ALTCHA payload")
+	ErrAltchaUnknownChallenge = errors.New("ALTCHA challenge was not issued for this token")
+	ErrAltchaInvalidSignature = errors.New("invalid ALTCHA challenge signature")
+	ErrAltchaWrongSolution    = errors.New("ALTCHA solution does not solve the challenge")
+	ErrAltchaExpired          = errors.New("ALTCHA challenge is expired")
+)
+
+// AltchaChallenge is an ALTCHA-compatible proof-of-work challenge. The client needs to find the
+// number between 0 and MaxNumber for which the SHA-256 hash of the salt followed by the number
+// equals the challenge.
+type AltchaChallenge struct {
+	Algorithm string `json:"algorithm"`
+	Challenge string `json:"challenge"`
+	MaxNumber int64  `json:"maxnumber"`
+	Salt      string `json:"salt"`
+	Signature string `json:"signature"`
+}
+
+// AltchaSolution is the payload the ALTCHA widget submits after solving a challenge.
+type AltchaSolution struct {
+	Algorithm string `json:"algorithm"`
+	Challenge string `json:"challenge"`
+	Number    int64  `json:"number"`
+	Salt      string `json:"salt"`
+	Signature string `json:"signature"`
+}
+
+// newAltchaChallenge creates a new proof-of-work challenge with the given difficulty that expires
+// at the given time. The challenge is signed with the given key.
+func newAltchaChallenge(key string, maxNumber int64, expires time.Time) (*AltchaChallenge, error) {
+	saltBytes := make([]byte, altchaSaltLen)
+	if _, err := rand.Read(saltBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate random salt: %w", err)
+	}
+	salt := hex.EncodeToString(saltBytes) + "?expires=" + strconv.FormatInt(expires.Unix(), 10)
+
+	number, err := rand.Int(rand.Reader, big.NewInt(maxNumber+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate random number: %w", err)
+	}
+
+	challenge := altchaHash(salt, number.Int64())
+	return &AltchaChallenge{
+		Algorithm: altchaAlgorithm,
+		Challenge: challenge,
+		MaxNumber: maxNumber,
+		Salt:      salt,
+		Signature: altchaSignature(key, challenge),
+	}, nil
+}
+
+// verifyAltchaSolution verifies the base64 encoded ALTCHA payload against the challenge that was
+// issued for the token and the key it was signed with.
+func verifyAltchaSolution(payload, issuedChallenge, key string) error {
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAltchaInvalidPayload, err)
+	}
+	solution := new(AltchaSolution)
+	if err = json.Unmarshal(data, solution); err != nil {
+		return fmt.Errorf("%w: %w", ErrAltchaInvalidPayload, err)
+	}
+	if !strings.EqualFold(solution.Algorithm, altchaAlgorithm) {
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrAltchaInvalidPayload, solution.Algorithm)
+	}
+
+	if issuedChallenge == "" ||
+		subtle.ConstantTimeCompare([]byte(solution.Challenge), []byte(issuedChallenge)) != 1 {
+		return ErrAltchaUnknownChallenge
+	}
+	if !hmac.Equal([]byte(solution.Signature), []byte(altchaSignature(key, solution.Challenge))) {
+		return ErrAltchaInvalidSignature
+	}
+	if altchaHash(solution.Salt, solution.Number) != solution.Challenge {
+		return ErrAltchaWrongSolution
+	}
+
+	if _, params, found := strings.Cut(solution.Salt, "?"); found {
+		values, err := url.ParseQuery(params)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrAltchaInvalidPayload, err)
+		}
+		if expires := values.Get("expires"); expires != "" {
+			expiresAt, err := strconv.ParseInt(expires, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrAltchaInvalidPayload, err)
+			}
+			if time.Now().After(time.Unix(expiresAt, 0)) {
+				return ErrAltchaExpired
+			}
+		}
+	}
+
+	return nil
+}
+
+// altchaHash returns the hex encoded SHA-256 hash of the salt followed by the number.
+func altchaHash(salt string, number int64) string {
+	hash := sha256.Sum256([]byte(salt + strconv.FormatInt(number, 10)))
+	return hex.EncodeToString(hash[:])
+}
+
+// altchaSignature returns the hex encoded HMAC-SHA256 of the challenge.
+func altchaSignature(key, challenge string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(challenge))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// altchaProvider verifies the proof-of-work solution locally against the challenge that was issued
+// with the form token. Since the challenge is bound to the single-use token, a solution can't be replayed.
+type altchaProvider struct {
+	srv *Server
+}
+
+func newAltchaProvider(s *Server) CaptchaProvider {
+	return &altchaProvider{srv: s}
+}
+
+// Name satisfies the CaptchaProvider interface.
+func (p *altchaProvider) Name() string {
+	return "ALTCHA"
+}
+
+// Enabled satisfies the CaptchaProvider interface.
+func (p *altchaProvider) Enabled(form *forms.Form) bool {
+	return form.Validation.Altcha.Enabled
+}
+
+// Verify satisfies the CaptchaProvider interface.
+func (p *altchaProvider) Verify(_ context.Context, req *CaptchaRequest) (bool, error) {
+	solution, err := captchaSolution(req, p.Name(), altchaSolutionField)
+	if err != nil {
+		return false, err
+	}
+
+	if err = verifyAltchaSolution(solution, req.Params.AltchaChallenge, req.Form.Secret); err != nil {
+		p.srv.log.Error("ALTCHA solution verification failed", logger.Err(err))
+		return false, ErrAltchaFailed
+	}
+
+	return false, nil
+}
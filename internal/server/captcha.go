@@ -9,227 +9,170 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
+	"github.com/wneessen/js-mailer/internal/cache"
 	"github.com/wneessen/js-mailer/internal/forms"
+	"github.com/wneessen/js-mailer/internal/logger"
 )
 
 const (
-	privateCaptchaSolutionField = "private-captcha-solution"
-	hCaptchaSolutionField       = "h-captcha-response"
-	turnstileSolutionField      = "cf-turnstile-response"
-	reCaptchaSolutionField      = "g-recaptcha-response"
-
-	hCpatchaEndpoint  = "https://hcaptcha.com/siteverify"
-	turnstileEndpoint = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
-	reCaptchaEndpoint = "https://www.google.com/recaptcha/api/siteverify"
+	// CaptchaPolicyAll requires all enabled captcha providers to succeed
+	CaptchaPolicyAll = "all"
+
+	// CaptchaPolicyAny requires at least one of the enabled captcha providers to succeed
+	CaptchaPolicyAny = "any"
 )
 
 var (
-	ErrPrivateCaptchaFailed = errors.New("private captcha validation failed")
-	ErrHCaptchaFailed       = errors.New("hCaptcha validation failed")
-	ErrTurnstileFailed      = errors.New("turnstile validation failed")
-	ErrReCaptchaFailed      = errors.New("reCaptcha validation failed")
+	ErrCaptchaHostnameMismatch = errors.New("captcha was not solved on a form domain")
+	ErrCaptchaChallengeTooOld  = errors.New("captcha challenge is too old")
+	ErrCaptchaActionMismatch   = errors.New("captcha action does not match the form")
+	ErrUnknownCaptchaPolicy    = errors.New("unknown captcha policy")
 )
 
-func (s *Server) validateCaptcha(ctx context.Context, form *forms.Form, submission map[string][]string, remoteAddr string) error {
-	// Private Captcha
-	if form.Validation.PrivateCaptcha.Enabled {
-		if err := s.privateCaptcha(ctx, form, submission); err != nil {
-			return fmt.Errorf("private captcha validation failed: %w", err)
-		}
-		s.log.Debug("private captcha validation succeeded")
-	}
+// CaptchaProvider is the interface that a captcha provider needs to implement to be used for
+// form submission validation.
+type CaptchaProvider interface {
+	// Name returns the name of the provider as used in logs and error messages
+	Name() string
 
-	// HCaptcha
-	if form.Validation.Hcaptcha.Enabled {
-		if err := s.hCaptcha(ctx, form, submission, remoteAddr); err != nil {
-			return fmt.Errorf("hCaptcha validation failed: %w", err)
-		}
-		s.log.Debug("hCaptcha validation succeeded")
-	}
+	// Enabled returns true if the provider is enabled for the given form
+	Enabled(form *forms.Form) bool
 
-	// Cloudflare Turnstile
-	if form.Validation.Turnstile.Enabled {
-		if err := s.turnstile(ctx, form, submission, remoteAddr); err != nil {
-			return fmt.Errorf("turnstile validation failed: %w", err)
-		}
-		s.log.Debug("turnstile validation succeeded")
-	}
-
-	// reCaptcha
-	if form.Validation.Recaptcha.Enabled {
-		if err := s.reCaptcha(ctx, form, submission, remoteAddr); err != nil {
-			return fmt.Errorf("reCaptcha validation failed: %w", err)
-		}
-		s.log.Debug("reCaptcha validation succeeded")
-	}
-
-	return nil
+	// Verify verifies the captcha solution of the submission. The returned bool indicates that
+	// the provider considers the submission suspicious, but not bad enough to be rejected.
+	Verify(ctx context.Context, req *CaptchaRequest) (bool, error)
 }
 
-// privateCaptcha verifies the captcha solution against the Private Captcha provider.
-func (s *Server) privateCaptcha(ctx context.Context, form *forms.Form, submission map[string][]string) error {
-	type response struct {
-		Success            bool   `json:"success"`
-		ChallengeTimestamp string `json:"timestamp"`
-		Origin             string `json:"origin"`
-		Code               int    `json:"code"`
-	}
+// CaptchaRequest holds the data of a form submission that is required for captcha verification
+type CaptchaRequest struct {
+	Form       *forms.Form
+	Submission map[string][]string
+	RemoteAddr string
+	Params     cache.ItemParams
+}
 
-	solution, ok := submission[privateCaptchaSolutionField]
-	if !ok || len(solution) == 0 {
-		return fmt.Errorf("missing private captcha solution")
-	}
+// captchaProviders is the registry of all available captcha providers. The providers are evaluated
+// in the order of the registry.
+var captchaProviders = []func(*Server) CaptchaProvider{
+	newPrivateCaptchaProvider,
+	newHCaptchaProvider,
+	newTurnstileProvider,
+	newReCaptchaProvider,
+	newFriendlyCaptchaProvider,
+	newMCaptchaProvider,
+	newAltchaProvider,
+	newMockCaptchaProvider,
+}
 
-	endpoint, err := url.Parse(fmt.Sprintf("https://%s/verify", form.Validation.PrivateCaptcha.Host))
-	if err != nil {
-		return fmt.Errorf("failed to parse private captcha endpoint: %w", err)
+// newCaptchaProviders returns an instance of each registered captcha provider for the server.
+func newCaptchaProviders(s *Server) []CaptchaProvider {
+	providers := make([]CaptchaProvider, 0, len(captchaProviders))
+	for _, provider := range captchaProviders {
+		providers = append(providers, provider(s))
 	}
+	return providers
+}
 
-	res := new(response)
-	body := strings.NewReader(solution[0])
-	header := map[string]string{"X-Api-Key": form.Validation.PrivateCaptcha.APIKey}
-	code, err := s.httpClient.Post(ctx, endpoint.String(), res, body, header)
-	if err != nil {
-		return fmt.Errorf("failed to verify private captcha solution: %w", err)
-	}
-	if code != http.StatusOK {
-		s.log.Error("private captcha solution verification failed", slog.Int("status_code", code))
-		return ErrPrivateCaptchaFailed
+// validateCaptcha validates the submission against the captcha providers that are enabled for the form.
+// Depending on the captcha policy of the form, all or at least one of the providers need to succeed. The
+// returned bool indicates that a provider considers the submission suspicious, but not bad enough to be
+// rejected.
+func (s *Server) validateCaptcha(ctx context.Context, form *forms.Form, submission map[string][]string, remoteAddr string,
+	params cache.ItemParams,
+) (bool, error) {
+	policy := strings.ToLower(form.Validation.CaptchaPolicy)
+	if policy != CaptchaPolicyAll && policy != CaptchaPolicyAny {
+		return false, fmt.Errorf("%w: %s", ErrUnknownCaptchaPolicy, form.Validation.CaptchaPolicy)
+	}
+
+	req := &CaptchaRequest{Form: form, Submission: submission, RemoteAddr: remoteAddr, Params: params}
+	var errs []error
+	enabled, passed, flagged := 0, 0, false
+	for _, provider := range s.captcha {
+		if !provider.Enabled(form) {
+			continue
+		}
+		enabled++
+
+		providerFlagged, err := provider.Verify(ctx, req)
+		if err != nil {
+			err = fmt.Errorf("%s validation failed: %w", provider.Name(), err)
+			if policy == CaptchaPolicyAll {
+				return false, err
+			}
+			errs = append(errs, err)
+			continue
+		}
+		s.log.Debug(provider.Name()+" validation succeeded", slog.Bool("flagged", providerFlagged))
+		flagged = flagged || providerFlagged
+		passed++
 	}
 
-	if !res.Success {
-		s.log.Error("private captcha solution verification failed", slog.Any("response", res))
-		return ErrPrivateCaptchaFailed
+	if enabled > 0 && passed == 0 {
+		return false, errors.Join(errs...)
 	}
-
-	return nil
+	return flagged, nil
 }
 
-func (s *Server) hCaptcha(ctx context.Context, form *forms.Form, submission map[string][]string, remoteAddr string) error {
-	type response struct {
-		Success     bool     `json:"success"`
-		Timestamp   string   `json:"challenge_ts"`
-		Hostname    string   `json:"hostname"`
-		Credit      bool     `json:"credit"`
-		ErrorCodes  []string `json:"error-codes"`
-		Score       float64  `json:"score"`
-		ScoreReason []string `json:"score_reason"`
-	}
-
-	solution, ok := submission[hCaptchaSolutionField]
+// captchaSolution returns the solution that the captcha widget submitted in the given form field.
+func captchaSolution(req *CaptchaRequest, provider, field string) (string, error) {
+	solution, ok := req.Submission[field]
 	if !ok || len(solution) == 0 {
-		return fmt.Errorf("missing hCaptcha solution")
-	}
-
-	endpoint := hCpatchaEndpoint
-	data := url.Values{}
-	data.Set("secret", form.Validation.Hcaptcha.SecretKey)
-	data.Set("remoteip", remoteAddr)
-	data.Set("response", solution[0])
-
-	res := new(response)
-	body := strings.NewReader(data.Encode())
-	header := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
-	code, err := s.httpClient.Post(ctx, endpoint, res, body, header)
-	if err != nil {
-		return fmt.Errorf("failed to verify hCaptcha solution: %w", err)
-	}
-	if code != http.StatusOK {
-		s.log.Error("hCaptcha solution verification failed", slog.Int("status_code", code))
-		return ErrHCaptchaFailed
+		return "", fmt.Errorf("missing %s solution", provider)
 	}
+	return solution[0], nil
+}
 
-	if !res.Success {
-		s.log.Error("hCaptcha solution verification failed", slog.Any("response", res))
-		return ErrHCaptchaFailed
+// captchaEndpoint returns the configured endpoint or the given default endpoint if none is configured.
+func captchaEndpoint(configured, fallback string) string {
+	if configured != "" {
+		return configured
 	}
-
-	return nil
+	return fallback
 }
 
-func (s *Server) turnstile(ctx context.Context, form *forms.Form, submission map[string][]string, remoteAddr string) error {
-	type response struct {
-		Success    bool     `json:"success"`
-		Timestamp  string   `json:"challenge_ts"`
-		Hostname   string   `json:"hostname"`
-		ErrorCodes []string `json:"error-codes"`
-		Action     string   `json:"action"`
-		CustomData string   `json:"cdata"` // Custom data payload from client-side
-		Metadata   struct {
-			EphemeralID string `json:"ephemeral_id"` // Device fingerprint ID (Enterprise only)
+// verifyChallenge checks that a captcha challenge was solved on one of the form domains and that it is
+// not older than the maximum challenge age configured for the form.
+func (s *Server) verifyChallenge(form *forms.Form, provider, hostname, timestamp string, skipHostnameCheck bool) error {
+	if !skipHostnameCheck && !matchesFormDomain(form, hostname) {
+		s.log.Error(provider+" hostname does not match any of the form domains",
+			slog.String("hostname", hostname), slog.Any("domains", form.Domains))
+		return ErrCaptchaHostnameMismatch
+	}
+
+	if form.Validation.MaxChallengeAge > 0 {
+		solvedAt, err := time.Parse(time.RFC3339, timestamp)
+		if err != nil {
+			s.log.Error("failed to parse "+provider+" challenge timestamp", logger.Err(err),
+				slog.String("timestamp", timestamp))
+			return ErrCaptchaChallengeTooOld
+		}
+		if age := time.Since(solvedAt); age > form.Validation.MaxChallengeAge {
+			s.log.Error(provider+" challenge is older than the maximum challenge age",
+				slog.String("age", age.String()), slog.String("max_age", form.Validation.MaxChallengeAge.String()))
+			return ErrCaptchaChallengeTooOld
 		}
-	}
-
-	solution, ok := submission[turnstileSolutionField]
-	if !ok || len(solution) == 0 {
-		return fmt.Errorf("missing turnstile solution")
-	}
-
-	endpoint := turnstileEndpoint
-	data := url.Values{}
-	data.Set("response", solution[0])
-	data.Set("remoteip", remoteAddr)
-	data.Set("secret", form.Validation.Turnstile.SecretKey)
-
-	res := new(response)
-	body := strings.NewReader(data.Encode())
-	header := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
-	code, err := s.httpClient.Post(ctx, endpoint, res, body, header)
-	if err != nil {
-		return fmt.Errorf("failed to verify turnstile solution: %w", err)
-	}
-	if code != http.StatusOK {
-		s.log.Error("turnstile solution verification failed", slog.Int("status_code", code))
-		return ErrTurnstileFailed
-	}
-
-	if !res.Success {
-		s.log.Error("turnstile solution verification failed", slog.Any("response", res))
-		return ErrTurnstileFailed
 	}
 
 	return nil
 }
 
-func (s *Server) reCaptcha(ctx context.Context, form *forms.Form, submission map[string][]string, remoteAddr string) error {
-	type response struct {
-		Success    bool     `json:"success"`
-		Timestamp  string   `json:"challenge_ts"`
-		Hostname   string   `json:"hostname"`
-		ErrorCodes []string `json:"error-codes"`
-	}
-
-	solution, ok := submission[reCaptchaSolutionField]
-	if !ok || len(solution) == 0 {
-		return fmt.Errorf("missing reCaptcha solution")
-	}
-
-	endpoint := reCaptchaEndpoint
-	data := url.Values{}
-	data.Set("secret", form.Validation.Recaptcha.SecretKey)
-	data.Set("response", solution[0])
-	data.Set("remoteip", remoteAddr)
-
-	res := new(response)
-	body := strings.NewReader(data.Encode())
-	header := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
-	code, err := s.httpClient.Post(ctx, endpoint, res, body, header)
-	if err != nil {
-		return fmt.Errorf("failed to verify reCaptcha solution: %w", err)
-	}
-	if code != http.StatusOK {
-		s.log.Error("reCaptcha solution verification failed", slog.Int("status_code", code))
-		return ErrReCaptchaFailed
+// matchesFormDomain returns true if the given hostname is one of the domains configured for the form.
+// The hostname may also be given as origin URL.
+func matchesFormDomain(form *forms.Form, hostname string) bool {
+	if strings.Contains(hostname, "://") {
+		if origin, err := url.Parse(hostname); err == nil {
+			hostname = origin.Hostname()
+		}
 	}
-
-	if !res.Success {
-		s.log.Error("reCaptcha solution verification failed", slog.Any("response", res))
-		return ErrReCaptchaFailed
+	for _, domain := range form.Domains {
+		if strings.EqualFold(hostname, domain) {
+			return true
+		}
 	}
-
-	return nil
+	return false
 }
@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+)
+
+const (
+	friendlyCaptchaSolutionField = "frc-captcha-response"
+	friendlyCaptchaEndpoint      = "https://global.frcapi.com/api/v2/captcha/siteverify"
+)
+
+var ErrFriendlyCaptchaFailed = errors.New("friendly captcha validation failed")
+
+// friendlyCaptchaProvider verifies captcha solutions against the Friendly Captcha v2 siteverify API.
+type friendlyCaptchaProvider struct {
+	srv *Server
+}
+
+func newFriendlyCaptchaProvider(s *Server) CaptchaProvider {
+	return &friendlyCaptchaProvider{srv: s}
+}
+
+// Name satisfies the CaptchaProvider interface.
+func (p *friendlyCaptchaProvider) Name() string {
+	return "Friendly Captcha"
+}
+
+// Enabled satisfies the CaptchaProvider interface.
+func (p *friendlyCaptchaProvider) Enabled(form *forms.Form) bool {
+	return form.Validation.FriendlyCaptcha.Enabled
+}
+
+// Verify satisfies the CaptchaProvider interface.
+func (p *friendlyCaptchaProvider) Verify(ctx context.Context, req *CaptchaRequest) (bool, error) {
+	type request struct {
+		Response string `json:"response"`
+		SiteKey  string `json:"sitekey,omitempty"`
+	}
+	type response struct {
+		Success bool `json:"success"`
+		Data    struct {
+			EventID   string `json:"event_id"`
+			Challenge struct {
+				Timestamp string `json:"timestamp"`
+				Origin    string `json:"origin"`
+			} `json:"challenge"`
+		} `json:"data"`
+		Error struct {
+			ErrorCode string `json:"error_code"`
+			Detail    string `json:"detail"`
+		} `json:"error"`
+	}
+
+	solution, err := captchaSolution(req, p.Name(), friendlyCaptchaSolutionField)
+	if err != nil {
+		return false, err
+	}
+
+	config := req.Form.Validation.FriendlyCaptcha
+	payload, err := json.Marshal(request{Response: solution, SiteKey: config.SiteKey})
+	if err != nil {
+		return false, fmt.Errorf("failed to encode Friendly Captcha request: %w", err)
+	}
+
+	res := new(response)
+	endpoint := captchaEndpoint(config.Endpoint, friendlyCaptchaEndpoint)
+	header := map[string]string{"Content-Type": "application/json", "X-API-Key": config.APIKey}
+	code, err := p.srv.httpClient.Post(ctx, endpoint, res, bytes.NewReader(payload), header)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify Friendly Captcha solution: %w", err)
+	}
+	if code != http.StatusOK || !res.Success {
+		p.srv.log.Error("Friendly Captcha solution verification failed", slog.Int("status_code", code),
+			slog.String("error_code", res.Error.ErrorCode), slog.String("detail", res.Error.Detail))
+		return false, ErrFriendlyCaptchaFailed
+	}
+
+	return false, p.srv.verifyChallenge(req.Form, p.Name(), res.Data.Challenge.Origin, res.Data.Challenge.Timestamp,
+		config.DisableHostnameCheck)
+}
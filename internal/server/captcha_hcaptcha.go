@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+)
+
+const (
+	hCaptchaSolutionField = "h-captcha-response"
+	hCpatchaEndpoint      = "https://hcaptcha.com/siteverify"
+)
+
+var ErrHCaptchaFailed = errors.New("hCaptcha validation failed")
+
+// hCaptchaProvider verifies captcha solutions against the hCaptcha siteverify API.
+type hCaptchaProvider struct {
+	srv *Server
+}
+
+func newHCaptchaProvider(s *Server) CaptchaProvider {
+	return &hCaptchaProvider{srv: s}
+}
+
+// Name satisfies the CaptchaProvider interface.
+func (p *hCaptchaProvider) Name() string {
+	return "hCaptcha"
+}
+
+// Enabled satisfies the CaptchaProvider interface.
+func (p *hCaptchaProvider) Enabled(form *forms.Form) bool {
+	return form.Validation.Hcaptcha.Enabled
+}
+
+// Verify satisfies the CaptchaProvider interface.
+func (p *hCaptchaProvider) Verify(ctx context.Context, req *CaptchaRequest) (bool, error) {
+	type response struct {
+		Success     bool     `json:"success"`
+		Timestamp   string   `json:"challenge_ts"`
+		Hostname    string   `json:"hostname"`
+		Credit      bool     `json:"credit"`
+		ErrorCodes  []string `json:"error-codes"`
+		Score       float64  `json:"score"`
+		ScoreReason []string `json:"score_reason"`
+	}
+
+	solution, err := captchaSolution(req, p.Name(), hCaptchaSolutionField)
+	if err != nil {
+		return false, err
+	}
+
+	config := req.Form.Validation.Hcaptcha
+	endpoint := captchaEndpoint(config.Endpoint, hCpatchaEndpoint)
+	data := url.Values{}
+	data.Set("secret", config.SecretKey)
+	data.Set("remoteip", req.RemoteAddr)
+	data.Set("response", solution)
+	if config.SiteKey != "" {
+		data.Set("sitekey", config.SiteKey)
+	}
+
+	res := new(response)
+	body := strings.NewReader(data.Encode())
+	header := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+	code, err := p.srv.httpClient.Post(ctx, endpoint, res, body, header)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify hCaptcha solution: %w", err)
+	}
+	if code != http.StatusOK {
+		p.srv.log.Error("hCaptcha solution verification failed", slog.Int("status_code", code))
+		return false, ErrHCaptchaFailed
+	}
+
+	if !res.Success {
+		p.srv.log.Error("hCaptcha solution verification failed", slog.Any("response", res))
+		return false, ErrHCaptchaFailed
+	}
+
+	// hCaptcha Enterprise risk scores range from 0.0 (no risk) to 1.0 (confirmed threat)
+	if config.MaxScore > 0 && res.Score > config.MaxScore {
+		p.srv.log.Error("hCaptcha risk score exceeds the maximum score", slog.Float64("score", res.Score),
+			slog.Float64("max_score", config.MaxScore), slog.Any("score_reason", res.ScoreReason))
+		return false, ErrHCaptchaFailed
+	}
+
+	return false, p.srv.verifyChallenge(req.Form, p.Name(), res.Hostname, res.Timestamp,
+		config.DisableHostnameCheck)
+}
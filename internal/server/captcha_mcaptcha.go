@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+)
+
+const mCaptchaSolutionField = "mcaptcha__token"
+
+var ErrMCaptchaFailed = errors.New("mCaptcha validation failed")
+
+// mCaptchaProvider verifies proof-of-work tokens against a self-hosted mCaptcha instance.
+type mCaptchaProvider struct {
+	srv *Server
+}
+
+func newMCaptchaProvider(s *Server) CaptchaProvider {
+	return &mCaptchaProvider{srv: s}
+}
+
+// Name satisfies the CaptchaProvider interface.
+func (p *mCaptchaProvider) Name() string {
+	return "mCaptcha"
+}
+
+// Enabled satisfies the CaptchaProvider interface.
+func (p *mCaptchaProvider) Enabled(form *forms.Form) bool {
+	return form.Validation.MCaptcha.Enabled
+}
+
+// Verify satisfies the CaptchaProvider interface.
+func (p *mCaptchaProvider) Verify(ctx context.Context, req *CaptchaRequest) (bool, error) {
+	type request struct {
+		Token  string `json:"token"`
+		Key    string `json:"key"`
+		Secret string `json:"secret"`
+	}
+	type response struct {
+		Valid bool `json:"valid"`
+	}
+
+	solution, err := captchaSolution(req, p.Name(), mCaptchaSolutionField)
+	if err != nil {
+		return false, err
+	}
+
+	config := req.Form.Validation.MCaptcha
+	endpoint, err := url.Parse(captchaEndpoint(config.Endpoint,
+		fmt.Sprintf("https://%s/api/v1/pow/siteverify", config.Host)))
+	if err != nil {
+		return false, fmt.Errorf("failed to parse mCaptcha endpoint: %w", err)
+	}
+	payload, err := json.Marshal(request{Token: solution, Key: config.SiteKey, Secret: config.SecretKey})
+	if err != nil {
+		return false, fmt.Errorf("failed to encode mCaptcha request: %w", err)
+	}
+
+	res := new(response)
+	header := map[string]string{"Content-Type": "application/json"}
+	code, err := p.srv.httpClient.Post(ctx, endpoint.String(), res, bytes.NewReader(payload), header)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify mCaptcha token: %w", err)
+	}
+	if code != http.StatusOK || !res.Valid {
+		p.srv.log.Error("mCaptcha token verification failed", slog.Int("status_code", code),
+			slog.Bool("valid", res.Valid))
+		return false, ErrMCaptchaFailed
+	}
+
+	return false, nil
+}
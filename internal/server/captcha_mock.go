@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+)
+
+const (
+	mockCaptchaSolutionField = "mock-captcha-response"
+
+	// mockCaptchaPass and mockCaptchaFlag are the tokens accepted by the mock captcha provider.
+	// Any other token fails the validation.
+	mockCaptchaPass = "mock-captcha-pass"
+	mockCaptchaFlag = "mock-captcha-flag"
+)
+
+var (
+	ErrMockCaptchaFailed     = errors.New("mock captcha validation failed")
+	ErrMockCaptchaNotAllowed = errors.New("mock captcha is not allowed in production mode")
+)
+
+// mockCaptchaProvider is a deterministic captcha provider for development and end-to-end tests. It can
+// only be used if the server is not running in production mode or the mock captcha is explicitly allowed.
+type mockCaptchaProvider struct {
+	srv *Server
+}
+
+func newMockCaptchaProvider(s *Server) CaptchaProvider {
+	return &mockCaptchaProvider{srv: s}
+}
+
+// Name satisfies the CaptchaProvider interface.
+func (p *mockCaptchaProvider) Name() string {
+	return "mock captcha"
+}
+
+// Enabled satisfies the CaptchaProvider interface.
+func (p *mockCaptchaProvider) Enabled(form *forms.Form) bool {
+	return form.Validation.Mock.Enabled
+}
+
+// Verify satisfies the CaptchaProvider interface.
+func (p *mockCaptchaProvider) Verify(_ context.Context, req *CaptchaRequest) (bool, error) {
+	if p.srv.config.IsProduction() && !p.srv.config.Server.AllowMockCaptcha {
+		p.srv.log.Error("mock captcha is enabled for the form, but the server is running in production mode")
+		return false, ErrMockCaptchaNotAllowed
+	}
+
+	solution, err := captchaSolution(req, p.Name(), mockCaptchaSolutionField)
+	if err != nil {
+		return false, err
+	}
+
+	switch solution {
+	case mockCaptchaPass:
+		return false, nil
+	case mockCaptchaFlag:
+		return true, nil
+	default:
+		return false, ErrMockCaptchaFailed
+	}
+}
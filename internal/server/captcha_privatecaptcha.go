@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+)
+
+const privateCaptchaSolutionField = "private-captcha-solution"
+
+var ErrPrivateCaptchaFailed = errors.New("private captcha validation failed")
+
+// privateCaptchaProvider verifies captcha solutions against a Private Captcha instance.
+type privateCaptchaProvider struct {
+	srv *Server
+}
+
+func newPrivateCaptchaProvider(s *Server) CaptchaProvider {
+	return &privateCaptchaProvider{srv: s}
+}
+
+// Name satisfies the CaptchaProvider interface.
+func (p *privateCaptchaProvider) Name() string {
+	return "private captcha"
+}
+
+// Enabled satisfies the CaptchaProvider interface.
+func (p *privateCaptchaProvider) Enabled(form *forms.Form) bool {
+	return form.Validation.PrivateCaptcha.Enabled
+}
+
+// Verify satisfies the CaptchaProvider interface.
+func (p *privateCaptchaProvider) Verify(ctx context.Context, req *CaptchaRequest) (bool, error) {
+	type response struct {
+		Success            bool   `json:"success"`
+		ChallengeTimestamp string `json:"timestamp"`
+		Origin             string `json:"origin"`
+		Code               int    `json:"code"`
+	}
+
+	solution, err := captchaSolution(req, p.Name(), privateCaptchaSolutionField)
+	if err != nil {
+		return false, err
+	}
+
+	config := req.Form.Validation.PrivateCaptcha
+	endpoint, err := url.Parse(captchaEndpoint(config.Endpoint, fmt.Sprintf("https://%s/verify", config.Host)))
+	if err != nil {
+		return false, fmt.Errorf("failed to parse private captcha endpoint: %w", err)
+	}
+
+	res := new(response)
+	body := strings.NewReader(solution)
+	header := map[string]string{"X-Api-Key": config.APIKey}
+	code, err := p.srv.httpClient.Post(ctx, endpoint.String(), res, body, header)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify private captcha solution: %w", err)
+	}
+	if code != http.StatusOK {
+		p.srv.log.Error("private captcha solution verification failed", slog.Int("status_code", code))
+		return false, ErrPrivateCaptchaFailed
+	}
+
+	if !res.Success {
+		p.srv.log.Error("private captcha solution verification failed", slog.Any("response", res))
+		return false, ErrPrivateCaptchaFailed
+	}
+
+	return false, p.srv.verifyChallenge(req.Form, p.Name(), res.Origin, res.ChallengeTimestamp,
+		config.DisableHostnameCheck)
+}
@@ -0,0 +1,209 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+)
+
+const (
+	reCaptchaSolutionField = "g-recaptcha-response"
+	reCaptchaEndpoint      = "https://www.google.com/recaptcha/api/siteverify"
+
+	reCaptchaEnterpriseEndpoint = "https://recaptchaenterprise.googleapis.com/v1/projects/%s/assessments"
+)
+
+var ErrReCaptchaFailed = errors.New("reCaptcha validation failed")
+
+// reCaptchaVerdict is the provider independent result of a reCaptcha verification
+type reCaptchaVerdict struct {
+	Success   bool
+	Score     float64
+	Action    string
+	Hostname  string
+	Timestamp string
+}
+
+// reCaptchaProvider verifies captcha solutions against reCaptcha. Depending on the form configuration
+// the legacy siteverify API (v2 and v3) or the reCaptcha Enterprise assessment API is used.
+type reCaptchaProvider struct {
+	srv *Server
+}
+
+func newReCaptchaProvider(s *Server) CaptchaProvider {
+	return &reCaptchaProvider{srv: s}
+}
+
+// Name satisfies the CaptchaProvider interface.
+func (p *reCaptchaProvider) Name() string {
+	return "reCaptcha"
+}
+
+// Enabled satisfies the CaptchaProvider interface.
+func (p *reCaptchaProvider) Enabled(form *forms.Form) bool {
+	return form.Validation.Recaptcha.Enabled
+}
+
+// Verify satisfies the CaptchaProvider interface. The submission is flagged if the score is within
+// the configured gray zone.
+func (p *reCaptchaProvider) Verify(ctx context.Context, req *CaptchaRequest) (bool, error) {
+	solution, err := captchaSolution(req, p.Name(), reCaptchaSolutionField)
+	if err != nil {
+		return false, err
+	}
+
+	var verdict *reCaptchaVerdict
+	switch req.Form.Validation.Recaptcha.ProjectID {
+	case "":
+		verdict, err = p.siteverify(ctx, req.Form, solution, req.RemoteAddr)
+	default:
+		verdict, err = p.enterprise(ctx, req.Form, solution, req.RemoteAddr)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !verdict.Success {
+		p.srv.log.Error("reCaptcha solution verification failed", slog.Any("response", verdict))
+		return false, ErrReCaptchaFailed
+	}
+	config := req.Form.Validation.Recaptcha
+	if config.Action != "" && !strings.EqualFold(verdict.Action, config.Action) {
+		p.srv.log.Error("reCaptcha action does not match", slog.String("want_action", config.Action),
+			slog.String("has_action", verdict.Action))
+		return false, ErrReCaptchaFailed
+	}
+	if err = p.srv.verifyChallenge(req.Form, p.Name(), verdict.Hostname, verdict.Timestamp,
+		config.DisableHostnameCheck); err != nil {
+		return false, err
+	}
+	if verdict.Score < config.MinScore {
+		p.srv.log.Error("reCaptcha score is below the minimum score", slog.Float64("score", verdict.Score),
+			slog.Float64("min_score", config.MinScore))
+		return false, ErrReCaptchaFailed
+	}
+	if verdict.Score < config.FlagScore {
+		p.srv.log.Warn("reCaptcha score is within the gray zone", slog.Float64("score", verdict.Score),
+			slog.Float64("flag_score", config.FlagScore))
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// siteverify verifies the solution using the reCaptcha siteverify API.
+func (p *reCaptchaProvider) siteverify(ctx context.Context, form *forms.Form, solution, remoteAddr string) (*reCaptchaVerdict, error) {
+	type response struct {
+		Success    bool     `json:"success"`
+		Timestamp  string   `json:"challenge_ts"`
+		Hostname   string   `json:"hostname"`
+		ErrorCodes []string `json:"error-codes"`
+		Score      float64  `json:"score"`
+		Action     string   `json:"action"`
+	}
+
+	endpoint := captchaEndpoint(form.Validation.Recaptcha.Endpoint, reCaptchaEndpoint)
+	data := url.Values{}
+	data.Set("secret", form.Validation.Recaptcha.SecretKey)
+	data.Set("response", solution)
+	data.Set("remoteip", remoteAddr)
+
+	res := new(response)
+	body := strings.NewReader(data.Encode())
+	header := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+	code, err := p.srv.httpClient.Post(ctx, endpoint, res, body, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify reCaptcha solution: %w", err)
+	}
+	if code != http.StatusOK {
+		p.srv.log.Error("reCaptcha solution verification failed", slog.Int("status_code", code))
+		return nil, ErrReCaptchaFailed
+	}
+
+	return &reCaptchaVerdict{
+		Success:   res.Success,
+		Score:     res.Score,
+		Action:    res.Action,
+		Hostname:  res.Hostname,
+		Timestamp: res.Timestamp,
+	}, nil
+}
+
+// enterprise verifies the solution by creating an assessment with the reCaptcha Enterprise API.
+func (p *reCaptchaProvider) enterprise(ctx context.Context, form *forms.Form, solution, remoteAddr string) (*reCaptchaVerdict, error) {
+	type event struct {
+		Token          string `json:"token"`
+		SiteKey        string `json:"siteKey"`
+		ExpectedAction string `json:"expectedAction,omitempty"`
+		UserIPAddress  string `json:"userIpAddress,omitempty"`
+	}
+	type request struct {
+		Event event `json:"event"`
+	}
+	type response struct {
+		TokenProperties struct {
+			Valid         bool   `json:"valid"`
+			InvalidReason string `json:"invalidReason"`
+			Hostname      string `json:"hostname"`
+			Action        string `json:"action"`
+			CreateTime    string `json:"createTime"`
+		} `json:"tokenProperties"`
+		RiskAnalysis struct {
+			Score   float64  `json:"score"`
+			Reasons []string `json:"reasons"`
+		} `json:"riskAnalysis"`
+	}
+
+	config := form.Validation.Recaptcha
+	endpoint, err := url.Parse(fmt.Sprintf(captchaEndpoint(config.EnterpriseEndpoint, reCaptchaEnterpriseEndpoint),
+		url.PathEscape(config.ProjectID)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse reCaptcha Enterprise endpoint: %w", err)
+	}
+	endpoint.RawQuery = url.Values{"key": {config.APIKey}}.Encode()
+
+	payload, err := json.Marshal(request{Event: event{
+		Token:          solution,
+		SiteKey:        config.SiteKey,
+		ExpectedAction: config.Action,
+		UserIPAddress:  remoteAddr,
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode reCaptcha Enterprise assessment: %w", err)
+	}
+
+	res := new(response)
+	header := map[string]string{"Content-Type": "application/json"}
+	code, err := p.srv.httpClient.Post(ctx, endpoint.String(), res, bytes.NewReader(payload), header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify reCaptcha solution: %w", err)
+	}
+	if code != http.StatusOK {
+		p.srv.log.Error("reCaptcha Enterprise assessment failed", slog.Int("status_code", code))
+		return nil, ErrReCaptchaFailed
+	}
+	if !res.TokenProperties.Valid {
+		p.srv.log.Error("reCaptcha Enterprise token is invalid",
+			slog.String("reason", res.TokenProperties.InvalidReason))
+	}
+
+	return &reCaptchaVerdict{
+		Success:   res.TokenProperties.Valid,
+		Score:     res.RiskAnalysis.Score,
+		Action:    res.TokenProperties.Action,
+		Hostname:  res.TokenProperties.Hostname,
+		Timestamp: res.TokenProperties.CreateTime,
+	}, nil
+}
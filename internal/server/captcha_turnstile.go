@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+)
+
+const (
+	turnstileSolutionField = "cf-turnstile-response"
+	turnstileEndpoint      = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+)
+
+var ErrTurnstileFailed = errors.New("turnstile validation failed")
+
+// turnstileProvider verifies captcha solutions against the Cloudflare Turnstile siteverify API.
+type turnstileProvider struct {
+	srv *Server
+}
+
+func newTurnstileProvider(s *Server) CaptchaProvider {
+	return &turnstileProvider{srv: s}
+}
+
+// Name satisfies the CaptchaProvider interface.
+func (p *turnstileProvider) Name() string {
+	return "turnstile"
+}
+
+// Enabled satisfies the CaptchaProvider interface.
+func (p *turnstileProvider) Enabled(form *forms.Form) bool {
+	return form.Validation.Turnstile.Enabled
+}
+
+// Verify satisfies the CaptchaProvider interface.
+func (p *turnstileProvider) Verify(ctx context.Context, req *CaptchaRequest) (bool, error) {
+	type response struct {
+		Success    bool     `json:"success"`
+		Timestamp  string   `json:"challenge_ts"`
+		Hostname   string   `json:"hostname"`
+		ErrorCodes []string `json:"error-codes"`
+		Action     string   `json:"action"`
+		CustomData string   `json:"cdata"` // Custom data payload from client-side
+		Metadata   struct {
+			EphemeralID string `json:"ephemeral_id"` // Device fingerprint ID (Enterprise only)
+		}
+	}
+
+	solution, err := captchaSolution(req, p.Name(), turnstileSolutionField)
+	if err != nil {
+		return false, err
+	}
+
+	config := req.Form.Validation.Turnstile
+	endpoint := captchaEndpoint(config.Endpoint, turnstileEndpoint)
+	data := url.Values{}
+	data.Set("response", solution)
+	data.Set("remoteip", req.RemoteAddr)
+	data.Set("secret", config.SecretKey)
+
+	res := new(response)
+	body := strings.NewReader(data.Encode())
+	header := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+	code, err := p.srv.httpClient.Post(ctx, endpoint, res, body, header)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify turnstile solution: %w", err)
+	}
+	if code != http.StatusOK {
+		p.srv.log.Error("turnstile solution verification failed", slog.Int("status_code", code))
+		return false, ErrTurnstileFailed
+	}
+
+	if !res.Success {
+		p.srv.log.Error("turnstile solution verification failed", slog.Any("response", res))
+		return false, ErrTurnstileFailed
+	}
+
+	// The widget needs to be rendered with the form ID as action or custom data
+	formID := req.Form.ID
+	if !config.DisableActionCheck && res.Action != formID && res.CustomData != formID {
+		p.srv.log.Error("turnstile action or custom data does not match the form ID",
+			slog.String("action", res.Action), slog.String("cdata", res.CustomData), slog.String("formID", formID))
+		return false, ErrCaptchaActionMismatch
+	}
+
+	return false, p.srv.verifyChallenge(req.Form, p.Name(), res.Hostname, res.Timestamp,
+		config.DisableHostnameCheck)
+}
@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wneessen/go-mail"
+)
+
+// capturedMail is a rendered mail that was captured instead of being delivered
+type capturedMail struct {
+	ID         string    `json:"id"`
+	FormID     string    `json:"form_id"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Name       string    `json:"name"`
+	From       string    `json:"from"`
+	Recipients []string  `json:"recipients"`
+	Subject    string    `json:"subject"`
+	Size       int       `json:"size"`
+	File       string    `json:"file,omitempty"`
+	CapturedAt time.Time `json:"captured_at"`
+
+	data []byte
+}
+
+// mailCapture keeps the most recently captured mails in memory and optionally writes every
+// captured mail as .eml file into a directory
+type mailCapture struct {
+	mu      sync.RWMutex
+	mails   []*capturedMail
+	keep    int
+	dir     string
+	counter atomic.Uint64
+}
+
+// newMailCapture returns a new mail capture that keeps the last keep mails in memory and writes
+// them into the directory, if it is not empty
+func newMailCapture(keep int, dir string) *mailCapture {
+	return &mailCapture{keep: keep, dir: dir}
+}
+
+// capture renders the message and stores it as mail of the form
+func (c *mailCapture) capture(formID, requestID, name string, message *mail.Msg) (*capturedMail, error) {
+	buf := bytes.NewBuffer(nil)
+	if _, err := message.WriteTo(buf); err != nil {
+		return nil, fmt.Errorf("failed to render message: %w", err)
+	}
+	now := time.Now()
+	captured := &capturedMail{
+		ID:         strconv.FormatInt(now.UnixMilli(), 36) + "-" + strconv.FormatUint(c.counter.Add(1), 36),
+		FormID:     formID,
+		RequestID:  requestID,
+		Name:       name,
+		Size:       buf.Len(),
+		CapturedAt: now.UTC(),
+		data:       buf.Bytes(),
+	}
+	if from := message.GetAddrHeader(mail.HeaderFrom); len(from) > 0 {
+		captured.From = from[0].Address
+	}
+	for _, header := range []mail.AddrHeader{mail.HeaderTo, mail.HeaderCc, mail.HeaderBcc} {
+		for _, recipient := range message.GetAddrHeader(header) {
+			captured.Recipients = append(captured.Recipients, recipient.Address)
+		}
+	}
+	if subject := message.GetGenHeader(mail.HeaderSubject); len(subject) > 0 {
+		captured.Subject = subject[0]
+	}
+
+	if c.dir != "" {
+		name := fmt.Sprintf("%s-%s-%s.eml", captured.ID, formID, strings.ReplaceAll(name, " ", "-"))
+		path := filepath.Join(c.dir, filepath.Base(name))
+		if err := os.MkdirAll(c.dir, 0o750); err != nil {
+			return nil, fmt.Errorf("failed to create capture directory: %w", err)
+		}
+		if err := os.WriteFile(path, captured.data, 0o640); err != nil {
+			return nil, fmt.Errorf("failed to write captured mail: %w", err)
+		}
+		captured.File = path
+	}
+
+	if c.keep > 0 {
+		c.mu.Lock()
+		c.mails = append(c.mails, captured)
+		if len(c.mails) > c.keep {
+			c.mails = slices.Delete(c.mails, 0, len(c.mails)-c.keep)
+		}
+		c.mu.Unlock()
+	}
+	return captured, nil
+}
+
+// list returns the captured mails that are kept in memory, newest first
+func (c *mailCapture) list() []*capturedMail {
+	c.mu.RLock()
+	mails := slices.Clone(c.mails)
+	c.mu.RUnlock()
+	slices.Reverse(mails)
+	return mails
+}
+
+// clear removes all captured mails from memory
+func (c *mailCapture) clear() {
+	c.mu.Lock()
+	c.mails = nil
+	c.mu.Unlock()
+}
+
+// get returns the captured mail with the ID
+func (c *mailCapture) get(id string) (*capturedMail, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, captured := range c.mails {
+		if captured.ID == id {
+			return captured, true
+		}
+	}
+	return nil, false
+}
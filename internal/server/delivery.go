@@ -0,0 +1,163 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/wneessen/go-mail"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+	"github.com/wneessen/js-mailer/internal/logger"
+)
+
+const (
+	// DeliveryOrderConfirmationFirst sends the confirmation mail before the form mail
+	DeliveryOrderConfirmationFirst = "confirmation-first"
+
+	// DeliveryOrderNotificationFirst sends the form mail before the confirmation mail
+	DeliveryOrderNotificationFirst = "notification-first"
+
+	// ConfirmationMandatory fails the submission if the confirmation mail can't be delivered
+	ConfirmationMandatory = "mandatory"
+
+	// ConfirmationBestEffort only logs failures of the confirmation mail
+	ConfirmationBestEffort = "best-effort"
+)
+
+var (
+	// ErrUnknownDeliveryOrder is returned if a form has an unsupported delivery order
+	ErrUnknownDeliveryOrder = errors.New("unknown delivery order")
+
+	// ErrUnknownConfirmationMode is returned if a form has an unsupported confirmation delivery mode
+	ErrUnknownConfirmationMode = errors.New("unknown confirmation delivery mode")
+
+	// ErrPrecedingMailFailed is returned for mails that were not sent, because the delivery of a
+	// preceding mandatory mail failed
+	ErrPrecedingMailFailed = errors.New("not sent, because a preceding mandatory mail failed")
+)
+
+// outgoingMail is a mail of a submission that is delivered via the SMTP relays of the form
+type outgoingMail struct {
+	// name identifies the mail in the logs
+	name    string
+	message *mail.Msg
+
+	// optional mails don't stop the delivery of the following mails if they fail
+	optional bool
+
+	response string
+	errs     []error
+}
+
+// err returns the delivery errors of the mail, or nil if it was delivered
+func (m *outgoingMail) err() error {
+	return errors.Join(m.errs...)
+}
+
+// orderMails returns the form mail and the confirmation mail in the delivery order of the form
+// and marks the confirmation mail as optional if its delivery is best-effort. The confirmation
+// mail may be nil.
+func orderMails(form *forms.Form, notification, confirmation *outgoingMail) ([]*outgoingMail, error) {
+	if confirmation == nil {
+		return []*outgoingMail{notification}, nil
+	}
+	switch strings.ToLower(form.Delivery.Confirmation) {
+	case "", ConfirmationMandatory:
+	case ConfirmationBestEffort:
+		confirmation.optional = true
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownConfirmationMode, form.Delivery.Confirmation)
+	}
+	switch strings.ToLower(form.Delivery.Order) {
+	case "", DeliveryOrderConfirmationFirst:
+		return []*outgoingMail{confirmation, notification}, nil
+	case DeliveryOrderNotificationFirst:
+		return []*outgoingMail{notification, confirmation}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDeliveryOrder, form.Delivery.Order)
+	}
+}
+
+// deliver sends the mails in order over one SMTP session per relay. Mails that a relay doesn't
+// accept are retried with the next relay. If a mail that is not optional fails, the following
+// mails are not sent via this relay either, so that the order of the mails is kept. Sessions
+// without errors are returned to the SMTP pool. The results are stored in the mails.
+func (s *Server) deliver(r *http.Request, relays []smtpRelay, mails ...*outgoingMail) {
+	log := s.log.With(logger.RequestID(r))
+	pending := mails
+	for _, relay := range relays {
+		if len(pending) == 0 || r.Context().Err() != nil {
+			break
+		}
+		session, err := s.smtpPool.get(r.Context(), relay)
+		if err != nil {
+			for _, outgoing := range pending {
+				outgoing.errs = append(outgoing.errs, fmt.Errorf("relay %s: %w", relay.address(), err))
+			}
+			log.Warn("failed to connect to SMTP relay", slog.String("relay", relay.address()), logger.Err(err))
+			continue
+		}
+
+		failed := sendInOrder(log.With(slog.String("relay", relay.address())), "relay "+relay.address(),
+			pending, func(message *mail.Msg) (string, error) {
+				if err := session.send(r.Context(), message); err != nil {
+					return "", err
+				}
+				return message.ServerResponse(), nil
+			})
+		if len(failed) == 0 {
+			s.smtpPool.put(relay, session)
+		} else {
+			session.close()
+		}
+		pending = failed
+	}
+	failPending(r.Context(), pending)
+}
+
+// sendInOrder sends the mails in order with the send function and returns the mails that failed
+// or were not sent, because a preceding mail that is not optional failed. Errors are prefixed with
+// the target the mails were sent to.
+func sendInOrder(log *slog.Logger, target string, mails []*outgoingMail,
+	send func(*mail.Msg) (string, error),
+) []*outgoingMail {
+	var failed []*outgoingMail
+	for i, outgoing := range mails {
+		response, err := send(outgoing.message)
+		if err != nil {
+			outgoing.errs = append(outgoing.errs, fmt.Errorf("%s: %w", target, err))
+			log.Warn("failed to deliver mail", slog.String("mail", outgoing.name), logger.Err(err))
+			failed = append(failed, outgoing)
+			if !outgoing.optional {
+				return append(failed, mails[i+1:]...)
+			}
+			continue
+		}
+		outgoing.response = response
+		outgoing.errs = nil
+	}
+	return failed
+}
+
+// failPending sets an error for the mails that were never attempted, either because the request
+// was canceled or because a preceding mandatory mail failed
+func failPending(ctx context.Context, pending []*outgoingMail) {
+	for _, outgoing := range pending {
+		if len(outgoing.errs) > 0 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			outgoing.errs = []error{err}
+			continue
+		}
+		outgoing.errs = []error{ErrPrecedingMailFailed}
+	}
+}
@@ -0,0 +1,254 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/wneessen/js-mailer/internal/logger"
+)
+
+var (
+	// ErrDevInboxNotLoopback is returned if the development inbox is enabled, but the server is bound
+	// to an address that is reachable from other hosts
+	ErrDevInboxNotLoopback = errors.New("development inbox requires a loopback bind address " +
+		"(set allow_non_loopback to override)")
+
+	// ErrAttachmentNotFound is returned if a captured mail has no attachment with the requested index
+	ErrAttachmentNotFound = errors.New("attachment not found")
+)
+
+//go:embed assets/devinbox.html
+var devInboxPage []byte
+
+// InboxListResponse is the JSON response struct for the list of mails in the development inbox
+type InboxListResponse struct {
+	Mails []*capturedMail `json:"mails"`
+}
+
+// InboxMessage is the JSON response struct for a mail in the development inbox
+type InboxMessage struct {
+	*capturedMail
+	Headers     map[string][]string `json:"headers"`
+	Text        string              `json:"text,omitempty"`
+	HTML        string              `json:"html,omitempty"`
+	Attachments []InboxAttachment   `json:"attachments"`
+}
+
+// InboxAttachment describes an attachment of a mail in the development inbox
+type InboxAttachment struct {
+	Index       int    `json:"index"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+
+	data []byte
+}
+
+// isLoopback returns true if the bind address is only reachable from the local host
+func isLoopback(address string) bool {
+	if strings.EqualFold(address, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLoopback()
+}
+
+// parseInboxMessage parses the captured mail into its headers, text and HTML body and attachments
+func parseInboxMessage(captured *capturedMail) (*InboxMessage, error) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(captured.data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse captured mail: %w", err)
+	}
+	message := &InboxMessage{capturedMail: captured, Headers: parsed.Header, Attachments: []InboxAttachment{}}
+	if err = message.walk(textproto.MIMEHeader(parsed.Header), parsed.Body); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// walk adds the MIME part to the message. The first text/plain and text/html parts that are not
+// attachments become the bodies of the message, all other leaf parts are attachments.
+func (m *InboxMessage) walk(header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read MIME part: %w", err)
+			}
+			if err = m.walk(part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body)
+	if err != nil {
+		return err
+	}
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	switch {
+	case disposition != "attachment" && filename == "" && mediaType == "text/plain" && m.Text == "":
+		m.Text = string(content)
+	case disposition != "attachment" && filename == "" && mediaType == "text/html" && m.HTML == "":
+		m.HTML = string(content)
+	default:
+		if filename == "" {
+			filename = "part-" + strconv.Itoa(len(m.Attachments)+1)
+		}
+		m.Attachments = append(m.Attachments, InboxAttachment{
+			Index: len(m.Attachments), Filename: filename, ContentType: mediaType, Size: len(content), data: content,
+		})
+	}
+	return nil
+}
+
+// decodeTransferEncoding returns the decoded content of a MIME part
+func decodeTransferEncoding(encoding string, body io.Reader) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode MIME part: %w", err)
+	}
+	return content, nil
+}
+
+// inboxMessage returns the parsed mail of the development inbox with the ID of the request and
+// renders an error response if it doesn't exist or can't be parsed
+func (s *Server) inboxMessage(w http.ResponseWriter, r *http.Request) (*InboxMessage, bool) {
+	captured, ok := s.inbox.get(chi.URLParam(r, "mailID"))
+	if !ok {
+		_ = renderResponse(w, r, ErrNotFound(ErrCapturedMailNotFound))
+		return nil, false
+	}
+	message, err := parseInboxMessage(captured)
+	if err != nil {
+		s.log.Error("failed to parse captured mail", logger.Err(err), logger.RequestID(r))
+		_ = renderResponse(w, r, ErrUnexpected(err))
+		return nil, false
+	}
+	return message, true
+}
+
+// HandlerDevInboxGet serves the web UI of the development inbox
+func (s *Server) HandlerDevInboxGet(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; "+
+		"style-src 'unsafe-inline'; frame-src 'self'")
+	if _, err := w.Write(devInboxPage); err != nil {
+		s.log.Error("failed to write development inbox page", logger.Err(err))
+	}
+}
+
+// HandlerDevInboxMessagesGet lists the mails in the development inbox, newest first
+func (s *Server) HandlerDevInboxMessagesGet(w http.ResponseWriter, r *http.Request) {
+	resp := NewResponse(http.StatusOK, "development inbox mails", InboxListResponse{Mails: s.inbox.list()})
+	if err := renderResponse(w, r, resp); err != nil {
+		s.log.Error("failed to render InboxListResponse", logger.Err(err))
+	}
+}
+
+// HandlerDevInboxMessagesDelete removes all mails from the development inbox
+func (s *Server) HandlerDevInboxMessagesDelete(w http.ResponseWriter, r *http.Request) {
+	s.inbox.clear()
+	resp := NewResponse(http.StatusOK, "development inbox cleared", InboxListResponse{Mails: s.inbox.list()})
+	if err := renderResponse(w, r, resp); err != nil {
+		s.log.Error("failed to render InboxListResponse", logger.Err(err))
+	}
+}
+
+// HandlerDevInboxMessageGet returns a mail of the development inbox with its bodies and attachments
+func (s *Server) HandlerDevInboxMessageGet(w http.ResponseWriter, r *http.Request) {
+	message, ok := s.inboxMessage(w, r)
+	if !ok {
+		return
+	}
+	resp := NewResponse(http.StatusOK, "development inbox mail", message)
+	if err := renderResponse(w, r, resp); err != nil {
+		s.log.Error("failed to render InboxMessage", logger.Err(err))
+	}
+}
+
+// HandlerDevInboxMessageHTMLGet returns the HTML body of a mail of the development inbox. The body
+// is sandboxed, so that scripts in it can't run in the origin of js-mailer.
+func (s *Server) HandlerDevInboxMessageHTMLGet(w http.ResponseWriter, r *http.Request) {
+	message, ok := s.inboxMessage(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	if _, err := io.WriteString(w, message.HTML); err != nil {
+		s.log.Error("failed to write HTML body", logger.Err(err))
+	}
+}
+
+// HandlerDevInboxMessageRawGet returns a mail of the development inbox as message/rfc822
+func (s *Server) HandlerDevInboxMessageRawGet(w http.ResponseWriter, r *http.Request) {
+	captured, ok := s.inbox.get(chi.URLParam(r, "mailID"))
+	if !ok {
+		_ = renderResponse(w, r, ErrNotFound(ErrCapturedMailNotFound))
+		return
+	}
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+captured.ID+`.eml"`)
+	if _, err := w.Write(captured.data); err != nil {
+		s.log.Error("failed to write captured mail", logger.Err(err))
+	}
+}
+
+// HandlerDevInboxAttachmentGet downloads an attachment of a mail of the development inbox
+func (s *Server) HandlerDevInboxAttachmentGet(w http.ResponseWriter, r *http.Request) {
+	message, ok := s.inboxMessage(w, r)
+	if !ok {
+		return
+	}
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil || index < 0 || index >= len(message.Attachments) {
+		_ = renderResponse(w, r, ErrNotFound(ErrAttachmentNotFound))
+		return
+	}
+	attachment := message.Attachments[index]
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err = w.Write(attachment.data); err != nil {
+		s.log.Error("failed to write attachment", logger.Err(err))
+	}
+}
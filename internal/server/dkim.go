@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"os"
+	"strings"
+
+	"github.com/wneessen/go-mail"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+)
+
+// ErrDKIMIncomplete is returned if a DKIM configuration lacks the selector or the private key
+var ErrDKIMIncomplete = errors.New("DKIM configuration requires a selector and a private key")
+
+// dkimSigner returns the DKIM signer for the form mails. The DKIM configuration of the form takes
+// precedence over the server configuration for the domain of the sender address. If neither is
+// configured, nil is returned.
+func (s *Server) dkimSigner(form *forms.Form) (*mail.DKIMSigner, error) {
+	sender, err := netmail.ParseAddress(form.Sender)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sender address: %w", err)
+	}
+	senderDomain := sender.Address[strings.LastIndex(sender.Address, "@")+1:]
+
+	config := form.DKIM
+	if config.Selector == "" && config.PrivateKey == "" {
+		var found bool
+		for _, entry := range s.config.DKIM {
+			if strings.EqualFold(entry.Domain, senderDomain) {
+				config, found = forms.DKIMConfig(entry), true
+				break
+			}
+		}
+		if !found {
+			return nil, nil
+		}
+	}
+	if config.Selector == "" || config.PrivateKey == "" {
+		return nil, ErrDKIMIncomplete
+	}
+	if config.Domain == "" {
+		config.Domain = senderDomain
+	}
+
+	pemBytes, err := os.ReadFile(config.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DKIM private key: %w", err)
+	}
+	key, err := mail.PrivKeyFromPEM(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse DKIM private key %s: %w", config.PrivateKey, err)
+	}
+	signer := mail.NewDKIMSigner(strings.ToLower(config.Domain), config.Selector, key)
+	signer.SignHeaders(config.Headers...)
+	if err = signer.ValidateConfig(); err != nil {
+		return nil, fmt.Errorf("invalid DKIM configuration: %w", err)
+	}
+	return signer, nil
+}
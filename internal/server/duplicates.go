@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+)
+
+const (
+	// DuplicateActionReject rejects duplicate submissions
+	DuplicateActionReject = "reject"
+
+	// DuplicateActionAccept accepts duplicate submissions without delivering them
+	DuplicateActionAccept = "accept"
+)
+
+var (
+	ErrDuplicateSubmission    = errors.New("form submission is a duplicate")
+	ErrUnknownDuplicateAction = errors.New("unknown duplicate action")
+)
+
+// checkDuplicate stores the hash of the normalized values of the duplicate fields in the cache
+// and returns the cache key and true if the same hash was already stored within the window. If
+// no fields are configured, the content fields of the form are used. Submissions in which all
+// of these fields are missing or empty are never treated as duplicates.
+func (s *Server) checkDuplicate(form *forms.Form, submission map[string][]string) (string, bool, error) {
+	config := form.Validation.Duplicates
+	switch strings.ToLower(config.Action) {
+	case DuplicateActionReject, DuplicateActionAccept:
+	default:
+		return "", false, fmt.Errorf("%w: %q", ErrUnknownDuplicateAction, config.Action)
+	}
+
+	fields := config.Fields
+	if len(fields) == 0 {
+		fields = form.Content.Fields
+	}
+	empty := true
+	hasher := sha256.New()
+	hasher.Write([]byte(form.ID))
+	for _, field := range fields {
+		hasher.Write([]byte{0})
+		hasher.Write([]byte(field))
+		for _, value := range submission[field] {
+			normalized := strings.ToLower(strings.Join(strings.Fields(value), " "))
+			if normalized != "" {
+				empty = false
+			}
+			hasher.Write([]byte{0})
+			hasher.Write([]byte(normalized))
+		}
+	}
+	if empty {
+		return "", false, nil
+	}
+	key := "duplicate_" + hex.EncodeToString(hasher.Sum(nil))
+
+	stored, err := s.cache.SetNX(key, config.Window)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to store duplicate hash in cache: %w", err)
+	}
+	return key, !stored, nil
+}
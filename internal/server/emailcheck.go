@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/wneessen/js-mailer/internal/deliverability"
+	"github.com/wneessen/js-mailer/internal/forms"
+	"github.com/wneessen/js-mailer/internal/logger"
+)
+
+// undeliverable runs the configured deliverability checks of the field against the email address
+// and returns the reason if the address fails one of them. Failed DNS lookups don't fail the check.
+func (s *Server) undeliverable(ctx context.Context, field forms.ValidationField, address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+	localPart, domain := address[:at], address[at+1:]
+	if field.RejectRoleAccounts && deliverability.IsRoleAccount(localPart) {
+		return "role account email addresses are not allowed"
+	}
+	if field.RejectDisposable && s.deliverability.IsDisposable(domain) {
+		return "disposable email addresses are not allowed"
+	}
+	if field.CheckMX {
+		hasMailHost, err := s.deliverability.HasMailHost(ctx, domain)
+		if err != nil {
+			s.log.Warn("failed to look up mail host, skipping MX check", slog.String("field", field.Name),
+				slog.String("domain", domain), logger.Err(err))
+			return ""
+		}
+		if !hasMailHost {
+			return "email domain does not accept mail"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/wneessen/go-mail"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+	"github.com/wneessen/js-mailer/internal/geoip"
+	"github.com/wneessen/js-mailer/internal/logger"
+)
+
+const (
+	headerClientCountry = "X-Client-Country"
+	headerClientASN     = "X-Client-ASN"
+)
+
+var ErrGeoIPNotConfigured = errors.New("geoip database is not configured")
+
+// geoBlocked checks the client address against the country and ASN restrictions of the form. If
+// the address can't be looked up, the client is blocked unless the form is configured to fail open.
+func (s *Server) geoBlocked(log *slog.Logger, addr netip.Addr, config forms.GeoIPConfig) (bool, string) {
+	if len(config.AllowedCountries)+len(config.BlockedCountries)+len(config.AllowedASNs)+
+		len(config.BlockedASNs) == 0 {
+		return false, ""
+	}
+	record, err := s.geoIPLookup(addr)
+	if err != nil {
+		if config.FailOpen {
+			log.Warn("geoip lookup failed, skipping country restrictions", logger.Err(err))
+			return false, ""
+		}
+		log.Error("geoip lookup failed", logger.Err(err))
+		return true, "geoip lookup failed"
+	}
+
+	hasCountry := func(countries []string) bool {
+		return slices.ContainsFunc(countries, func(country string) bool {
+			return strings.EqualFold(country, record.Country)
+		})
+	}
+	switch {
+	case len(config.AllowedCountries) > 0 && !hasCountry(config.AllowedCountries):
+		return true, fmt.Sprintf("country %q not allowed", record.Country)
+	case hasCountry(config.BlockedCountries):
+		return true, fmt.Sprintf("country %q blocked", record.Country)
+	case len(config.AllowedASNs) > 0 && !slices.Contains(config.AllowedASNs, record.ASN):
+		return true, fmt.Sprintf("ASN %d not allowed", record.ASN)
+	case slices.Contains(config.BlockedASNs, record.ASN):
+		return true, fmt.Sprintf("ASN %d blocked", record.ASN)
+	}
+	return false, ""
+}
+
+// geoIPMetadata adds the country and ASN of the client to the form mail headers and returns the
+// logger with the country attached.
+func (s *Server) geoIPMetadata(r *http.Request, log *slog.Logger, message *mail.Msg) *slog.Logger {
+	addr, err := clientAddr(r)
+	if err != nil {
+		log.Warn("failed to determine client address for geoip metadata", logger.Err(err))
+		return log
+	}
+	record, err := s.geoIPLookup(addr)
+	if err != nil {
+		log.Warn("geoip lookup failed, skipping geoip metadata", logger.Err(err))
+		return log
+	}
+	if record.Country != "" {
+		message.SetGenHeader(headerClientCountry, record.Country)
+	}
+	if record.ASN != 0 {
+		asn := "AS" + strconv.FormatUint(uint64(record.ASN), 10)
+		if record.ASOrganization != "" {
+			asn += " " + record.ASOrganization
+		}
+		message.SetGenHeader(headerClientASN, asn)
+	}
+	return log.With(slog.String("country", record.Country))
+}
+
+// geoIPLookup looks up the address in the configured geoip databases
+func (s *Server) geoIPLookup(addr netip.Addr) (*geoip.Record, error) {
+	if s.geoip == nil {
+		return nil, ErrGeoIPNotConfigured
+	}
+	return s.geoip.Lookup(addr)
+}
@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/wneessen/js-mailer/internal/logger"
+)
+
+// ErrCapturedMailNotFound is returned if a captured mail is not (or no longer) kept in memory
+var ErrCapturedMailNotFound = errors.New("captured mail not found")
+
+// DryRunListResponse is the JSON response struct for the list of captured dry-run mails
+type DryRunListResponse struct {
+	Mails []*capturedMail `json:"mails"`
+}
+
+// HandlerAdminDryRunGet lists the captured dry-run mails that are kept in memory, newest first
+func (s *Server) HandlerAdminDryRunGet(w http.ResponseWriter, r *http.Request) {
+	resp := NewResponse(http.StatusOK, "captured dry-run mails", DryRunListResponse{Mails: s.capture.list()})
+	if err := renderResponse(w, r, resp); err != nil {
+		s.log.Error("failed to render DryRunListResponse", logger.Err(err))
+	}
+}
+
+// HandlerAdminDryRunMailGet returns a captured dry-run mail as message/rfc822
+func (s *Server) HandlerAdminDryRunMailGet(w http.ResponseWriter, r *http.Request) {
+	captured, ok := s.capture.get(chi.URLParam(r, "mailID"))
+	if !ok {
+		_ = renderResponse(w, r, ErrNotFound(ErrCapturedMailNotFound))
+		return
+	}
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+captured.ID+`.eml"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(captured.data)))
+	if _, err := w.Write(captured.data); err != nil {
+		s.log.Error("failed to write captured mail", logger.Err(err))
+	}
+}
@@ -7,8 +7,6 @@ package server
 import (
 	"net/http"
 
-	"github.com/go-chi/render"
-
 	"github.com/wneessen/js-mailer/internal/logger"
 )
 
@@ -23,7 +21,7 @@ func (s *Server) HandlerAPIPingGet(w http.ResponseWriter, r *http.Request) {
 			Ping: "pong",
 		},
 	)
-	if err := render.Render(w, r, resp); err != nil {
+	if err := renderResponse(w, r, resp); err != nil {
 		s.log.Error("failed to render PingResponse", logger.Err(err))
 	}
 }
@@ -5,25 +5,28 @@
 package server
 
 import (
+	"context"
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
 	"net/mail"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/render"
 
 	"github.com/wneessen/js-mailer/internal/cache"
 	"github.com/wneessen/js-mailer/internal/forms"
 	"github.com/wneessen/js-mailer/internal/logger"
+	"github.com/wneessen/js-mailer/internal/spam"
 )
 
 // SendResponse is the JSON response struct for the send endpoint
@@ -34,6 +37,29 @@ type SendResponse struct {
 	MessageResponse      string `json:"message_response"`
 }
 
+// FieldError describes a single form field that did not pass validation
+type FieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// Error satisfies the error interface for FieldError
+func (f FieldError) Error() string {
+	return f.Field + ": " + f.Detail
+}
+
+// FieldErrors is a list of form fields that did not pass validation
+type FieldErrors []FieldError
+
+// Error satisfies the error interface for FieldErrors
+func (f FieldErrors) Error() string {
+	errs := make([]string, len(f))
+	for i, fieldErr := range f {
+		errs[i] = fieldErr.Error()
+	}
+	return strings.Join(errs, "\n")
+}
+
 const (
 	formMaxMemory       = 32 << 20
 	formSubmissionSpeed = time.Second * 3
@@ -46,6 +72,9 @@ var (
 	ErrRequiredFieldsValidationFailed = errors.New("required fields validation failed")
 	ErrCaptchaValidationFailed        = errors.New("captcha validation failed")
 	ErrFormSubmittedTooFast           = errors.New("form submission was not expected yet")
+	ErrFormSubmittedTooLate           = errors.New("form submission took too long")
+	ErrSubmissionRejectedAsSpam       = errors.New("form submission was rejected as spam")
+	ErrSubmissionInfected             = errors.New("form submission contains malware")
 )
 
 func (s *Server) HandlerAPISendFormPost(w http.ResponseWriter, r *http.Request) {
@@ -53,18 +82,18 @@ func (s *Server) HandlerAPISendFormPost(w http.ResponseWriter, r *http.Request)
 	formID := chi.URLParam(r, "formID")
 	hash := chi.URLParam(r, "hash")
 	if formID == "" || hash == "" {
-		_ = render.Render(w, r, ErrBadRequest(ErrMissingFormIDOrHash))
+		_ = renderResponse(w, r, ErrBadRequest(ErrMissingFormIDOrHash))
 		return
 	}
 	providedHash, err := hex.DecodeString(hash)
 	if err != nil {
 		log.Error("failed to decode provided form token hash", logger.Err(err))
-		_ = render.Render(w, r, ErrBadRequest(ErrInvalidFormIDOrToken))
+		_ = renderResponse(w, r, ErrBadRequest(ErrInvalidFormIDOrToken))
 		return
 	}
 	if len(providedHash) != sha256.Size {
 		log.Error("invalid form token hash length", slog.Int("length", len(providedHash)))
-		_ = render.Render(w, r, ErrBadRequest(ErrInvalidFormIDOrToken))
+		_ = renderResponse(w, r, ErrBadRequest(ErrInvalidFormIDOrToken))
 		return
 	}
 
@@ -79,7 +108,7 @@ func (s *Server) HandlerAPISendFormPost(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		log.Error("failed to validate requested form", logger.Err(err), slog.String("formID", formID),
 			slog.String("hash", hash))
-		_ = render.Render(w, r, ErrNotFound(ErrInvalidFormIDOrToken))
+		_ = renderResponse(w, r, ErrNotFound(ErrInvalidFormIDOrToken))
 		return
 	}
 	tokenCreatedAt := params.TokenCreatedAt
@@ -93,35 +122,60 @@ func (s *Server) HandlerAPISendFormPost(w http.ResponseWriter, r *http.Request)
 	computedHash := hasher.Sum(nil)
 	if subtle.ConstantTimeCompare(computedHash, providedHash) != 1 {
 		log.Error("invalid form token", slog.String("formID", formID), slog.String("hash", hash))
-		_ = render.Render(w, r, ErrNotFound(ErrInvalidFormIDOrToken))
+		_ = renderResponse(w, r, ErrNotFound(ErrInvalidFormIDOrToken))
+		return
+	}
+	if time.Now().After(tokenExpiresAt) {
+		log.Error("form token expired", slog.String("formID", formID), slog.String("hash", hash),
+			slog.Time("expired_at", tokenExpiresAt))
+		_ = renderResponse(w, r, ErrNotFound(ErrInvalidFormIDOrToken))
+		return
+	}
+	if reason := tokenBindingMismatch(r, form, params); reason != "" {
+		log.Warn("form token is bound to a different client", slog.String("formID", formID),
+			slog.String("hash", hash), slog.String("reason", reason))
+		_ = renderResponse(w, r, ErrNotFound(ErrInvalidFormIDOrToken))
 		return
 	}
 
 	// Parse the form submission
 	if err = r.ParseMultipartForm(formMaxMemory); err != nil {
 		log.Error("failed to parse form submission", logger.Err(err))
-		_ = render.Render(w, r, ErrUnexpected(ErrFailedToParseForm))
+		_ = renderResponse(w, r, ErrUnexpected(ErrFailedToParseForm))
 		return
 	}
 
 	// Check the submission speed
+	fillTime := time.Since(tokenCreatedAt)
 	if !form.Validation.DisableSubmissionSpeedCheck {
-		if time.Since(tokenCreatedAt) < formSubmissionSpeed {
+		minFillTime := formSubmissionSpeed
+		if form.Validation.MinFillTime > 0 {
+			minFillTime = form.Validation.MinFillTime
+		}
+		if fillTime < minFillTime {
 			log.Error("form was submitted too fast", slog.String("formID", formID),
 				slog.String("hash", hash),
-				slog.String("submission_speed", time.Since(tokenCreatedAt).String()),
+				slog.String("submission_speed", fillTime.String()),
 			)
-			_ = render.Render(w, r, NewErrResponse(http.StatusTooEarly, ErrFormSubmittedTooFast))
+			_ = renderResponse(w, r, NewErrResponse(http.StatusTooEarly, ErrFormSubmittedTooFast))
 			return
 		}
 	}
+	if form.Validation.MaxFillTime > 0 && fillTime > form.Validation.MaxFillTime {
+		log.Error("form was submitted too slow", slog.String("formID", formID),
+			slog.String("hash", hash),
+			slog.String("submission_speed", fillTime.String()),
+		)
+		_ = renderResponse(w, r, NewErrResponse(http.StatusGone, ErrFormSubmittedTooLate))
+		return
+	}
 
 	// Check for honeypot fields
 	if form.Validation.Honeypot != "" {
 		fails := s.failsHoneypot(form.Validation.Honeypot, r.MultipartForm.Value)
 		if fails {
 			log.Warn("submitted values did not pass honeypot validation")
-			_ = render.Render(w, r, ErrNotFound(ErrInvalidFormIDOrToken))
+			_ = renderResponse(w, r, ErrNotFound(ErrInvalidFormIDOrToken))
 			return
 		}
 	}
@@ -132,21 +186,21 @@ func (s *Server) HandlerAPISendFormPost(w http.ResponseWriter, r *http.Request)
 		if fails {
 			log.Warn("submitted values did not pass random anti spam field validation",
 				slog.String("field", params.RandomFieldName), slog.String("value", params.RandomFieldValue))
-			_ = render.Render(w, r, ErrNotFound(ErrInvalidFormIDOrToken))
+			_ = renderResponse(w, r, ErrNotFound(ErrInvalidFormIDOrToken))
 			return
 		}
 	}
 
 	// Check if required fields are present
 	if len(form.Validation.Fields) > 0 {
-		fails, missingFields := s.failsRequiredFields(form.Validation.Fields, r.MultipartForm.Value)
+		fails, missingFields := s.failsRequiredFields(r.Context(), form.Validation.Fields, r.MultipartForm.Value)
 		if fails {
 			log.Warn("submitted values did not pass required field validation")
-			errList := []error{ErrRequiredFieldsValidationFailed}
-			for field, msg := range missingFields {
-				errList = append(errList, fmt.Errorf("%s: %s", field, msg))
+			fieldErrs := make(FieldErrors, 0, len(missingFields))
+			for _, field := range slices.Sorted(maps.Keys(missingFields)) {
+				fieldErrs = append(fieldErrs, FieldError{Field: field, Detail: missingFields[field]})
 			}
-			_ = render.Render(w, r, ErrBadRequest(errors.Join(errList...)))
+			_ = renderResponse(w, r, ErrBadRequest(errors.Join(ErrRequiredFieldsValidationFailed, fieldErrs)))
 			return
 		}
 	}
@@ -156,18 +210,97 @@ func (s *Server) HandlerAPISendFormPost(w http.ResponseWriter, r *http.Request)
 	if val := middleware.GetClientIP(r.Context()); val != "" {
 		clientIP = val
 	}
-	if err = s.validateCaptcha(r.Context(), form, r.MultipartForm.Value, clientIP); err != nil {
+	var opts deliveryOptions
+	flagged, err := s.validateCaptcha(r.Context(), form, r.MultipartForm.Value, clientIP, params)
+	if err != nil {
 		log.Error("captcha validation failed", logger.Err(err))
-		_ = render.Render(w, r, ErrNotFound(ErrCaptchaValidationFailed))
+		_ = renderResponse(w, r, ErrNotFound(ErrCaptchaValidationFailed))
 		return
 	}
+	if flagged {
+		log.Warn("submission was flagged as suspicious by the captcha provider",
+			slog.String("spam_tag", form.Validation.SpamTag))
+		opts.addSubjectTag(form.Validation.SpamTag)
+	}
+
+	// Score the submission content against the spam rules
+	if form.Validation.Spam.Enabled {
+		result, err := s.scoreSubmission(form, r.MultipartForm.Value)
+		if err != nil {
+			log.Error("failed to score form submission", logger.Err(err))
+			_ = renderResponse(w, r, ErrUnexpected(err))
+			return
+		}
+		log.Debug("form submission spam score", slog.Float64("score", result.Score),
+			slog.String("action", string(result.Action)), slog.Any("hits", result.Hits))
+
+		if result.Action == spam.ActionReject {
+			log.Warn("form submission was rejected as spam", slog.Float64("score", result.Score))
+			_ = renderResponse(w, r, NewErrResponse(http.StatusUnprocessableEntity, ErrSubmissionRejectedAsSpam))
+			return
+		}
+		if result.Action != spam.ActionNone {
+			log.Warn("form submission was classified as spam", slog.Float64("score", result.Score),
+				slog.String("action", string(result.Action)))
+		}
+		opts.applySpamAction(form, result.Action)
+	}
+
+	// Check for duplicate submissions
+	var duplicateKey string
+	if form.Validation.Duplicates.Enabled {
+		key, duplicate, err := s.checkDuplicate(form, r.MultipartForm.Value)
+		if err != nil {
+			log.Error("failed to check for duplicate submission", logger.Err(err))
+			_ = renderResponse(w, r, ErrUnexpected(err))
+			return
+		}
+		if duplicate && strings.EqualFold(form.Validation.Duplicates.Action, DuplicateActionAccept) {
+			log.Warn("duplicate form submission was accepted without delivery", slog.String("formID", form.ID))
+			resp := NewResponse(http.StatusOK, "form mail successfully delivered",
+				&SendResponse{FormID: form.ID, SentAt: time.Now().Unix()})
+			if renderErr := renderResponse(w, r, resp); renderErr != nil {
+				log.Error("failed to render SendResponse", logger.Err(renderErr))
+			}
+			return
+		}
+		if duplicate {
+			log.Warn("duplicate form submission was rejected", slog.String("formID", form.ID))
+			_ = renderResponse(w, r, NewErrResponse(http.StatusConflict, ErrDuplicateSubmission))
+			return
+		}
+		duplicateKey = key
+	}
 
 	// Compose and deliver the actual form mail
 	now := time.Now()
-	confirmationResponse, messageResponse, err := s.sendMail(r, form)
-	if err != nil {
+	confirmationResponse, messageResponse, err := s.sendMail(r, form, opts)
+	if err != nil && duplicateKey != "" {
+		// Allow the poster to retry the failed submission
+		if rmErr := s.cache.Remove(duplicateKey); rmErr != nil {
+			log.Error("failed to remove duplicate hash from cache", logger.Err(rmErr))
+		}
+	}
+	switch {
+	case errors.Is(err, ErrSubmissionRejectedAsSpam):
+		log.Warn("form mail was rejected as spam")
+		_ = renderResponse(w, r, NewErrResponse(http.StatusUnprocessableEntity, ErrSubmissionRejectedAsSpam))
+		return
+	case errors.Is(err, ErrSubmissionInfected):
+		log.Warn("form submission was rejected due to infected files")
+		_ = renderResponse(w, r, NewErrResponse(http.StatusUnprocessableEntity, ErrSubmissionInfected))
+		return
+	case errors.Is(err, ErrVirusScanUnavailable):
+		log.Error("failed to scan form submission for malware", logger.Err(err))
+		_ = renderResponse(w, r, NewErrResponse(http.StatusServiceUnavailable, ErrVirusScanUnavailable))
+		return
+	case errors.Is(err, ErrSpamCheckUnavailable):
+		log.Error("failed to check form mail for spam", logger.Err(err))
+		_ = renderResponse(w, r, NewErrResponse(http.StatusServiceUnavailable, ErrSpamCheckUnavailable))
+		return
+	case err != nil:
 		log.Error("failed to send form mail", logger.Err(err))
-		_ = render.Render(w, r, ErrUnexpected(err))
+		_ = renderResponse(w, r, ErrUnexpected(err))
 		return
 	}
 
@@ -179,7 +312,7 @@ func (s *Server) HandlerAPISendFormPost(w http.ResponseWriter, r *http.Request)
 	}
 
 	resp := NewResponse(http.StatusOK, "form mail successfully delivered", sendRes)
-	if renderErr := render.Render(w, r, resp); renderErr != nil {
+	if renderErr := renderResponse(w, r, resp); renderErr != nil {
 		log.Error("failed to render SendResponse", logger.Err(renderErr))
 	}
 	log.Info("form mail successfully delivered", slog.String("formID", form.ID),
@@ -214,7 +347,9 @@ func (s *Server) failsHoneypot(honeyField string, values map[string][]string) bo
 }
 
 // failsRequiredFields checks if the submitted values fail the required field validation.
-func (s *Server) failsRequiredFields(validations []forms.ValidationField, submission map[string][]string) (bool, map[string]string) {
+func (s *Server) failsRequiredFields(ctx context.Context, validations []forms.ValidationField,
+	submission map[string][]string,
+) (bool, map[string]string) {
 	invalidFields := make(map[string]string)
 
 	for _, field := range validations {
@@ -232,11 +367,17 @@ func (s *Server) failsRequiredFields(validations []forms.ValidationField, submis
 		case "text":
 			continue
 		case "email":
-			_, err := mail.ParseAddress(value)
+			addr, err := mail.ParseAddress(value)
 			if err != nil {
 				s.log.Warn("field is not of type email", logger.Err(err), slog.String("field", field.Name),
 					slog.String("value", value))
 				invalidFields[field.Name] = "field is not of type email"
+				continue
+			}
+			if reason := s.undeliverable(ctx, field, addr.Address); reason != "" {
+				s.log.Warn("email address failed deliverability check", slog.String("field", field.Name),
+					slog.String("value", value), slog.String("reason", reason))
+				invalidFields[field.Name] = reason
 			}
 			continue
 		case "number":
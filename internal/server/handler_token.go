@@ -9,13 +9,13 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/render"
 
 	"github.com/wneessen/js-mailer/internal/cache"
 	"github.com/wneessen/js-mailer/internal/forms"
@@ -33,28 +33,30 @@ var (
 
 // TokenResponse is the JSON response struct for the token endpoint
 type TokenResponse struct {
-	Token       string `json:"token"`
-	FormID      string `json:"form_id"`
-	CreateTime  int64  `json:"create_time,omitempty"`
-	ExpireTime  int64  `json:"expire_time,omitempty"`
-	URL         string `json:"url"`
-	Encoding    string `json:"encoding"`
-	ReqMethod   string `json:"request_method"`
-	RandomField string `json:"random_field,omitempty"`
+	Token       string           `json:"token"`
+	FormID      string           `json:"form_id"`
+	CreateTime  int64            `json:"create_time,omitempty"`
+	ExpireTime  int64            `json:"expire_time,omitempty"`
+	URL         string           `json:"url"`
+	Encoding    string           `json:"encoding"`
+	ReqMethod   string           `json:"request_method"`
+	RandomField string           `json:"random_field,omitempty"`
+	Altcha      *AltchaChallenge `json:"altcha,omitempty"`
 }
 
 func (s *Server) HandlerAPITokenGet(w http.ResponseWriter, r *http.Request) {
 	log := s.log.With(logger.RequestID(r))
 	formID := chi.URLParam(r, "formID")
 	if formID == "" {
-		_ = render.Render(w, r, ErrBadRequest(ErrNoFormID))
+		_ = renderResponse(w, r, ErrBadRequest(ErrNoFormID))
 		return
 	}
 
 	// Get the form configuration
-	form, err := forms.New(s.config.Forms.Path, formID)
+	form, err := s.loadForm(r, formID)
 	if err != nil {
-		_ = render.Render(w, r, ErrBadRequest(err))
+		log.Error("failed to load form configuration", logger.Err(err), slog.String("formID", formID))
+		_ = renderResponse(w, r, ErrBadRequest(err))
 		return
 	}
 
@@ -67,7 +69,7 @@ func (s *Server) HandlerAPITokenGet(w http.ResponseWriter, r *http.Request) {
 	// Validate that the request is coming from the correct origin
 	origin := r.Header.Get("origin")
 	if origin == "" {
-		_ = render.Render(w, r, ErrForbidden(ErrDomainNotAllowed))
+		_ = renderResponse(w, r, ErrForbidden(ErrDomainNotAllowed))
 		return
 	}
 
@@ -76,7 +78,7 @@ func (s *Server) HandlerAPITokenGet(w http.ResponseWriter, r *http.Request) {
 		schema = "https"
 	}
 	now := time.Now()
-	expire := now.Add(s.config.Forms.DefaultExpiration)
+	expire := now.Add(s.tokenLifetime(form))
 	value := fmt.Sprintf("%s_%d_%d_%s_%s", origin, now.UnixNano(), expire.UnixNano(), form.ID, form.Secret)
 	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(value)))
 	token := &TokenResponse{
@@ -90,18 +92,46 @@ func (s *Server) HandlerAPITokenGet(w http.ResponseWriter, r *http.Request) {
 		ReqMethod:   http.MethodPost,
 		RandomField: randHTML,
 	}
+
+	// Proof-of-work challenge
+	var altchaChallenge string
+	if form.Validation.Altcha.Enabled {
+		token.Altcha, err = newAltchaChallenge(form.Secret, form.Validation.Altcha.MaxNumber, expire)
+		if err != nil {
+			log.Error("failed to create ALTCHA challenge", logger.Err(err))
+			_ = renderResponse(w, r, ErrUnexpected(err))
+			return
+		}
+		altchaChallenge = token.Altcha.Challenge
+	}
+
+	// Bind the token to the client
+	clientIP, err := tokenBindingIP(r, form.Validation.TokenBinding.IP)
+	if err != nil {
+		log.Error("failed to bind token to client IP", logger.Err(err))
+		_ = renderResponse(w, r, ErrUnexpected(err))
+		return
+	}
+	var userAgent string
+	if form.Validation.TokenBinding.UserAgent {
+		userAgent = r.UserAgent()
+	}
+
 	if err = s.cache.Set(hash, form, cache.ItemParams{
 		TokenCreatedAt:   now,
 		TokenExpiresAt:   expire,
 		RandomFieldName:  "_" + randName,
 		RandomFieldValue: randValue,
+		AltchaChallenge:  altchaChallenge,
+		ClientIP:         clientIP,
+		UserAgent:        userAgent,
 	}); err != nil {
-		_ = render.Render(w, r, ErrUnexpected(err))
+		_ = renderResponse(w, r, ErrUnexpected(err))
 		return
 	}
 
 	resp := NewResponse(http.StatusCreated, "sender token successfully created", token)
-	if renderErr := render.Render(w, r, resp); renderErr != nil {
+	if renderErr := renderResponse(w, r, resp); renderErr != nil {
 		log.Error("failed to render TokenResposne", logger.Err(renderErr))
 	}
 }
@@ -111,3 +141,11 @@ func (s *Server) antiSpamField() (string, string, string) {
 	value := strings.ToLower(rand.Text())
 	return name, value, `<input type="hidden" name="_` + name + `" value="` + value + `">`
 }
+
+// tokenLifetime returns the token lifetime of the form, falling back to the default expiration
+func (s *Server) tokenLifetime(form *forms.Form) time.Duration {
+	if form.Validation.TokenLifetime > 0 {
+		return form.Validation.TokenLifetime
+	}
+	return s.config.Forms.DefaultExpiration
+}
@@ -0,0 +1,191 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+	"github.com/wneessen/js-mailer/internal/logger"
+)
+
+// ErrInvalidAccessEntry is returned if an access list contains an invalid address or CIDR range
+var ErrInvalidAccessEntry = errors.New("invalid access list entry")
+
+// accessCheck rejects clients that are denied by the global or form access lists, by the country
+// and ASN restrictions of the form or that are listed in one of the configured DNSBLs. Blocked
+// clients get the same opaque response as invalid form submissions. The form of the request is
+// stored in the request context for the following middlewares and handlers.
+func (s *Server) accessCheck(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := s.log.With(logger.RequestID(r))
+		addr, err := clientAddr(r)
+		if err != nil {
+			log.Error("failed to determine client address", logger.Err(err))
+			_ = renderResponse(w, r, ErrNotFound(ErrInvalidFormIDOrToken))
+			return
+		}
+
+		var access forms.AccessConfig
+		var geo forms.GeoIPConfig
+		if form := s.requestForm(r); form != nil {
+			access, geo = form.Access, form.GeoIP
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyForm, form))
+		}
+
+		if blocked, reason := s.clientBlocked(r.Context(), log, addr, access, geo); blocked {
+			log.Warn("client was blocked", slog.String("client_ip", addr.String()),
+				slog.String("reason", reason))
+			_ = renderResponse(w, r, ErrNotFound(ErrInvalidFormIDOrToken))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestForm returns the form of the request. Submissions use the form that was cached when the
+// token was issued, all other requests load the form from the forms path. Nil is returned if the
+// form can't be determined.
+func (s *Server) requestForm(r *http.Request) *forms.Form {
+	if hash := chi.URLParam(r, "hash"); hash != "" {
+		form, _, err := s.formFromCache(hash)
+		if err != nil {
+			return nil
+		}
+		return form
+	}
+	formID := chi.URLParam(r, "formID")
+	if formID == "" {
+		return nil
+	}
+	form, err := s.loadForm(r, formID)
+	if err != nil {
+		return nil
+	}
+	return form
+}
+
+// loadForm returns the form that the access check stored in the request context or loads the
+// form from the forms path. Forms that reference an unknown SMTP profile are rejected.
+func (s *Server) loadForm(r *http.Request, formID string) (*forms.Form, error) {
+	if form, ok := r.Context().Value(ctxKeyForm).(*forms.Form); ok && form != nil {
+		return form, nil
+	}
+	form, err := forms.New(s.config.Forms.Path, formID)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.checkSMTPProfile(form); err != nil {
+		return nil, err
+	}
+	return form, nil
+}
+
+// clientBlocked checks the client address against the access lists, the country and ASN
+// restrictions and the DNSBLs and returns the reason if the client is blocked. Failed DNSBL
+// lookups don't block the client.
+func (s *Server) clientBlocked(ctx context.Context, log *slog.Logger, addr netip.Addr,
+	access forms.AccessConfig, geo forms.GeoIPConfig,
+) (bool, string) {
+	allow, err := parsePrefixes(access.Allow)
+	if err != nil {
+		log.Error("invalid entries in form allow list", logger.Err(err))
+	}
+	if prefixesContain(s.accessAllow, addr) || prefixesContain(allow, addr) {
+		return false, ""
+	}
+	deny, err := parsePrefixes(access.Deny)
+	if err != nil {
+		log.Error("invalid entries in form deny list", logger.Err(err))
+	}
+	if prefixesContain(s.accessDeny, addr) || prefixesContain(deny, addr) {
+		return true, "deny list"
+	}
+	if blocked, reason := s.geoBlocked(log, addr, geo); blocked {
+		return true, reason
+	}
+
+	if access.DisableDNSBL {
+		return false, ""
+	}
+	zones := append(append([]string{}, s.config.Access.DNSBL.Zones...), access.DNSBLZones...)
+	if len(zones) == 0 {
+		return false, ""
+	}
+	result, err := s.dnsbl.Lookup(ctx, addr, zones)
+	if err != nil {
+		log.Warn("dnsbl lookup failed", slog.String("client_ip", addr.String()), logger.Err(err))
+		return false, ""
+	}
+	if result.Listed {
+		return true, fmt.Sprintf("listed in dnsbl %s", result.Zone)
+	}
+	return false, ""
+}
+
+// parsePrefixes parses the list of CIDR ranges and single addresses. Invalid entries are skipped
+// and returned as joined error together with the valid entries.
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	var errs []error
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%w: %q: %w", ErrInvalidAccessEntry, entry, err))
+				continue
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: %q: %w", ErrInvalidAccessEntry, entry, err))
+			continue
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, errors.Join(errs...)
+}
+
+// prefixesContain returns true if any of the prefixes contains the address
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address of the client, preferring the client IP set by the
+// ClientIPFromHeader middleware over the remote address of the connection.
+func clientAddr(r *http.Request) (netip.Addr, error) {
+	host := middleware.GetClientIP(r.Context())
+	if host == "" {
+		host = r.RemoteAddr
+		if h, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			host = h
+		}
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("failed to parse client address %q: %w", host, err)
+	}
+	return addr.Unmap(), nil
+}
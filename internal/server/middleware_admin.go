@@ -0,0 +1,30 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrAdminUnauthorized is returned if a request to an admin endpoint has no valid admin token
+var ErrAdminUnauthorized = errors.New("missing or invalid admin token")
+
+// adminAuth only lets requests with the configured admin token as bearer token through
+func (s *Server) adminAuth(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Admin.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="js-mailer admin"`)
+			_ = renderResponse(w, r, NewErrResponse(http.StatusUnauthorized, ErrAdminUnauthorized))
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
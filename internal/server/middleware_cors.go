@@ -12,7 +12,6 @@ import (
 
 	"github.com/go-chi/chi/v5"
 
-	"github.com/wneessen/js-mailer/internal/forms"
 	"github.com/wneessen/js-mailer/internal/logger"
 )
 
@@ -33,7 +32,7 @@ func (s *Server) preflightCheck(next http.Handler) http.Handler {
 			next.ServeHTTP(w, r)
 			return
 		}
-		form, err := forms.New(s.config.Forms.Path, formID)
+		form, err := s.loadForm(r, formID)
 		if err != nil || form == nil {
 			s.log.Error("failed to load form configuration", logger.Err(err), slog.String("formID", formID))
 			next.ServeHTTP(w, r)
@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"slices"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/wneessen/go-mail"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+	"github.com/wneessen/js-mailer/internal/pgp"
+)
+
+var (
+	// ErrRecipientKeyMissing is returned if the form mail should be encrypted, but there is no
+	// PGP key for one of its recipients
+	ErrRecipientKeyMissing = errors.New("no PGP key for recipient")
+
+	// ErrPGPSigningKeyMissing is returned if the form mail should be signed, but the server has no
+	// PGP signing key configured
+	ErrPGPSigningKeyMissing = errors.New("PGP signing enabled, but no server signing key configured")
+
+	// ErrMultipleEncryption is returned if a form enables both S/MIME and PGP encryption
+	ErrMultipleEncryption = errors.New("form mail can't be encrypted with both S/MIME and PGP")
+)
+
+// applyPGP encrypts (and optionally signs) the form mail according to the PGP configuration of the
+// form. If the form mail is encrypted, a new PGP/MIME message with the headers of the form mail and
+// the encrypted content is returned.
+func (s *Server) applyPGP(form *forms.Form, message *mail.Msg) (*mail.Msg, error) {
+	config := form.PGP
+	if len(config.RecipientKeys) == 0 {
+		return message, nil
+	}
+	if len(form.SMIME.RecipientCertificates) > 0 {
+		return nil, ErrMultipleEncryption
+	}
+
+	keys, err := pgp.LoadPublicKeys(config.RecipientKeys...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load PGP recipient keys: %w", err)
+	}
+	var recipients openpgp.EntityList
+	for _, header := range []mail.AddrHeader{mail.HeaderTo, mail.HeaderCc, mail.HeaderBcc} {
+		for _, recipient := range message.GetAddrHeader(header) {
+			key, ok := pgp.KeyFor(keys, recipient.Address)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrRecipientKeyMissing, recipient.Address)
+			}
+			if !slices.Contains(recipients, key) {
+				recipients = append(recipients, key)
+			}
+		}
+	}
+
+	var signer *openpgp.Entity
+	if config.Sign {
+		if s.config.PGP.SigningKey == "" {
+			return nil, ErrPGPSigningKeyMissing
+		}
+		if signer, err = pgp.LoadPrivateKey(s.config.PGP.SigningKey, s.config.PGP.Passphrase); err != nil {
+			return nil, fmt.Errorf("failed to load PGP signing key: %w", err)
+		}
+	}
+	return encryptPGP(message, recipients, signer)
+}
+
+// encryptPGP encrypts the content of the message and returns a new message that carries the
+// headers of the message and the encrypted content as multipart/encrypted body (RFC 3156).
+func encryptPGP(message *mail.Msg, recipients openpgp.EntityList, signer *openpgp.Entity) (*mail.Msg, error) {
+	encrypted, entity, err := splitEntity(message)
+	if err != nil {
+		return nil, err
+	}
+	armored, err := pgp.Encrypt(entity, recipients, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt message with PGP: %w", err)
+	}
+	body := bytes.NewBuffer(nil)
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {mail.TypePGPEncrypted.String()},
+		"Content-Description": {"PGP/MIME version identification"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PGP/MIME version part: %w", err)
+	}
+	if _, err = io.WriteString(part, "Version: 1\r\n"); err != nil {
+		return nil, fmt.Errorf("failed to write PGP/MIME version part: %w", err)
+	}
+	part, err = writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {`application/octet-stream; name="encrypted.asc"`},
+		"Content-Description": {"OpenPGP encrypted message"},
+		"Content-Disposition": {`inline; filename="encrypted.asc"`},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PGP/MIME encrypted part: %w", err)
+	}
+	if _, err = io.WriteString(part, strings.ReplaceAll(string(armored), "\n", "\r\n")); err != nil {
+		return nil, fmt.Errorf("failed to write PGP/MIME encrypted part: %w", err)
+	}
+	if err = writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close PGP/MIME body: %w", err)
+	}
+	contentType := mail.ContentType(`multipart/encrypted; protocol="application/pgp-encrypted"; boundary="` +
+		writer.Boundary() + `"`)
+	encrypted.SetCharset(mail.CharsetASCII)
+	encrypted.SetBodyString(contentType, body.String(), mail.WithPartEncoding(mail.NoEncoding))
+	return encrypted, nil
+}
@@ -5,12 +5,34 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+)
+
+const (
+	// contentTypeProblemJSON is the media type for RFC 9457 problem details
+	contentTypeProblemJSON = "application/problem+json"
+
+	// problemTypeBase is the URI prefix for all problem types emitted by js-mailer
+	problemTypeBase = "urn:js-mailer:problem:"
+)
+
+type ctxKey int
+
+const (
+	ctxKeyProblemDetails ctxKey = iota
+	ctxKeyForm
 )
 
 type Response struct {
@@ -22,6 +44,45 @@ type Response struct {
 	RequestID  string    `json:"request_id,omitempty"`
 	Data       any       `json:"data,omitempty"`
 	Errors     []string  `json:"errors,omitempty"`
+
+	err error
+}
+
+// ProblemDetails represents an RFC 9457 problem details object
+type ProblemDetails struct {
+	Type     string      `json:"type"`
+	Title    string      `json:"title"`
+	Status   int         `json:"status"`
+	Detail   string      `json:"detail,omitempty"`
+	Instance string      `json:"instance,omitempty"`
+	Errors   FieldErrors `json:"errors,omitempty"`
+}
+
+// problemType maps an error kind to its problem type URI suffix and title
+type problemType struct {
+	err   error
+	name  string
+	title string
+}
+
+// problemTypes is the list of known error kinds. The first entry that matches the error
+// of a response determines the problem type.
+var problemTypes = []problemType{
+	{ErrNoFormID, "missing-parameter", "Missing request parameter"},
+	{ErrMissingFormIDOrHash, "missing-parameter", "Missing request parameter"},
+	{ErrInvalidFormIDOrToken, "invalid-token", "Invalid form ID or token"},
+	{ErrDomainNotAllowed, "domain-not-allowed", "Domain not allowed"},
+	{forms.ErrFormNotFound, "form-not-found", "Form not found"},
+	{ErrFailedToParseForm, "invalid-submission", "Form submission could not be parsed"},
+	{ErrFormSubmittedTooFast, "submitted-too-early", "Form submitted too early"},
+	{ErrFormSubmittedTooLate, "submitted-too-late", "Form submitted too late"},
+	{ErrRequiredFieldsValidationFailed, "validation-failed", "Form field validation failed"},
+	{ErrCaptchaValidationFailed, "captcha-failed", "Captcha validation failed"},
+	{ErrSubmissionRejectedAsSpam, "spam-rejected", "Form submission rejected as spam"},
+	{ErrSpamCheckUnavailable, "spam-check-unavailable", "Spam check unavailable"},
+	{ErrSubmissionInfected, "virus-detected", "Form submission contains malware"},
+	{ErrVirusScanUnavailable, "virus-scan-unavailable", "Virus scan unavailable"},
+	{ErrDuplicateSubmission, "duplicate-submission", "Duplicate form submission"},
 }
 
 // Render satisfies the go-chi render.Renderer interface.
@@ -36,6 +97,37 @@ func (re *Response) Render(_ http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// ProblemDetails converts the error response into an RFC 9457 problem details object.
+func (re *Response) ProblemDetails() *ProblemDetails {
+	problem := &ProblemDetails{
+		Type:     "about:blank",
+		Title:    http.StatusText(re.StatusCode),
+		Status:   re.StatusCode,
+		Instance: re.RequestID,
+	}
+	for _, kind := range problemTypes {
+		if re.err != nil && errors.Is(re.err, kind.err) {
+			problem.Type = problemTypeBase + kind.name
+			problem.Title = kind.title
+			break
+		}
+	}
+
+	var fieldErrs FieldErrors
+	if re.err != nil && errors.As(re.err, &fieldErrs) {
+		problem.Errors = fieldErrs
+	}
+	details := make([]string, 0, len(re.Errors))
+	for _, msg := range re.Errors {
+		if !slices.ContainsFunc(fieldErrs, func(fieldErr FieldError) bool { return fieldErr.Error() == msg }) {
+			details = append(details, msg)
+		}
+	}
+	problem.Detail = strings.Join(details, "; ")
+
+	return problem
+}
+
 func NewResponse(code int, msg string, data any) *Response {
 	return &Response{
 		Success:    true,
@@ -56,6 +148,7 @@ func NewErrResponse(code int, err error) render.Renderer {
 		Message:    "request could not be processed",
 		Timestamp:  time.Now().UTC(),
 		Errors:     errList,
+		err:        err,
 	}
 }
 
@@ -74,3 +167,62 @@ func ErrNotFound(err error) render.Renderer {
 func ErrUnexpected(err error) render.Renderer {
 	return NewErrResponse(http.StatusInternalServerError, err)
 }
+
+// renderResponse renders the response like render.Render, but hands it to respond instead of
+// the global go-chi responder.
+func renderResponse(w http.ResponseWriter, r *http.Request, v render.Renderer) error {
+	if err := v.Render(w, r); err != nil {
+		return err
+	}
+	respond(w, r, v)
+	return nil
+}
+
+// respond writes the response. Error responses are rendered as RFC 9457 problem details if the
+// client or the server configuration asks for it, everything else is handed to the go-chi
+// default responder.
+func respond(w http.ResponseWriter, r *http.Request, v any) {
+	resp, ok := v.(*Response)
+	if !ok || resp.Success || !wantsProblemDetails(r) {
+		render.DefaultResponder(w, r, v)
+		return
+	}
+
+	buf, err := json.Marshal(resp.ProblemDetails())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeProblemJSON)
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write(buf)
+}
+
+// wantsProblemDetails returns true if problem details are enabled globally or if the client
+// explicitly accepts the problem details media type.
+func wantsProblemDetails(r *http.Request) bool {
+	if enabled, ok := r.Context().Value(ctxKeyProblemDetails).(bool); ok && enabled {
+		return true
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(mediaType, contentTypeProblemJSON) {
+			return true
+		}
+	}
+	return false
+}
+
+// problemDetails is a middleware that marks the request context for problem details error
+// responses if they are enabled in the server configuration.
+func (s *Server) problemDetails(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.config.Server.ProblemDetails {
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyProblemDetails, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -47,15 +47,33 @@ func (s *Server) routes(_ context.Context) {
 	s.mux.Use(middleware.StripSlashes)
 	s.mux.Use(middleware.Compress(5))
 	s.mux.Use(logHandler)
+	s.mux.Use(s.problemDetails)
 
 	// Register routes
 	s.mux.Get("/ping", s.HandlerAPIPingGet)
-	s.mux.With(s.preflightCheck).Route("/token/{formID}", func(r chi.Router) {
+	s.mux.With(s.accessCheck, s.preflightCheck).Route("/token/{formID}", func(r chi.Router) {
 		r.Get("/", s.HandlerAPITokenGet)
 		r.Options("/", s.HandlerAPITokenGet)
 	})
-	s.mux.With(s.preflightCheck).Route("/send/{formID}/{hash}", func(r chi.Router) {
+	s.mux.With(s.accessCheck, s.preflightCheck).Route("/send/{formID}/{hash}", func(r chi.Router) {
 		r.Post("/", s.HandlerAPISendFormPost)
 		r.Options("/", s.HandlerAPISendFormPost)
 	})
+	if s.inbox != nil {
+		s.mux.Route("/dev/inbox", func(r chi.Router) {
+			r.Get("/", s.HandlerDevInboxGet)
+			r.Get("/api/messages", s.HandlerDevInboxMessagesGet)
+			r.Delete("/api/messages", s.HandlerDevInboxMessagesDelete)
+			r.Get("/api/messages/{mailID}", s.HandlerDevInboxMessageGet)
+			r.Get("/api/messages/{mailID}/html", s.HandlerDevInboxMessageHTMLGet)
+			r.Get("/api/messages/{mailID}/raw", s.HandlerDevInboxMessageRawGet)
+			r.Get("/api/messages/{mailID}/attachments/{index}", s.HandlerDevInboxAttachmentGet)
+		})
+	}
+	if s.config.Admin.Token != "" {
+		s.mux.With(s.adminAuth).Route("/admin", func(r chi.Router) {
+			r.Get("/dry-run", s.HandlerAdminDryRunGet)
+			r.Get("/dry-run/{mailID}", s.HandlerAdminDryRunMailGet)
+		})
+	}
 }
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+)
+
+const (
+	// RoutingMatchExact matches the submitted value against the value of a routing rule
+	RoutingMatchExact = "exact"
+
+	// RoutingMatchRegex matches the submitted value against the regular expression of a routing rule
+	RoutingMatchRegex = "regex"
+
+	// RoutingMatchIn matches the submitted value against the list of values of a routing rule
+	RoutingMatchIn = "in"
+)
+
+var (
+	// ErrUnknownRoutingMatch is returned if a routing rule has an unsupported match type
+	ErrUnknownRoutingMatch = errors.New("unknown routing match type")
+
+	// ErrInvalidRoutingPattern is returned if the regular expression of a routing rule is invalid
+	ErrInvalidRoutingPattern = forms.ErrInvalidRoutingPattern
+)
+
+// routingRule is a routing rule of a form
+type routingRule forms.RoutingRule
+
+// routingTarget holds the recipients, CC and BCC of the form mail
+type routingTarget struct {
+	recipients []string
+	cc         []string
+	bcc        []string
+}
+
+// route returns the recipients, CC and BCC of the form mail for the submission and the index of the
+// matching routing rule (-1 if no rule matched). The addresses are only taken from the form
+// configuration, the submitted values just select the routing rule.
+func route(r *http.Request, form *forms.Form) (routingTarget, int, error) {
+	for i, rule := range form.Routing.Rules {
+		matched, err := routingRule(rule).matches(r.FormValue(rule.Field))
+		if err != nil {
+			return routingTarget{}, -1, fmt.Errorf("routing rule %d: %w", i+1, err)
+		}
+		if matched {
+			return newRoutingTarget(form, rule.Recipients, rule.CC, rule.BCC), i, nil
+		}
+	}
+	config := form.Routing.Default
+	return newRoutingTarget(form, config.Recipients, config.CC, config.BCC), -1, nil
+}
+
+// newRoutingTarget returns the routing target with the recipients, CC and BCC. If no recipients
+// are set, the recipients of the form are used.
+func newRoutingTarget(form *forms.Form, recipients, cc, bcc []string) routingTarget {
+	if len(recipients) == 0 {
+		recipients = form.Recipients
+	}
+	return routingTarget{recipients: recipients, cc: cc, bcc: bcc}
+}
+
+// matches returns true if the submitted value matches the routing rule
+func (rule routingRule) matches(value string) (bool, error) {
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(rule.Match) {
+	case "", RoutingMatchExact:
+		return value != "" && strings.EqualFold(value, strings.TrimSpace(rule.Value)), nil
+	case RoutingMatchRegex:
+		pattern, err := forms.RoutingRule(rule).Pattern()
+		if err != nil {
+			return false, err
+		}
+		return pattern.MatchString(value), nil
+	case RoutingMatchIn:
+		return value != "" && slices.ContainsFunc(rule.Values, func(candidate string) bool {
+			return strings.EqualFold(value, strings.TrimSpace(candidate))
+		}), nil
+	default:
+		return false, fmt.Errorf("%w: %s", ErrUnknownRoutingMatch, rule.Match)
+	}
+}
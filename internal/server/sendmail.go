@@ -7,12 +7,16 @@ package server
 import (
 	"bytes"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
 	"strings"
 
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/wneessen/go-mail"
 
 	"github.com/wneessen/js-mailer/internal/forms"
+	"github.com/wneessen/js-mailer/internal/logger"
 )
 
 var (
@@ -23,95 +27,227 @@ var (
 	userAgent = fmt.Sprintf("js-mailer/%s // https://github.com/wneessen/js-mailer", version)
 )
 
-func (s *Server) sendMail(r *http.Request, form *forms.Form) (string, string, error) {
-	if form.Server.DryRun {
-		s.log.Info("dry-run mode enabled, skipping actual mail delivery")
-		return "dry-run succeeded", "dry-run succeeded", nil
+// deliveryOptions holds per-submission adjustments that are applied when composing the form mail
+type deliveryOptions struct {
+	// subjectTags are prepended to the subject of the form mail
+	subjectTags []string
+
+	// recipients replace the recipients of the form if set
+	recipients []string
+
+	// skipConfirmation suppresses the confirmation mail to the poster
+	skipConfirmation bool
+}
+
+// addSubjectTag adds the tag to the subject tags, unless it was already added
+func (o *deliveryOptions) addSubjectTag(tag string) {
+	if tag != "" && !slices.Contains(o.subjectTags, tag) {
+		o.subjectTags = append(o.subjectTags, tag)
+	}
+}
+
+// subject returns the given subject prefixed with all subject tags of the delivery options
+func (o deliveryOptions) subject(subject string) string {
+	if len(o.subjectTags) == 0 {
+		return subject
 	}
+	return strings.Join(o.subjectTags, " ") + " " + subject
+}
 
-	var confirmationResponse, messageResponse string
+func (s *Server) sendMail(r *http.Request, form *forms.Form, opts deliveryOptions) (string, string, error) {
+	log := s.log.With(logger.RequestID(r))
 
-	// Initialize mail client
-	client, err := mail.NewClient(form.Server.Host, mail.WithPort(form.Server.Port),
-		mail.WithSMTPAuth(mail.SMTPAuthAutoDiscover), mail.WithUsername(form.Server.Username),
-		mail.WithPassword(form.Server.Password), mail.WithTLSPolicy(mail.DefaultTLSPolicy),
-	)
+	// Compose and check the form mail before anything is delivered
+	message, err := s.composeMessage(r, form, opts)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create mail client: %w", err)
+		return "", "", fmt.Errorf("failed to compose message: %w", err)
+	}
+	if form.GeoIP.IncludeMetadata {
+		log = s.geoIPMetadata(r, log, message)
+	}
+	if form.Validation.ClamAV.Enabled {
+		if err = s.scanSubmission(r, log, form, message); err != nil {
+			return "", "", err
+		}
+	}
+	if form.Validation.Spamd.Enabled {
+		if err = s.checkSpamd(r.Context(), log, form, message, &opts); err != nil {
+			return "", "", err
+		}
 	}
-	if !form.Server.ForceTLS {
-		client.SetTLSPolicy(mail.TLSOpportunistic)
+	if message, err = s.applySMIME(form, message); err != nil {
+		return "", "", fmt.Errorf("failed to apply S/MIME: %w", err)
+	}
+	if message, err = s.applyPGP(form, message); err != nil {
+		return "", "", fmt.Errorf("failed to apply PGP: %w", err)
+	}
+	dkimSigner, err := s.dkimSigner(form)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to configure DKIM signing: %w", err)
+	}
+	if dkimSigner != nil {
+		message.SetDKIM(dkimSigner)
 	}
 
-	// Send confirmation mail
-	if form.Confirmation.Enabled {
-		confirmationResponse, err = s.sendConfirmation(r, form, client)
+	notification := &outgoingMail{name: "form mail", message: message}
+	var confirmation *outgoingMail
+	if form.Confirmation.Enabled && !opts.skipConfirmation {
+		confirmationMessage, err := composeConfirmation(r, form, dkimSigner)
 		if err != nil {
-			return "", "", fmt.Errorf("failed to send confirmation mail: %w", err)
+			return "", "", fmt.Errorf("failed to compose confirmation mail: %w", err)
+		}
+		confirmation = &outgoingMail{name: "confirmation mail", message: confirmationMessage}
+	}
+	mails, err := orderMails(form, notification, confirmation)
+	if err != nil {
+		return "", "", err
+	}
+
+	if s.inbox != nil {
+		if err = s.captureMails(r, log, form, s.inbox, "captured in development inbox", mails); err != nil {
+			return "", "", fmt.Errorf("failed to capture mail in development inbox: %w", err)
+		}
+		return responses(notification, confirmation)
+	}
+	if form.Server.DryRun {
+		log.Info("dry-run mode enabled, capturing mails instead of delivering them")
+		if err = s.captureMails(r, log, form, s.capture, "dry-run succeeded", mails); err != nil {
+			return "", "", fmt.Errorf("failed to capture dry-run mail: %w", err)
 		}
+		return responses(notification, confirmation)
 	}
 
-	// Send actual message
-	messageResponse, err = s.sendMessage(r, form, client)
+	transport, err := localTransport(form)
 	if err != nil {
+		return "", "", fmt.Errorf("failed to configure transport: %w", err)
+	}
+	var relays []smtpRelay
+	if transport == nil {
+		if relays, err = s.smtpRelays(form); err != nil {
+			return "", "", fmt.Errorf("failed to determine SMTP relays: %w", err)
+		}
+	}
+
+	// Send the form mail and the confirmation mail over one SMTP session in the configured order
+	if transport != nil {
+		s.deliverLocal(r, form, transport, mails...)
+	} else {
+		s.deliver(r, relays, mails...)
+	}
+
+	// The first failed mandatory mail fails the submission, so that the error of a preceding mail is
+	// reported instead of the mails that were not sent because of it
+	for _, outgoing := range mails {
+		if err = outgoing.err(); err == nil {
+			continue
+		}
+		if outgoing.optional {
+			log.Warn("failed to send best-effort "+outgoing.name, logger.Err(err))
+			continue
+		}
+		if outgoing == confirmation {
+			return "", "", fmt.Errorf("failed to send confirmation mail: %w", err)
+		}
 		return "", "", fmt.Errorf("failed to send message: %w", err)
 	}
+	return responses(notification, confirmation)
+}
+
+// captureMails captures the mails instead of delivering them and sets the response for each mail
+func (s *Server) captureMails(r *http.Request, log *slog.Logger, form *forms.Form, store *mailCapture,
+	response string, mails []*outgoingMail,
+) error {
+	for _, outgoing := range mails {
+		captured, err := store.capture(form.ID, middleware.GetReqID(r.Context()), outgoing.name, outgoing.message)
+		if err != nil {
+			return fmt.Errorf("%s: %w", outgoing.name, err)
+		}
+		log.Debug("captured mail", slog.String("mail", outgoing.name), slog.String("id", captured.ID),
+			slog.String("file", captured.File))
+		outgoing.response = response
+	}
+	return nil
+}
 
-	return confirmationResponse, messageResponse, nil
+// responses returns the responses of the confirmation mail (if any) and the form mail
+func responses(notification, confirmation *outgoingMail) (string, string, error) {
+	var confirmationResponse string
+	if confirmation != nil {
+		confirmationResponse = confirmation.response
+	}
+	return confirmationResponse, notification.response, nil
 }
 
-func (s *Server) sendConfirmation(r *http.Request, form *forms.Form, client *mail.Client) (string, error) {
+// composeConfirmation composes the confirmation mail to the poster of the submission.
+func composeConfirmation(r *http.Request, form *forms.Form, dkimSigner *mail.DKIMSigner) (*mail.Msg, error) {
 	rcpt := r.FormValue(form.Confirmation.RecipientField)
 	if rcpt == "" {
-		return "", fmt.Errorf("confirmation mail feature activated, but recipient field is empty")
+		return nil, fmt.Errorf("confirmation mail feature activated, but recipient field is empty")
 	}
 
 	message := mail.NewMsg()
 	if err := message.From(form.Sender); err != nil {
-		return "", fmt.Errorf("failed to set sender address: %w", err)
+		return nil, fmt.Errorf("failed to set sender address: %w", err)
 	}
 	if err := message.To(rcpt); err != nil {
-		return "", fmt.Errorf("failed to set recipient address: %w", err)
+		return nil, fmt.Errorf("failed to set recipient address: %w", err)
 	}
 	message.Subject(form.Confirmation.Subject)
 	message.SetBodyString(mail.TypeTextPlain, form.Confirmation.Content)
 	message.SetUserAgent(userAgent)
-
-	if err := client.DialAndSendWithContext(r.Context(), message); err != nil {
-		return "", fmt.Errorf("failed to send confirmation mail: %w", err)
+	if dkimSigner != nil {
+		message.SetDKIM(dkimSigner)
 	}
-
-	return message.ServerResponse(), nil
+	return message, nil
 }
 
-func (s *Server) sendMessage(r *http.Request, form *forms.Form, client *mail.Client) (string, error) {
+// composeMessage composes the form mail from the submission.
+func (s *Server) composeMessage(r *http.Request, form *forms.Form, opts deliveryOptions) (*mail.Msg, error) {
 	message := mail.NewMsg()
 	if err := message.From(form.Sender); err != nil {
-		return "", fmt.Errorf("failed to set sender address: %w", err)
+		return nil, fmt.Errorf("failed to set sender address: %w", err)
 	}
-	if err := message.To(form.Recipients...); err != nil {
-		return "", fmt.Errorf("failed to set recipient address: %w", err)
+	// Quarantined submissions go to the quarantine recipients only
+	target := routingTarget{recipients: opts.recipients}
+	if len(opts.recipients) == 0 {
+		var rule int
+		var err error
+		if target, rule, err = route(r, form); err != nil {
+			return nil, fmt.Errorf("failed to route form mail: %w", err)
+		}
+		if rule >= 0 {
+			s.log.Debug("form mail routed by rule", logger.RequestID(r), slog.Int("rule", rule+1))
+		}
+	}
+	if err := message.To(target.recipients...); err != nil {
+		return nil, fmt.Errorf("failed to set recipient address: %w", err)
+	}
+	if err := message.Cc(target.cc...); err != nil {
+		return nil, fmt.Errorf("failed to set CC address: %w", err)
 	}
-	message.Subject(form.Content.Subject)
+	if err := message.Bcc(target.bcc...); err != nil {
+		return nil, fmt.Errorf("failed to set BCC address: %w", err)
+	}
+	message.Subject(opts.subject(form.Content.Subject))
 	message.SetUserAgent(userAgent)
 
 	if form.ReplyTo.Field != "" {
 		replyto := r.FormValue(form.ReplyTo.Field)
 		if replyto == "" {
-			return "", fmt.Errorf("reply-to field is set, but no value was provided")
+			return nil, fmt.Errorf("reply-to field is set, but no value was provided")
 		}
 		if err := message.ReplyTo(replyto); err != nil {
-			return "", fmt.Errorf("failed to set reply-to address: %w", err)
+			return nil, fmt.Errorf("failed to set reply-to address: %w", err)
 		}
 	}
 
 	if form.AttachCSV {
 		buf := bytes.NewBuffer(nil)
 		if err := s.csvFromFields(buf, r); err != nil {
-			return "", fmt.Errorf("failed to read fields into CSV: %w", err)
+			return nil, fmt.Errorf("failed to read fields into CSV: %w", err)
 		}
 		if err := message.AttachReader("submission.csv", buf); err != nil {
-			return "", fmt.Errorf("failed to attach CSV file to message: %w", err)
+			return nil, fmt.Errorf("failed to attach CSV file to message: %w", err)
 		}
 	}
 
@@ -124,9 +260,5 @@ func (s *Server) sendMessage(r *http.Request, form *forms.Form, client *mail.Cli
 	}
 	message.SetBodyString(mail.TypeTextPlain, body.String())
 
-	if err := client.DialAndSendWithContext(r.Context(), message); err != nil {
-		return "", fmt.Errorf("failed to send message: %w", err)
-	}
-
-	return message.ServerResponse(), nil
+	return message, nil
 }
@@ -11,30 +11,49 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 
 	"github.com/wneessen/js-mailer/internal/cache"
 	"github.com/wneessen/js-mailer/internal/cache/inmemory"
+	"github.com/wneessen/js-mailer/internal/clamav"
 	"github.com/wneessen/js-mailer/internal/config"
+	"github.com/wneessen/js-mailer/internal/deliverability"
+	"github.com/wneessen/js-mailer/internal/dnsbl"
+	"github.com/wneessen/js-mailer/internal/geoip"
 	"github.com/wneessen/js-mailer/internal/httpclient"
 	"github.com/wneessen/js-mailer/internal/logger"
+	"github.com/wneessen/js-mailer/internal/spamd"
 )
 
 type Server struct {
-	cache      cache.Cache
-	config     *config.Config
-	httpClient *httpclient.Client
-	httpSrv    *http.Server
-	log        *logger.Logger
-	mux        *chi.Mux
+	accessAllow    []netip.Prefix
+	accessDeny     []netip.Prefix
+	cache          cache.Cache
+	capture        *mailCapture
+	captcha        []CaptchaProvider
+	clamav         *clamav.Client
+	config         *config.Config
+	deliverability *deliverability.Checker
+	dnsbl          *dnsbl.Checker
+	geoip          *geoip.Reader
+	httpClient     *httpclient.Client
+	httpSrv        *http.Server
+	inbox          *mailCapture
+	log            *logger.Logger
+	mux            *chi.Mux
+	relayCounters  sync.Map
+	smtpPool       *smtpPool
+	spamd          *spamd.Client
 }
 
 var Version = "dev"
 
 // New returns a new server instance
-func New(conf *config.Config, log *logger.Logger, ver string) *Server {
+func New(conf *config.Config, log *logger.Logger, ver string) (*Server, error) {
 	mux := chi.NewMux()
 	listenAddr := net.JoinHostPort(conf.Server.BindAddress, conf.Server.BindPort)
 	Version = ver
@@ -45,9 +64,13 @@ func New(conf *config.Config, log *logger.Logger, ver string) *Server {
 		formCache = inmemory.New(conf.Cache.Lifetime)
 	}
 
-	return &Server{
-		cache:      formCache,
-		config:     conf,
+	server := &Server{
+		cache:   formCache,
+		capture: newMailCapture(conf.DryRun.Keep, conf.DryRun.Directory),
+		config:  conf,
+		deliverability: deliverability.New(conf.Deliverability.Resolver, conf.Deliverability.Timeout,
+			conf.Deliverability.CacheTTL),
+		dnsbl:      dnsbl.New(conf.Access.DNSBL.Resolver, conf.Access.DNSBL.Timeout, conf.Access.DNSBL.CacheTTL),
 		httpClient: httpclient.New(log),
 		httpSrv: &http.Server{
 			Addr:              listenAddr,
@@ -60,6 +83,42 @@ func New(conf *config.Config, log *logger.Logger, ver string) *Server {
 		log: log,
 		mux: mux,
 	}
+	server.captcha = newCaptchaProviders(server)
+	var err error
+	if server.accessAllow, err = parsePrefixes(conf.Access.Allow); err != nil {
+		return nil, fmt.Errorf("failed to parse access allow list: %w", err)
+	}
+	if server.accessDeny, err = parsePrefixes(conf.Access.Deny); err != nil {
+		return nil, fmt.Errorf("failed to parse access deny list: %w", err)
+	}
+	if conf.DevInbox.Enabled {
+		server.inbox = newMailCapture(conf.DevInbox.Keep, "")
+	}
+	maxIdle := conf.SMTPPool.MaxIdle
+	if conf.SMTPPool.Disable {
+		maxIdle = 0
+	}
+	server.smtpPool = newSMTPPool(maxIdle, conf.SMTPPool.IdleTimeout)
+	if conf.Spamd.Address != "" {
+		server.spamd = spamd.New(conf.Spamd.Network, conf.Spamd.Address, conf.Spamd.Timeout)
+	}
+	if conf.ClamAV.Address != "" {
+		server.clamav = clamav.New(conf.ClamAV.Network, conf.ClamAV.Address, conf.ClamAV.Timeout)
+	}
+	if conf.Deliverability.DisposableDomains != "" {
+		if err := server.deliverability.LoadDisposableDomains(conf.Deliverability.DisposableDomains); err != nil {
+			return nil, fmt.Errorf("failed to load disposable domains list: %w", err)
+		}
+	}
+	if conf.GeoIP.CountryDB != "" || conf.GeoIP.ASNDB != "" {
+		reader, err := geoip.New(conf.GeoIP.ReloadInterval, log.Logger, conf.GeoIP.CountryDB, conf.GeoIP.ASNDB)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open geoip databases: %w", err)
+		}
+		server.geoip = reader
+	}
+
+	return server, nil
 }
 
 // Start starts up the server and waits for a shutdown signal
@@ -67,6 +126,13 @@ func (s *Server) Start(ctx context.Context) error {
 	ctxServer, cancelServer := context.WithCancel(ctx)
 	defer cancelServer()
 
+	if s.inbox != nil {
+		if !s.config.DevInbox.AllowNonLoopback && !isLoopback(s.config.Server.BindAddress) {
+			return fmt.Errorf("%w: %s", ErrDevInboxNotLoopback, s.config.Server.BindAddress)
+		}
+		s.log.Warn("development inbox enabled, mails are captured instead of delivered")
+	}
+
 	s.log.Info("starting js-mailer http server", slog.String("listen_addr", s.httpSrv.Addr))
 
 	// Assign routes
@@ -74,6 +140,10 @@ func (s *Server) Start(ctx context.Context) error {
 
 	// Start cache
 	s.cache.Start()
+	s.smtpPool.start()
+	if s.geoip != nil {
+		s.geoip.Start()
+	}
 
 	// Start http server
 	listenerFailed := false
@@ -97,6 +167,10 @@ func (s *Server) Start(ctx context.Context) error {
 		s.log.Error("failed to shut down http server gracefully", logger.Err(err))
 	}
 	s.cache.Stop()
+	s.smtpPool.close()
+	if s.geoip != nil {
+		s.geoip.Stop()
+	}
 
 	return nil
 }
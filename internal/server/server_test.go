@@ -7,30 +7,53 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/csv"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"mime"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	netmail "net/mail"
+	"net/netip"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync/atomic"
 	"testing"
 	"testing/synctest"
 	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/wneessen/go-mail"
 
 	"github.com/wneessen/js-mailer/internal/cache"
+	"github.com/wneessen/js-mailer/internal/clamav"
 	"github.com/wneessen/js-mailer/internal/config"
+	"github.com/wneessen/js-mailer/internal/deliverability"
+	"github.com/wneessen/js-mailer/internal/dnsbl"
 	"github.com/wneessen/js-mailer/internal/forms"
+	"github.com/wneessen/js-mailer/internal/geoip"
 	"github.com/wneessen/js-mailer/internal/logger"
+	"github.com/wneessen/js-mailer/internal/pgp"
+	"github.com/wneessen/js-mailer/internal/spam"
+	"github.com/wneessen/js-mailer/internal/spamd"
 	"github.com/wneessen/js-mailer/internal/testhelper"
 )
 
@@ -48,9 +71,9 @@ func TestNew(t *testing.T) {
 			t.Fatalf("failed to create config: %s", err)
 		}
 		log := logger.New(slog.LevelError, logger.Opts{Format: "text"})
-		server := New(conf, log, testVersion)
-		if server == nil {
-			t.Fatal("server is nil")
+		server, err := New(conf, log, testVersion)
+		if err != nil {
+			t.Fatalf("failed to create server: %s", err)
 		}
 		if server.log != log {
 			t.Errorf("expected log to be %p, got %p", log, server.log)
@@ -59,6 +82,62 @@ func TestNew(t *testing.T) {
 			t.Errorf("expected config to be %p, got %p", conf, server.config)
 		}
 	})
+	t.Run("return a server with geoip databases", func(t *testing.T) {
+		conf, err := config.New()
+		if err != nil {
+			t.Fatalf("failed to create config: %s", err)
+		}
+		conf.GeoIP.CountryDB = filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+		testhelper.WriteMMDB(t, conf.GeoIP.CountryDB, "GeoLite2-Country", map[string]testhelper.MMDBRecord{
+			"192.0.2.0/24": {Country: "DE"},
+		})
+		server, err := New(conf, logger.New(slog.LevelError, logger.Opts{Format: "text"}), testVersion)
+		if err != nil {
+			t.Fatalf("failed to create server: %s", err)
+		}
+		if server.geoip == nil {
+			t.Error("expected geoip reader to be set")
+		}
+	})
+	t.Run("return a server with missing geoip database", func(t *testing.T) {
+		conf, err := config.New()
+		if err != nil {
+			t.Fatalf("failed to create config: %s", err)
+		}
+		conf.GeoIP.CountryDB = filepath.Join(t.TempDir(), "missing.mmdb")
+		server, err := New(conf, logger.NewLogger(slog.LevelError, io.Discard, logger.Opts{Format: "text"}), testVersion)
+		if err != nil {
+			t.Fatalf("failed to create server: %s", err)
+		}
+		if server.geoip == nil {
+			t.Fatal("expected geoip reader to be set")
+		}
+		if _, err = server.geoIPLookup(netip.MustParseAddr("192.0.2.10")); !errors.Is(err, geoip.ErrNotLoaded) {
+			t.Errorf("expected error to be %s, got: %v", geoip.ErrNotLoaded, err)
+		}
+	})
+	t.Run("invalid access list entries fail", func(t *testing.T) {
+		conf, err := config.New()
+		if err != nil {
+			t.Fatalf("failed to create config: %s", err)
+		}
+		conf.Access.Deny = []string{"192.0.2.0/24", "192.0.2.0/33"}
+		_, err = New(conf, logger.NewLogger(slog.LevelError, io.Discard, logger.Opts{Format: "text"}), testVersion)
+		if !errors.Is(err, ErrInvalidAccessEntry) {
+			t.Errorf("expected error to be %s, got: %s", ErrInvalidAccessEntry, err)
+		}
+	})
+	t.Run("missing disposable domains list fails", func(t *testing.T) {
+		conf, err := config.New()
+		if err != nil {
+			t.Fatalf("failed to create config: %s", err)
+		}
+		conf.Deliverability.DisposableDomains = filepath.Join(t.TempDir(), "missing.txt")
+		_, err = New(conf, logger.NewLogger(slog.LevelError, io.Discard, logger.Opts{Format: "text"}), testVersion)
+		if err == nil {
+			t.Error("expected server creation to fail")
+		}
+	})
 }
 
 func TestServer_Start(t *testing.T) {
@@ -100,6 +179,17 @@ func TestServer_Start(t *testing.T) {
 			t.Fatal("expected error when starting server with invalid port")
 		}
 	})
+	t.Run("development inbox on a non-loopback address fails", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.inbox = newMailCapture(10, "")
+		server.config.Server.BindAddress = "0.0.0.0"
+		if err = server.Start(t.Context()); !errors.Is(err, ErrDevInboxNotLoopback) {
+			t.Fatalf("expected error to be %s, got: %s", ErrDevInboxNotLoopback, err)
+		}
+	})
 }
 
 func TestServer_HandlerAPIPingGet(t *testing.T) {
@@ -415,6 +505,51 @@ func TestServer_HandlerAPITokenGet(t *testing.T) {
 			t.Errorf("expected random field %s, got: %s", want, body.Data.RandomField)
 		}
 	})
+	t.Run("token response contains an ALTCHA challenge", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.config.Forms.Path = "../../testdata"
+
+		router := chi.NewRouter()
+		router.With(server.preflightCheck).Get("/token/{formID}", server.HandlerAPITokenGet)
+
+		req := httptest.NewRequest(http.MethodGet, "/token/testform_toml_altcha", nil)
+		req.TLS = &tls.ConnectionState{}
+		req.Header.Set("Origin", "https://example.com")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusCreated {
+			t.Errorf("expected status code %d, got: %d", http.StatusCreated, recorder.Code)
+		}
+
+		type response struct {
+			Data TokenResponse `json:"data,omitempty"`
+		}
+		body := new(response)
+		if err = json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+			t.Fatalf("failed to decode JSON response: %s", err)
+		}
+		if body.Data.Altcha == nil {
+			t.Fatal("expected ALTCHA challenge to be set in token response")
+		}
+		if body.Data.Altcha.Algorithm != altchaAlgorithm {
+			t.Errorf("expected ALTCHA algorithm %s, got: %s", altchaAlgorithm, body.Data.Altcha.Algorithm)
+		}
+		if body.Data.Altcha.MaxNumber != 1000 {
+			t.Errorf("expected ALTCHA max number %d, got: %d", 1000, body.Data.Altcha.MaxNumber)
+		}
+		_, params, err := server.cache.Get(body.Data.Token)
+		if err != nil {
+			t.Errorf("failed to get form from cache: %s", err)
+		}
+		if params.AltchaChallenge != body.Data.Altcha.Challenge {
+			t.Errorf("expected cached ALTCHA challenge %s, got: %s", body.Data.Altcha.Challenge,
+				params.AltchaChallenge)
+		}
+	})
 	t.Run("token response fails due to error from cache", func(t *testing.T) {
 		server, err := testServer(t, slog.LevelDebug, io.Discard)
 		if err != nil {
@@ -522,6 +657,317 @@ func TestServer_preflightCheck(t *testing.T) {
 	})
 }
 
+func TestServer_accessCheck(t *testing.T) {
+	resolver := testhelper.DNSServer(t, map[string]string{
+		"10.2.0.192.zen.example.":   "127.0.0.2",
+		"20.2.0.192.form.example.":  "127.0.0.2",
+		"30.2.0.192.zen.example.":   testhelper.DNSServFail,
+		"10.2.0.192.other.example.": "127.0.0.2",
+	})
+	formsPath := t.TempDir()
+	formConfig := `id = "access_form"
+domains = ["example.com"]
+recipients = ["support@example.com"]
+secret = "test-secret-key"
+sender = "no-reply@example.com"
+
+[server]
+host = "smtp.example.com"
+
+[access]
+allow = ["198.51.100.7"]
+deny = ["203.0.113.0/24"]
+dnsbl_zones = ["form.example"]
+`
+	if err := os.WriteFile(filepath.Join(formsPath, "access_form.toml"), []byte(formConfig), 0o600); err != nil {
+		t.Fatalf("failed to write form config: %s", err)
+	}
+	noDNSBLConfig := strings.Replace(formConfig, `dnsbl_zones = ["form.example"]`, "disable_dnsbl = true", 1)
+	if err := os.WriteFile(filepath.Join(formsPath, "nodnsbl_form.toml"), []byte(noDNSBLConfig), 0o600); err != nil {
+		t.Fatalf("failed to write form config: %s", err)
+	}
+	invalidConfig := strings.Replace(formConfig, `deny = ["203.0.113.0/24"]`, `deny = ["invalid", "192.0.2.0/33", "203.0.113.0/24"]`, 1)
+	if err := os.WriteFile(filepath.Join(formsPath, "invalid_form.toml"), []byte(invalidConfig), 0o600); err != nil {
+		t.Fatalf("failed to write form config: %s", err)
+	}
+
+	tests := []struct {
+		name       string
+		formID     string
+		remoteAddr string
+		allow      []string
+		deny       []string
+		zones      []string
+		code       int
+	}{
+		{"client without restrictions passes", "unknown", "192.0.2.1:1234", nil, nil, nil, http.StatusOK},
+		{"client in global deny list is blocked", "unknown", "192.0.2.1:1234", nil, []string{"192.0.2.0/24"}, nil, http.StatusNotFound},
+		{"client in global allow list is exempt from deny list", "unknown", "192.0.2.1:1234", []string{"192.0.2.1"}, []string{"192.0.2.0/24"}, nil, http.StatusOK},
+		{"IPv6 client in global deny list is blocked", "unknown", "[2001:db8::1]:1234", nil, []string{"2001:db8::/32"}, nil, http.StatusNotFound},
+		{"IPv4-mapped client in global deny list is blocked", "unknown", "[::ffff:192.0.2.1]:1234", nil, []string{"192.0.2.0/24"}, nil, http.StatusNotFound},
+		{"invalid form list entries are skipped", "invalid_form", "192.0.2.1:1234", nil, nil, nil, http.StatusOK},
+		{"valid form list entries are used", "invalid_form", "203.0.113.5:1234", nil, nil, nil, http.StatusNotFound},
+		{"client in form deny list is blocked", "access_form", "203.0.113.5:1234", nil, nil, nil, http.StatusNotFound},
+		{"client in form allow list is exempt from global deny list", "access_form", "198.51.100.7:1234", nil, []string{"198.51.100.0/24"}, nil, http.StatusOK},
+		{"client listed in global DNSBL is blocked", "unknown", "192.0.2.10:1234", nil, nil, []string{"zen.example"}, http.StatusNotFound},
+		{"client not listed in DNSBL passes", "unknown", "192.0.2.11:1234", nil, nil, []string{"zen.example"}, http.StatusOK},
+		{"client listed in form DNSBL is blocked", "access_form", "192.0.2.20:1234", nil, nil, nil, http.StatusNotFound},
+		{"form can disable DNSBL checks", "nodnsbl_form", "192.0.2.10:1234", nil, nil, []string{"zen.example"}, http.StatusOK},
+		{"failed DNSBL lookup passes", "unknown", "192.0.2.30:1234", nil, nil, []string{"zen.example"}, http.StatusOK},
+		{"invalid client address is blocked", "unknown", "invalid", nil, nil, nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := testServer(t, slog.LevelDebug, io.Discard)
+			if err != nil {
+				t.Fatalf("failed to create test server: %s", err)
+			}
+			server.config.Forms.Path = formsPath
+			if server.accessAllow, err = parsePrefixes(tt.allow); err != nil {
+				t.Fatalf("failed to parse allow list: %s", err)
+			}
+			if server.accessDeny, err = parsePrefixes(tt.deny); err != nil {
+				t.Fatalf("failed to parse deny list: %s", err)
+			}
+			server.config.Access.DNSBL.Zones = tt.zones
+			server.dnsbl = dnsbl.New(resolver, time.Second, time.Minute)
+
+			router := chi.NewRouter()
+			router.With(server.accessCheck).Get("/token/{formID}", func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/token/"+tt.formID, nil)
+			req.RemoteAddr = tt.remoteAddr
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+			if recorder.Code != tt.code {
+				t.Errorf("expected status code %d, got: %d", tt.code, recorder.Code)
+			}
+		})
+	}
+	t.Run("form is stored in the request context", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		contextPath := t.TempDir()
+		formFile := filepath.Join(contextPath, "context_form.toml")
+		if err = os.WriteFile(formFile, []byte(noDNSBLConfig), 0o600); err != nil {
+			t.Fatalf("failed to write form config: %s", err)
+		}
+		server.config.Forms.Path = contextPath
+
+		router := chi.NewRouter()
+		router.With(server.accessCheck).Get("/token/{formID}", func(w http.ResponseWriter, r *http.Request) {
+			if err = os.Remove(formFile); err != nil {
+				t.Errorf("failed to remove form config: %s", err)
+			}
+			form, err := server.loadForm(r, "context_form")
+			if err != nil || !form.Access.DisableDNSBL {
+				t.Errorf("expected form from request context, got: %v", err)
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/token/context_form", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got: %d", http.StatusOK, recorder.Code)
+		}
+	})
+	t.Run("submissions use the cached form", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		form := &forms.Form{ID: "cached_form"}
+		form.Access.Deny = []string{"192.0.2.0/24"}
+		if err = server.cache.Set("cached-hash", form, cache.ItemParams{}); err != nil {
+			t.Fatalf("failed to cache form: %s", err)
+		}
+
+		router := chi.NewRouter()
+		router.With(server.accessCheck).Post("/send/{formID}/{hash}", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		for hash, code := range map[string]int{"cached-hash": http.StatusNotFound, "unknown-hash": http.StatusOK} {
+			req := httptest.NewRequest(http.MethodPost, "/send/cached_form/"+hash, nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+			if recorder.Code != code {
+				t.Errorf("expected status code %d for %s, got: %d", code, hash, recorder.Code)
+			}
+		}
+	})
+	t.Run("client IP header is used", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.accessDeny = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+
+		router := chi.NewRouter()
+		router.Use(middleware.ClientIPFromHeader("X-Real-IP"))
+		router.With(server.accessCheck).Get("/token/{formID}", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/token/unknown", nil)
+		req.RemoteAddr = "198.51.100.1:1234"
+		req.Header.Set("X-Real-IP", "192.0.2.1")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got: %d", http.StatusNotFound, recorder.Code)
+		}
+	})
+}
+
+func TestServer_geoBlocked(t *testing.T) {
+	countryDB := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	testhelper.WriteMMDB(t, countryDB, "GeoLite2-Country", map[string]testhelper.MMDBRecord{
+		"192.0.2.0/24":    {Country: "DE", ASN: 64500, Organization: "Example Hosting"},
+		"198.51.100.0/24": {Country: "US", ASN: 64501, Organization: "Example Cloud"},
+	})
+	reader, err := geoip.New(0, nil, countryDB)
+	if err != nil {
+		t.Fatalf("failed to open geoip database: %s", err)
+	}
+
+	dach := []string{"de", "AT", "CH"}
+	tests := []struct {
+		name    string
+		addr    string
+		config  forms.GeoIPConfig
+		noGeoIP bool
+		blocked bool
+	}{
+		{"no restrictions", "198.51.100.1", forms.GeoIPConfig{}, false, false},
+		{"allowed country", "192.0.2.1", forms.GeoIPConfig{AllowedCountries: dach}, false, false},
+		{"country not in allowed countries", "198.51.100.1", forms.GeoIPConfig{AllowedCountries: dach}, false, true},
+		{"unknown country with allowed countries", "203.0.113.1", forms.GeoIPConfig{AllowedCountries: dach}, false, true},
+		{"blocked country", "198.51.100.1", forms.GeoIPConfig{BlockedCountries: []string{"US"}}, false, true},
+		{"unknown country with blocked countries", "203.0.113.1", forms.GeoIPConfig{BlockedCountries: []string{"US"}}, false, false},
+		{"allowed ASN", "192.0.2.1", forms.GeoIPConfig{AllowedASNs: []uint{64500}}, false, false},
+		{"ASN not in allowed ASNs", "198.51.100.1", forms.GeoIPConfig{AllowedASNs: []uint{64500}}, false, true},
+		{"blocked ASN", "198.51.100.1", forms.GeoIPConfig{BlockedASNs: []uint{64501}}, false, true},
+		{"missing database fails closed", "192.0.2.1", forms.GeoIPConfig{AllowedCountries: dach}, true, true},
+		{"missing database fails open", "198.51.100.1", forms.GeoIPConfig{AllowedCountries: dach, FailOpen: true}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := testServer(t, slog.LevelDebug, io.Discard)
+			if err != nil {
+				t.Fatalf("failed to create test server: %s", err)
+			}
+			if !tt.noGeoIP {
+				server.geoip = reader
+			}
+			blocked, reason := server.geoBlocked(server.log.Logger, netip.MustParseAddr(tt.addr), tt.config)
+			if blocked != tt.blocked {
+				t.Errorf("expected blocked to be %t, got %t (reason: %s)", tt.blocked, blocked, reason)
+			}
+		})
+	}
+	t.Run("access check applies geoip restrictions", func(t *testing.T) {
+		formsPath := t.TempDir()
+		formConfig := `id = "geo_form"
+domains = ["example.com"]
+recipients = ["support@example.com"]
+secret = "test-secret-key"
+sender = "no-reply@example.com"
+
+[server]
+host = "smtp.example.com"
+
+[access]
+allow = ["198.51.100.7"]
+
+[geoip]
+allowed_countries = ["DE", "AT", "CH"]
+`
+		if err := os.WriteFile(filepath.Join(formsPath, "geo_form.toml"), []byte(formConfig), 0o600); err != nil {
+			t.Fatalf("failed to write form config: %s", err)
+		}
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.config.Forms.Path = formsPath
+		server.geoip = reader
+
+		router := chi.NewRouter()
+		router.With(server.accessCheck).Get("/token/{formID}", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		for remoteAddr, code := range map[string]int{
+			"192.0.2.1:1234":    http.StatusOK,
+			"198.51.100.1:1234": http.StatusNotFound,
+			"198.51.100.7:1234": http.StatusOK,
+		} {
+			req := httptest.NewRequest(http.MethodGet, "/token/geo_form", nil)
+			req.RemoteAddr = remoteAddr
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+			if recorder.Code != code {
+				t.Errorf("expected status code %d for %s, got: %d", code, remoteAddr, recorder.Code)
+			}
+		}
+	})
+}
+
+func TestServer_geoIPMetadata(t *testing.T) {
+	countryDB := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	testhelper.WriteMMDB(t, countryDB, "GeoLite2-Country", map[string]testhelper.MMDBRecord{
+		"192.0.2.0/24": {Country: "DE", ASN: 64500, Organization: "Example Hosting"},
+	})
+	reader, err := geoip.New(0, nil, countryDB)
+	if err != nil {
+		t.Fatalf("failed to open geoip database: %s", err)
+	}
+
+	tests := []struct {
+		name        string
+		remoteAddr  string
+		noGeoIP     bool
+		wantCountry string
+		wantASN     string
+	}{
+		{"known client", "192.0.2.1:1234", false, "DE", "AS64500 Example Hosting"},
+		{"unknown client", "203.0.113.1:1234", false, "", ""},
+		{"invalid client address", "invalid", false, "", ""},
+		{"missing database", "192.0.2.1:1234", true, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := testServer(t, slog.LevelDebug, io.Discard)
+			if err != nil {
+				t.Fatalf("failed to create test server: %s", err)
+			}
+			if !tt.noGeoIP {
+				server.geoip = reader
+			}
+			buf := bytes.NewBuffer(nil)
+			log := slog.New(slog.NewJSONHandler(buf, nil))
+			req := httptest.NewRequest(http.MethodPost, "/send", nil)
+			req.RemoteAddr = tt.remoteAddr
+			message := mail.NewMsg()
+
+			server.geoIPMetadata(req, log, message).Info("test")
+			if country := message.GetGenHeader(headerClientCountry); strings.Join(country, "") != tt.wantCountry {
+				t.Errorf("expected country header %q, got: %v", tt.wantCountry, country)
+			}
+			if asn := message.GetGenHeader(headerClientASN); strings.Join(asn, "") != tt.wantASN {
+				t.Errorf("expected ASN header %q, got: %v", tt.wantASN, asn)
+			}
+			if tt.wantCountry != "" && !strings.Contains(buf.String(), `"country":"`+tt.wantCountry+`"`) {
+				t.Errorf("expected country to be logged, got: %s", buf.String())
+			}
+		})
+	}
+}
+
 func TestServer_serverHeader(t *testing.T) {
 	t.Run("server header is set", func(t *testing.T) {
 		server, err := testServer(t, slog.LevelDebug, io.Discard)
@@ -967,371 +1413,3701 @@ func TestServer_HandlerAPISendFormPost(t *testing.T) {
 			t.Errorf("expected status code %d, got: %d", http.StatusNotFound, recorder.Code)
 		}
 	})
-}
-
-func TestResponse_Render(t *testing.T) {
-	t.Run("render response without timestamp", func(t *testing.T) {
-		req := new(http.Request)
-		resp := NewResponse(http.StatusOK, "test", map[string]interface{}{"ping": "pong"})
-		resp.Timestamp = time.Time{}
-		if err := resp.Render(nil, req); err != nil {
-			t.Errorf("failed to render response: %s", err)
+	t.Run("spam scoring", func(t *testing.T) {
+		origin := "https://example.com"
+		tests := []struct {
+			name    string
+			message string
+			code    int
+		}{
+			{"clean message is delivered", "this is a test message", http.StatusOK},
+			{"tagged message is delivered", "cheap SEO services", http.StatusOK},
+			{"spam message is rejected", "cheap SEO services and backlinks at https://bit.ly/seo", http.StatusUnprocessableEntity},
 		}
-		if resp.Timestamp.IsZero() {
-			t.Errorf("expected timestamp to be set, got: %s", resp.Timestamp)
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tokenCreatedAt := time.Now()
+				tokenExpiresAt := tokenCreatedAt.Add(time.Hour)
+				form, err := forms.New("../../testdata", "testform_toml")
+				if err != nil {
+					t.Fatalf("failed to create form: %s", err)
+				}
+				form.Validation.Spam = forms.SpamConfig{
+					Enabled:        true,
+					TagScore:       1,
+					RejectScore:    3,
+					Keywords:       []string{"seo", "backlinks"},
+					KeywordScore:   1,
+					ShortenerScore: 1,
+				}
+				hasher := sha256.New()
+				value := fmt.Sprintf("%s_%d_%d_%s_%s", origin, tokenCreatedAt.UnixNano(),
+					tokenExpiresAt.UnixNano(), form.ID, form.Secret)
+				hasher.Write([]byte(value))
+				computedHash := fmt.Sprintf("%x", hasher.Sum(nil))
+				server, err := testServer(t, slog.LevelDebug, io.Discard)
+				if err != nil {
+					t.Fatalf("failed to create test server: %s", err)
+				}
+				if err = server.cache.Set(computedHash, form, cache.ItemParams{
+					TokenCreatedAt: tokenCreatedAt,
+					TokenExpiresAt: tokenExpiresAt,
+				}); err != nil {
+					t.Errorf("failed to set cache item: %s", err)
+				}
+
+				router := chi.NewRouter()
+				router.With(server.preflightCheck).Post("/send/{formID}/{hash}", server.HandlerAPISendFormPost)
+				buf := bytes.NewBuffer(nil)
+				writer := multipart.NewWriter(buf)
+				_ = writer.WriteField("email", "example@example.com")
+				_ = writer.WriteField("message", tt.message)
+				_ = writer.Close()
+				req := httptest.NewRequest(http.MethodPost, "/send/testform_toml/"+computedHash, buf)
+				req.Header.Set("Content-Type", writer.FormDataContentType())
+				req.TLS = &tls.ConnectionState{}
+				req.Header.Set("Origin", origin)
+				recorder := httptest.NewRecorder()
+				router.ServeHTTP(recorder, req)
+				if recorder.Code != tt.code {
+					t.Errorf("expected status code %d, got: %d", tt.code, recorder.Code)
+				}
+			})
 		}
 	})
-}
+	t.Run("duplicate detection", func(t *testing.T) {
+		origin := "https://example.com"
+		submit := func(t *testing.T, server *Server, form *forms.Form, message string) int {
+			t.Helper()
+			tokenCreatedAt := time.Now()
+			tokenExpiresAt := tokenCreatedAt.Add(time.Hour)
+			hasher := sha256.New()
+			value := fmt.Sprintf("%s_%d_%d_%s_%s", origin, tokenCreatedAt.UnixNano(),
+				tokenExpiresAt.UnixNano(), form.ID, form.Secret)
+			hasher.Write([]byte(value))
+			computedHash := fmt.Sprintf("%x", hasher.Sum(nil))
+			if err := server.cache.Set(computedHash, form, cache.ItemParams{
+				TokenCreatedAt: tokenCreatedAt,
+				TokenExpiresAt: tokenExpiresAt,
+			}); err != nil {
+				t.Errorf("failed to set cache item: %s", err)
+			}
 
-func TestServer_failsRequiredFields(t *testing.T) {
-	tests := []struct {
-		name        string
-		validations []forms.ValidationField
-		submission  map[string][]string
-		fails       bool
-	}{
-		{
-			"text passes through",
-			[]forms.ValidationField{{Name: "text", Type: "text", Required: true}},
-			map[string][]string{"text": {"hello world"}},
-			false,
-		},
-		{
-			"no valid email format",
-			[]forms.ValidationField{{Name: "email", Type: "email", Required: true}},
-			map[string][]string{"email": {"not@val."}},
-			true,
-		},
-		{
-			"valid email format",
-			[]forms.ValidationField{{Name: "email", Type: "email", Required: true}},
-			map[string][]string{"email": {"valid@example.com"}},
-			false,
-		},
-		{
-			"not a number",
-			[]forms.ValidationField{{Name: "number", Type: "number", Required: true}},
-			map[string][]string{"number": {"text"}},
-			true,
-		},
-		{
-			"is a number",
-			[]forms.ValidationField{
-				{Name: "number", Type: "number", Required: true},
-				{Name: "number2", Type: "number", Required: true},
-				{Name: "number3", Type: "number", Required: true},
-			},
-			map[string][]string{
-				"number":  {"1"},
-				"number2": {"-42"},
-				"number3": {"3.14"},
-			},
-			false,
-		},
-		{
-			"not a boolean",
-			[]forms.ValidationField{{Name: "bool", Type: "bool", Required: true}},
-			map[string][]string{"bool": {"text"}},
-			true,
-		},
-		{
-			"boolean is valid",
-			[]forms.ValidationField{
-				{Name: "bool", Type: "bool", Required: true},
-				{Name: "bool2", Type: "bool", Required: true},
-				{Name: "bool3", Type: "bool", Required: true},
-			},
-			map[string][]string{
-				"bool":  {"1"},
-				"bool2": {"true"},
-				"bool3": {"on"},
-			},
-			false,
-		},
-		{
-			"value does not match",
-			[]forms.ValidationField{{Name: "text", Type: "matchval", Required: true, Value: "expected"}},
-			map[string][]string{"text": {"unexpected"}},
-			true,
-		},
-		{
-			"value matches",
-			[]forms.ValidationField{{Name: "text", Type: "matchval", Required: true, Value: "expected"}},
-			map[string][]string{"text": {"expected"}},
-			false,
-		},
-	}
+			router := chi.NewRouter()
+			router.With(server.preflightCheck).Post("/send/{formID}/{hash}", server.HandlerAPISendFormPost)
+			buf := bytes.NewBuffer(nil)
+			writer := multipart.NewWriter(buf)
+			_ = writer.WriteField("email", "example@example.com")
+			_ = writer.WriteField("message", message)
+			_ = writer.Close()
+			req := httptest.NewRequest(http.MethodPost, "/send/testform_toml/"+computedHash, buf)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			req.TLS = &tls.ConnectionState{}
+			req.Header.Set("Origin", origin)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+			return recorder.Code
+		}
+		tests := []struct {
+			name   string
+			action string
+			second string
+			codes  [2]int
+		}{
+			{"duplicate is rejected", DuplicateActionReject, "this is a test message", [2]int{http.StatusOK, http.StatusConflict}},
+			{"normalized duplicate is rejected", DuplicateActionReject, "  This is a TEST\n message ", [2]int{http.StatusOK, http.StatusConflict}},
+			{"duplicate is accepted without delivery", DuplicateActionAccept, "this is a test message", [2]int{http.StatusOK, http.StatusOK}},
+			{"different message is delivered", DuplicateActionReject, "this is another message", [2]int{http.StatusOK, http.StatusOK}},
+			{"unknown action fails", "invalid", "this is a test message", [2]int{http.StatusInternalServerError, http.StatusInternalServerError}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				server, err := testServer(t, slog.LevelDebug, io.Discard)
+				if err != nil {
+					t.Fatalf("failed to create test server: %s", err)
+				}
+				form, err := forms.New("../../testdata", "testform_toml")
+				if err != nil {
+					t.Fatalf("failed to create form: %s", err)
+				}
+				form.Validation.Duplicates.Enabled = true
+				form.Validation.Duplicates.Fields = []string{"email", "message"}
+				form.Validation.Duplicates.Window = time.Minute
+				form.Validation.Duplicates.Action = tt.action
 
-	t.Run("checking required fields", func(t *testing.T) {
+				if code := submit(t, server, form, "this is a test message"); code != tt.codes[0] {
+					t.Errorf("expected status code %d for first submission, got: %d", tt.codes[0], code)
+				}
+				if code := submit(t, server, form, tt.second); code != tt.codes[1] {
+					t.Errorf("expected status code %d for second submission, got: %d", tt.codes[1], code)
+				}
+			})
+		}
+		t.Run("failed delivery can be retried", func(t *testing.T) {
+			server, err := testServer(t, slog.LevelDebug, io.Discard)
+			if err != nil {
+				t.Fatalf("failed to create test server: %s", err)
+			}
+			form, err := forms.New("../../testdata", "testform_toml")
+			if err != nil {
+				t.Fatalf("failed to create form: %s", err)
+			}
+			form.Validation.Duplicates.Enabled = true
+			form.Validation.Duplicates.Window = time.Minute
+			form.Validation.Duplicates.Action = DuplicateActionReject
+			form.Validation.Spamd.Enabled = true
+
+			for range 2 {
+				if code := submit(t, server, form, "this is a test message"); code != http.StatusServiceUnavailable {
+					t.Errorf("expected status code %d, got: %d", http.StatusServiceUnavailable, code)
+				}
+			}
+		})
+	})
+	t.Run("token timing", func(t *testing.T) {
+		origin := "https://example.com"
+		tests := []struct {
+			name        string
+			createdAgo  time.Duration
+			lifetime    time.Duration
+			minFillTime time.Duration
+			maxFillTime time.Duration
+			code        int
+		}{
+			{"submission within the fill time window", time.Second * 10, time.Hour, time.Second * 5, time.Minute, http.StatusOK},
+			{"expired token", time.Hour, time.Minute, 0, 0, http.StatusNotFound},
+			{"submission before the minimum fill time", time.Second * 10, time.Hour, time.Minute, 0, http.StatusTooEarly},
+			{"submission after the maximum fill time", time.Minute, time.Hour, 0, time.Second * 30, http.StatusGone},
+		}
 		for _, tt := range tests {
 			t.Run(tt.name, func(t *testing.T) {
+				tokenCreatedAt := time.Now().Add(-tt.createdAgo)
+				tokenExpiresAt := tokenCreatedAt.Add(tt.lifetime)
+				form, err := forms.New("../../testdata", "testform_toml")
+				if err != nil {
+					t.Fatalf("failed to create form: %s", err)
+				}
+				form.Validation.DisableSubmissionSpeedCheck = false
+				form.Validation.MinFillTime = tt.minFillTime
+				form.Validation.MaxFillTime = tt.maxFillTime
+				hasher := sha256.New()
+				value := fmt.Sprintf("%s_%d_%d_%s_%s", origin, tokenCreatedAt.UnixNano(),
+					tokenExpiresAt.UnixNano(), form.ID, form.Secret)
+				hasher.Write([]byte(value))
+				computedHash := fmt.Sprintf("%x", hasher.Sum(nil))
 				server, err := testServer(t, slog.LevelDebug, io.Discard)
 				if err != nil {
 					t.Fatalf("failed to create test server: %s", err)
 				}
+				server.cache = &timingCache{form: form, params: cache.ItemParams{
+					TokenCreatedAt: tokenCreatedAt,
+					TokenExpiresAt: tokenExpiresAt,
+				}}
 
-				fails, _ := server.failsRequiredFields(tt.validations, tt.submission)
-				if fails != tt.fails {
-					t.Errorf("expected fails to be %t, got: %t", tt.fails, fails)
+				router := chi.NewRouter()
+				router.With(server.preflightCheck).Post("/send/{formID}/{hash}", server.HandlerAPISendFormPost)
+				buf := bytes.NewBuffer(nil)
+				writer := multipart.NewWriter(buf)
+				_ = writer.WriteField("email", "example@example.com")
+				_ = writer.WriteField("message", "this is a test message")
+				_ = writer.Close()
+				req := httptest.NewRequest(http.MethodPost, "/send/testform_toml/"+computedHash, buf)
+				req.Header.Set("Content-Type", writer.FormDataContentType())
+				req.TLS = &tls.ConnectionState{}
+				req.Header.Set("Origin", origin)
+				recorder := httptest.NewRecorder()
+				router.ServeHTTP(recorder, req)
+				if recorder.Code != tt.code {
+					t.Errorf("expected status code %d, got: %d", tt.code, recorder.Code)
+				}
+			})
+		}
+	})
+	t.Run("token binding", func(t *testing.T) {
+		origin := "https://example.com"
+		tests := []struct {
+			name      string
+			ip        string
+			userAgent bool
+			params    cache.ItemParams
+			code      int
+		}{
+			{"matching address", TokenBindingAddress, false, cache.ItemParams{ClientIP: "192.0.2.1"}, http.StatusOK},
+			{"different address", TokenBindingAddress, false, cache.ItemParams{ClientIP: "192.0.2.2"}, http.StatusNotFound},
+			{"matching prefix", TokenBindingPrefix, false, cache.ItemParams{ClientIP: "192.0.2.0/24"}, http.StatusOK},
+			{"different prefix", TokenBindingPrefix, false, cache.ItemParams{ClientIP: "198.51.100.0/24"}, http.StatusNotFound},
+			{"matching user agent", "", true, cache.ItemParams{UserAgent: "test-agent/1.0"}, http.StatusOK},
+			{"different user agent", "", true, cache.ItemParams{UserAgent: "other-agent/1.0"}, http.StatusNotFound},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tokenCreatedAt := time.Now()
+				tokenExpiresAt := tokenCreatedAt.Add(time.Hour)
+				form, err := forms.New("../../testdata", "testform_toml")
+				if err != nil {
+					t.Fatalf("failed to create form: %s", err)
+				}
+				form.Validation.TokenBinding.IP = tt.ip
+				form.Validation.TokenBinding.UserAgent = tt.userAgent
+				hasher := sha256.New()
+				value := fmt.Sprintf("%s_%d_%d_%s_%s", origin, tokenCreatedAt.UnixNano(),
+					tokenExpiresAt.UnixNano(), form.ID, form.Secret)
+				hasher.Write([]byte(value))
+				computedHash := fmt.Sprintf("%x", hasher.Sum(nil))
+				server, err := testServer(t, slog.LevelDebug, io.Discard)
+				if err != nil {
+					t.Fatalf("failed to create test server: %s", err)
+				}
+				params := tt.params
+				params.TokenCreatedAt = tokenCreatedAt
+				params.TokenExpiresAt = tokenExpiresAt
+				if err = server.cache.Set(computedHash, form, params); err != nil {
+					t.Errorf("failed to set cache item: %s", err)
+				}
+
+				router := chi.NewRouter()
+				router.With(server.preflightCheck).Post("/send/{formID}/{hash}", server.HandlerAPISendFormPost)
+				buf := bytes.NewBuffer(nil)
+				writer := multipart.NewWriter(buf)
+				_ = writer.WriteField("email", "example@example.com")
+				_ = writer.WriteField("message", "this is a test message")
+				_ = writer.Close()
+				req := httptest.NewRequest(http.MethodPost, "/send/testform_toml/"+computedHash, buf)
+				req.Header.Set("Content-Type", writer.FormDataContentType())
+				req.Header.Set("User-Agent", "test-agent/1.0")
+				req.TLS = &tls.ConnectionState{}
+				req.Header.Set("Origin", origin)
+				recorder := httptest.NewRecorder()
+				router.ServeHTTP(recorder, req)
+				if recorder.Code != tt.code {
+					t.Errorf("expected status code %d, got: %d", tt.code, recorder.Code)
+				}
+			})
+		}
+	})
+	t.Run("virus scanning", func(t *testing.T) {
+		origin := "https://example.com"
+		clamdAddr := testClamd(t)
+		tests := []struct {
+			name    string
+			content string
+			code    int
+		}{
+			{"clean upload is delivered", "this is a clean file", http.StatusOK},
+			{"infected upload is rejected", testhelper.EICAR, http.StatusUnprocessableEntity},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tokenCreatedAt := time.Now()
+				tokenExpiresAt := tokenCreatedAt.Add(time.Hour)
+				form, err := forms.New("../../testdata", "testform_toml")
+				if err != nil {
+					t.Fatalf("failed to create form: %s", err)
+				}
+				form.Validation.ClamAV.Enabled = true
+				hasher := sha256.New()
+				value := fmt.Sprintf("%s_%d_%d_%s_%s", origin, tokenCreatedAt.UnixNano(),
+					tokenExpiresAt.UnixNano(), form.ID, form.Secret)
+				hasher.Write([]byte(value))
+				computedHash := fmt.Sprintf("%x", hasher.Sum(nil))
+				server, err := testServer(t, slog.LevelDebug, io.Discard)
+				if err != nil {
+					t.Fatalf("failed to create test server: %s", err)
+				}
+				server.clamav = clamav.New("tcp", clamdAddr, time.Second)
+				if err = server.cache.Set(computedHash, form, cache.ItemParams{
+					TokenCreatedAt: tokenCreatedAt,
+					TokenExpiresAt: tokenExpiresAt,
+				}); err != nil {
+					t.Errorf("failed to set cache item: %s", err)
+				}
+
+				router := chi.NewRouter()
+				router.With(server.preflightCheck).Post("/send/{formID}/{hash}", server.HandlerAPISendFormPost)
+				buf := bytes.NewBuffer(nil)
+				writer := multipart.NewWriter(buf)
+				_ = writer.WriteField("email", "example@example.com")
+				_ = writer.WriteField("message", "this is a test message")
+				part, err := writer.CreateFormFile("upload", "upload.txt")
+				if err != nil {
+					t.Fatalf("failed to create form file: %s", err)
+				}
+				_, _ = io.WriteString(part, tt.content)
+				_ = writer.Close()
+				req := httptest.NewRequest(http.MethodPost, "/send/testform_toml/"+computedHash, buf)
+				req.Header.Set("Content-Type", writer.FormDataContentType())
+				req.TLS = &tls.ConnectionState{}
+				req.Header.Set("Origin", origin)
+				recorder := httptest.NewRecorder()
+				router.ServeHTTP(recorder, req)
+				if recorder.Code != tt.code {
+					t.Errorf("expected status code %d, got: %d", tt.code, recorder.Code)
 				}
 			})
 		}
 	})
 }
 
-func TestServer_validateCaptcha(t *testing.T) {
-	tests := []struct {
-		name       string
-		captchaFn  func(*http.Request) (*http.Response, error)
-		formFn     func(*forms.Form)
-		submission map[string][]string
-		succeeds   bool
-	}{
-		{
-			"private captcha successful response",
-			testResponseFromFile(t, "../../testdata/private_captcha_success.json", http.StatusOK, false),
-			func(form *forms.Form) {
-				form.Validation.PrivateCaptcha.Enabled = true
-			},
-			map[string][]string{
-				privateCaptchaSolutionField: {"private_captcha_token"},
-			},
-			true,
-		},
-		{
-			"private captcha failure response",
-			testResponseFromFile(t, "../../testdata/private_captcha_failure.json", http.StatusUnauthorized, false),
-			func(form *forms.Form) {
-				form.Validation.PrivateCaptcha.Enabled = true
-			},
-			map[string][]string{
-				privateCaptchaSolutionField: {"private_captcha_token"},
-			},
-			false,
-		},
-		{
-			"private captcha http request errors",
-			testResponseFromFile(t, "../../testdata/private_captcha_failure.json", http.StatusUnauthorized, true),
-			func(form *forms.Form) {
-				form.Validation.PrivateCaptcha.Enabled = true
-			},
-			map[string][]string{
-				privateCaptchaSolutionField: {"private_captcha_token"},
-			},
-			false,
-		},
-		{
-			"private captcha succeeds but is negative",
-			testResponseFromFile(t, "../../testdata/private_captcha_success_but_negative.json", http.StatusOK, false),
-			func(form *forms.Form) {
-				form.Validation.PrivateCaptcha.Enabled = true
-			},
-			map[string][]string{
-				privateCaptchaSolutionField: {"private_captcha_token"},
-			},
-			false,
-		},
-		{
-			"private captcha without challenge field",
-			testResponseFromFile(t, "../../testdata/private_captcha_success.json", http.StatusUnauthorized, false),
-			func(form *forms.Form) {
-				form.Validation.PrivateCaptcha.Enabled = true
-			},
-			map[string][]string{},
-			false,
-		},
-		{
-			"private captcha with invalid endpoint",
-			testResponseFromFile(t, "../../testdata/private_captcha_success.json", http.StatusUnauthorized, false),
-			func(form *forms.Form) {
-				form.Validation.PrivateCaptcha.Enabled = true
-				form.Validation.PrivateCaptcha.Host = "invalid%"
-			},
-			map[string][]string{
-				privateCaptchaSolutionField: {"private_captcha_token"},
-			},
-			false,
-		},
-		{
-			"hCaptcha successful response",
-			testResponseFromFile(t, "../../testdata/hcaptcha_success.json", http.StatusOK, false),
-			func(form *forms.Form) {
-				form.Validation.Hcaptcha.Enabled = true
-			},
-			map[string][]string{
-				hCaptchaSolutionField: {"hcaptcha_token"},
-			},
-			true,
-		},
-		{
-			"hCaptcha successful request but negative solution",
-			testResponseFromFile(t, "../../testdata/hcaptcha_success_but_negative.json", http.StatusOK, false),
-			func(form *forms.Form) {
-				form.Validation.Hcaptcha.Enabled = true
-			},
-			map[string][]string{
-				hCaptchaSolutionField: {"hcaptcha_token"},
-			},
-			false,
-		},
-		{
-			"hCaptcha failure response",
-			testResponseFromFile(t, "../../testdata/hcaptcha_failure.json", http.StatusUnauthorized, false),
-			func(form *forms.Form) {
-				form.Validation.Hcaptcha.Enabled = true
-			},
-			map[string][]string{
-				hCaptchaSolutionField: {"hcaptcha_token"},
-			},
-			false,
-		},
-		{
-			"hCaptcha fails http request",
-			testResponseFromFile(t, "../../testdata/hcaptcha_failure.json", http.StatusUnauthorized, true),
-			func(form *forms.Form) {
-				form.Validation.Hcaptcha.Enabled = true
-			},
-			map[string][]string{
-				hCaptchaSolutionField: {"hcaptcha_token"},
-			},
-			false,
-		},
+func TestResponse_Render(t *testing.T) {
+	t.Run("render response without timestamp", func(t *testing.T) {
+		req := new(http.Request)
+		resp := NewResponse(http.StatusOK, "test", map[string]interface{}{"ping": "pong"})
+		resp.Timestamp = time.Time{}
+		if err := resp.Render(nil, req); err != nil {
+			t.Errorf("failed to render response: %s", err)
+		}
+		if resp.Timestamp.IsZero() {
+			t.Errorf("expected timestamp to be set, got: %s", resp.Timestamp)
+		}
+	})
+}
+
+func TestResponse_ProblemDetails(t *testing.T) {
+	t.Run("problem details are returned if accepted by the client", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.config.Forms.Path = "../../testdata"
+
+		router := chi.NewRouter()
+		router.Use(middleware.RequestID)
+		router.With(server.preflightCheck).Get("/token/{formID}", server.HandlerAPITokenGet)
+
+		req := httptest.NewRequest(http.MethodGet, "/token/non_existing_form", nil)
+		req.Header.Set("Accept", "application/json, application/problem+json;q=0.9")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got: %d", http.StatusBadRequest, recorder.Code)
+		}
+		if ct := recorder.Header().Get("Content-Type"); ct != contentTypeProblemJSON {
+			t.Errorf("expected content type %s, got: %s", contentTypeProblemJSON, ct)
+		}
+		problem := new(ProblemDetails)
+		if err = json.NewDecoder(recorder.Body).Decode(problem); err != nil {
+			t.Fatalf("failed to decode JSON response: %s", err)
+		}
+		wantType := problemTypeBase + "form-not-found"
+		if problem.Type != wantType {
+			t.Errorf("expected problem type %s, got: %s", wantType, problem.Type)
+		}
+		wantTitle := "Form not found"
+		if problem.Title != wantTitle {
+			t.Errorf("expected problem title %s, got: %s", wantTitle, problem.Title)
+		}
+		if problem.Status != http.StatusBadRequest {
+			t.Errorf("expected problem status %d, got: %d", http.StatusBadRequest, problem.Status)
+		}
+		if problem.Detail != forms.ErrFormNotFound.Error() {
+			t.Errorf("expected problem detail %s, got: %s", forms.ErrFormNotFound.Error(), problem.Detail)
+		}
+		if problem.Instance == "" {
+			t.Error("expected problem instance to be set to the request ID")
+		}
+	})
+	t.Run("problem details are returned if enabled in the config", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.config.Forms.Path = "../../testdata"
+		server.config.Server.ProblemDetails = true
+
+		router := chi.NewRouter()
+		router.Use(server.problemDetails)
+		router.With(server.preflightCheck).Get("/token", server.HandlerAPITokenGet)
+
+		req := httptest.NewRequest(http.MethodGet, "/token", nil)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		if ct := recorder.Header().Get("Content-Type"); ct != contentTypeProblemJSON {
+			t.Errorf("expected content type %s, got: %s", contentTypeProblemJSON, ct)
+		}
+		problem := new(ProblemDetails)
+		if err = json.NewDecoder(recorder.Body).Decode(problem); err != nil {
+			t.Fatalf("failed to decode JSON response: %s", err)
+		}
+		wantType := problemTypeBase + "missing-parameter"
+		if problem.Type != wantType {
+			t.Errorf("expected problem type %s, got: %s", wantType, problem.Type)
+		}
+	})
+	t.Run("envelope is returned if problem details are not requested", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.config.Forms.Path = "../../testdata"
+
+		router := chi.NewRouter()
+		router.Use(server.problemDetails)
+		router.With(server.preflightCheck).Get("/token", server.HandlerAPITokenGet)
+
+		req := httptest.NewRequest(http.MethodGet, "/token", nil)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		if ct := recorder.Header().Get("Content-Type"); ct == contentTypeProblemJSON {
+			t.Errorf("expected content type not to be %s", contentTypeProblemJSON)
+		}
+		data := new(Response)
+		if err = json.NewDecoder(recorder.Body).Decode(data); err != nil {
+			t.Fatalf("failed to decode JSON response: %s", err)
+		}
+		if data.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got: %d", http.StatusBadRequest, data.StatusCode)
+		}
+	})
+	t.Run("problem details contain field errors", func(t *testing.T) {
+		origin := "https://example.com"
+		tokenCreatedAt := time.Now()
+		tokenExpiresAt := tokenCreatedAt.Add(time.Hour)
+
+		form, err := forms.New("../../testdata", "testform_toml")
+		if err != nil {
+			t.Fatalf("failed to create form: %s", err)
+		}
+		hasher := sha256.New()
+		value := fmt.Sprintf("%s_%d_%d_%s_%s", origin, tokenCreatedAt.UnixNano(),
+			tokenExpiresAt.UnixNano(), form.ID, form.Secret)
+		hasher.Write([]byte(value))
+		computedHash := fmt.Sprintf("%x", hasher.Sum(nil))
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.config.Forms.Path = "../../testdata"
+		if err = server.cache.Set(computedHash, form, cache.ItemParams{
+			TokenCreatedAt: tokenCreatedAt,
+			TokenExpiresAt: tokenExpiresAt,
+		}); err != nil {
+			t.Errorf("failed to set cache item: %s", err)
+		}
+
+		router := chi.NewRouter()
+		router.With(server.preflightCheck).Post("/send/{formID}/{hash}", server.HandlerAPISendFormPost)
+		buf := bytes.NewBuffer(nil)
+		writer := multipart.NewWriter(buf)
+		_ = writer.WriteField("email", "not@val.")
+		_ = writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/send/testform_toml/"+computedHash, buf)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Accept", contentTypeProblemJSON)
+		req.Header.Set("Origin", origin)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got: %d", http.StatusBadRequest, recorder.Code)
+		}
+		problem := new(ProblemDetails)
+		if err = json.NewDecoder(recorder.Body).Decode(problem); err != nil {
+			t.Fatalf("failed to decode JSON response: %s", err)
+		}
+		wantType := problemTypeBase + "validation-failed"
+		if problem.Type != wantType {
+			t.Errorf("expected problem type %s, got: %s", wantType, problem.Type)
+		}
+		if problem.Detail != ErrRequiredFieldsValidationFailed.Error() {
+			t.Errorf("expected problem detail %s, got: %s", ErrRequiredFieldsValidationFailed, problem.Detail)
+		}
+		wantErrs := FieldErrors{
+			{Field: "email", Detail: "field is not of type email"},
+			{Field: "message", Detail: "required field is missing"},
+		}
+		if len(problem.Errors) != len(wantErrs) {
+			t.Fatalf("expected %d field errors, got: %d", len(wantErrs), len(problem.Errors))
+		}
+		for i, fieldErr := range problem.Errors {
+			if fieldErr != wantErrs[i] {
+				t.Errorf("expected field error %s, got: %s", wantErrs[i], fieldErr)
+			}
+		}
+	})
+	t.Run("unknown errors use the about:blank problem type", func(t *testing.T) {
+		resp := NewErrResponse(http.StatusTeapot, errors.New("unknown error"))
+		errResp, ok := resp.(*Response)
+		if !ok {
+			t.Fatalf("expected response to be of type *Response, got: %T", resp)
+		}
+		problem := errResp.ProblemDetails()
+		if problem.Type != "about:blank" {
+			t.Errorf("expected problem type about:blank, got: %s", problem.Type)
+		}
+		if problem.Title != http.StatusText(http.StatusTeapot) {
+			t.Errorf("expected problem title %s, got: %s", http.StatusText(http.StatusTeapot), problem.Title)
+		}
+	})
+}
+
+func TestServer_failsRequiredFields(t *testing.T) {
+	tests := []struct {
+		name        string
+		validations []forms.ValidationField
+		submission  map[string][]string
+		fails       bool
+	}{
 		{
-			"hCaptcha solution field is missing",
-			testResponseFromFile(t, "../../testdata/hcaptcha_failure.json", http.StatusUnauthorized, false),
-			func(form *forms.Form) {
-				form.Validation.Hcaptcha.Enabled = true
-			},
-			map[string][]string{},
+			"text passes through",
+			[]forms.ValidationField{{Name: "text", Type: "text", Required: true}},
+			map[string][]string{"text": {"hello world"}},
 			false,
 		},
 		{
-			"turnstile successful response",
-			testResponseFromFile(t, "../../testdata/turnstile_success.json", http.StatusOK, false),
-			func(form *forms.Form) {
-				form.Validation.Turnstile.Enabled = true
-			},
-			map[string][]string{
-				turnstileSolutionField: {"turnstile_token"},
-			},
+			"no valid email format",
+			[]forms.ValidationField{{Name: "email", Type: "email", Required: true}},
+			map[string][]string{"email": {"not@val."}},
 			true,
 		},
 		{
-			"turnstile successful request but negative solution",
-			testResponseFromFile(t, "../../testdata/turnstile_success_but_negative.json", http.StatusOK, false),
-			func(form *forms.Form) {
-				form.Validation.Turnstile.Enabled = true
-			},
-			map[string][]string{
-				turnstileSolutionField: {"turnstile_token"},
-			},
+			"valid email format",
+			[]forms.ValidationField{{Name: "email", Type: "email", Required: true}},
+			map[string][]string{"email": {"valid@example.com"}},
 			false,
 		},
 		{
-			"turnstile failure response",
-			testResponseFromFile(t, "../../testdata/turnstile_failure.json", http.StatusUnauthorized, false),
-			func(form *forms.Form) {
-				form.Validation.Turnstile.Enabled = true
-			},
-			map[string][]string{
-				turnstileSolutionField: {"turnstile_token"},
-			},
-			false,
+			"not a number",
+			[]forms.ValidationField{{Name: "number", Type: "number", Required: true}},
+			map[string][]string{"number": {"text"}},
+			true,
 		},
 		{
-			"turnstile fails http request",
-			testResponseFromFile(t, "../../testdata/turnstile_failure.json", http.StatusUnauthorized, true),
-			func(form *forms.Form) {
-				form.Validation.Turnstile.Enabled = true
+			"is a number",
+			[]forms.ValidationField{
+				{Name: "number", Type: "number", Required: true},
+				{Name: "number2", Type: "number", Required: true},
+				{Name: "number3", Type: "number", Required: true},
 			},
 			map[string][]string{
-				turnstileSolutionField: {"turnstile_token"},
+				"number":  {"1"},
+				"number2": {"-42"},
+				"number3": {"3.14"},
 			},
 			false,
 		},
 		{
-			"turnstile solution field is missing",
-			testResponseFromFile(t, "../../testdata/turnstile_failure.json", http.StatusUnauthorized, false),
-			func(form *forms.Form) {
-				form.Validation.Turnstile.Enabled = true
-			},
-			map[string][]string{},
-			false,
+			"not a boolean",
+			[]forms.ValidationField{{Name: "bool", Type: "bool", Required: true}},
+			map[string][]string{"bool": {"text"}},
+			true,
 		},
 		{
-			"reCaptcha successful response",
-			testResponseFromFile(t, "../../testdata/recaptcha_success.json", http.StatusOK, false),
-			func(form *forms.Form) {
-				form.Validation.Recaptcha.Enabled = true
+			"boolean is valid",
+			[]forms.ValidationField{
+				{Name: "bool", Type: "bool", Required: true},
+				{Name: "bool2", Type: "bool", Required: true},
+				{Name: "bool3", Type: "bool", Required: true},
 			},
 			map[string][]string{
-				reCaptchaSolutionField: {"reCaptcha_token"},
+				"bool":  {"1"},
+				"bool2": {"true"},
+				"bool3": {"on"},
 			},
+			false,
+		},
+		{
+			"value does not match",
+			[]forms.ValidationField{{Name: "text", Type: "matchval", Required: true, Value: "expected"}},
+			map[string][]string{"text": {"unexpected"}},
 			true,
 		},
 		{
-			"reCaptcha successful request but negative solution",
-			testResponseFromFile(t, "../../testdata/recaptcha_success_but_negative.json", http.StatusOK, false),
-			func(form *forms.Form) {
-				form.Validation.Recaptcha.Enabled = true
-			},
-			map[string][]string{
-				reCaptchaSolutionField: {"reCaptcha_token"},
-			},
+			"value matches",
+			[]forms.ValidationField{{Name: "text", Type: "matchval", Required: true, Value: "expected"}},
+			map[string][]string{"text": {"expected"}},
 			false,
 		},
+	}
+
+	t.Run("checking required fields", func(t *testing.T) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				server, err := testServer(t, slog.LevelDebug, io.Discard)
+				if err != nil {
+					t.Fatalf("failed to create test server: %s", err)
+				}
+
+				fails, _ := server.failsRequiredFields(t.Context(), tt.validations, tt.submission)
+				if fails != tt.fails {
+					t.Errorf("expected fails to be %t, got: %t", tt.fails, fails)
+				}
+			})
+		}
+	})
+}
+
+func TestServer_undeliverable(t *testing.T) {
+	resolver := testhelper.DNSServer(t, map[string]string{
+		"example.com.":      "MX mail.example.com.",
+		"mailinator.com.":   "MX mail.mailinator.com.",
+		"nullmx.example.":   "MX .",
+		"failing.example.":  testhelper.DNSServFail,
+		"a-record.example.": "192.0.2.1",
+	})
+	disposable := filepath.Join(t.TempDir(), "disposable.txt")
+	if err := os.WriteFile(disposable, []byte("mailinator.com\n"), 0o600); err != nil {
+		t.Fatalf("failed to write disposable domains list: %s", err)
+	}
+	allChecks := forms.ValidationField{
+		Name: "email", Type: "email", CheckMX: true, RejectDisposable: true,
+		RejectRoleAccounts: true,
+	}
+
+	tests := []struct {
+		name    string
+		field   forms.ValidationField
+		address string
+		reason  string
+	}{
+		{"deliverable address", allChecks, "jane@example.com", ""},
+		{"domain with A record", allChecks, "jane@a-record.example", ""},
+		{"non-existing domain", allChecks, "test@asdfgh.invalid", "email domain does not accept mail"},
+		{"domain with null MX", allChecks, "jane@nullmx.example", "email domain does not accept mail"},
+		{"failed lookup is skipped", allChecks, "jane@failing.example", ""},
+		{"disposable domain", allChecks, "jane@mailinator.com", "disposable email addresses are not allowed"},
+		{"role account", allChecks, "postmaster@example.com", "role account email addresses are not allowed"},
+		{"checks are disabled", forms.ValidationField{Name: "email", Type: "email"}, "postmaster@mailinator.com", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := testServer(t, slog.LevelDebug, io.Discard)
+			if err != nil {
+				t.Fatalf("failed to create test server: %s", err)
+			}
+			server.deliverability = deliverability.New(resolver, time.Second, time.Minute)
+			if err = server.deliverability.LoadDisposableDomains(disposable); err != nil {
+				t.Fatalf("failed to load disposable domains list: %s", err)
+			}
+			if reason := server.undeliverable(t.Context(), tt.field, tt.address); reason != tt.reason {
+				t.Errorf("expected reason %q, got %q", tt.reason, reason)
+			}
+		})
+	}
+	t.Run("required field validation reports the reason", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.deliverability = deliverability.New(resolver, time.Second, time.Minute)
+		fails, invalidFields := server.failsRequiredFields(t.Context(), []forms.ValidationField{allChecks},
+			map[string][]string{"email": {"Jane Doe <test@asdfgh.invalid>"}})
+		if !fails {
+			t.Fatal("expected required field validation to fail")
+		}
+		if invalidFields["email"] != "email domain does not accept mail" {
+			t.Errorf("unexpected reason: %q", invalidFields["email"])
+		}
+	})
+}
+
+func TestServer_validateCaptcha(t *testing.T) {
+	tests := []struct {
+		name       string
+		captchaFn  func(*http.Request) (*http.Response, error)
+		formFn     func(*forms.Form)
+		submission map[string][]string
+		succeeds   bool
+	}{
+		{
+			"private captcha successful response",
+			testResponseFromFile(t, "../../testdata/private_captcha_success.json", http.StatusOK, false),
+			func(form *forms.Form) {
+				form.Validation.PrivateCaptcha.Enabled = true
+			},
+			map[string][]string{
+				privateCaptchaSolutionField: {"private_captcha_token"},
+			},
+			true,
+		},
+		{
+			"private captcha failure response",
+			testResponseFromFile(t, "../../testdata/private_captcha_failure.json", http.StatusUnauthorized, false),
+			func(form *forms.Form) {
+				form.Validation.PrivateCaptcha.Enabled = true
+			},
+			map[string][]string{
+				privateCaptchaSolutionField: {"private_captcha_token"},
+			},
+			false,
+		},
+		{
+			"private captcha http request errors",
+			testResponseFromFile(t, "../../testdata/private_captcha_failure.json", http.StatusUnauthorized, true),
+			func(form *forms.Form) {
+				form.Validation.PrivateCaptcha.Enabled = true
+			},
+			map[string][]string{
+				privateCaptchaSolutionField: {"private_captcha_token"},
+			},
+			false,
+		},
+		{
+			"private captcha succeeds but is negative",
+			testResponseFromFile(t, "../../testdata/private_captcha_success_but_negative.json", http.StatusOK, false),
+			func(form *forms.Form) {
+				form.Validation.PrivateCaptcha.Enabled = true
+			},
+			map[string][]string{
+				privateCaptchaSolutionField: {"private_captcha_token"},
+			},
+			false,
+		},
+		{
+			"private captcha without challenge field",
+			testResponseFromFile(t, "../../testdata/private_captcha_success.json", http.StatusUnauthorized, false),
+			func(form *forms.Form) {
+				form.Validation.PrivateCaptcha.Enabled = true
+			},
+			map[string][]string{},
+			false,
+		},
+		{
+			"private captcha with invalid endpoint",
+			testResponseFromFile(t, "../../testdata/private_captcha_success.json", http.StatusUnauthorized, false),
+			func(form *forms.Form) {
+				form.Validation.PrivateCaptcha.Enabled = true
+				form.Validation.PrivateCaptcha.Host = "invalid%"
+			},
+			map[string][]string{
+				privateCaptchaSolutionField: {"private_captcha_token"},
+			},
+			false,
+		},
+		{
+			"hCaptcha successful response",
+			testResponseFromFile(t, "../../testdata/hcaptcha_success.json", http.StatusOK, false),
+			func(form *forms.Form) {
+				form.Validation.Hcaptcha.Enabled = true
+			},
+			map[string][]string{
+				hCaptchaSolutionField: {"hcaptcha_token"},
+			},
+			true,
+		},
+		{
+			"hCaptcha successful request but negative solution",
+			testResponseFromFile(t, "../../testdata/hcaptcha_success_but_negative.json", http.StatusOK, false),
+			func(form *forms.Form) {
+				form.Validation.Hcaptcha.Enabled = true
+			},
+			map[string][]string{
+				hCaptchaSolutionField: {"hcaptcha_token"},
+			},
+			false,
+		},
+		{
+			"hCaptcha failure response",
+			testResponseFromFile(t, "../../testdata/hcaptcha_failure.json", http.StatusUnauthorized, false),
+			func(form *forms.Form) {
+				form.Validation.Hcaptcha.Enabled = true
+			},
+			map[string][]string{
+				hCaptchaSolutionField: {"hcaptcha_token"},
+			},
+			false,
+		},
+		{
+			"hCaptcha fails http request",
+			testResponseFromFile(t, "../../testdata/hcaptcha_failure.json", http.StatusUnauthorized, true),
+			func(form *forms.Form) {
+				form.Validation.Hcaptcha.Enabled = true
+			},
+			map[string][]string{
+				hCaptchaSolutionField: {"hcaptcha_token"},
+			},
+			false,
+		},
+		{
+			"hCaptcha solution field is missing",
+			testResponseFromFile(t, "../../testdata/hcaptcha_failure.json", http.StatusUnauthorized, false),
+			func(form *forms.Form) {
+				form.Validation.Hcaptcha.Enabled = true
+			},
+			map[string][]string{},
+			false,
+		},
+		{
+			"turnstile successful response",
+			testResponseFromFile(t, "../../testdata/turnstile_success.json", http.StatusOK, false),
+			func(form *forms.Form) {
+				form.Validation.Turnstile.Enabled = true
+			},
+			map[string][]string{
+				turnstileSolutionField: {"turnstile_token"},
+			},
+			true,
+		},
+		{
+			"turnstile successful request but negative solution",
+			testResponseFromFile(t, "../../testdata/turnstile_success_but_negative.json", http.StatusOK, false),
+			func(form *forms.Form) {
+				form.Validation.Turnstile.Enabled = true
+			},
+			map[string][]string{
+				turnstileSolutionField: {"turnstile_token"},
+			},
+			false,
+		},
+		{
+			"turnstile failure response",
+			testResponseFromFile(t, "../../testdata/turnstile_failure.json", http.StatusUnauthorized, false),
+			func(form *forms.Form) {
+				form.Validation.Turnstile.Enabled = true
+			},
+			map[string][]string{
+				turnstileSolutionField: {"turnstile_token"},
+			},
+			false,
+		},
+		{
+			"turnstile fails http request",
+			testResponseFromFile(t, "../../testdata/turnstile_failure.json", http.StatusUnauthorized, true),
+			func(form *forms.Form) {
+				form.Validation.Turnstile.Enabled = true
+			},
+			map[string][]string{
+				turnstileSolutionField: {"turnstile_token"},
+			},
+			false,
+		},
+		{
+			"turnstile solution field is missing",
+			testResponseFromFile(t, "../../testdata/turnstile_failure.json", http.StatusUnauthorized, false),
+			func(form *forms.Form) {
+				form.Validation.Turnstile.Enabled = true
+			},
+			map[string][]string{},
+			false,
+		},
+		{
+			"reCaptcha successful response",
+			testResponseFromFile(t, "../../testdata/recaptcha_success.json", http.StatusOK, false),
+			func(form *forms.Form) {
+				form.Validation.Recaptcha.Enabled = true
+			},
+			map[string][]string{
+				reCaptchaSolutionField: {"reCaptcha_token"},
+			},
+			true,
+		},
+		{
+			"reCaptcha successful request but negative solution",
+			testResponseFromFile(t, "../../testdata/recaptcha_success_but_negative.json", http.StatusOK, false),
+			func(form *forms.Form) {
+				form.Validation.Recaptcha.Enabled = true
+			},
+			map[string][]string{
+				reCaptchaSolutionField: {"reCaptcha_token"},
+			},
+			false,
+		},
+		{
+			"reCaptcha failure response",
+			testResponseFromFile(t, "../../testdata/recaptcha_failure.json", http.StatusUnauthorized, false),
+			func(form *forms.Form) {
+				form.Validation.Recaptcha.Enabled = true
+			},
+			map[string][]string{
+				reCaptchaSolutionField: {"reCaptcha_token"},
+			},
+			false,
+		},
+		{
+			"reCaptcha fails http request",
+			testResponseFromFile(t, "../../testdata/recaptcha_failure.json", http.StatusUnauthorized, true),
+			func(form *forms.Form) {
+				form.Validation.Recaptcha.Enabled = true
+			},
+			map[string][]string{
+				reCaptchaSolutionField: {"reCaptcha_token"},
+			},
+			false,
+		},
+		{
+			"reCaptcha solution field is missing",
+			testResponseFromFile(t, "../../testdata/recaptcha_failure.json", http.StatusUnauthorized, false),
+			func(form *forms.Form) {
+				form.Validation.Recaptcha.Enabled = true
+			},
+			map[string][]string{},
+			false,
+		},
+	}
+
+	remoteAddr := "127.0.0.1"
+	t.Run("validate captcha against", func(t *testing.T) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				server, err := testServer(t, slog.LevelDebug, io.Discard)
+				if err != nil {
+					t.Fatalf("failed to create test server: %s", err)
+				}
+				form, err := forms.New("../../testdata", "testform_toml")
+				if err != nil {
+					t.Fatalf("failed to load form: %s", err)
+				}
+				tt.formFn(form)
+
+				server.httpClient.Transport = testhelper.MockRoundTripper{Fn: tt.captchaFn}
+				if _, err = server.validateCaptcha(t.Context(), form, tt.submission, remoteAddr, cache.ItemParams{}); err != nil && tt.succeeds {
+					t.Errorf("captcha validation failed: %s", err)
+				}
+			})
+		}
+	})
+}
+
+func TestServer_reCaptcha(t *testing.T) {
+	tests := []struct {
+		name      string
+		captchaFn func(*http.Request) (*http.Response, error)
+		formFn    func(*forms.Form)
+		succeeds  bool
+		flagged   bool
+	}{
+		{
+			"v3 score within gray zone is flagged",
+			testResponseFromFile(t, "../../testdata/recaptcha_v3_success.json", http.StatusOK, false),
+			func(form *forms.Form) {
+				form.Validation.Recaptcha.MinScore = 0.3
+				form.Validation.Recaptcha.FlagScore = 0.7
+			},
+			true,
+			true,
+		},
+		{
+			"v3 score above gray zone passes",
+			testResponseFromFile(t, "../../testdata/recaptcha_v3_success.json", http.StatusOK, false),
+			func(form *forms.Form) {
+				form.Validation.Recaptcha.MinScore = 0.3
+				form.Validation.Recaptcha.FlagScore = 0.4
+			},
+			true,
+			false,
+		},
+		{
+			"v3 score below minimum score fails",
+			testResponseFromFile(t, "../../testdata/recaptcha_v3_success.json", http.StatusOK, false),
+			func(form *forms.Form) {
+				form.Validation.Recaptcha.MinScore = 0.7
+			},
+			false,
+			false,
+		},
+		{
+			"v2 response without score fails with minimum score",
+			testResponseFromFile(t, "../../testdata/recaptcha_success.json", http.StatusOK, false),
+			func(form *forms.Form) {
+				form.Validation.Recaptcha.MinScore = 0.5
+			},
+			false,
+			false,
+		},
+		{
+			"expected action matches",
+			testResponseFromFile(t, "../../testdata/recaptcha_v3_success.json", http.StatusOK, false),
+			func(form *forms.Form) {
+				form.Validation.Recaptcha.Action = "contact"
+			},
+			true,
+			false,
+		},
+		{
+			"expected action does not match",
+			testResponseFromFile(t, "../../testdata/recaptcha_v3_success.json", http.StatusOK, false),
+			func(form *forms.Form) {
+				form.Validation.Recaptcha.Action = "login"
+			},
+			false,
+			false,
+		},
+		{
+			"hostname does not match form domains",
+			testResponseFromFile(t, "../../testdata/recaptcha_v3_success.json", http.StatusOK, false),
+			func(form *forms.Form) {
+				form.Domains = []string{"example.org"}
+			},
+			false,
+			false,
+		},
+		{
+			"hostname check can be disabled",
+			testResponseFromFile(t, "../../testdata/recaptcha_v3_success.json", http.StatusOK, false),
+			func(form *forms.Form) {
+				form.Domains = []string{"example.org"}
+				form.Validation.Recaptcha.DisableHostnameCheck = true
+			},
+			true,
+			false,
+		},
+		{
+			"enterprise assessment within gray zone is flagged",
+			func(req *http.Request) (*http.Response, error) {
+				if !strings.Contains(req.URL.Path, "/projects/123456789/assessments") {
+					return nil, fmt.Errorf("unexpected enterprise endpoint: %s", req.URL)
+				}
+				if req.URL.Query().Get("key") != "recaptcha-api-key" {
+					return nil, fmt.Errorf("unexpected enterprise API key: %s", req.URL.Query().Get("key"))
+				}
+				return testResponseFromFile(t, "../../testdata/recaptcha_enterprise_success.json",
+					http.StatusOK, false)(req)
+			},
+			func(form *forms.Form) {
+				form.Validation.Recaptcha.ProjectID = "123456789"
+				form.Validation.Recaptcha.APIKey = "recaptcha-api-key"
+				form.Validation.Recaptcha.SiteKey = "recaptcha-site-key"
+				form.Validation.Recaptcha.Action = "contact"
+				form.Validation.Recaptcha.MinScore = 0.3
+				form.Validation.Recaptcha.FlagScore = 0.7
+			},
+			true,
+			true,
+		},
+		{
+			"enterprise assessment with invalid token fails",
+			testResponseFromFile(t, "../../testdata/recaptcha_enterprise_invalid.json", http.StatusOK, false),
+			func(form *forms.Form) {
+				form.Validation.Recaptcha.ProjectID = "123456789"
+			},
+			false,
+			false,
+		},
+		{
+			"enterprise assessment with error status fails",
+			testResponseFromFile(t, "../../testdata/recaptcha_enterprise_invalid.json", http.StatusForbidden, false),
+			func(form *forms.Form) {
+				form.Validation.Recaptcha.ProjectID = "123456789"
+			},
+			false,
+			false,
+		},
+	}
+
+	t.Run("validate reCaptcha scores", func(t *testing.T) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				server, err := testServer(t, slog.LevelDebug, io.Discard)
+				if err != nil {
+					t.Fatalf("failed to create test server: %s", err)
+				}
+				form, err := forms.New("../../testdata", "testform_toml")
+				if err != nil {
+					t.Fatalf("failed to load form: %s", err)
+				}
+				form.Validation.Recaptcha.Enabled = true
+				tt.formFn(form)
+
+				server.httpClient.Transport = testhelper.MockRoundTripper{Fn: tt.captchaFn}
+				submission := map[string][]string{reCaptchaSolutionField: {"recaptcha_token"}}
+				flagged, err := server.validateCaptcha(t.Context(), form, submission, "127.0.0.1", cache.ItemParams{})
+				if tt.succeeds && err != nil {
+					t.Errorf("reCaptcha validation failed: %s", err)
+				}
+				if !tt.succeeds && err == nil {
+					t.Error("expected reCaptcha validation to fail")
+				}
+				if flagged != tt.flagged {
+					t.Errorf("expected flagged to be %t, got: %t", tt.flagged, flagged)
+				}
+			})
+		}
+	})
+}
+
+func TestServer_verifyChallenge(t *testing.T) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	old := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	tests := []struct {
+		name       string
+		captchaFn  func(*http.Request) (*http.Response, error)
+		formFn     func(*forms.Form)
+		submission map[string][]string
+		succeeds   bool
+	}{
+		{
+			"hCaptcha hostname does not match form domains",
+			testJSONResponse(t, http.StatusOK, map[string]any{
+				"success": true, "challenge_ts": now, "hostname": "evil.example.org",
+			}),
+			func(form *forms.Form) { form.Validation.Hcaptcha.Enabled = true },
+			map[string][]string{hCaptchaSolutionField: {"hcaptcha_token"}},
+			false,
+		},
+		{
+			"hCaptcha hostname check can be disabled",
+			testJSONResponse(t, http.StatusOK, map[string]any{
+				"success": true, "challenge_ts": now, "hostname": "evil.example.org",
+			}),
+			func(form *forms.Form) {
+				form.Validation.Hcaptcha.Enabled = true
+				form.Validation.Hcaptcha.DisableHostnameCheck = true
+			},
+			map[string][]string{hCaptchaSolutionField: {"hcaptcha_token"}},
+			true,
+		},
+		{
+			"hCaptcha sends the expected sitekey",
+			func(req *http.Request) (*http.Response, error) {
+				if err := req.ParseForm(); err != nil {
+					return nil, err
+				}
+				if req.PostForm.Get("sitekey") != "hcaptcha-site-key" {
+					return nil, fmt.Errorf("unexpected sitekey: %s", req.PostForm.Get("sitekey"))
+				}
+				return testJSONResponse(t, http.StatusOK, map[string]any{
+					"success": true, "challenge_ts": now, "hostname": "example.com",
+				})(req)
+			},
+			func(form *forms.Form) {
+				form.Validation.Hcaptcha.Enabled = true
+				form.Validation.Hcaptcha.SiteKey = "hcaptcha-site-key"
+			},
+			map[string][]string{hCaptchaSolutionField: {"hcaptcha_token"}},
+			true,
+		},
+		{
+			"hCaptcha risk score exceeds maximum score",
+			testJSONResponse(t, http.StatusOK, map[string]any{
+				"success": true, "challenge_ts": now, "hostname": "example.com", "score": 0.9,
+			}),
+			func(form *forms.Form) {
+				form.Validation.Hcaptcha.Enabled = true
+				form.Validation.Hcaptcha.MaxScore = 0.5
+			},
+			map[string][]string{hCaptchaSolutionField: {"hcaptcha_token"}},
+			false,
+		},
+		{
+			"hCaptcha challenge within maximum age",
+			testJSONResponse(t, http.StatusOK, map[string]any{
+				"success": true, "challenge_ts": now, "hostname": "example.com",
+			}),
+			func(form *forms.Form) {
+				form.Validation.Hcaptcha.Enabled = true
+				form.Validation.MaxChallengeAge = time.Minute * 5
+			},
+			map[string][]string{hCaptchaSolutionField: {"hcaptcha_token"}},
+			true,
+		},
+		{
+			"hCaptcha challenge is too old",
+			testJSONResponse(t, http.StatusOK, map[string]any{
+				"success": true, "challenge_ts": old, "hostname": "example.com",
+			}),
+			func(form *forms.Form) {
+				form.Validation.Hcaptcha.Enabled = true
+				form.Validation.MaxChallengeAge = time.Minute * 5
+			},
+			map[string][]string{hCaptchaSolutionField: {"hcaptcha_token"}},
+			false,
+		},
+		{
+			"hCaptcha challenge timestamp is invalid",
+			testJSONResponse(t, http.StatusOK, map[string]any{
+				"success": true, "challenge_ts": "invalid", "hostname": "example.com",
+			}),
+			func(form *forms.Form) {
+				form.Validation.Hcaptcha.Enabled = true
+				form.Validation.MaxChallengeAge = time.Minute * 5
+			},
+			map[string][]string{hCaptchaSolutionField: {"hcaptcha_token"}},
+			false,
+		},
+		{
+			"turnstile action does not match form ID",
+			testJSONResponse(t, http.StatusOK, map[string]any{
+				"success": true, "challenge_ts": now, "hostname": "example.com", "action": "login",
+			}),
+			func(form *forms.Form) { form.Validation.Turnstile.Enabled = true },
+			map[string][]string{turnstileSolutionField: {"turnstile_token"}},
+			false,
+		},
+		{
+			"turnstile custom data matches form ID",
+			testJSONResponse(t, http.StatusOK, map[string]any{
+				"success": true, "challenge_ts": now, "hostname": "example.com", "action": "login",
+				"cdata": "contact-form",
+			}),
+			func(form *forms.Form) { form.Validation.Turnstile.Enabled = true },
+			map[string][]string{turnstileSolutionField: {"turnstile_token"}},
+			true,
+		},
+		{
+			"turnstile action check can be disabled",
+			testJSONResponse(t, http.StatusOK, map[string]any{
+				"success": true, "challenge_ts": now, "hostname": "example.com", "action": "login",
+			}),
+			func(form *forms.Form) {
+				form.Validation.Turnstile.Enabled = true
+				form.Validation.Turnstile.DisableActionCheck = true
+			},
+			map[string][]string{turnstileSolutionField: {"turnstile_token"}},
+			true,
+		},
+		{
+			"turnstile hostname does not match form domains",
+			testJSONResponse(t, http.StatusOK, map[string]any{
+				"success": true, "challenge_ts": now, "hostname": "evil.example.org", "action": "contact-form",
+			}),
+			func(form *forms.Form) { form.Validation.Turnstile.Enabled = true },
+			map[string][]string{turnstileSolutionField: {"turnstile_token"}},
+			false,
+		},
+		{
+			"private captcha origin URL matches form domains",
+			testJSONResponse(t, http.StatusOK, map[string]any{
+				"success": true, "timestamp": now, "origin": "https://www.example.com",
+			}),
+			func(form *forms.Form) { form.Validation.PrivateCaptcha.Enabled = true },
+			map[string][]string{privateCaptchaSolutionField: {"private_captcha_token"}},
+			true,
+		},
+		{
+			"private captcha origin does not match form domains",
+			testJSONResponse(t, http.StatusOK, map[string]any{
+				"success": true, "timestamp": now, "origin": "https://evil.example.org",
+			}),
+			func(form *forms.Form) { form.Validation.PrivateCaptcha.Enabled = true },
+			map[string][]string{privateCaptchaSolutionField: {"private_captcha_token"}},
+			false,
+		},
+		{
+			"reCaptcha challenge is too old",
+			testJSONResponse(t, http.StatusOK, map[string]any{
+				"success": true, "challenge_ts": old, "hostname": "example.com",
+			}),
+			func(form *forms.Form) {
+				form.Validation.Recaptcha.Enabled = true
+				form.Validation.MaxChallengeAge = time.Minute * 5
+			},
+			map[string][]string{reCaptchaSolutionField: {"recaptcha_token"}},
+			false,
+		},
+	}
+
+	t.Run("verify captcha challenge", func(t *testing.T) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				server, err := testServer(t, slog.LevelDebug, io.Discard)
+				if err != nil {
+					t.Fatalf("failed to create test server: %s", err)
+				}
+				form, err := forms.New("../../testdata", "testform_toml")
+				if err != nil {
+					t.Fatalf("failed to load form: %s", err)
+				}
+				tt.formFn(form)
+
+				server.httpClient.Transport = testhelper.MockRoundTripper{Fn: tt.captchaFn}
+				_, err = server.validateCaptcha(t.Context(), form, tt.submission, "127.0.0.1", cache.ItemParams{})
+				if tt.succeeds && err != nil {
+					t.Errorf("captcha validation failed: %s", err)
+				}
+				if !tt.succeeds && err == nil {
+					t.Error("expected captcha validation to fail")
+				}
+			})
+		}
+	})
+}
+
+func TestDeliveryOptions_subject(t *testing.T) {
+	t.Run("subject without tags is unchanged", func(t *testing.T) {
+		opts := deliveryOptions{}
+		if subject := opts.subject("Contact form"); subject != "Contact form" {
+			t.Errorf("expected subject %q, got: %q", "Contact form", subject)
+		}
+	})
+	t.Run("subject tags are prepended", func(t *testing.T) {
+		opts := deliveryOptions{subjectTags: []string{"[SPAM]", "[CHECK]"}}
+		want := "[SPAM] [CHECK] Contact form"
+		if subject := opts.subject("Contact form"); subject != want {
+			t.Errorf("expected subject %q, got: %q", want, subject)
+		}
+	})
+}
+
+func TestServer_altcha(t *testing.T) {
+	key := "test-secret-key"
+	tests := []struct {
+		name      string
+		payloadFn func(*testing.T, *AltchaChallenge) string
+		expires   time.Time
+		issued    func(*AltchaChallenge) string
+		succeeds  bool
+	}{
+		{
+			"valid solution",
+			func(t *testing.T, challenge *AltchaChallenge) string {
+				return testAltchaPayload(t, challenge, testSolveAltcha(t, challenge), challenge.Signature)
+			},
+			time.Now().Add(time.Minute),
+			func(challenge *AltchaChallenge) string { return challenge.Challenge },
+			true,
+		},
+		{
+			"wrong solution",
+			func(t *testing.T, challenge *AltchaChallenge) string {
+				number := (testSolveAltcha(t, challenge) + 1) % (challenge.MaxNumber + 1)
+				return testAltchaPayload(t, challenge, number, challenge.Signature)
+			},
+			time.Now().Add(time.Minute),
+			func(challenge *AltchaChallenge) string { return challenge.Challenge },
+			false,
+		},
+		{
+			"tampered signature",
+			func(t *testing.T, challenge *AltchaChallenge) string {
+				return testAltchaPayload(t, challenge, testSolveAltcha(t, challenge), altchaSignature("wrong", challenge.Challenge))
+			},
+			time.Now().Add(time.Minute),
+			func(challenge *AltchaChallenge) string { return challenge.Challenge },
+			false,
+		},
+		{
+			"challenge not issued for token",
+			func(t *testing.T, challenge *AltchaChallenge) string {
+				return testAltchaPayload(t, challenge, testSolveAltcha(t, challenge), challenge.Signature)
+			},
+			time.Now().Add(time.Minute),
+			func(*AltchaChallenge) string { return "" },
+			false,
+		},
+		{
+			"expired challenge",
+			func(t *testing.T, challenge *AltchaChallenge) string {
+				return testAltchaPayload(t, challenge, testSolveAltcha(t, challenge), challenge.Signature)
+			},
+			time.Now().Add(-time.Minute),
+			func(challenge *AltchaChallenge) string { return challenge.Challenge },
+			false,
+		},
+		{
+			"invalid payload",
+			func(*testing.T, *AltchaChallenge) string { return "invalid%payload" },
+			time.Now().Add(time.Minute),
+			func(challenge *AltchaChallenge) string { return challenge.Challenge },
+			false,
+		},
+	}
+
+	t.Run("validate ALTCHA solution", func(t *testing.T) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				server, err := testServer(t, slog.LevelDebug, io.Discard)
+				if err != nil {
+					t.Fatalf("failed to create test server: %s", err)
+				}
+				form, err := forms.New("../../testdata", "testform_toml_altcha")
+				if err != nil {
+					t.Fatalf("failed to load form: %s", err)
+				}
+				challenge, err := newAltchaChallenge(key, 1000, tt.expires)
+				if err != nil {
+					t.Fatalf("failed to create ALTCHA challenge: %s", err)
+				}
+				submission := map[string][]string{altchaSolutionField: {tt.payloadFn(t, challenge)}}
+				params := cache.ItemParams{AltchaChallenge: tt.issued(challenge)}
+				_, err = server.validateCaptcha(t.Context(), form, submission, "127.0.0.1", params)
+				if tt.succeeds && err != nil {
+					t.Errorf("ALTCHA validation failed: %s", err)
+				}
+				if !tt.succeeds && err == nil {
+					t.Error("expected ALTCHA validation to fail")
+				}
+			})
+		}
+	})
+	t.Run("missing ALTCHA solution fails", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		form, err := forms.New("../../testdata", "testform_toml_altcha")
+		if err != nil {
+			t.Fatalf("failed to load form: %s", err)
+		}
+		if _, err = server.validateCaptcha(t.Context(), form, map[string][]string{}, "127.0.0.1", cache.ItemParams{}); err == nil {
+			t.Error("expected ALTCHA validation to fail")
+		}
+	})
+}
+
+func TestServer_captchaEndpoints(t *testing.T) {
+	tests := []struct {
+		name       string
+		response   string
+		field      string
+		formFn     func(*forms.Form)
+		wantPrefix string
+	}{
+		{
+			"custom hCaptcha endpoint",
+			"../../testdata/hcaptcha_success.json",
+			hCaptchaSolutionField,
+			func(form *forms.Form) {
+				form.Validation.Hcaptcha.Enabled = true
+				form.Validation.Hcaptcha.Endpoint = "https://api.hcaptcha-eu.example.com/siteverify"
+			},
+			"https://api.hcaptcha-eu.example.com/siteverify",
+		},
+		{
+			"custom Turnstile endpoint",
+			"../../testdata/turnstile_success.json",
+			turnstileSolutionField,
+			func(form *forms.Form) {
+				form.Validation.Turnstile.Enabled = true
+				form.Validation.Turnstile.Endpoint = "http://127.0.0.1:8081/turnstile/siteverify"
+			},
+			"http://127.0.0.1:8081/turnstile/siteverify",
+		},
+		{
+			"custom reCaptcha endpoint",
+			"../../testdata/recaptcha_success.json",
+			reCaptchaSolutionField,
+			func(form *forms.Form) {
+				form.Validation.Recaptcha.Enabled = true
+				form.Validation.Recaptcha.Endpoint = "http://127.0.0.1:8081/recaptcha/siteverify"
+			},
+			"http://127.0.0.1:8081/recaptcha/siteverify",
+		},
+		{
+			"custom reCaptcha Enterprise endpoint",
+			"../../testdata/recaptcha_enterprise_success.json",
+			reCaptchaSolutionField,
+			func(form *forms.Form) {
+				form.Validation.Recaptcha.Enabled = true
+				form.Validation.Recaptcha.ProjectID = "123456789"
+				form.Validation.Recaptcha.EnterpriseEndpoint = "http://127.0.0.1:8081/v1/projects/%s/assessments"
+			},
+			"http://127.0.0.1:8081/v1/projects/123456789/assessments",
+		},
+		{
+			"custom Private Captcha endpoint",
+			"../../testdata/private_captcha_success.json",
+			privateCaptchaSolutionField,
+			func(form *forms.Form) {
+				form.Validation.PrivateCaptcha.Enabled = true
+				form.Validation.PrivateCaptcha.Host = "api.privatecaptcha.com"
+				form.Validation.PrivateCaptcha.Endpoint = "http://127.0.0.1:8081/verify"
+			},
+			"http://127.0.0.1:8081/verify",
+		},
+		{
+			"default hCaptcha endpoint",
+			"../../testdata/hcaptcha_success.json",
+			hCaptchaSolutionField,
+			func(form *forms.Form) {
+				form.Validation.Hcaptcha.Enabled = true
+			},
+			hCpatchaEndpoint,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := testServer(t, slog.LevelDebug, io.Discard)
+			if err != nil {
+				t.Fatalf("failed to create test server: %s", err)
+			}
+			form, err := forms.New("../../testdata", "testform_toml")
+			if err != nil {
+				t.Fatalf("failed to load form: %s", err)
+			}
+			tt.formFn(form)
+
+			var requested string
+			server.httpClient.Transport = testhelper.MockRoundTripper{Fn: func(req *http.Request) (*http.Response, error) {
+				requested = req.URL.String()
+				return testResponseFromFile(t, tt.response, http.StatusOK, false)(req)
+			}}
+			submission := map[string][]string{tt.field: {"captcha_token"}}
+			if _, err = server.validateCaptcha(t.Context(), form, submission, "127.0.0.1", cache.ItemParams{}); err != nil {
+				t.Errorf("captcha validation failed: %s", err)
+			}
+			if !strings.HasPrefix(requested, tt.wantPrefix) {
+				t.Errorf("expected captcha endpoint %q, got: %q", tt.wantPrefix, requested)
+			}
+		})
+	}
+}
+
+func TestServer_mockCaptcha(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		allow    bool
+		token    []string
+		succeeds bool
+		flagged  bool
+	}{
+		{"pass token succeeds", config.ModeDevelopment, false, []string{mockCaptchaPass}, true, false},
+		{"flag token is flagged", config.ModeDevelopment, false, []string{mockCaptchaFlag}, true, true},
+		{"other token fails", config.ModeDevelopment, false, []string{"invalid"}, false, false},
+		{"missing token fails", config.ModeDevelopment, false, nil, false, false},
+		{"production mode refuses mock captcha", config.ModeProduction, false, []string{mockCaptchaPass}, false, false},
+		{"unknown mode is treated as production", "staging", false, []string{mockCaptchaPass}, false, false},
+		{"production mode with explicit override", config.ModeProduction, true, []string{mockCaptchaPass}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := testServer(t, slog.LevelDebug, io.Discard)
+			if err != nil {
+				t.Fatalf("failed to create test server: %s", err)
+			}
+			server.config.Server.Mode = tt.mode
+			server.config.Server.AllowMockCaptcha = tt.allow
+			form, err := forms.New("../../testdata", "testform_toml")
+			if err != nil {
+				t.Fatalf("failed to load form: %s", err)
+			}
+			form.Validation.Mock.Enabled = true
+
+			submission := map[string][]string{}
+			if tt.token != nil {
+				submission[mockCaptchaSolutionField] = tt.token
+			}
+			flagged, err := server.validateCaptcha(t.Context(), form, submission, "127.0.0.1", cache.ItemParams{})
+			if tt.succeeds && err != nil {
+				t.Errorf("mock captcha validation failed: %s", err)
+			}
+			if !tt.succeeds && err == nil {
+				t.Error("expected mock captcha validation to fail")
+			}
+			if flagged != tt.flagged {
+				t.Errorf("expected flagged to be %t, got: %t", tt.flagged, flagged)
+			}
+		})
+	}
+}
+
+func TestServer_captchaProviders(t *testing.T) {
+	friendlyCaptcha := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Response string `json:"response"`
+			SiteKey  string `json:"sitekey"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("X-API-Key") != "frc-api-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"success":false,"error":{"error_code":"auth_invalid"}}`))
+			return
+		}
+		if req.Response != "frc-valid" || req.SiteKey != "frc-site-key" {
+			_, _ = w.Write([]byte(`{"success":false,"error":{"error_code":"response_invalid"}}`))
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"success":true,"data":{"event_id":"ev_1","challenge":{"timestamp":%q,"origin":"https://example.com"}}}`,
+			time.Now().UTC().Format(time.RFC3339))
+	}))
+	t.Cleanup(friendlyCaptcha.Close)
+	mCaptcha := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Token  string `json:"token"`
+			Key    string `json:"key"`
+			Secret string `json:"secret"`
+		}
+		if r.URL.Path != "/api/v1/pow/siteverify" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		valid := req.Token == "mcaptcha-valid" && req.Key == "mcaptcha-site-key" && req.Secret == "mcaptcha-secret"
+		_, _ = fmt.Fprintf(w, `{"valid":%t}`, valid)
+	}))
+	t.Cleanup(mCaptcha.Close)
+
+	enableFriendlyCaptcha := func(form *forms.Form) {
+		form.Validation.FriendlyCaptcha.Enabled = true
+		form.Validation.FriendlyCaptcha.APIKey = "frc-api-key"
+		form.Validation.FriendlyCaptcha.SiteKey = "frc-site-key"
+		form.Validation.FriendlyCaptcha.Endpoint = friendlyCaptcha.URL + "/api/v2/captcha/siteverify"
+	}
+	enableMCaptcha := func(form *forms.Form) {
+		form.Validation.MCaptcha.Enabled = true
+		form.Validation.MCaptcha.SiteKey = "mcaptcha-site-key"
+		form.Validation.MCaptcha.SecretKey = "mcaptcha-secret"
+		form.Validation.MCaptcha.Endpoint = mCaptcha.URL + "/api/v1/pow/siteverify"
+	}
+	enableMock := func(form *forms.Form) {
+		form.Validation.Mock.Enabled = true
+	}
+
+	tests := []struct {
+		name       string
+		formFn     func(*forms.Form)
+		submission map[string][]string
+		succeeds   bool
+		flagged    bool
+	}{
+		{
+			"Friendly Captcha valid solution",
+			enableFriendlyCaptcha,
+			map[string][]string{friendlyCaptchaSolutionField: {"frc-valid"}},
+			true,
+			false,
+		},
+		{
+			"Friendly Captcha invalid solution",
+			enableFriendlyCaptcha,
+			map[string][]string{friendlyCaptchaSolutionField: {"frc-invalid"}},
+			false,
+			false,
+		},
+		{
+			"Friendly Captcha invalid API key",
+			func(form *forms.Form) {
+				enableFriendlyCaptcha(form)
+				form.Validation.FriendlyCaptcha.APIKey = "wrong"
+			},
+			map[string][]string{friendlyCaptchaSolutionField: {"frc-valid"}},
+			false,
+			false,
+		},
+		{
+			"Friendly Captcha origin does not match form domains",
+			func(form *forms.Form) {
+				enableFriendlyCaptcha(form)
+				form.Domains = []string{"example.org"}
+			},
+			map[string][]string{friendlyCaptchaSolutionField: {"frc-valid"}},
+			false,
+			false,
+		},
+		{
+			"Friendly Captcha solution is missing",
+			enableFriendlyCaptcha,
+			map[string][]string{},
+			false,
+			false,
+		},
+		{
+			"mCaptcha valid token",
+			enableMCaptcha,
+			map[string][]string{mCaptchaSolutionField: {"mcaptcha-valid"}},
+			true,
+			false,
+		},
+		{
+			"mCaptcha endpoint derived from host",
+			func(form *forms.Form) {
+				enableMCaptcha(form)
+				form.Validation.MCaptcha.Endpoint = ""
+				form.Validation.MCaptcha.Host = "127.0.0.1:1"
+			},
+			map[string][]string{mCaptchaSolutionField: {"mcaptcha-valid"}},
+			false,
+			false,
+		},
+		{
+			"mCaptcha invalid token",
+			enableMCaptcha,
+			map[string][]string{mCaptchaSolutionField: {"mcaptcha-invalid"}},
+			false,
+			false,
+		},
+		{
+			"all policy requires all providers",
+			func(form *forms.Form) {
+				enableMCaptcha(form)
+				enableMock(form)
+			},
+			map[string][]string{
+				mCaptchaSolutionField:    {"mcaptcha-invalid"},
+				mockCaptchaSolutionField: {mockCaptchaPass},
+			},
+			false,
+			false,
+		},
+		{
+			"all policy succeeds if all providers succeed",
+			func(form *forms.Form) {
+				enableMCaptcha(form)
+				enableMock(form)
+			},
+			map[string][]string{
+				mCaptchaSolutionField:    {"mcaptcha-valid"},
+				mockCaptchaSolutionField: {mockCaptchaFlag},
+			},
+			true,
+			true,
+		},
+		{
+			"any policy succeeds with one provider",
+			func(form *forms.Form) {
+				form.Validation.CaptchaPolicy = CaptchaPolicyAny
+				enableMCaptcha(form)
+				enableFriendlyCaptcha(form)
+			},
+			map[string][]string{
+				mCaptchaSolutionField:        {"mcaptcha-invalid"},
+				friendlyCaptchaSolutionField: {"frc-valid"},
+			},
+			true,
+			false,
+		},
+		{
+			"any policy is flagged by a succeeding provider",
+			func(form *forms.Form) {
+				form.Validation.CaptchaPolicy = CaptchaPolicyAny
+				enableMCaptcha(form)
+				enableMock(form)
+			},
+			map[string][]string{
+				mCaptchaSolutionField:    {"mcaptcha-invalid"},
+				mockCaptchaSolutionField: {mockCaptchaFlag},
+			},
+			true,
+			true,
+		},
+		{
+			"any policy fails if no provider succeeds",
+			func(form *forms.Form) {
+				form.Validation.CaptchaPolicy = CaptchaPolicyAny
+				enableMCaptcha(form)
+				enableFriendlyCaptcha(form)
+			},
+			map[string][]string{
+				mCaptchaSolutionField:        {"mcaptcha-invalid"},
+				friendlyCaptchaSolutionField: {"frc-invalid"},
+			},
+			false,
+			false,
+		},
+		{
+			"unknown policy fails",
+			func(form *forms.Form) {
+				form.Validation.CaptchaPolicy = "most"
+				enableMock(form)
+			},
+			map[string][]string{mockCaptchaSolutionField: {mockCaptchaPass}},
+			false,
+			false,
+		},
+		{
+			"no provider enabled succeeds",
+			func(*forms.Form) {},
+			map[string][]string{},
+			true,
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := testServer(t, slog.LevelDebug, io.Discard)
+			if err != nil {
+				t.Fatalf("failed to create test server: %s", err)
+			}
+			server.config.Server.Mode = config.ModeDevelopment
+			form, err := forms.New("../../testdata", "testform_toml")
+			if err != nil {
+				t.Fatalf("failed to load form: %s", err)
+			}
+			tt.formFn(form)
+
+			flagged, err := server.validateCaptcha(t.Context(), form, tt.submission, "127.0.0.1", cache.ItemParams{})
+			if tt.succeeds && err != nil {
+				t.Errorf("captcha validation failed: %s", err)
+			}
+			if !tt.succeeds && err == nil {
+				t.Error("expected captcha validation to fail")
+			}
+			if flagged != tt.flagged {
+				t.Errorf("expected flagged to be %t, got: %t", tt.flagged, flagged)
+			}
+		})
+	}
+	t.Run("custom provider is used", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		form, err := forms.New("../../testdata", "testform_toml")
+		if err != nil {
+			t.Fatalf("failed to load form: %s", err)
+		}
+		provider := &testCaptchaProvider{err: errors.New("intentionally failing")}
+		server.captcha = append(server.captcha, provider)
+		if _, err = server.validateCaptcha(t.Context(), form, map[string][]string{}, "127.0.0.1", cache.ItemParams{}); err == nil {
+			t.Error("expected captcha validation to fail")
+		}
+		if !provider.called {
+			t.Error("expected custom captcha provider to be called")
+		}
+	})
+}
+
+func TestServer_scoreSubmission(t *testing.T) {
+	submission := map[string][]string{
+		"name":    {"John"},
+		"email":   {"john@mail.example"},
+		"message": {"Visit https://seo.example"},
+		"company": {"https://seo.example"},
+	}
+	t.Run("content fields are scored by default", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		form, err := forms.New("../../testdata", "testform_toml")
+		if err != nil {
+			t.Fatalf("failed to load form: %s", err)
+		}
+		form.Validation.Spam = forms.SpamConfig{LinkScore: 1, EmailField: "email", DomainMismatchScore: 2}
+		result, err := server.scoreSubmission(form, submission)
+		if err != nil {
+			t.Fatalf("failed to score submission: %s", err)
+		}
+		if result.Score != 3 {
+			t.Errorf("expected score to be 3, got: %.2f (hits: %+v)", result.Score, result.Hits)
+		}
+	})
+	t.Run("configured fields are scored", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		form, err := forms.New("../../testdata", "testform_toml")
+		if err != nil {
+			t.Fatalf("failed to load form: %s", err)
+		}
+		form.Validation.Spam = forms.SpamConfig{Fields: []string{"message", "company"}, LinkScore: 1}
+		result, err := server.scoreSubmission(form, submission)
+		if err != nil {
+			t.Fatalf("failed to score submission: %s", err)
+		}
+		if result.Score != 2 {
+			t.Errorf("expected score to be 2, got: %.2f (hits: %+v)", result.Score, result.Hits)
+		}
+	})
+	t.Run("invalid pattern fails", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		form, err := forms.New("../../testdata", "testform_toml")
+		if err != nil {
+			t.Fatalf("failed to load form: %s", err)
+		}
+		form.Validation.Spam = forms.SpamConfig{Patterns: []string{"("}, PatternScore: 1}
+		if _, err = server.scoreSubmission(form, submission); err == nil {
+			t.Error("expected spam scoring to fail")
+		}
+	})
+}
+
+func TestDeliveryOptions_applySpamAction(t *testing.T) {
+	form, err := forms.New("../../testdata", "testform_toml")
+	if err != nil {
+		t.Fatalf("failed to load form: %s", err)
+	}
+	form.Validation.SpamTag = "[SPAM]"
+	quarantine := []string{"quarantine@example.com"}
+
+	tests := []struct {
+		name             string
+		action           spam.Action
+		quarantine       []string
+		subject          string
+		recipients       []string
+		skipConfirmation bool
+	}{
+		{"none", spam.ActionNone, quarantine, "Contact", nil, false},
+		{"tag", spam.ActionTag, quarantine, "[SPAM] Contact", nil, false},
+		{"quarantine", spam.ActionQuarantine, quarantine, "[SPAM] Contact", quarantine, true},
+		{"quarantine without recipients is tagged", spam.ActionQuarantine, nil, "[SPAM] Contact", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form.Validation.Spam.QuarantineRecipients = tt.quarantine
+			opts := deliveryOptions{subjectTags: []string{}}
+			opts.applySpamAction(form, tt.action)
+			opts.applySpamAction(form, tt.action)
+			if subject := opts.subject("Contact"); subject != tt.subject {
+				t.Errorf("expected subject %q, got: %q", tt.subject, subject)
+			}
+			if !slices.Equal(opts.recipients, tt.recipients) {
+				t.Errorf("expected recipients %v, got: %v", tt.recipients, opts.recipients)
+			}
+			if opts.skipConfirmation != tt.skipConfirmation {
+				t.Errorf("expected skip confirmation to be %t, got: %t", tt.skipConfirmation, opts.skipConfirmation)
+			}
+		})
+	}
+}
+
+func TestServer_checkSpamd(t *testing.T) {
+	spamdAddr := testhelper.SpamdServer(t, "tcp", "127.0.0.1:0", func(message string) string {
+		score := "1.0"
+		switch {
+		case strings.Contains(message, "invalid"):
+			return "SPAMD/1.1 76 EX_PROTOCOL\r\n\r\n"
+		case strings.Contains(message, "reject"):
+			score = "12.0"
+		case strings.Contains(message, "quarantine"):
+			score = "6.0"
+		}
+		report := " Content analysis details:\n  (" + score + " points, 5.0 required)"
+		return fmt.Sprintf("SPAMD/1.1 0 EX_OK\r\nContent-length: %d\r\nSpam: False ; %s / 5.0\r\n\r\n%s",
+			len(report), score, report)
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	unavailableAddr := listener.Addr().String()
+	_ = listener.Close()
+
+	tests := []struct {
+		name        string
+		address     string
+		body        string
+		failOpen    bool
+		wantErr     error
+		wantScore   string
+		wantRcpt    string
+		wantSubject string
+	}{
+		{"clean message gets spam headers", spamdAddr, "hello", false, nil, "1.0", "support@example.com", "Contact"},
+		{"message above reject score is rejected", spamdAddr, "reject", false, ErrSubmissionRejectedAsSpam, "12.0", "", ""},
+		{"message above quarantine score is quarantined", spamdAddr, "quarantine", false, nil, "6.0", "quarantine@example.com", "[SPAM] Contact"},
+		{"unavailable spamd fails closed", unavailableAddr, "hello", false, ErrSpamCheckUnavailable, "", "", ""},
+		{"unavailable spamd fails open", unavailableAddr, "hello", true, nil, "", "support@example.com", "Contact"},
+		{"unconfigured spamd fails closed", "", "hello", false, ErrSpamCheckUnavailable, "", "", ""},
+		{"spamd protocol errors fail closed", spamdAddr, "invalid", true, ErrSpamCheckUnavailable, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := testServer(t, slog.LevelDebug, io.Discard)
+			if err != nil {
+				t.Fatalf("failed to create test server: %s", err)
+			}
+			if tt.address != "" {
+				server.spamd = spamd.New("tcp", tt.address, time.Second)
+			}
+			form, err := forms.New("../../testdata", "testform_toml")
+			if err != nil {
+				t.Fatalf("failed to load form: %s", err)
+			}
+			form.Content.Subject = "Contact"
+			form.Validation.SpamTag = "[SPAM]"
+			form.Validation.Spamd.Enabled = true
+			form.Validation.Spamd.RejectScore = 10
+			form.Validation.Spamd.QuarantineScore = 5
+			form.Validation.Spamd.FailOpen = tt.failOpen
+			form.Validation.Spam.QuarantineRecipients = []string{"quarantine@example.com"}
+
+			message := mail.NewMsg()
+			if err = message.To("support@example.com"); err != nil {
+				t.Fatalf("failed to set recipient: %s", err)
+			}
+			message.Subject(form.Content.Subject)
+			message.SetBodyString(mail.TypeTextPlain, tt.body)
+
+			opts := deliveryOptions{}
+			err = server.checkSpamd(t.Context(), server.log.Logger, form, message, &opts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("expected error to be %s, got: %s", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("spamd check failed: %s", err)
+			}
+			if score := message.GetGenHeader(headerSpamScore); tt.wantScore != "" && (len(score) == 0 || score[0] != tt.wantScore) {
+				t.Errorf("expected spam score header %q, got: %v", tt.wantScore, score)
+			}
+			if tt.failOpen && len(message.GetGenHeader(headerSpamStatus)) == 0 {
+				t.Error("expected spam status header to be set")
+			}
+			if rcpts := message.GetToString(); len(rcpts) != 1 || !strings.Contains(rcpts[0], tt.wantRcpt) {
+				t.Errorf("expected recipient %q, got: %v", tt.wantRcpt, rcpts)
+			}
+			if subject := message.GetGenHeader(mail.HeaderSubject); len(subject) == 0 || subject[0] != tt.wantSubject {
+				t.Errorf("expected subject %q, got: %v", tt.wantSubject, subject)
+			}
+		})
+	}
+}
+
+func TestServer_scanSubmission(t *testing.T) {
+	clamdAddr := testClamd(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	unavailableAddr := listener.Addr().String()
+	_ = listener.Close()
+
+	tests := []struct {
+		name       string
+		address    string
+		upload     string
+		attachment string
+		failOpen   bool
+		wantErr    error
+	}{
+		{"clean upload and attachment pass", clamdAddr, "clean file", "clean attachment", false, nil},
+		{"infected upload is rejected", clamdAddr, "prefix " + testhelper.EICAR, "", false, ErrSubmissionInfected},
+		{"infected attachment is rejected", clamdAddr, "", testhelper.EICAR, false, ErrSubmissionInfected},
+		{"unavailable clamd fails closed", unavailableAddr, "clean file", "", false, ErrVirusScanUnavailable},
+		{"unavailable clamd fails open", unavailableAddr, "clean file", "", true, nil},
+		{"clamd error does not fail open", clamdAddr, strings.Repeat("a", testClamdStreamLimit+1), "", true, ErrVirusScanUnavailable},
+		{"unconfigured clamd fails closed", "", "clean file", "", false, ErrVirusScanUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := testServer(t, slog.LevelDebug, io.Discard)
+			if err != nil {
+				t.Fatalf("failed to create test server: %s", err)
+			}
+			if tt.address != "" {
+				server.clamav = clamav.New("tcp", tt.address, time.Second)
+			}
+			form, err := forms.New("../../testdata", "testform_toml")
+			if err != nil {
+				t.Fatalf("failed to load form: %s", err)
+			}
+			form.Validation.ClamAV.Enabled = true
+			form.Validation.ClamAV.FailOpen = tt.failOpen
+
+			body := bytes.NewBuffer(nil)
+			writer := multipart.NewWriter(body)
+			if tt.upload != "" {
+				part, err := writer.CreateFormFile("upload", "upload.txt")
+				if err != nil {
+					t.Fatalf("failed to create form file: %s", err)
+				}
+				if _, err = io.WriteString(part, tt.upload); err != nil {
+					t.Fatalf("failed to write form file: %s", err)
+				}
+			}
+			if err = writer.Close(); err != nil {
+				t.Fatalf("failed to close writer: %s", err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/submit", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			if err = req.ParseMultipartForm(formMaxMemory); err != nil {
+				t.Fatalf("failed to parse multipart form: %s", err)
+			}
+
+			message := mail.NewMsg()
+			if tt.attachment != "" {
+				if err = message.AttachReader("attachment.txt", strings.NewReader(tt.attachment)); err != nil {
+					t.Fatalf("failed to attach file: %s", err)
+				}
+			}
+
+			err = server.scanSubmission(req, server.log.Logger, form, message)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("expected error to be %s, got: %s", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("virus scan failed: %s", err)
+			}
+		})
+	}
+}
+
+func TestServer_checkDuplicate(t *testing.T) {
+	form, err := forms.New("../../testdata", "testform_toml")
+	if err != nil {
+		t.Fatalf("failed to load form: %s", err)
+	}
+	form.Validation.Duplicates.Window = time.Minute
+	form.Validation.Duplicates.Action = DuplicateActionReject
+
+	t.Run("content fields are used by default", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		first := map[string][]string{"name": {"Jane"}, "message": {"hello"}, "other": {"a"}}
+		second := map[string][]string{"name": {"jane"}, "message": {"hello"}, "other": {"b"}}
+		if _, duplicate, err := server.checkDuplicate(form, first); err != nil || duplicate {
+			t.Fatalf("expected first submission not to be a duplicate, got: %t, %v", duplicate, err)
+		}
+		if _, duplicate, err := server.checkDuplicate(form, second); err != nil || !duplicate {
+			t.Errorf("expected second submission to be a duplicate, got: %t, %v", duplicate, err)
+		}
+	})
+	t.Run("empty submissions are not duplicates", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		for _, submission := range []map[string][]string{{}, {"name": {" "}, "message": {""}, "other": {"a"}}} {
+			if _, duplicate, err := server.checkDuplicate(form, submission); err != nil || duplicate {
+				t.Errorf("expected empty submission not to be a duplicate, got: %t, %v", duplicate, err)
+			}
+		}
+	})
+	t.Run("cache failure is returned", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.cache = &errCache{}
+		if _, _, err = server.checkDuplicate(form, map[string][]string{"message": {"hello"}}); err == nil {
+			t.Error("expected duplicate check to fail")
+		}
+	})
+}
+
+func TestServer_tokenLifetime(t *testing.T) {
+	server, err := testServer(t, slog.LevelDebug, io.Discard)
+	if err != nil {
+		t.Fatalf("failed to create test server: %s", err)
+	}
+	server.config.Forms.DefaultExpiration = time.Minute * 10
+	form := &forms.Form{}
+	if lifetime := server.tokenLifetime(form); lifetime != time.Minute*10 {
+		t.Errorf("expected default token lifetime to be %s, got %s", time.Minute*10, lifetime)
+	}
+	form.Validation.TokenLifetime = time.Hour
+	if lifetime := server.tokenLifetime(form); lifetime != time.Hour {
+		t.Errorf("expected form token lifetime to be %s, got %s", time.Hour, lifetime)
+	}
+}
+
+func TestServer_tokenBindingIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		mode       string
+		want       string
+		wantErr    bool
+	}{
+		{"no binding", "192.0.2.1:1234", "", "", false},
+		{"IPv4 address", "192.0.2.1:1234", TokenBindingAddress, "192.0.2.1", false},
+		{"IPv4 prefix", "192.0.2.1:1234", TokenBindingPrefix, "192.0.2.0/24", false},
+		{"IPv6 address", "[2001:db8::1]:1234", TokenBindingAddress, "2001:db8::1", false},
+		{"IPv6 prefix", "[2001:db8:0:1::1]:1234", "Prefix", "2001:db8:0:1::/64", false},
+		{"IPv4-mapped prefix", "[::ffff:192.0.2.1]:1234", TokenBindingPrefix, "192.0.2.0/24", false},
+		{"unknown binding", "192.0.2.1:1234", "invalid", "", true},
+		{"invalid client address", "invalid", TokenBindingAddress, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			got, err := tokenBindingIP(req, tt.mode)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected token binding to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("token binding failed: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected token binding %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestServer_dkimSigner(t *testing.T) {
+	dir := t.TempDir()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %s", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("failed to marshal Ed25519 key: %s", err)
+	}
+	keyFile := filepath.Join(dir, "dkim.key")
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write DKIM key: %s", err)
+	}
+	invalidKeyFile := filepath.Join(dir, "invalid.key")
+	if err = os.WriteFile(invalidKeyFile, []byte("invalid"), 0o600); err != nil {
+		t.Fatalf("failed to write DKIM key: %s", err)
+	}
+
+	tests := []struct {
+		name       string
+		server     []forms.DKIMConfig
+		form       forms.DKIMConfig
+		wantDomain string
+		wantErr    bool
+	}{
+		{"no DKIM configuration", nil, forms.DKIMConfig{}, "", false},
+		{"server configuration for other domain", []forms.DKIMConfig{{Domain: "example.org", Selector: "test", PrivateKey: keyFile}}, forms.DKIMConfig{}, "", false},
+		{"server configuration for sender domain", []forms.DKIMConfig{{Domain: "Example.com", Selector: "test", PrivateKey: keyFile}}, forms.DKIMConfig{}, "example.com", false},
+		{"form configuration with sender domain", nil, forms.DKIMConfig{Selector: "test", PrivateKey: keyFile}, "example.com", false},
+		{"form configuration takes precedence", []forms.DKIMConfig{{Domain: "example.com", Selector: "test", PrivateKey: invalidKeyFile}}, forms.DKIMConfig{Domain: "mail.example.com", Selector: "test", PrivateKey: keyFile}, "mail.example.com", false},
+		{"incomplete configuration", nil, forms.DKIMConfig{Selector: "test"}, "", true},
+		{"missing private key", nil, forms.DKIMConfig{Selector: "test", PrivateKey: filepath.Join(dir, "missing.key")}, "", true},
+		{"invalid private key", nil, forms.DKIMConfig{Selector: "test", PrivateKey: invalidKeyFile}, "", true},
+		{"signed headers without From", nil, forms.DKIMConfig{Selector: "test", PrivateKey: keyFile, Headers: []string{"Subject"}}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := testServer(t, slog.LevelDebug, io.Discard)
+			if err != nil {
+				t.Fatalf("failed to create test server: %s", err)
+			}
+			for _, entry := range tt.server {
+				server.config.DKIM = append(server.config.DKIM, entry)
+			}
+			form, err := forms.New("../../testdata", "testform_toml")
+			if err != nil {
+				t.Fatalf("failed to load form: %s", err)
+			}
+			form.DKIM = tt.form
+
+			signer, err := server.dkimSigner(form)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected DKIM configuration to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to configure DKIM signing: %s", err)
+			}
+			if tt.wantDomain == "" {
+				if signer != nil {
+					t.Error("expected no DKIM signer")
+				}
+				return
+			}
+			if signer == nil {
+				t.Fatal("expected a DKIM signer")
+			}
+			if signer.Domain != tt.wantDomain {
+				t.Errorf("expected signing domain %s, got %s", tt.wantDomain, signer.Domain)
+			}
+
+			message := mail.NewMsg()
+			if err = message.From(form.Sender); err != nil {
+				t.Fatalf("failed to set sender address: %s", err)
+			}
+			if err = message.To(form.Recipients...); err != nil {
+				t.Fatalf("failed to set recipient address: %s", err)
+			}
+			message.Subject("test")
+			message.SetBodyString(mail.TypeTextPlain, "this is a test message")
+			message.SetDKIM(signer)
+			buf := bytes.NewBuffer(nil)
+			if _, err = message.WriteTo(buf); err != nil {
+				t.Fatalf("failed to write message: %s", err)
+			}
+			if err = testhelper.VerifyDKIM(buf.Bytes(), publicKey); err != nil {
+				t.Errorf("failed to verify DKIM signature: %s", err)
+			}
+		})
+	}
+}
+
+func TestServer_applySMIME(t *testing.T) {
+	dir := t.TempDir()
+	signCert, signKey := testhelper.WriteCertificate(t, dir, "no-reply@example.com")
+	rcptCert, _ := testhelper.WriteCertificate(t, dir, "test@example.com")
+	otherCert, _ := testhelper.WriteCertificate(t, dir, "other@example.com")
+	testMessage := func(t *testing.T) *mail.Msg {
+		t.Helper()
+		message := mail.NewMsg()
+		if err := message.From("no-reply@example.com"); err != nil {
+			t.Fatalf("failed to set sender address: %s", err)
+		}
+		if err := message.To("test@example.com"); err != nil {
+			t.Fatalf("failed to set recipient address: %s", err)
+		}
+		message.Subject("Form submission with ümlauts")
+		message.SetGenHeader("X-Client-Country", "DE")
+		message.SetBodyString(mail.TypeTextPlain, "this is a secret message")
+		return message
+	}
+	tests := []struct {
+		name      string
+		config    forms.SMIMEConfig
+		signed    bool
+		encrypted bool
+		wantErr   error
+	}{
+		{"no S/MIME configuration", forms.SMIMEConfig{}, false, false, nil},
+		{"signing only", forms.SMIMEConfig{Certificate: signCert, PrivateKey: signKey}, true, false, nil},
+		{"encryption only", forms.SMIMEConfig{RecipientCertificates: []string{rcptCert, otherCert}}, false, true, nil},
+		{
+			"signing and encryption",
+			forms.SMIMEConfig{Certificate: signCert, PrivateKey: signKey, RecipientCertificates: []string{rcptCert}},
+			true, true, nil,
+		},
+		{"missing recipient certificate", forms.SMIMEConfig{RecipientCertificates: []string{otherCert}}, false, false, ErrRecipientCertificateMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := testServer(t, slog.LevelDebug, io.Discard)
+			if err != nil {
+				t.Fatalf("failed to create test server: %s", err)
+			}
+			form := &forms.Form{SMIME: tt.config}
+			message, err := server.applySMIME(form, testMessage(t))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("expected error to be %s, got: %s", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to apply S/MIME: %s", err)
+			}
+			buf := bytes.NewBuffer(nil)
+			if _, err = message.WriteTo(buf); err != nil {
+				t.Fatalf("failed to write message: %s", err)
+			}
+			raw := buf.String()
+			if !strings.Contains(raw, "Subject: =?UTF-8?q?Form_submission_with_=C3=BCmlauts?=") ||
+				!strings.Contains(raw, "X-Client-Country: DE") || !strings.Contains(raw, "To: <test@example.com>") {
+				t.Errorf("expected headers to be preserved, got: %s", raw)
+			}
+			if got := strings.Contains(raw, "application/pkcs7-mime; smime-type=enveloped-data"); got != tt.encrypted {
+				t.Errorf("expected encrypted to be %t, got %t", tt.encrypted, got)
+			}
+			if got := strings.Contains(raw, "this is a secret message"); got == tt.encrypted {
+				t.Errorf("expected plain text body to be present: %t, got %t", !tt.encrypted, got)
+			}
+			if !tt.encrypted {
+				if got := strings.Contains(raw, "multipart/signed"); got != tt.signed {
+					t.Errorf("expected signed to be %t, got %t", tt.signed, got)
+				}
+			}
+		})
+	}
+	t.Run("invalid signing certificate fails", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		form := &forms.Form{SMIME: forms.SMIMEConfig{Certificate: signCert, PrivateKey: filepath.Join(dir, "missing.key")}}
+		if _, err = server.applySMIME(form, testMessage(t)); err == nil {
+			t.Error("expected S/MIME signing to fail")
+		}
+	})
+	t.Run("missing recipient certificate file fails", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		form := &forms.Form{SMIME: forms.SMIMEConfig{RecipientCertificates: []string{filepath.Join(dir, "missing.crt")}}}
+		if _, err = server.applySMIME(form, testMessage(t)); err == nil {
+			t.Error("expected S/MIME encryption to fail")
+		}
+	})
+}
+
+func TestServer_applyPGP(t *testing.T) {
+	dir := t.TempDir()
+	rcptPublic, rcptPrivate := testhelper.WritePGPKey(t, dir, "test@example.com", "")
+	otherPublic, _ := testhelper.WritePGPKey(t, dir, "other@example.com", "")
+	signerPublic, signerPrivate := testhelper.WritePGPKey(t, dir, "no-reply@example.com", "secret")
+	keyring, err := pgp.LoadPublicKeys(signerPublic)
+	if err != nil {
+		t.Fatalf("failed to load signer public key: %s", err)
+	}
+	rcptKey, err := pgp.LoadPrivateKey(rcptPrivate, "")
+	if err != nil {
+		t.Fatalf("failed to load recipient private key: %s", err)
+	}
+	keyring = append(keyring, rcptKey)
+	testMessage := func(t *testing.T) *mail.Msg {
+		t.Helper()
+		message := mail.NewMsg()
+		if err := message.From("no-reply@example.com"); err != nil {
+			t.Fatalf("failed to set sender address: %s", err)
+		}
+		if err := message.To("test@example.com"); err != nil {
+			t.Fatalf("failed to set recipient address: %s", err)
+		}
+		message.Subject("Form submission with ümlauts")
+		message.SetGenHeader("X-Client-Country", "DE")
+		message.SetBodyString(mail.TypeTextPlain, "this is a secret message")
+		return message
+	}
+	tests := []struct {
+		name      string
+		config    forms.PGPConfig
+		smime     forms.SMIMEConfig
+		encrypted bool
+		signed    bool
+		wantErr   error
+	}{
+		{"no PGP configuration", forms.PGPConfig{}, forms.SMIMEConfig{}, false, false, nil},
+		{"encryption only", forms.PGPConfig{RecipientKeys: []string{rcptPublic, otherPublic}}, forms.SMIMEConfig{}, true, false, nil},
+		{"signing and encryption", forms.PGPConfig{RecipientKeys: []string{rcptPublic}, Sign: true}, forms.SMIMEConfig{}, true, true, nil},
+		{"missing recipient key", forms.PGPConfig{RecipientKeys: []string{otherPublic}}, forms.SMIMEConfig{}, false, false, ErrRecipientKeyMissing},
+		{
+			"S/MIME and PGP encryption",
+			forms.PGPConfig{RecipientKeys: []string{rcptPublic}},
+			forms.SMIMEConfig{RecipientCertificates: []string{rcptPublic}},
+			false, false, ErrMultipleEncryption,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := testServer(t, slog.LevelDebug, io.Discard)
+			if err != nil {
+				t.Fatalf("failed to create test server: %s", err)
+			}
+			server.config.PGP.SigningKey = signerPrivate
+			server.config.PGP.Passphrase = "secret"
+			form := &forms.Form{PGP: tt.config, SMIME: tt.smime}
+			message, err := server.applyPGP(form, testMessage(t))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("expected error to be %s, got: %s", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to apply PGP: %s", err)
+			}
+			buf := bytes.NewBuffer(nil)
+			if _, err = message.WriteTo(buf); err != nil {
+				t.Fatalf("failed to write message: %s", err)
+			}
+			raw := buf.String()
+			if !strings.Contains(raw, "Subject: =?UTF-8?q?Form_submission_with_=C3=BCmlauts?=") ||
+				!strings.Contains(raw, "X-Client-Country: DE") || !strings.Contains(raw, "To: <test@example.com>") {
+				t.Errorf("expected headers to be preserved, got: %s", raw)
+			}
+			if got := strings.Contains(raw, "this is a secret message"); got == tt.encrypted {
+				t.Errorf("expected plain text body to be present: %t, got %t", !tt.encrypted, got)
+			}
+			if !tt.encrypted {
+				return
+			}
+
+			parsed, err := netmail.ReadMessage(strings.NewReader(raw))
+			if err != nil {
+				t.Fatalf("failed to parse message: %s", err)
+			}
+			mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+			if err != nil {
+				t.Fatalf("failed to parse content type: %s", err)
+			}
+			if mediaType != "multipart/encrypted" || params["protocol"] != "application/pgp-encrypted" {
+				t.Fatalf("expected multipart/encrypted PGP/MIME message, got: %s", parsed.Header.Get("Content-Type"))
+			}
+			if count := strings.Count(raw, "--"+params["boundary"]+"--"); count != 1 {
+				t.Errorf("expected exactly one closing delimiter, got %d", count)
+			}
+			reader := multipart.NewReader(parsed.Body, params["boundary"])
+			var parts [][]byte
+			for {
+				part, err := reader.NextPart()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("failed to read message part: %s", err)
+				}
+				content, err := io.ReadAll(part)
+				if err != nil {
+					t.Fatalf("failed to read message part: %s", err)
+				}
+				parts = append(parts, content)
+			}
+			if len(parts) != 2 || !bytes.HasPrefix(parts[0], []byte("Version: 1")) {
+				t.Fatalf("expected version identification and encrypted part, got %d parts", len(parts))
+			}
+			block, err := armor.Decode(bytes.NewReader(parts[1]))
+			if err != nil {
+				t.Fatalf("failed to decode armored message: %s", err)
+			}
+			details, err := openpgp.ReadMessage(block.Body, keyring, nil, nil)
+			if err != nil {
+				t.Fatalf("failed to decrypt message: %s", err)
+			}
+			entity, err := io.ReadAll(details.UnverifiedBody)
+			if err != nil {
+				t.Fatalf("failed to read decrypted message: %s", err)
+			}
+			if !strings.Contains(string(entity), "Content-Type: text/plain") ||
+				!strings.Contains(string(entity), "this is a secret message") {
+				t.Errorf("expected decrypted MIME entity, got: %s", entity)
+			}
+			if details.IsSigned != tt.signed {
+				t.Errorf("expected signed to be %t, got %t", tt.signed, details.IsSigned)
+			}
+			if tt.signed && details.SignatureError != nil {
+				t.Errorf("signature verification failed: %s", details.SignatureError)
+			}
+		})
+	}
+	t.Run("missing signing key fails", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		form := &forms.Form{PGP: forms.PGPConfig{RecipientKeys: []string{rcptPublic}, Sign: true}}
+		if _, err = server.applyPGP(form, testMessage(t)); !errors.Is(err, ErrPGPSigningKeyMissing) {
+			t.Errorf("expected error to be %s, got: %s", ErrPGPSigningKeyMissing, err)
+		}
+	})
+	t.Run("missing recipient key file fails", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		form := &forms.Form{PGP: forms.PGPConfig{RecipientKeys: []string{filepath.Join(dir, "missing.asc")}}}
+		if _, err = server.applyPGP(form, testMessage(t)); err == nil {
+			t.Error("expected PGP encryption to fail")
+		}
+	})
+}
+
+func TestServer_smtpRelays(t *testing.T) {
+	dir := t.TempDir()
+	profiles := `[forms]
+path = "testdata"
+
+[[smtp]]
+name = "failover"
+[[smtp.relays]]
+host = "relay1.example.com"
+port = 587
+username = "smtp-user"
+password = "smtp-password"
+force_tls = true
+[[smtp.relays]]
+host = "relay2.example.com"
+
+[[smtp]]
+name = "Round-Robin"
+strategy = "round-robin"
+[[smtp.relays]]
+host = "relay1.example.com"
+[[smtp.relays]]
+host = "relay2.example.com"
+[[smtp.relays]]
+host = "relay3.example.com"
+
+[[smtp]]
+name = "random"
+strategy = "random"
+[[smtp.relays]]
+host = "relay1.example.com"
+
+[[smtp]]
+name = "empty"
+`
+	if err := os.WriteFile(filepath.Join(dir, "js-mailer.toml"), []byte(profiles), 0o600); err != nil {
+		t.Fatalf("failed to write config: %s", err)
+	}
+	conf, err := config.NewFromFile(dir, "js-mailer.toml")
+	if err != nil {
+		t.Fatalf("failed to load config: %s", err)
+	}
+	hosts := func(relays []smtpRelay) []string {
+		var hosts []string
+		for _, relay := range relays {
+			hosts = append(hosts, relay.Host)
+		}
+		return hosts
+	}
+
+	t.Run("form mail server is used without profile", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		form := &forms.Form{}
+		form.Server.Host = "smtp.example.com"
+		form.Server.Port = 465
+		relays, err := server.smtpRelays(form)
+		if err != nil {
+			t.Fatalf("failed to determine SMTP relays: %s", err)
+		}
+		if len(relays) != 1 || relays[0].address() != "smtp.example.com:465" {
+			t.Errorf("expected form mail server as only relay, got %v", relays)
+		}
+	})
+	t.Run("profile relays are tried in order", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.config.SMTP = conf.SMTP
+		form := &forms.Form{}
+		form.Server.Profile = "failover"
+		for range 2 {
+			relays, err := server.smtpRelays(form)
+			if err != nil {
+				t.Fatalf("failed to determine SMTP relays: %s", err)
+			}
+			if got := hosts(relays); !slices.Equal(got, []string{"relay1.example.com", "relay2.example.com"}) {
+				t.Errorf("unexpected relay order: %v", got)
+			}
+			want := smtpRelay{Host: "relay1.example.com", Port: 587, Username: "smtp-user", Password: "smtp-password", ForceTLS: true}
+			if relays[0] != want {
+				t.Errorf("expected first relay to be %+v, got %+v", want, relays[0])
+			}
+			if relays[1].Port != 25 {
+				t.Errorf("expected default port 25, got %d", relays[1].Port)
+			}
+		}
+	})
+	t.Run("round-robin profile rotates the relays", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.config.SMTP = conf.SMTP
+		form := &forms.Form{}
+		form.Server.Profile = "round-robin"
+		want := [][]string{
+			{"relay1.example.com", "relay2.example.com", "relay3.example.com"},
+			{"relay2.example.com", "relay3.example.com", "relay1.example.com"},
+			{"relay3.example.com", "relay1.example.com", "relay2.example.com"},
+			{"relay1.example.com", "relay2.example.com", "relay3.example.com"},
+		}
+		for _, order := range want {
+			relays, err := server.smtpRelays(form)
+			if err != nil {
+				t.Fatalf("failed to determine SMTP relays: %s", err)
+			}
+			if got := hosts(relays); !slices.Equal(got, order) {
+				t.Errorf("expected relay order %v, got %v", order, got)
+			}
+		}
+	})
+	t.Run("invalid configurations fail", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			profile string
+			wantErr error
+		}{
+			{"no profile and no mail server", "", ErrNoSMTPRelay},
+			{"unknown profile", "unknown", ErrUnknownSMTPProfile},
+			{"unknown strategy", "random", ErrUnknownSMTPStrategy},
+			{"profile without relays", "empty", ErrNoSMTPRelay},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				server, err := testServer(t, slog.LevelDebug, io.Discard)
+				if err != nil {
+					t.Fatalf("failed to create test server: %s", err)
+				}
+				server.config.SMTP = conf.SMTP
+				form := &forms.Form{}
+				form.Server.Profile = tt.profile
+				if _, err = server.smtpRelays(form); !errors.Is(err, tt.wantErr) {
+					t.Errorf("expected error to be %s, got: %s", tt.wantErr, err)
+				}
+			})
+		}
+	})
+	t.Run("forms with unknown profiles are not loaded", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.config.SMTP = conf.SMTP
+		server.config.Forms.Path = t.TempDir()
+		formConfig := `id = "profile_form"
+domains = ["example.com"]
+recipients = ["support@example.com"]
+secret = "test-secret-key"
+sender = "no-reply@example.com"
+
+[server]
+profile = "%s"
+`
+		for profile, wantErr := range map[string]error{"Failover": nil, "unknown": ErrUnknownSMTPProfile} {
+			content := fmt.Sprintf(formConfig, profile)
+			if err = os.WriteFile(filepath.Join(server.config.Forms.Path, "profile_form.toml"), []byte(content), 0o600); err != nil {
+				t.Fatalf("failed to write form config: %s", err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/token/profile_form", nil)
+			if _, err = server.loadForm(req, "profile_form"); !errors.Is(err, wantErr) {
+				t.Errorf("expected error to be %v for profile %s, got: %v", wantErr, profile, err)
+			}
+		}
+	})
+}
+
+func TestServer_deliver(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	down := smtpRelay{Host: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port}
+	if err = listener.Close(); err != nil {
+		t.Fatalf("failed to close listener: %s", err)
+	}
+	testMail := func(t *testing.T, rcpt string, optional bool) *outgoingMail {
+		t.Helper()
+		message := mail.NewMsg()
+		if err := message.From("no-reply@example.com"); err != nil {
+			t.Fatalf("failed to set sender address: %s", err)
+		}
+		if err := message.To(rcpt); err != nil {
+			t.Fatalf("failed to set recipient address: %s", err)
+		}
+		message.Subject("Form submission")
+		message.SetBodyString(mail.TypeTextPlain, "this is a test message")
+		return &outgoingMail{name: rcpt, message: message, optional: optional}
+	}
+	relay := func(server *testhelper.SMTPServer) smtpRelay {
+		return smtpRelay{Host: server.Host, Port: server.Port, Username: "smtp-user", Password: "smtp-password"}
+	}
+	recipients := func(server *testhelper.SMTPServer) []string {
+		var recipients []string
+		for _, message := range server.Messages() {
+			recipients = append(recipients, message.Recipients...)
+		}
+		return recipients
+	}
+
+	t.Run("failed relays are skipped", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		rejecting := testhelper.NewSMTPServer(t, testhelper.SMTPServerOptions{Reject: true})
+		accepting := testhelper.NewSMTPServer(t, testhelper.SMTPServerOptions{})
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		outgoing := testMail(t, "test@example.com", false)
+		server.deliver(req, []smtpRelay{down, relay(rejecting), relay(accepting)}, outgoing)
+		if err = outgoing.err(); err != nil {
+			t.Fatalf("failed to deliver message: %s", err)
+		}
+		if !strings.Contains(outgoing.response, "queued") {
+			t.Errorf("expected server response of the accepting relay, got: %s", outgoing.response)
+		}
+		if len(accepting.Messages()) != 1 {
+			t.Errorf("expected 1 delivered message, got %d", len(accepting.Messages()))
+		}
+	})
+	t.Run("delivery fails if all relays fail", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		rejecting := testhelper.NewSMTPServer(t, testhelper.SMTPServerOptions{Reject: true})
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		outgoing := testMail(t, "test@example.com", false)
+		server.deliver(req, []smtpRelay{down, relay(rejecting)}, outgoing)
+		err = outgoing.err()
+		if err == nil {
+			t.Fatal("expected delivery to fail")
+		}
+		for _, address := range []string{down.address(), relay(rejecting).address()} {
+			if !strings.Contains(err.Error(), address) {
+				t.Errorf("expected error to contain relay %s, got: %s", address, err)
+			}
+		}
+	})
+	t.Run("mails are sent over one pooled session", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		accepting := testhelper.NewSMTPServer(t, testhelper.SMTPServerOptions{})
+		for range 2 {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			first, second := testMail(t, "first@example.com", false), testMail(t, "second@example.com", false)
+			server.deliver(req, []smtpRelay{relay(accepting)}, first, second)
+			if err = errors.Join(first.err(), second.err()); err != nil {
+				t.Fatalf("failed to deliver messages: %s", err)
+			}
+		}
+		expected := []string{"first@example.com", "second@example.com", "first@example.com", "second@example.com"}
+		if !slices.Equal(recipients(accepting), expected) {
+			t.Errorf("expected messages to %v, got %v", expected, recipients(accepting))
+		}
+		if accepting.Connections() != 1 {
+			t.Errorf("expected 1 SMTP connection, got %d", accepting.Connections())
+		}
+	})
+	t.Run("expired sessions are closed by the reaper", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.smtpPool = newSMTPPool(2, time.Millisecond*20)
+		server.smtpPool.start()
+		defer server.smtpPool.close()
+		accepting := testhelper.NewSMTPServer(t, testhelper.SMTPServerOptions{})
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		outgoing := testMail(t, "test@example.com", false)
+		server.deliver(req, []smtpRelay{relay(accepting)}, outgoing)
+		if err = outgoing.err(); err != nil {
+			t.Fatalf("failed to deliver message: %s", err)
+		}
+
+		deadline := time.Now().Add(time.Second * 5)
+		for time.Now().Before(deadline) {
+			server.smtpPool.mu.Lock()
+			idle := len(server.smtpPool.idle)
+			server.smtpPool.mu.Unlock()
+			if idle == 0 {
+				return
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		t.Error("expected expired session to be removed from the pool")
+	})
+	t.Run("canceled requests abort the SMTP session", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		slow := testhelper.NewSMTPServer(t, testhelper.SMTPServerOptions{DataDelay: time.Second * 2})
+		ctx, cancel := context.WithTimeout(t.Context(), time.Millisecond*100)
+		defer cancel()
+		req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/", nil)
+		outgoing := testMail(t, "test@example.com", false)
+		start := time.Now()
+		server.deliver(req, []smtpRelay{relay(slow)}, outgoing)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("expected delivery to be aborted with the request, took %s", elapsed)
+		}
+		if err = outgoing.err(); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected error to be %s, got: %v", context.DeadlineExceeded, err)
+		}
+		server.smtpPool.mu.Lock()
+		idle := len(server.smtpPool.idle[relay(slow)])
+		server.smtpPool.mu.Unlock()
+		if idle != 0 {
+			t.Errorf("expected aborted session not to be pooled, got %d idle sessions", idle)
+		}
+	})
+	t.Run("sessions are not pooled if the pool is disabled", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.smtpPool = newSMTPPool(0, time.Minute)
+		accepting := testhelper.NewSMTPServer(t, testhelper.SMTPServerOptions{})
+		for range 2 {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			outgoing := testMail(t, "test@example.com", false)
+			server.deliver(req, []smtpRelay{relay(accepting)}, outgoing)
+			if err = outgoing.err(); err != nil {
+				t.Fatalf("failed to deliver message: %s", err)
+			}
+		}
+		if accepting.Connections() != 2 {
+			t.Errorf("expected 2 SMTP connections, got %d", accepting.Connections())
+		}
+	})
+	t.Run("failed optional mail doesn't stop the delivery", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		accepting := testhelper.NewSMTPServer(t, testhelper.SMTPServerOptions{
+			RejectRecipients: []string{"poster@example.com"},
+		})
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		confirmation, notification := testMail(t, "poster@example.com", true), testMail(t, "test@example.com", false)
+		server.deliver(req, []smtpRelay{relay(accepting)}, confirmation, notification)
+		if confirmation.err() == nil {
+			t.Error("expected confirmation mail to fail")
+		}
+		if err = notification.err(); err != nil {
+			t.Errorf("failed to deliver form mail: %s", err)
+		}
+		if !slices.Equal(recipients(accepting), []string{"test@example.com"}) {
+			t.Errorf("expected message to test@example.com, got %v", recipients(accepting))
+		}
+	})
+	t.Run("failed mandatory mail stops the delivery", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		accepting := testhelper.NewSMTPServer(t, testhelper.SMTPServerOptions{
+			RejectRecipients: []string{"poster@example.com"},
+		})
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		confirmation, notification := testMail(t, "poster@example.com", false), testMail(t, "test@example.com", false)
+		server.deliver(req, []smtpRelay{relay(accepting)}, confirmation, notification)
+		if confirmation.err() == nil {
+			t.Error("expected confirmation mail to fail")
+		}
+		if err = notification.err(); !errors.Is(err, ErrPrecedingMailFailed) {
+			t.Errorf("expected error to be %s, got: %s", ErrPrecedingMailFailed, err)
+		}
+		if len(accepting.Messages()) != 0 {
+			t.Errorf("expected no delivered messages, got %d", len(accepting.Messages()))
+		}
+	})
+	t.Run("failed mails are retried in order on the next relay", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		rejecting := testhelper.NewSMTPServer(t, testhelper.SMTPServerOptions{
+			RejectRecipients: []string{"poster@example.com"},
+		})
+		accepting := testhelper.NewSMTPServer(t, testhelper.SMTPServerOptions{})
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		confirmation, notification := testMail(t, "poster@example.com", false), testMail(t, "test@example.com", false)
+		server.deliver(req, []smtpRelay{relay(rejecting), relay(accepting)}, confirmation, notification)
+		if err = errors.Join(confirmation.err(), notification.err()); err != nil {
+			t.Fatalf("failed to deliver messages: %s", err)
+		}
+		expected := []string{"poster@example.com", "test@example.com"}
+		if !slices.Equal(recipients(accepting), expected) {
+			t.Errorf("expected messages to %v, got %v", expected, recipients(accepting))
+		}
+	})
+}
+
+func TestOrderMails(t *testing.T) {
+	tests := []struct {
+		name         string
+		order        string
+		confirmation string
+		first        string
+		optional     bool
+		wantErr      error
+	}{
+		{"defaults", "", "", "confirmation", false, nil},
+		{"confirmation first", DeliveryOrderConfirmationFirst, ConfirmationMandatory, "confirmation", false, nil},
+		{"notification first", DeliveryOrderNotificationFirst, ConfirmationMandatory, "notification", false, nil},
+		{"best-effort confirmation", DeliveryOrderNotificationFirst, ConfirmationBestEffort, "notification", true, nil},
+		{"unknown order", "random", ConfirmationMandatory, "", false, ErrUnknownDeliveryOrder},
+		{"unknown confirmation mode", DeliveryOrderConfirmationFirst, "sometimes", "", false, ErrUnknownConfirmationMode},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			form := &forms.Form{}
+			form.Delivery.Order = tc.order
+			form.Delivery.Confirmation = tc.confirmation
+			notification := &outgoingMail{name: "notification"}
+			confirmation := &outgoingMail{name: "confirmation"}
+			mails, err := orderMails(form, notification, confirmation)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error to be %v, got: %v", tc.wantErr, err)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+			if len(mails) != 2 || mails[0].name != tc.first {
+				t.Errorf("expected %s mail to be sent first, got %+v", tc.first, mails)
+			}
+			if confirmation.optional != tc.optional {
+				t.Errorf("expected confirmation optional to be %t, got %t", tc.optional, confirmation.optional)
+			}
+		})
+	}
+	t.Run("form mail without confirmation", func(t *testing.T) {
+		notification := &outgoingMail{name: "notification"}
+		mails, err := orderMails(&forms.Form{}, notification, nil)
+		if err != nil {
+			t.Fatalf("failed to order mails: %s", err)
+		}
+		if len(mails) != 1 || mails[0] != notification {
+			t.Errorf("expected only the form mail, got %+v", mails)
+		}
+	})
+}
+
+func TestServer_deliverLocal(t *testing.T) {
+	testMail := func(t *testing.T, rcpt string, optional bool) *outgoingMail {
+		t.Helper()
+		message := mail.NewMsg()
+		if err := message.From("no-reply@example.com"); err != nil {
+			t.Fatalf("failed to set sender address: %s", err)
+		}
+		if err := message.To(rcpt); err != nil {
+			t.Fatalf("failed to set recipient address: %s", err)
+		}
+		message.Subject("Form submission")
+		message.SetBodyString(mail.TypeTextPlain, "this is a test message")
+		return &outgoingMail{name: rcpt, message: message, optional: optional}
+	}
+	deliver := func(t *testing.T, kind, path string, mails ...*outgoingMail) {
+		t.Helper()
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		form := &forms.Form{}
+		form.Transport.Type = kind
+		form.Transport.Path = path
+		transport, err := localTransport(form)
+		if err != nil {
+			t.Fatalf("failed to configure transport: %s", err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		server.deliverLocal(req, form, transport, mails...)
+	}
+
+	t.Run("mails are written into the Maildir", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "Maildir")
+		first, second := testMail(t, "first@example.com", false), testMail(t, "second@example.com", false)
+		deliver(t, TransportMaildir, dir, first, second)
+		if err := errors.Join(first.err(), second.err()); err != nil {
+			t.Fatalf("failed to deliver messages: %s", err)
+		}
+		entries, err := os.ReadDir(filepath.Join(dir, "new"))
+		if err != nil {
+			t.Fatalf("failed to read Maildir: %s", err)
+		}
+		if len(entries) != 2 {
+			t.Errorf("expected 2 messages in the Maildir, got %d", len(entries))
+		}
+		if !strings.HasPrefix(first.response, filepath.Join(dir, "new")) {
+			t.Errorf("expected response to contain the message file, got: %s", first.response)
+		}
+	})
+	t.Run("mails are appended to the mbox file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "mbox")
+		outgoing := testMail(t, "test@example.com", false)
+		deliver(t, TransportMbox, path, outgoing)
+		if err := outgoing.err(); err != nil {
+			t.Fatalf("failed to deliver message: %s", err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read mbox file: %s", err)
+		}
+		if !strings.HasPrefix(string(content), "From no-reply@example.com ") ||
+			!strings.Contains(string(content), "this is a test message") {
+			t.Errorf("expected message in mbox file, got: %s", content)
+		}
+	})
+	t.Run("mails are piped to the sendmail binary", func(t *testing.T) {
+		dir := t.TempDir()
+		output := filepath.Join(dir, "output")
+		binary := filepath.Join(dir, "sendmail")
+		script := "#!/bin/sh\necho \"$@\" > " + output + ".args\ncat > " + output + "\n"
+		if err := os.WriteFile(binary, []byte(script), 0o700); err != nil {
+			t.Fatalf("failed to write sendmail script: %s", err)
+		}
+		outgoing := testMail(t, "test@example.com", false)
+		deliver(t, TransportSendmail, binary, outgoing)
+		if err := outgoing.err(); err != nil {
+			t.Fatalf("failed to deliver message: %s", err)
+		}
+		args, err := os.ReadFile(output + ".args")
+		if err != nil {
+			t.Fatalf("failed to read sendmail arguments: %s", err)
+		}
+		if strings.TrimSpace(string(args)) != "-oi -t" {
+			t.Errorf("expected sendmail to be called with -oi -t, got: %s", args)
+		}
+		content, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatalf("failed to read sendmail input: %s", err)
+		}
+		if !strings.Contains(string(content), "To: <test@example.com>") {
+			t.Errorf("expected message on sendmail input, got: %s", content)
+		}
+	})
+	t.Run("unresponsive LMTP server times out", func(t *testing.T) {
+		socket := filepath.Join(t.TempDir(), "lmtp.sock")
+		listener, err := net.Listen("unix", socket)
+		if err != nil {
+			t.Fatalf("failed to listen on unix socket: %s", err)
+		}
+		t.Cleanup(func() { _ = listener.Close() })
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { _ = conn.Close() })
+		}()
+
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		form := &forms.Form{}
+		form.Transport.Type = TransportLMTP
+		form.Transport.Path = socket
+		form.Server.Timeout = time.Millisecond * 100
+		transport, err := localTransport(form)
+		if err != nil {
+			t.Fatalf("failed to configure transport: %s", err)
+		}
+		outgoing := testMail(t, "test@example.com", false)
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		start := time.Now()
+		server.deliverLocal(req, form, transport, outgoing)
+		if outgoing.err() == nil {
+			t.Error("expected delivery to an unresponsive LMTP server to fail")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second*5 {
+			t.Errorf("expected delivery to time out after %s, took %s", form.Server.Timeout, elapsed)
+		}
+	})
+	t.Run("failed mandatory mail stops the delivery", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "missing", "mbox")
+		first, second := testMail(t, "first@example.com", false), testMail(t, "second@example.com", false)
+		deliver(t, TransportMbox, path, first, second)
+		if first.err() == nil {
+			t.Error("expected delivery to a missing directory to fail")
+		}
+		if err := second.err(); !errors.Is(err, ErrPrecedingMailFailed) {
+			t.Errorf("expected error to be %s, got: %s", ErrPrecedingMailFailed, err)
+		}
+	})
+}
+
+func TestLocalTransport(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		path    string
+		local   bool
+		wantErr error
+	}{
+		{"default is SMTP", "", "", false, nil},
+		{"SMTP", "SMTP", "", false, nil},
+		{"sendmail with default path", TransportSendmail, "", true, nil},
+		{"LMTP", TransportLMTP, "/run/dovecot/lmtp", true, nil},
+		{"Maildir", TransportMaildir, "/var/mail/forms", true, nil},
+		{"Maildir without path", TransportMaildir, "", false, ErrTransportPathMissing},
+		{"mbox without path", TransportMbox, "", false, ErrTransportPathMissing},
+		{"unknown transport", "pigeon", "/dev/null", false, ErrUnknownTransport},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			form := &forms.Form{}
+			form.Transport.Type = tc.kind
+			form.Transport.Path = tc.path
+			transport, err := localTransport(form)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error to be %v, got: %v", tc.wantErr, err)
+			}
+			if (transport != nil) != tc.local {
+				t.Errorf("expected local transport to be %t, got %t", tc.local, transport != nil)
+			}
+		})
+	}
+}
+
+func TestSmtpRelay_client(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, serverKey := testhelper.WriteTLSCertificate(t, dir, "127.0.0.1")
+	clientCert, clientKey := testhelper.WriteTLSCertificate(t, dir, "client.example.com")
+	otherCA, _ := testhelper.WriteTLSCertificate(t, dir, "other.example.com")
+	serverKeyPair, err := tls.LoadX509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatalf("failed to load server certificate: %s", err)
+	}
+	clientKeyPair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatalf("failed to load client certificate: %s", err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientKeyPair.Leaf)
+	tlsServer := testhelper.NewSMTPServer(t, testhelper.SMTPServerOptions{TLSConfig: &tls.Config{
+		Certificates: []tls.Certificate{serverKeyPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}})
+	plainServer := testhelper.NewSMTPServer(t, testhelper.SMTPServerOptions{})
+	testMessage := func(t *testing.T) *mail.Msg {
+		t.Helper()
+		message := mail.NewMsg()
+		if err := message.From("no-reply@example.com"); err != nil {
+			t.Fatalf("failed to set sender address: %s", err)
+		}
+		if err := message.To("test@example.com"); err != nil {
+			t.Fatalf("failed to set recipient address: %s", err)
+		}
+		message.Subject("Form submission")
+		message.SetBodyString(mail.TypeTextPlain, "this is a test message")
+		return message
+	}
+	tlsRelay := smtpRelay{
+		Host: tlsServer.Host, Port: tlsServer.Port, Username: "smtp-user", Password: "smtp-password",
+		SSL: true, CABundle: serverCert, ClientCertificate: clientCert, ClientKey: clientKey,
+		AuthMechanism: "login", HELO: "forms.example.com", Timeout: time.Second * 5,
+	}
+
+	t.Run("implicit TLS with client certificate and auth mechanism", func(t *testing.T) {
+		client, err := tlsRelay.client()
+		if err != nil {
+			t.Fatalf("failed to create mail client: %s", err)
+		}
+		if err = client.DialAndSend(testMessage(t)); err != nil {
+			t.Fatalf("failed to send message: %s", err)
+		}
+		messages := tlsServer.Messages()
+		if len(messages) != 1 {
+			t.Fatalf("expected 1 message, got %d", len(messages))
+		}
+		if messages[0].Helo != "forms.example.com" {
+			t.Errorf("expected HELO forms.example.com, got %s", messages[0].Helo)
+		}
+		if messages[0].Auth != "LOGIN" {
+			t.Errorf("expected LOGIN auth, got %s", messages[0].Auth)
+		}
+	})
+	t.Run("authentication is skipped", func(t *testing.T) {
+		relay := smtpRelay{Host: plainServer.Host, Port: plainServer.Port, SkipAuth: true, AuthMechanism: "plain"}
+		client, err := relay.client()
+		if err != nil {
+			t.Fatalf("failed to create mail client: %s", err)
+		}
+		if err = client.DialAndSend(testMessage(t)); err != nil {
+			t.Fatalf("failed to send message: %s", err)
+		}
+		messages := plainServer.Messages()
+		if len(messages) != 1 || messages[0].Auth != "" {
+			t.Errorf("expected 1 message without authentication, got %+v", messages)
+		}
+	})
+	t.Run("relay with unknown CA fails", func(t *testing.T) {
+		relay := tlsRelay
+		relay.CABundle = otherCA
+		client, err := relay.client()
+		if err != nil {
+			t.Fatalf("failed to create mail client: %s", err)
+		}
+		if err = client.DialAndSend(testMessage(t)); err == nil {
+			t.Error("expected certificate verification to fail")
+		}
+	})
+	t.Run("invalid configurations fail", func(t *testing.T) {
+		invalidBundle := filepath.Join(dir, "invalid.pem")
+		if err := os.WriteFile(invalidBundle, []byte("no certificates"), 0o600); err != nil {
+			t.Fatalf("failed to write CA bundle: %s", err)
+		}
+		tests := []struct {
+			name    string
+			modify  func(relay *smtpRelay)
+			wantErr error
+		}{
+			{"unknown auth mechanism", func(relay *smtpRelay) { relay.AuthMechanism = "kerberos" }, ErrUnknownAuthMechanism},
+			{"custom auth mechanism", func(relay *smtpRelay) { relay.AuthMechanism = "custom" }, ErrUnknownAuthMechanism},
+			{"CA bundle without certificates", func(relay *smtpRelay) { relay.CABundle = invalidBundle }, ErrInvalidCABundle},
+			{"missing CA bundle", func(relay *smtpRelay) { relay.CABundle = filepath.Join(dir, "missing.pem") }, os.ErrNotExist},
+			{"client certificate without key", func(relay *smtpRelay) { relay.ClientKey = "" }, os.ErrNotExist},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				relay := tlsRelay
+				tt.modify(&relay)
+				if _, err := relay.client(); !errors.Is(err, tt.wantErr) {
+					t.Errorf("expected error to be %s, got: %s", tt.wantErr, err)
+				}
+			})
+		}
+	})
+}
+
+func TestServer_dryRun(t *testing.T) {
+	submit := func(t *testing.T, server *Server) {
+		t.Helper()
+		form, err := forms.New("../../testdata", "testform_toml")
+		if err != nil {
+			t.Fatalf("failed to load form: %s", err)
+		}
+		values := url.Values{"email": {"poster@example.com"}, "message": {"this is a test message"}}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		confirmationResponse, messageResponse, err := server.sendMail(req, form, deliveryOptions{})
+		if err != nil {
+			t.Fatalf("failed to send mail: %s", err)
+		}
+		if confirmationResponse != "dry-run succeeded" || messageResponse != "dry-run succeeded" {
+			t.Errorf("expected dry-run responses, got %q and %q", confirmationResponse, messageResponse)
+		}
+	}
+
+	t.Run("mails are captured to disk and memory", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		dir := t.TempDir()
+		server.capture = newMailCapture(3, dir)
+		submit(t, server)
+		submit(t, server)
+
+		files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+		if err != nil {
+			t.Fatalf("failed to list captured mails: %s", err)
+		}
+		if len(files) != 4 {
+			t.Errorf("expected 4 captured mail files, got %d", len(files))
+		}
+		mails := server.capture.list()
+		if len(mails) != 3 {
+			t.Fatalf("expected 3 captured mails in memory, got %d", len(mails))
+		}
+		// The confirmation mail is sent first by default, so the newest mail is the form mail
+		if mails[0].Name != "form mail" || mails[1].Name != "confirmation mail" {
+			t.Errorf("expected newest mails to be the form and confirmation mail, got %s and %s", mails[0].Name,
+				mails[1].Name)
+		}
+		if mails[0].Subject != "Contact form submission" || mails[0].FormID != "contact-form" {
+			t.Errorf("expected form mail of contact-form, got %+v", mails[0])
+		}
+		if !slices.Equal(mails[1].Recipients, []string{"poster@example.com"}) {
+			t.Errorf("expected confirmation mail to poster@example.com, got %v", mails[1].Recipients)
+		}
+		content, err := os.ReadFile(mails[0].File)
+		if err != nil {
+			t.Fatalf("failed to read captured mail: %s", err)
+		}
+		if !bytes.Equal(content, mails[0].data) || !strings.Contains(string(content), "this is a test message") {
+			t.Errorf("expected captured mail file to contain the form mail, got: %s", content)
+		}
+	})
+	t.Run("captured mails are served by the admin endpoints", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.config.Admin.Token = "admin-token"
+		server.routes(t.Context())
+		submit(t, server)
+
+		request := func(path, token string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			recorder := httptest.NewRecorder()
+			server.mux.ServeHTTP(recorder, req)
+			return recorder
+		}
+		for _, token := range []string{"", "wrong-token"} {
+			if recorder := request("/admin/dry-run", token); recorder.Code != http.StatusUnauthorized {
+				t.Errorf("expected status code %d, got: %d", http.StatusUnauthorized, recorder.Code)
+			}
+		}
+
+		recorder := request("/admin/dry-run", "admin-token")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got: %d", http.StatusOK, recorder.Code)
+		}
+		body := new(struct {
+			Data struct {
+				Mails []capturedMail `json:"mails"`
+			} `json:"data"`
+		})
+		if err = json.NewDecoder(recorder.Body).Decode(body); err != nil {
+			t.Fatalf("failed to decode JSON response: %s", err)
+		}
+		if len(body.Data.Mails) != 2 {
+			t.Fatalf("expected 2 captured mails, got %d", len(body.Data.Mails))
+		}
+
+		recorder = request("/admin/dry-run/"+body.Data.Mails[0].ID, "admin-token")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got: %d", http.StatusOK, recorder.Code)
+		}
+		if recorder.Header().Get("Content-Type") != "message/rfc822" {
+			t.Errorf("expected message/rfc822 content type, got: %s", recorder.Header().Get("Content-Type"))
+		}
+		if !strings.Contains(recorder.Body.String(), "Subject: Contact form submission") {
+			t.Errorf("expected captured form mail, got: %s", recorder.Body.String())
+		}
+		if recorder = request("/admin/dry-run/unknown", "admin-token"); recorder.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got: %d", http.StatusNotFound, recorder.Code)
+		}
+	})
+	t.Run("admin endpoints are disabled without a token", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.routes(t.Context())
+		req := httptest.NewRequest(http.MethodGet, "/admin/dry-run", nil)
+		recorder := httptest.NewRecorder()
+		server.mux.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got: %d", http.StatusNotFound, recorder.Code)
+		}
+	})
+}
+
+func TestServer_devInbox(t *testing.T) {
+	testInbox := func(t *testing.T) *Server {
+		t.Helper()
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.config.DevInbox.Enabled = true
+		server.inbox = newMailCapture(10, "")
+		server.routes(t.Context())
+		return server
+	}
+	request := func(server *Server, method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		recorder := httptest.NewRecorder()
+		server.mux.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	t.Run("submissions are captured instead of delivered", func(t *testing.T) {
+		server := testInbox(t)
+		form, err := forms.New("../../testdata", "testform_toml")
+		if err != nil {
+			t.Fatalf("failed to load form: %s", err)
+		}
+		form.Server.DryRun = false
+		form.Server.Host = "127.0.0.1"
+		form.Server.Port = 1
+		values := url.Values{"email": {"poster@example.com"}, "message": {"this is a test message"}}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		_, messageResponse, err := server.sendMail(req, form, deliveryOptions{})
+		if err != nil {
+			t.Fatalf("failed to send mail: %s", err)
+		}
+		if messageResponse != "captured in development inbox" {
+			t.Errorf("expected development inbox response, got: %s", messageResponse)
+		}
+		if len(server.inbox.list()) != 2 {
+			t.Errorf("expected 2 mails in the development inbox, got %d", len(server.inbox.list()))
+		}
+		if len(server.capture.list()) != 0 {
+			t.Errorf("expected no dry-run captures, got %d", len(server.capture.list()))
+		}
+	})
+	t.Run("mails are served with bodies and attachments", func(t *testing.T) {
+		server := testInbox(t)
+		message := mail.NewMsg()
+		if err := message.From("no-reply@example.com"); err != nil {
+			t.Fatalf("failed to set sender address: %s", err)
+		}
+		if err := message.To("test@example.com"); err != nil {
+			t.Fatalf("failed to set recipient address: %s", err)
+		}
+		message.Subject("Form submission")
+		message.SetBodyString(mail.TypeTextPlain, "this is the text body")
+		message.AddAlternativeString(mail.TypeTextHTML, "<p>this is the HTML body</p>")
+		if err := message.AttachReader("submission.csv", strings.NewReader("name,value\nemail,test\n")); err != nil {
+			t.Fatalf("failed to attach file: %s", err)
+		}
+		captured, err := server.inbox.capture("contact-form", "", "form mail", message)
+		if err != nil {
+			t.Fatalf("failed to capture mail: %s", err)
+		}
+
+		recorder := request(server, http.MethodGet, "/dev/inbox/api/messages/"+captured.ID)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got: %d", http.StatusOK, recorder.Code)
+		}
+		body := new(struct {
+			Data struct {
+				Subject     string            `json:"subject"`
+				Text        string            `json:"text"`
+				HTML        string            `json:"html"`
+				Attachments []InboxAttachment `json:"attachments"`
+			} `json:"data"`
+		})
+		if err = json.NewDecoder(recorder.Body).Decode(body); err != nil {
+			t.Fatalf("failed to decode JSON response: %s", err)
+		}
+		if body.Data.Subject != "Form submission" {
+			t.Errorf("expected subject Form submission, got: %s", body.Data.Subject)
+		}
+		if body.Data.Text != "this is the text body" || body.Data.HTML != "<p>this is the HTML body</p>" {
+			t.Errorf("expected text and HTML body, got %q and %q", body.Data.Text, body.Data.HTML)
+		}
+		if len(body.Data.Attachments) != 1 || body.Data.Attachments[0].Filename != "submission.csv" {
+			t.Fatalf("expected submission.csv attachment, got %+v", body.Data.Attachments)
+		}
+
+		recorder = request(server, http.MethodGet, "/dev/inbox/api/messages/"+captured.ID+"/attachments/0")
+		if recorder.Code != http.StatusOK || recorder.Body.String() != "name,value\nemail,test\n" {
+			t.Errorf("expected attachment content, got %d: %s", recorder.Code, recorder.Body.String())
+		}
+		if !strings.Contains(recorder.Header().Get("Content-Disposition"), "submission.csv") {
+			t.Errorf("expected attachment filename, got: %s", recorder.Header().Get("Content-Disposition"))
+		}
+		recorder = request(server, http.MethodGet, "/dev/inbox/api/messages/"+captured.ID+"/html")
+		if recorder.Header().Get("Content-Security-Policy") != "sandbox" {
+			t.Errorf("expected sandboxed HTML body, got CSP: %s", recorder.Header().Get("Content-Security-Policy"))
+		}
+		recorder = request(server, http.MethodGet, "/dev/inbox/api/messages/"+captured.ID+"/raw")
+		if !strings.Contains(recorder.Body.String(), "Subject: Form submission") {
+			t.Errorf("expected raw mail, got: %s", recorder.Body.String())
+		}
+		for _, path := range []string{"/dev/inbox/api/messages/unknown", "/dev/inbox/api/messages/" + captured.ID +
+			"/attachments/1"} {
+			if recorder = request(server, http.MethodGet, path); recorder.Code != http.StatusNotFound {
+				t.Errorf("expected status code %d for %s, got: %d", http.StatusNotFound, path, recorder.Code)
+			}
+		}
+	})
+	t.Run("web UI is served and inbox can be cleared", func(t *testing.T) {
+		server := testInbox(t)
+		message := mail.NewMsg()
+		message.Subject("Form submission")
+		if _, err := server.inbox.capture("contact-form", "", "form mail", message); err != nil {
+			t.Fatalf("failed to capture mail: %s", err)
+		}
+		recorder := request(server, http.MethodGet, "/dev/inbox")
+		if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "Development inbox") {
+			t.Errorf("expected web UI, got %d: %s", recorder.Code, recorder.Body.String())
+		}
+		if recorder = request(server, http.MethodDelete, "/dev/inbox/api/messages"); recorder.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got: %d", http.StatusOK, recorder.Code)
+		}
+		if len(server.inbox.list()) != 0 {
+			t.Errorf("expected empty development inbox, got %d mails", len(server.inbox.list()))
+		}
+	})
+	t.Run("inbox is not served if disabled", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		server.routes(t.Context())
+		if recorder := request(server, http.MethodGet, "/dev/inbox"); recorder.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got: %d", http.StatusNotFound, recorder.Code)
+		}
+	})
+}
+
+func TestIsLoopback(t *testing.T) {
+	for address, want := range map[string]bool{
+		"127.0.0.1": true, "::1": true, "localhost": true, "127.0.0.2": true,
+		"0.0.0.0": false, "": false, "192.168.1.10": false, "example.com": false,
+	} {
+		if got := isLoopback(address); got != want {
+			t.Errorf("expected isLoopback(%q) to be %t, got %t", address, want, got)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	form := &forms.Form{Recipients: []string{"contact@example.com"}}
+	form.Routing.Rules = []forms.RoutingRule{
+		{Field: "department", Match: RoutingMatchExact, Value: "sales", Recipients: []string{"sales@example.com"}},
 		{
-			"reCaptcha failure response",
-			testResponseFromFile(t, "../../testdata/recaptcha_failure.json", http.StatusUnauthorized, false),
-			func(form *forms.Form) {
-				form.Validation.Recaptcha.Enabled = true
-			},
-			map[string][]string{
-				reCaptchaSolutionField: {"reCaptcha_token"},
-			},
-			false,
+			Field: "department", Match: RoutingMatchRegex, Value: `^support-(de|en)$`,
+			Recipients: []string{"support@example.com"}, CC: []string{"support-lead@example.com"},
 		},
 		{
-			"reCaptcha fails http request",
-			testResponseFromFile(t, "../../testdata/recaptcha_failure.json", http.StatusUnauthorized, true),
-			func(form *forms.Form) {
-				form.Validation.Recaptcha.Enabled = true
-			},
-			map[string][]string{
-				reCaptchaSolutionField: {"reCaptcha_token"},
-			},
-			false,
+			Field: "department", Match: RoutingMatchIn, Values: []string{"billing", "accounting"},
+			BCC: []string{"archive@example.com"},
 		},
+	}
+	form.Routing.Default = forms.RoutingTarget{Recipients: []string{"office@example.com"}}
+
+	tests := []struct {
+		name       string
+		department string
+		rule       int
+		recipients []string
+		cc         []string
+		bcc        []string
+	}{
+		{"exact match ignores case", " Sales ", 0, []string{"sales@example.com"}, nil, nil},
+		{"regex match", "support-de", 1, []string{"support@example.com"}, []string{"support-lead@example.com"}, nil},
 		{
-			"reCaptcha solution field is missing",
-			testResponseFromFile(t, "../../testdata/recaptcha_failure.json", http.StatusUnauthorized, false),
-			func(form *forms.Form) {
-				form.Validation.Recaptcha.Enabled = true
-			},
-			map[string][]string{},
-			false,
+			"in-list match falls back to form recipients", "accounting", 2,
+			[]string{"contact@example.com"},
+			nil,
+			[]string{"archive@example.com"},
 		},
+		{"no match uses the default", "marketing", -1, []string{"office@example.com"}, nil, nil},
+		{"submitted addresses are never used", "attacker@example.org", -1, []string{"office@example.com"}, nil, nil},
+		{"missing value uses the default", "", -1, []string{"office@example.com"}, nil, nil},
 	}
-
-	remoteAddr := "127.0.0.1"
-	t.Run("validate captcha against", func(t *testing.T) {
-		for _, tt := range tests {
-			t.Run(tt.name, func(t *testing.T) {
-				server, err := testServer(t, slog.LevelDebug, io.Discard)
-				if err != nil {
-					t.Fatalf("failed to create test server: %s", err)
-				}
-				form, err := forms.New("../../testdata", "testform_toml")
-				if err != nil {
-					t.Fatalf("failed to load form: %s", err)
-				}
-				tt.formFn(form)
-
-				server.httpClient.Transport = testhelper.MockRoundTripper{Fn: tt.captchaFn}
-				if err = server.validateCaptcha(t.Context(), form, tt.submission, remoteAddr); err != nil && tt.succeeds {
-					t.Errorf("captcha validation failed: %s", err)
-				}
-			})
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			values := url.Values{"department": {tc.department}}
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(values.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			target, rule, err := route(req, form)
+			if err != nil {
+				t.Fatalf("failed to route form mail: %s", err)
+			}
+			if rule != tc.rule {
+				t.Errorf("expected rule %d to match, got %d", tc.rule, rule)
+			}
+			if !slices.Equal(target.recipients, tc.recipients) || !slices.Equal(target.cc, tc.cc) ||
+				!slices.Equal(target.bcc, tc.bcc) {
+				t.Errorf("expected recipients %v, CC %v and BCC %v, got %v, %v and %v", tc.recipients, tc.cc, tc.bcc,
+					target.recipients, target.cc, target.bcc)
+			}
+		})
+	}
+	t.Run("form recipients are used without routing", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		target, rule, err := route(req, &forms.Form{Recipients: []string{"contact@example.com"}})
+		if err != nil {
+			t.Fatalf("failed to route form mail: %s", err)
+		}
+		if rule != -1 || !slices.Equal(target.recipients, []string{"contact@example.com"}) {
+			t.Errorf("expected form recipients, got rule %d and %v", rule, target.recipients)
+		}
+	})
+	t.Run("routed form mail carries CC and BCC", func(t *testing.T) {
+		server, err := testServer(t, slog.LevelDebug, io.Discard)
+		if err != nil {
+			t.Fatalf("failed to create test server: %s", err)
+		}
+		routed := *form
+		routed.Sender = "no-reply@example.com"
+		compose := func(department string, opts deliveryOptions) string {
+			values := url.Values{"department": {department}}
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(values.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			message, err := server.composeMessage(req, &routed, opts)
+			if err != nil {
+				t.Fatalf("failed to compose message: %s", err)
+			}
+			buf := bytes.NewBuffer(nil)
+			if _, err = message.WriteTo(buf); err != nil {
+				t.Fatalf("failed to render message: %s", err)
+			}
+			return buf.String()
+		}
+		rendered := compose("support-en", deliveryOptions{})
+		if !strings.Contains(rendered, "To: <support@example.com>") ||
+			!strings.Contains(rendered, "Cc: <support-lead@example.com>") {
+			t.Errorf("expected routed recipients and CC, got: %s", rendered)
+		}
+		rendered = compose("support-en", deliveryOptions{recipients: []string{"quarantine@example.com"}})
+		if !strings.Contains(rendered, "To: <quarantine@example.com>") || strings.Contains(rendered, "Cc:") {
+			t.Errorf("expected quarantined mail to the quarantine recipients only, got: %s", rendered)
+		}
+	})
+	t.Run("invalid rules fail", func(t *testing.T) {
+		tests := []struct {
+			rule    forms.RoutingRule
+			wantErr error
+		}{
+			{forms.RoutingRule{Field: "department", Match: RoutingMatchRegex, Value: "("}, ErrInvalidRoutingPattern},
+			{forms.RoutingRule{Field: "department", Match: "prefix", Value: "sales"}, ErrUnknownRoutingMatch},
+		}
+		for _, tc := range tests {
+			invalid := &forms.Form{Recipients: []string{"contact@example.com"}}
+			invalid.Routing.Rules = []forms.RoutingRule{tc.rule}
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			if _, _, err := route(req, invalid); !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected error to be %s, got: %s", tc.wantErr, err)
+			}
 		}
 	})
 }
@@ -1452,12 +5228,7 @@ func testServer(t *testing.T, level slog.Level, output io.Writer) (*Server, erro
 	testPortInc.Add(1)
 	conf.Server.BindPort = fmt.Sprintf("%d", testBasePort+testPortInc.Load())
 
-	server := New(conf, log, testVersion)
-	if server == nil {
-		t.Fatal("server is nil")
-	}
-
-	return server, nil
+	return New(conf, log, testVersion)
 }
 
 func testResponseFromFile(t *testing.T, filename string, code int, fails bool) func(req *http.Request) (*http.Response, error) {
@@ -1480,6 +5251,48 @@ func testResponseFromFile(t *testing.T, filename string, code int, fails bool) f
 	}
 }
 
+// testSolveAltcha brute-forces the solution of the given ALTCHA challenge like a client would
+func testSolveAltcha(t *testing.T, challenge *AltchaChallenge) int64 {
+	t.Helper()
+	for number := int64(0); number <= challenge.MaxNumber; number++ {
+		if altchaHash(challenge.Salt, number) == challenge.Challenge {
+			return number
+		}
+	}
+	t.Fatal("failed to solve ALTCHA challenge")
+	return 0
+}
+
+func testAltchaPayload(t *testing.T, challenge *AltchaChallenge, number int64, signature string) string {
+	t.Helper()
+	payload, err := json.Marshal(AltchaSolution{
+		Algorithm: challenge.Algorithm,
+		Challenge: challenge.Challenge,
+		Number:    number,
+		Salt:      challenge.Salt,
+		Signature: signature,
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal ALTCHA payload: %s", err)
+	}
+	return base64.StdEncoding.EncodeToString(payload)
+}
+
+func testJSONResponse(t *testing.T, code int, body any) func(req *http.Request) (*http.Response, error) {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("failed to marshal JSON response: %s", err)
+	}
+	return func(*http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: code,
+			Body:       io.NopCloser(bytes.NewReader(data)),
+			Header:     make(http.Header),
+		}, nil
+	}
+}
+
 func newMultipartRequest(t *testing.T, fields map[string][]string) *http.Request {
 	t.Helper()
 
@@ -1516,6 +5329,17 @@ func (w *failWriter) Write(p []byte) (n int, err error) {
 }
 
 // nilCache statisfies the cache.Cache interface and returns nil for any action
+// timingCache always returns the same form and parameters, regardless of their expiration
+type timingCache struct {
+	nilCache
+	form   *forms.Form
+	params cache.ItemParams
+}
+
+func (c *timingCache) Get(string) (*forms.Form, cache.ItemParams, error) {
+	return c.form, c.params, nil
+}
+
 type nilCache struct{}
 
 func (n *nilCache) Get(string) (*forms.Form, cache.ItemParams, error) {
@@ -1523,6 +5347,7 @@ func (n *nilCache) Get(string) (*forms.Form, cache.ItemParams, error) {
 }
 func (n *nilCache) Set(string, *forms.Form, cache.ItemParams) error { return nil }
 func (n *nilCache) Remove(string) error                             { return nil }
+func (n *nilCache) SetNX(string, time.Duration) (bool, error)       { return true, nil }
 func (n *nilCache) Start()                                          {}
 func (n *nilCache) Stop()                                           {}
 
@@ -1540,5 +5365,42 @@ func (e *errCache) Set(string, *forms.Form, cache.ItemParams) error {
 func (e *errCache) Remove(string) error {
 	return errors.New("method Remove() is intentionally failing")
 }
+
+func (e *errCache) SetNX(string, time.Duration) (bool, error) {
+	return false, errors.New("method SetNX() is intentionally failing")
+}
 func (e *errCache) Start() {}
 func (e *errCache) Stop()  {}
+
+// testCaptchaProvider is a CaptchaProvider that is always enabled and returns the given error
+type testCaptchaProvider struct {
+	called bool
+	err    error
+}
+
+func (p *testCaptchaProvider) Name() string { return "test captcha" }
+
+func (p *testCaptchaProvider) Enabled(*forms.Form) bool { return true }
+
+func (p *testCaptchaProvider) Verify(context.Context, *CaptchaRequest) (bool, error) {
+	p.called = true
+	return false, p.err
+}
+
+// testClamdStreamLimit is the stream size above which the clamd stand-in answers with an error
+const testClamdStreamLimit = 1024
+
+// testClamd starts a clamd stand-in that reports streams containing the EICAR test string as
+// infected and rejects streams larger than testClamdStreamLimit
+func testClamd(t *testing.T) string {
+	t.Helper()
+	return testhelper.ClamdServer(t, "tcp", "127.0.0.1:0", func(content []byte) string {
+		switch {
+		case len(content) > testClamdStreamLimit:
+			return "INSTREAM size limit exceeded. ERROR"
+		case bytes.Contains(content, []byte(testhelper.EICAR)):
+			return "stream: Eicar-Test-Signature FOUND"
+		}
+		return "stream: OK"
+	})
+}
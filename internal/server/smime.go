@@ -0,0 +1,145 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/wneessen/go-mail"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+	"github.com/wneessen/js-mailer/internal/smime"
+)
+
+// ErrRecipientCertificateMissing is returned if the form mail should be encrypted, but there is no
+// S/MIME certificate for one of its recipients
+var ErrRecipientCertificateMissing = errors.New("no S/MIME certificate for recipient")
+
+// addressHeaders are the headers that are copied as addresses to the encrypted message
+var addressHeaders = []mail.AddrHeader{mail.HeaderFrom, mail.HeaderTo, mail.HeaderCc, mail.HeaderBcc, mail.HeaderReplyTo}
+
+// applySMIME signs and encrypts the form mail according to the S/MIME configuration of the form.
+// If the form mail is encrypted, a new message with the headers of the form mail and the encrypted
+// content is returned.
+func (s *Server) applySMIME(form *forms.Form, message *mail.Msg) (*mail.Msg, error) {
+	config := form.SMIME
+	if config.Certificate != "" || config.PrivateKey != "" {
+		keyPair, err := tls.LoadX509KeyPair(config.Certificate, config.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load S/MIME certificate: %w", err)
+		}
+		if err = message.SignWithTLSCertificate(&keyPair); err != nil {
+			return nil, fmt.Errorf("failed to sign message with S/MIME: %w", err)
+		}
+	}
+	if len(config.RecipientCertificates) == 0 {
+		return message, nil
+	}
+
+	certs, err := smime.LoadCertificates(config.RecipientCertificates...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load S/MIME recipient certificates: %w", err)
+	}
+	for _, header := range []mail.AddrHeader{mail.HeaderTo, mail.HeaderCc, mail.HeaderBcc} {
+		for _, recipient := range message.GetAddrHeader(header) {
+			if _, ok := smime.CertificateFor(certs, recipient.Address); !ok {
+				return nil, fmt.Errorf("%w: %s", ErrRecipientCertificateMissing, recipient.Address)
+			}
+		}
+	}
+	return encryptSMIME(message, certs)
+}
+
+// encryptSMIME encrypts the content of the message and returns a new message that carries the
+// headers of the message and the encrypted content as application/pkcs7-mime body.
+func encryptSMIME(message *mail.Msg, certs []*x509.Certificate) (*mail.Msg, error) {
+	encrypted, entity, err := splitEntity(message)
+	if err != nil {
+		return nil, err
+	}
+	der, err := smime.Encrypt(entity, certs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt message with S/MIME: %w", err)
+	}
+	encrypted.SetBodyString(smime.ContentType, string(der), mail.WithPartEncoding(mail.EncodingB64))
+	return encrypted, nil
+}
+
+// splitEntity writes the message and splits it into a new message without body that carries the
+// headers of the message and the MIME entity (content headers and body) of the message that is
+// to be encrypted.
+func splitEntity(message *mail.Msg) (*mail.Msg, []byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if _, err := message.WriteTo(buf); err != nil {
+		return nil, nil, fmt.Errorf("failed to write message: %w", err)
+	}
+	headers, body, err := splitMessage(buf.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	envelope := mail.NewMsg()
+	for _, header := range addressHeaders {
+		if addresses := message.GetAddrHeader(header); len(addresses) > 0 {
+			envelope.SetAddrHeaderFromMailAddress(header, addresses...)
+		}
+	}
+	entity := bytes.NewBuffer(nil)
+	values := make(map[string][]string)
+	var names []string
+	for _, header := range headers {
+		if strings.HasPrefix(strings.ToLower(header[0]), "content-") {
+			entity.WriteString(header[0] + ": " + header[1] + "\r\n")
+			continue
+		}
+		if isAddressHeader(header[0]) {
+			continue
+		}
+		if _, ok := values[header[0]]; !ok {
+			names = append(names, header[0])
+		}
+		values[header[0]] = append(values[header[0]], header[1])
+	}
+	for _, name := range names {
+		envelope.SetGenHeader(mail.Header(name), values[name]...)
+	}
+	entity.WriteString("\r\n")
+	entity.Write(body)
+	return envelope, entity.Bytes(), nil
+}
+
+// splitMessage splits the raw message into its unfolded header fields (name and value) and body
+func splitMessage(raw []byte) ([][2]string, []byte, error) {
+	rawHeaders, body, found := bytes.Cut(raw, []byte("\r\n\r\n"))
+	if !found {
+		return nil, nil, errors.New("message has no header and body separator")
+	}
+	var headers [][2]string
+	for _, line := range strings.Split(string(rawHeaders), "\r\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(headers) > 0 {
+			headers[len(headers)-1][1] += line
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid header line: %q", line)
+		}
+		headers = append(headers, [2]string{name, strings.TrimSpace(value)})
+	}
+	return headers, body, nil
+}
+
+// isAddressHeader returns true if the header is one of the address headers
+func isAddressHeader(name string) bool {
+	return slices.ContainsFunc(addressHeaders, func(header mail.AddrHeader) bool {
+		return strings.EqualFold(string(header), name)
+	})
+}
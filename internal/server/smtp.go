@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/wneessen/go-mail"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+)
+
+const (
+	// SMTPStrategyFailover tries the relays of an SMTP profile in the configured order
+	SMTPStrategyFailover = "failover"
+
+	// SMTPStrategyRoundRobin starts with the next relay of an SMTP profile for every mail and tries
+	// the remaining relays in order
+	SMTPStrategyRoundRobin = "round-robin"
+)
+
+var (
+	// ErrUnknownSMTPProfile is returned if a form references an SMTP profile that is not configured
+	ErrUnknownSMTPProfile = errors.New("unknown SMTP profile")
+
+	// ErrUnknownSMTPStrategy is returned if an SMTP profile has an unsupported relay strategy
+	ErrUnknownSMTPStrategy = errors.New("unknown SMTP relay strategy")
+
+	// ErrNoSMTPRelay is returned if neither an SMTP profile nor a mail server is configured for a form
+	ErrNoSMTPRelay = errors.New("no SMTP relay configured")
+
+	// ErrUnknownAuthMechanism is returned if a relay has an unsupported SMTP auth mechanism
+	ErrUnknownAuthMechanism = errors.New("unknown SMTP auth mechanism")
+
+	// ErrInvalidCABundle is returned if the CA bundle of a relay contains no certificate
+	ErrInvalidCABundle = errors.New("no certificates found in CA bundle")
+)
+
+// smtpRelay is a mail server that the mails of a form are delivered to
+type smtpRelay struct {
+	Host              string
+	Port              int
+	Username          string
+	Password          string
+	ForceTLS          bool
+	SSL               bool
+	CABundle          string
+	ClientCertificate string
+	ClientKey         string
+	AuthMechanism     string
+	SkipAuth          bool
+	HELO              string
+	Timeout           time.Duration
+}
+
+// address returns the host and port of the relay
+func (r smtpRelay) address() string {
+	return net.JoinHostPort(r.Host, strconv.Itoa(r.Port))
+}
+
+// client returns a new mail client for the relay
+func (r smtpRelay) client() (*mail.Client, error) {
+	authType := mail.SMTPAuthAutoDiscover
+	if r.AuthMechanism != "" {
+		if err := authType.UnmarshalString(r.AuthMechanism); err != nil || authType == mail.SMTPAuthCustom {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownAuthMechanism, r.AuthMechanism)
+		}
+	}
+	if r.SkipAuth {
+		authType = mail.SMTPAuthNoAuth
+	}
+	tlsConfig, err := r.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []mail.Option{
+		mail.WithPort(r.Port), mail.WithSMTPAuth(authType), mail.WithTLSPolicy(mail.DefaultTLSPolicy),
+		mail.WithTLSConfig(tlsConfig),
+	}
+	if authType != mail.SMTPAuthNoAuth {
+		opts = append(opts, mail.WithUsername(r.Username), mail.WithPassword(r.Password))
+	}
+	if r.SSL {
+		opts = append(opts, mail.WithSSL())
+	}
+	if r.HELO != "" {
+		opts = append(opts, mail.WithHELO(r.HELO))
+	}
+	if r.Timeout > 0 {
+		opts = append(opts, mail.WithTimeout(r.Timeout))
+	}
+	client, err := mail.NewClient(r.Host, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mail client: %w", err)
+	}
+	if !r.ForceTLS {
+		client.SetTLSPolicy(mail.TLSOpportunistic)
+	}
+	return client, nil
+}
+
+// tlsConfig returns the TLS configuration for STARTTLS and implicit TLS connections to the relay
+func (r smtpRelay) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{ServerName: r.Host, MinVersion: tls.VersionTLS12}
+	if r.CABundle != "" {
+		bundle, err := os.ReadFile(r.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCABundle, r.CABundle)
+		}
+		config.RootCAs = pool
+	}
+	if r.ClientCertificate != "" || r.ClientKey != "" {
+		keyPair, err := tls.LoadX509KeyPair(r.ClientCertificate, r.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{keyPair}
+	}
+	return config, nil
+}
+
+// checkSMTPProfile returns an error if the form references an SMTP profile that is not configured
+func (s *Server) checkSMTPProfile(form *forms.Form) error {
+	if form.Server.Profile == "" {
+		return nil
+	}
+	for _, profile := range s.config.SMTP {
+		if strings.EqualFold(profile.Name, form.Server.Profile) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownSMTPProfile, form.Server.Profile)
+}
+
+// smtpRelays returns the SMTP relays for the mails of the form in the order they are tried. Forms
+// that reference an SMTP profile use the relays of the profile, all other forms their own mail server.
+func (s *Server) smtpRelays(form *forms.Form) ([]smtpRelay, error) {
+	if form.Server.Profile == "" {
+		if form.Server.Host == "" {
+			return nil, ErrNoSMTPRelay
+		}
+		return []smtpRelay{{
+			Host:              form.Server.Host,
+			Port:              form.Server.Port,
+			Username:          form.Server.Username,
+			Password:          form.Server.Password,
+			ForceTLS:          form.Server.ForceTLS,
+			SSL:               form.Server.SSL,
+			CABundle:          form.Server.CABundle,
+			ClientCertificate: form.Server.ClientCertificate,
+			ClientKey:         form.Server.ClientKey,
+			AuthMechanism:     form.Server.AuthMechanism,
+			SkipAuth:          form.Server.SkipAuth,
+			HELO:              form.Server.HELO,
+			Timeout:           form.Server.Timeout,
+		}}, nil
+	}
+
+	for _, profile := range s.config.SMTP {
+		if !strings.EqualFold(profile.Name, form.Server.Profile) {
+			continue
+		}
+		if len(profile.Relays) == 0 {
+			return nil, fmt.Errorf("%w in SMTP profile %s", ErrNoSMTPRelay, profile.Name)
+		}
+		relays := make([]smtpRelay, 0, len(profile.Relays))
+		for _, relay := range profile.Relays {
+			relays = append(relays, smtpRelay(relay))
+		}
+
+		switch strings.ToLower(profile.Strategy) {
+		case "", SMTPStrategyFailover:
+			return relays, nil
+		case SMTPStrategyRoundRobin:
+			value, _ := s.relayCounters.LoadOrStore(strings.ToLower(profile.Name), new(atomic.Uint64))
+			start := int((value.(*atomic.Uint64).Add(1) - 1) % uint64(len(relays)))
+			return slices.Concat(relays[start:], relays[:start]), nil
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnknownSMTPStrategy, profile.Strategy)
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownSMTPProfile, form.Server.Profile)
+}
@@ -0,0 +1,210 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/wneessen/go-mail"
+	"github.com/wneessen/go-mail/smtp"
+)
+
+// smtpSession is an established and authenticated SMTP session with a relay
+type smtpSession struct {
+	client    *mail.Client
+	conn      *smtp.Client
+	idleSince time.Time
+	abandoned bool
+}
+
+// send sends the message over the session
+func (s *smtpSession) send(ctx context.Context, message *mail.Msg) error {
+	return s.run(ctx, func() error {
+		return s.client.SendWithSMTPClient(s.conn, message)
+	})
+}
+
+// reset resets the session with RSET
+func (s *smtpSession) reset(ctx context.Context) error {
+	return s.run(ctx, func() error {
+		return s.client.ResetWithSMTPClient(s.conn)
+	})
+}
+
+// run runs the SMTP commands of fn. If the context is done before the commands complete, the
+// session is abandoned and closed once the commands returned or timed out.
+func (s *smtpSession) run(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		s.abandoned = true
+		go func() {
+			<-done
+			_ = s.client.CloseWithSMTPClient(s.conn)
+		}()
+		return fmt.Errorf("SMTP session aborted: %w", ctx.Err())
+	}
+}
+
+// close ends the session. Abandoned sessions are closed by the goroutine that runs their commands.
+func (s *smtpSession) close() {
+	if s.abandoned {
+		return
+	}
+	_ = s.client.CloseWithSMTPClient(s.conn)
+}
+
+// smtpPool keeps idle SMTP sessions per relay, so that subsequent submissions don't have to go
+// through the TCP, TLS and AUTH handshakes again. Idle sessions are verified with RSET before they
+// are reused. Expired sessions are closed by the reaper goroutine.
+type smtpPool struct {
+	mu          sync.Mutex
+	idle        map[smtpRelay][]*smtpSession
+	maxIdle     int
+	idleTimeout time.Duration
+	stop        chan struct{}
+}
+
+// newSMTPPool returns a new SMTP session pool that keeps up to maxIdle sessions per relay for the
+// idle timeout. A pool with maxIdle of 0 keeps no sessions.
+func newSMTPPool(maxIdle int, idleTimeout time.Duration) *smtpPool {
+	return &smtpPool{
+		idle:        make(map[smtpRelay][]*smtpSession),
+		maxIdle:     maxIdle,
+		idleTimeout: idleTimeout,
+		stop:        make(chan struct{}),
+	}
+}
+
+// start starts the reaper goroutine that closes expired idle sessions every idle timeout
+func (p *smtpPool) start() {
+	if p.maxIdle <= 0 || p.idleTimeout <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(p.idleTimeout)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.sweep()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// get returns an idle session with the relay or establishes a new one
+func (p *smtpPool) get(ctx context.Context, relay smtpRelay) (*smtpSession, error) {
+	for {
+		session := p.takeIdle(relay)
+		if session == nil {
+			break
+		}
+		if err := session.reset(ctx); err == nil {
+			return session, nil
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("reset failed: %w", ctx.Err())
+		}
+		session.close()
+	}
+
+	client, err := relay.client()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.DialToSMTPClientWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("dial failed: %w", err)
+	}
+	return &smtpSession{client: client, conn: conn}, nil
+}
+
+// put returns the session to the pool. If the pool for the relay is full or the session was
+// abandoned, the session is closed.
+func (p *smtpPool) put(relay smtpRelay, session *smtpSession) {
+	p.mu.Lock()
+	if session.abandoned || len(p.idle[relay]) >= p.maxIdle {
+		p.mu.Unlock()
+		session.close()
+		return
+	}
+	session.idleSince = time.Now()
+	p.idle[relay] = append(p.idle[relay], session)
+	p.mu.Unlock()
+}
+
+// takeIdle removes the most recently used idle session with the relay from the pool and returns
+// it. Expired sessions are closed. If there is no idle session, nil is returned.
+func (p *smtpPool) takeIdle(relay smtpRelay) *smtpSession {
+	p.mu.Lock()
+	var expired []*smtpSession
+	var session *smtpSession
+	sessions := p.idle[relay]
+	for len(sessions) > 0 {
+		session, sessions = sessions[len(sessions)-1], sessions[:len(sessions)-1]
+		if time.Since(session.idleSince) < p.idleTimeout {
+			break
+		}
+		expired = append(expired, session)
+		session = nil
+	}
+	p.idle[relay] = sessions
+	p.mu.Unlock()
+
+	for _, session := range expired {
+		session.close()
+	}
+	return session
+}
+
+// sweep removes the expired idle sessions of all relays from the pool and closes them
+func (p *smtpPool) sweep() {
+	p.mu.Lock()
+	var expired []*smtpSession
+	for relay, sessions := range p.idle {
+		active := sessions[:0]
+		for _, session := range sessions {
+			if time.Since(session.idleSince) < p.idleTimeout {
+				active = append(active, session)
+				continue
+			}
+			expired = append(expired, session)
+		}
+		if len(active) == 0 {
+			delete(p.idle, relay)
+			continue
+		}
+		p.idle[relay] = active
+	}
+	p.mu.Unlock()
+
+	for _, session := range expired {
+		session.close()
+	}
+}
+
+// close stops the reaper goroutine and closes all idle sessions of the pool
+func (p *smtpPool) close() {
+	close(p.stop)
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = make(map[smtpRelay][]*smtpSession)
+	p.mu.Unlock()
+
+	for _, sessions := range idle {
+		for _, session := range sessions {
+			session.close()
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/wneessen/go-mail"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+	"github.com/wneessen/js-mailer/internal/logger"
+	"github.com/wneessen/js-mailer/internal/spam"
+	"github.com/wneessen/js-mailer/internal/spamd"
+)
+
+const (
+	headerSpamFlag   = "X-Spam-Flag"
+	headerSpamScore  = "X-Spam-Score"
+	headerSpamStatus = "X-Spam-Status"
+	headerSpamReport = "X-Spam-Report"
+)
+
+var (
+	ErrSpamCheckUnavailable = errors.New("spam check is unavailable")
+	ErrSpamdNotConfigured   = errors.New("spamd address is not configured")
+)
+
+// scoreSubmission scores the content of the form submission with the spam rules of the form. If no
+// fields are configured for the spam scoring, the content fields of the form are scored.
+func (s *Server) scoreSubmission(form *forms.Form, submission map[string][]string) (*spam.Result, error) {
+	engine, err := spam.New(form.Validation.Spam)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spam scoring engine: %w", err)
+	}
+
+	fields := form.Validation.Spam.Fields
+	if len(fields) == 0 {
+		fields = form.Content.Fields
+	}
+	sub := spam.Submission{Domains: form.Domains}
+	for _, field := range fields {
+		sub.Content = append(sub.Content, submission[field]...)
+	}
+	if values := submission[form.Validation.Spam.EmailField]; len(values) > 0 {
+		sub.Email = values[0]
+	}
+
+	return engine.Score(sub), nil
+}
+
+// applySpamAction adjusts the delivery options for the action of the spam scoring. Quarantined
+// submissions are delivered to the quarantine recipients without a confirmation mail to the poster.
+// If no quarantine recipients are configured, the submission is tagged instead.
+func (o *deliveryOptions) applySpamAction(form *forms.Form, action spam.Action) {
+	switch action {
+	case spam.ActionQuarantine:
+		if len(form.Validation.Spam.QuarantineRecipients) > 0 {
+			o.recipients = form.Validation.Spam.QuarantineRecipients
+			o.skipConfirmation = true
+		}
+		o.addSubjectTag(form.Validation.SpamTag)
+	case spam.ActionTag:
+		o.addSubjectTag(form.Validation.SpamTag)
+	case spam.ActionNone, spam.ActionReject:
+	}
+}
+
+// checkSpamd runs the composed form mail through spamd and adds the X-Spam-* headers to it. The
+// reject and quarantine thresholds of the form are applied to the spamd score. If spamd can't be
+// reached or times out, the submission is rejected unless the form is configured to fail open.
+// Invalid spamd responses always reject the submission.
+func (s *Server) checkSpamd(ctx context.Context, log *slog.Logger, form *forms.Form, message *mail.Msg,
+	opts *deliveryOptions,
+) error {
+	config := form.Validation.Spamd
+	result, err := s.spamdReport(ctx, message)
+	if err != nil {
+		if config.FailOpen && errors.Is(err, spamd.ErrUnavailable) {
+			log.Warn("spamd check failed, delivering form mail unchecked", logger.Err(err))
+			message.SetGenHeader(headerSpamStatus, "Unknown, spamd unavailable")
+			return nil
+		}
+		log.Error("spamd check failed", logger.Err(err))
+		return fmt.Errorf("%w: %w", ErrSpamCheckUnavailable, err)
+	}
+	log.Info("spamd check completed", slog.Float64("score", result.Score),
+		slog.Float64("threshold", result.Threshold), slog.Bool("spam", result.Spam))
+
+	flag, status := "NO", "No"
+	if result.Spam {
+		flag, status = "YES", "Yes"
+	}
+	message.SetGenHeader(headerSpamFlag, flag)
+	message.SetGenHeader(headerSpamScore, strconv.FormatFloat(result.Score, 'f', 1, 64))
+	message.SetGenHeader(headerSpamStatus, fmt.Sprintf("%s, score=%.1f required=%.1f", status,
+		result.Score, result.Threshold))
+	if report := strings.Join(strings.Fields(result.Report), " "); report != "" {
+		message.SetGenHeader(headerSpamReport, report)
+	}
+
+	switch {
+	case config.RejectScore > 0 && result.Score >= config.RejectScore:
+		log.Warn("form mail was rejected by spamd", slog.Float64("score", result.Score))
+		return ErrSubmissionRejectedAsSpam
+	case config.QuarantineScore > 0 && result.Score >= config.QuarantineScore:
+		log.Warn("form mail was routed to quarantine by spamd", slog.Float64("score", result.Score))
+		opts.applySpamAction(form, spam.ActionQuarantine)
+		if len(opts.recipients) > 0 {
+			if err = message.To(opts.recipients...); err != nil {
+				return fmt.Errorf("failed to set quarantine recipient address: %w", err)
+			}
+			// Routed CC and BCC recipients must not receive quarantined mails
+			_ = message.Cc()
+			_ = message.Bcc()
+		}
+		message.Subject(opts.subject(form.Content.Subject))
+	}
+
+	return nil
+}
+
+// spamdReport sends the composed form mail to spamd and returns the result.
+func (s *Server) spamdReport(ctx context.Context, message *mail.Msg) (*spamd.Result, error) {
+	if s.spamd == nil {
+		return nil, ErrSpamdNotConfigured
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := message.WriteTo(buf); err != nil {
+		return nil, fmt.Errorf("failed to write message: %w", err)
+	}
+	return s.spamd.Report(ctx, buf.Bytes())
+}
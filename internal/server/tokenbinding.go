@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/wneessen/js-mailer/internal/cache"
+	"github.com/wneessen/js-mailer/internal/forms"
+)
+
+const (
+	// TokenBindingAddress binds the token to the IP address of the client
+	TokenBindingAddress = "address"
+
+	// TokenBindingPrefix binds the token to the /24 (IPv4) or /64 (IPv6) prefix of the client
+	TokenBindingPrefix = "prefix"
+)
+
+var ErrUnknownTokenBinding = errors.New("unknown token binding")
+
+// tokenBindingIP returns the IP address or prefix of the client that a token is bound to. If the
+// token is not bound to the client IP, an empty string is returned.
+func tokenBindingIP(r *http.Request, mode string) (string, error) {
+	mode = strings.ToLower(mode)
+	if mode == "" {
+		return "", nil
+	}
+	if mode != TokenBindingAddress && mode != TokenBindingPrefix {
+		return "", fmt.Errorf("%w: %q", ErrUnknownTokenBinding, mode)
+	}
+
+	addr, err := clientAddr(r)
+	if err != nil {
+		return "", err
+	}
+	if mode == TokenBindingAddress {
+		return addr.String(), nil
+	}
+	bits := 64
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine client prefix: %w", err)
+	}
+	return prefix.String(), nil
+}
+
+// tokenBindingMismatch compares the client of the request with the client the token was issued to
+// and returns the reason if they don't match.
+func tokenBindingMismatch(r *http.Request, form *forms.Form, params cache.ItemParams) string {
+	binding := form.Validation.TokenBinding
+	if binding.IP != "" {
+		clientIP, err := tokenBindingIP(r, binding.IP)
+		if err != nil {
+			return fmt.Sprintf("failed to determine client IP: %s", err)
+		}
+		if clientIP != params.ClientIP {
+			return fmt.Sprintf("client IP %s does not match token IP %s", clientIP, params.ClientIP)
+		}
+	}
+	if binding.UserAgent && r.UserAgent() != params.UserAgent {
+		return "client User-Agent does not match token User-Agent"
+	}
+	return ""
+}
@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/wneessen/go-mail"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+	"github.com/wneessen/js-mailer/internal/localmail"
+	"github.com/wneessen/js-mailer/internal/logger"
+)
+
+const (
+	// TransportSMTP delivers the mails of a form via SMTP relays
+	TransportSMTP = "smtp"
+
+	// TransportSendmail pipes the mails of a form to a sendmail compatible binary
+	TransportSendmail = "sendmail"
+
+	// TransportLMTP delivers the mails of a form via LMTP over a Unix socket
+	TransportLMTP = "lmtp"
+
+	// TransportMaildir writes the mails of a form into a Maildir
+	TransportMaildir = "maildir"
+
+	// TransportMbox appends the mails of a form to an mbox file
+	TransportMbox = "mbox"
+
+	// defaultSendmailPath is the sendmail binary that is used if the form doesn't set a path
+	defaultSendmailPath = "/usr/sbin/sendmail"
+)
+
+var (
+	// ErrUnknownTransport is returned if a form has an unsupported transport type
+	ErrUnknownTransport = errors.New("unknown transport")
+
+	// ErrTransportPathMissing is returned if a local transport of a form has no path configured
+	ErrTransportPathMissing = errors.New("transport path is missing")
+)
+
+// localSender sends a mail via a local transport and returns a response describing the delivery
+type localSender func(ctx context.Context, message *mail.Msg) (string, error)
+
+// localTransport returns the sender for the local transport of the form, or nil if the form
+// delivers its mails via SMTP.
+func localTransport(form *forms.Form) (localSender, error) {
+	config := form.Transport
+	kind := strings.ToLower(config.Type)
+	if kind == "" || kind == TransportSMTP {
+		return nil, nil
+	}
+	if config.Path == "" && kind != TransportSendmail {
+		return nil, fmt.Errorf("%w for %s transport", ErrTransportPathMissing, kind)
+	}
+
+	switch kind {
+	case TransportSendmail:
+		path := config.Path
+		if path == "" {
+			path = defaultSendmailPath
+		}
+		return func(ctx context.Context, message *mail.Msg) (string, error) {
+			if err := message.WriteToSendmailWithContext(ctx, path); err != nil {
+				return "", err
+			}
+			return "handed over to " + path, nil
+		}, nil
+	case TransportLMTP:
+		lhlo := form.Server.HELO
+		if lhlo == "" {
+			lhlo = "localhost"
+		}
+		return func(ctx context.Context, message *mail.Msg) (string, error) {
+			from, recipients, content, err := envelope(message)
+			if err != nil {
+				return "", err
+			}
+			return localmail.DeliverLMTP(ctx, config.Path, lhlo, from, recipients, content)
+		}, nil
+	case TransportMaildir:
+		return func(_ context.Context, message *mail.Msg) (string, error) {
+			_, _, content, err := envelope(message)
+			if err != nil {
+				return "", err
+			}
+			return localmail.DeliverMaildir(config.Path, content)
+		}, nil
+	case TransportMbox:
+		return func(_ context.Context, message *mail.Msg) (string, error) {
+			from, _, content, err := envelope(message)
+			if err != nil {
+				return "", err
+			}
+			if err = localmail.DeliverMbox(config.Path, from, content); err != nil {
+				return "", err
+			}
+			return "appended to " + config.Path, nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTransport, config.Type)
+	}
+}
+
+// envelope returns the envelope sender and recipients and the rendered (and DKIM signed) content
+// of the message
+func envelope(message *mail.Msg) (string, []string, []byte, error) {
+	from, err := message.GetSender(false)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to get envelope sender: %w", err)
+	}
+	recipients, err := message.GetRecipients()
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to get envelope recipients: %w", err)
+	}
+	// go-mail returns the addresses in angle brackets
+	from = strings.Trim(from, "<>")
+	for i, recipient := range recipients {
+		recipients[i] = strings.Trim(recipient, "<>")
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err = message.WriteTo(buf); err != nil {
+		return "", nil, nil, fmt.Errorf("failed to render message: %w", err)
+	}
+	return from, recipients, buf.Bytes(), nil
+}
+
+// deliverLocal sends the mails in order via the local transport of the form. If a mail that is
+// not optional fails, the following mails are not sent. The results are stored in the mails.
+// Each mail has to be delivered within the timeout of the form's mail server configuration.
+func (s *Server) deliverLocal(r *http.Request, form *forms.Form, send localSender, mails ...*outgoingMail) {
+	log := s.log.With(logger.RequestID(r), slog.String("transport", form.Transport.Type))
+	timeout := form.Server.Timeout
+	if timeout <= 0 {
+		timeout = mail.DefaultTimeout
+	}
+	pending := sendInOrder(log, form.Transport.Type+" transport", mails, func(message *mail.Msg) (string, error) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		return send(ctx, message)
+	})
+	failPending(r.Context(), pending)
+}
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/wneessen/go-mail"
+
+	"github.com/wneessen/js-mailer/internal/clamav"
+	"github.com/wneessen/js-mailer/internal/forms"
+	"github.com/wneessen/js-mailer/internal/logger"
+)
+
+var (
+	ErrVirusScanUnavailable = errors.New("virus scan is unavailable")
+	ErrClamAVNotConfigured  = errors.New("clamav address is not configured")
+)
+
+// scanSubmission scans all uploaded files of the form submission and all attachments of the
+// composed form mail with clamd. Infected submissions are rejected. If clamd can't be reached or
+// times out, the submission is rejected unless the form is configured to fail open. Submissions
+// that clamd answered with an error are always rejected.
+func (s *Server) scanSubmission(r *http.Request, log *slog.Logger, form *forms.Form, message *mail.Msg) error {
+	if r.MultipartForm != nil {
+		for _, field := range slices.Sorted(maps.Keys(r.MultipartForm.File)) {
+			for _, header := range r.MultipartForm.File[field] {
+				file, err := header.Open()
+				if err != nil {
+					return fmt.Errorf("failed to open uploaded file %q: %w", header.Filename, err)
+				}
+				err = s.scanFile(r, log, form, header.Filename, file)
+				_ = file.Close()
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, attachment := range message.GetAttachments() {
+		buf := bytes.NewBuffer(nil)
+		if _, err := attachment.Writer(buf); err != nil {
+			return fmt.Errorf("failed to read attachment %q: %w", attachment.Name, err)
+		}
+		if err := s.scanFile(r, log, form, attachment.Name, buf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanFile scans a single file with clamd and logs the result.
+func (s *Server) scanFile(r *http.Request, log *slog.Logger, form *forms.Form, name string, file io.Reader) error {
+	if s.clamav == nil {
+		return s.virusScanFailed(log, form, name, ErrClamAVNotConfigured)
+	}
+	result, err := s.clamav.Scan(r.Context(), file)
+	if err != nil {
+		return s.virusScanFailed(log, form, name, err)
+	}
+	if result.Infected {
+		log.Warn("virus scan found infected file", slog.String("file", name),
+			slog.String("signature", result.Signature))
+		return fmt.Errorf("%w: %s", ErrSubmissionInfected, result.Signature)
+	}
+	log.Info("virus scan completed", slog.String("file", name), slog.Bool("infected", false))
+
+	return nil
+}
+
+// virusScanFailed handles a failed virus scan. Only connection and timeout errors are subject to
+// the fail open setting of the form.
+func (s *Server) virusScanFailed(log *slog.Logger, form *forms.Form, name string, err error) error {
+	if form.Validation.ClamAV.FailOpen && errors.Is(err, clamav.ErrUnavailable) {
+		log.Warn("virus scan failed, delivering file unchecked", slog.String("file", name), logger.Err(err))
+		return nil
+	}
+	log.Error("virus scan failed", slog.String("file", name), logger.Err(err))
+	return fmt.Errorf("%w: %w", ErrVirusScanUnavailable, err)
+}
@@ -0,0 +1,211 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+// Package smime implements the encryption of MIME entities to the certificates of the recipients
+// as CMS enveloped data (RFC 5652, RFC 8551). The content is encrypted with AES-256-CBC, the
+// content encryption key with RSAES-OAEP (SHA-256) for every recipient.
+package smime
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ContentType is the content type of an S/MIME encrypted message
+const ContentType = `application/pkcs7-mime; smime-type=enveloped-data; name="smime.p7m"`
+
+var (
+	// ErrNoRecipients is returned if the content should be encrypted without any recipient
+	ErrNoRecipients = errors.New("no recipient certificates")
+
+	// ErrUnsupportedKey is returned if the certificate of a recipient has no RSA public key
+	ErrUnsupportedKey = errors.New("unsupported recipient public key, only RSA keys are supported")
+
+	// ErrNoCertificate is returned if a certificate file contains no certificate
+	ErrNoCertificate = errors.New("no certificate found")
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidRSAESOAEP     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidMGF1          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidAES256CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	sha256Algorithm  = pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []keyTransRecipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type keyTransRecipientInfo struct {
+	Version                int
+	IssuerAndSerialNumber  issuerAndSerialNumber
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"tag:0,optional"`
+}
+
+type rsaesOAEPParams struct {
+	HashFunc    pkix.AlgorithmIdentifier `asn1:"explicit,tag:0"`
+	MaskGenFunc pkix.AlgorithmIdentifier `asn1:"explicit,tag:1"`
+}
+
+// Encrypt encrypts the MIME entity (headers and body) to the certificates of the recipients and
+// returns the DER encoded CMS content info.
+func Encrypt(entity []byte, recipients []*x509.Certificate) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate content encryption key: %w", err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, fmt.Errorf("failed to generate initialization vector: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create content cipher: %w", err)
+	}
+	padding := aes.BlockSize - len(entity)%aes.BlockSize
+	ciphertext := append(slices.Clone(entity), slices.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	keyEncryptionAlgorithm, err := oaepAlgorithmIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	recipientInfos := make([]keyTransRecipientInfo, 0, len(recipients))
+	for _, cert := range recipients {
+		publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedKey, cert.Subject)
+		}
+		encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, key, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt content encryption key for %s: %w", cert.Subject, err)
+		}
+		recipientInfos = append(recipientInfos, keyTransRecipientInfo{
+			IssuerAndSerialNumber: issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			KeyEncryptionAlgorithm: keyEncryptionAlgorithm,
+			EncryptedKey:           encryptedKey,
+		})
+	}
+
+	envelope, err := asn1.Marshal(envelopedData{
+		RecipientInfos: recipientInfos,
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType: oidData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  oidAES256CBC,
+				Parameters: asn1.RawValue{Tag: asn1.TagOctetString, Bytes: iv},
+			},
+			EncryptedContent: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: ciphertext},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal enveloped data: %w", err)
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidEnvelopedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: envelope, IsCompound: true},
+	})
+}
+
+// LoadCertificates loads all certificates from the PEM encoded certificate files.
+func LoadCertificates(paths ...string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificate file: %w", err)
+		}
+		var found bool
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse certificate in %s: %w", path, err)
+			}
+			certs = append(certs, cert)
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("%w in %s", ErrNoCertificate, path)
+		}
+	}
+	return certs, nil
+}
+
+// CertificateFor returns the first certificate that is issued for the email address.
+func CertificateFor(certs []*x509.Certificate, address string) (*x509.Certificate, bool) {
+	for _, cert := range certs {
+		for _, email := range cert.EmailAddresses {
+			if strings.EqualFold(email, address) {
+				return cert, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// oaepAlgorithmIdentifier returns the algorithm identifier for RSAES-OAEP with SHA-256 and MGF1
+func oaepAlgorithmIdentifier() (pkix.AlgorithmIdentifier, error) {
+	mgfParams, err := asn1.Marshal(sha256Algorithm)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, fmt.Errorf("failed to marshal MGF1 parameters: %w", err)
+	}
+	params, err := asn1.Marshal(rsaesOAEPParams{
+		HashFunc:    sha256Algorithm,
+		MaskGenFunc: pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: mgfParams}},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, fmt.Errorf("failed to marshal RSAES-OAEP parameters: %w", err)
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oidRSAESOAEP, Parameters: asn1.RawValue{FullBytes: params}}, nil
+}
@@ -0,0 +1,179 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package smime
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wneessen/js-mailer/internal/testhelper"
+)
+
+const testEntity = "Content-Type: text/plain; charset=UTF-8\r\n\r\nThis is a test message\r\n"
+
+func TestEncrypt(t *testing.T) {
+	dir := t.TempDir()
+	firstCert, firstKey := testhelper.WriteCertificate(t, dir, "first@example.com")
+	secondCert, secondKey := testhelper.WriteCertificate(t, dir, "second@example.com")
+	certs, err := LoadCertificates(firstCert, secondCert)
+	if err != nil {
+		t.Fatalf("failed to load certificates: %s", err)
+	}
+
+	t.Run("every recipient can decrypt the entity", func(t *testing.T) {
+		der, err := Encrypt([]byte(testEntity), certs)
+		if err != nil {
+			t.Fatalf("failed to encrypt entity: %s", err)
+		}
+		for _, files := range [][2]string{{firstCert, firstKey}, {secondCert, secondKey}} {
+			keyPair, err := tls.LoadX509KeyPair(files[0], files[1])
+			if err != nil {
+				t.Fatalf("failed to load key pair: %s", err)
+			}
+			content, err := decrypt(der, keyPair.Leaf, keyPair.PrivateKey.(*rsa.PrivateKey))
+			if err != nil {
+				t.Fatalf("failed to decrypt entity: %s", err)
+			}
+			if !bytes.Equal(content, []byte(testEntity)) {
+				t.Errorf("expected decrypted entity %q, got %q", testEntity, content)
+			}
+		}
+	})
+	t.Run("encryption without recipients fails", func(t *testing.T) {
+		if _, err := Encrypt([]byte(testEntity), nil); !errors.Is(err, ErrNoRecipients) {
+			t.Errorf("expected error to be %s, got: %s", ErrNoRecipients, err)
+		}
+	})
+	t.Run("encryption to non-RSA certificate fails", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate ECDSA key: %s", err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "ecdsa@example.com"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatalf("failed to create certificate: %s", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("failed to parse certificate: %s", err)
+		}
+		if _, err = Encrypt([]byte(testEntity), []*x509.Certificate{cert}); !errors.Is(err, ErrUnsupportedKey) {
+			t.Errorf("expected error to be %s, got: %s", ErrUnsupportedKey, err)
+		}
+	})
+}
+
+func TestLoadCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := testhelper.WriteCertificate(t, dir, "rcpt@example.com")
+	t.Run("certificate is loaded", func(t *testing.T) {
+		certs, err := LoadCertificates(certFile)
+		if err != nil {
+			t.Fatalf("failed to load certificates: %s", err)
+		}
+		if len(certs) != 1 {
+			t.Errorf("expected 1 certificate, got %d", len(certs))
+		}
+	})
+	t.Run("file without certificate fails", func(t *testing.T) {
+		if _, err := LoadCertificates(keyFile); !errors.Is(err, ErrNoCertificate) {
+			t.Errorf("expected error to be %s, got: %s", ErrNoCertificate, err)
+		}
+	})
+	t.Run("missing file fails", func(t *testing.T) {
+		if _, err := LoadCertificates(filepath.Join(dir, "missing.crt")); err == nil {
+			t.Error("expected loading a missing certificate file to fail")
+		}
+	})
+	t.Run("invalid certificate fails", func(t *testing.T) {
+		path := filepath.Join(dir, "invalid.crt")
+		data := "-----BEGIN CERTIFICATE-----\naW52YWxpZA==\n-----END CERTIFICATE-----\n"
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatalf("failed to write certificate: %s", err)
+		}
+		if _, err := LoadCertificates(path); err == nil {
+			t.Error("expected loading an invalid certificate to fail")
+		}
+	})
+}
+
+func TestCertificateFor(t *testing.T) {
+	certFile, _ := testhelper.WriteCertificate(t, t.TempDir(), "rcpt@example.com")
+	certs, err := LoadCertificates(certFile)
+	if err != nil {
+		t.Fatalf("failed to load certificates: %s", err)
+	}
+	if _, ok := CertificateFor(certs, "RCPT@example.com"); !ok {
+		t.Error("expected certificate for rcpt@example.com")
+	}
+	if _, ok := CertificateFor(certs, "other@example.com"); ok {
+		t.Error("expected no certificate for other@example.com")
+	}
+}
+
+// decrypt decrypts the CMS enveloped data with the certificate and private key of a recipient
+func decrypt(der []byte, cert *x509.Certificate, key *rsa.PrivateKey) ([]byte, error) {
+	var info contentInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if !info.ContentType.Equal(oidEnvelopedData) {
+		return nil, errors.New("not enveloped data")
+	}
+	var envelope envelopedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &envelope); err != nil {
+		return nil, err
+	}
+
+	var encryptedKey []byte
+	for _, recipient := range envelope.RecipientInfos {
+		if bytes.Equal(recipient.IssuerAndSerialNumber.Issuer.FullBytes, cert.RawIssuer) &&
+			recipient.IssuerAndSerialNumber.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			encryptedKey = recipient.EncryptedKey
+		}
+	}
+	if encryptedKey == nil {
+		return nil, errors.New("no recipient info for certificate")
+	}
+	contentKey, err := rsa.DecryptOAEP(sha256.New(), nil, key, encryptedKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var iv []byte
+	if _, err = asn1.Unmarshal(envelope.EncryptedContentInfo.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	content := bytes.Clone(envelope.EncryptedContentInfo.EncryptedContent.Bytes)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(content, content)
+	padding := int(content[len(content)-1])
+	return content[:len(content)-padding], nil
+}
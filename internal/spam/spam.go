@@ -0,0 +1,331 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+// Package spam implements a content-based spam scoring engine for form submissions
+package spam
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+)
+
+// Action is the outcome of the spam scoring of a submission
+type Action string
+
+const (
+	// ActionNone means the submission is delivered as usual
+	ActionNone Action = "none"
+
+	// ActionTag means the submission is delivered with a tagged subject
+	ActionTag Action = "tag"
+
+	// ActionQuarantine means the submission is delivered to the quarantine recipients
+	ActionQuarantine Action = "quarantine"
+
+	// ActionReject means the submission is rejected
+	ActionReject Action = "reject"
+)
+
+// minCapsLetters is the minimum number of cased letters a submission needs before the all-caps
+// rule is applied, so that short messages like "OK" are not considered shouting.
+const minCapsLetters = 20
+
+// DefaultShorteners is the list of well-known URL shortener domains
+var DefaultShorteners = []string{
+	"bit.ly", "buff.ly", "cutt.ly", "goo.gl", "is.gd", "ow.ly", "rb.gy", "rebrand.ly", "shorturl.at",
+	"t.co", "t.ly", "tiny.cc", "tinyurl.com", "v.gd",
+}
+
+var (
+	// ErrInvalidPattern is returned if a configured pattern is not a valid regular expression
+	ErrInvalidPattern = forms.ErrInvalidSpamPattern
+
+	// ErrUnknownScript is returned if a configured script is not a known Unicode script
+	ErrUnknownScript = forms.ErrUnknownSpamScript
+)
+
+// linkPattern matches URLs with a scheme or a "www." prefix
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"'\[\]]+`)
+
+// Submission holds the content of a form submission that is scored
+type Submission struct {
+	// Content are the values of the submitted form fields
+	Content []string
+
+	// Email is the email address the poster provided
+	Email string
+
+	// Domains are the domains of the website the form belongs to. Links to these domains are not
+	// considered a domain mismatch.
+	Domains []string
+}
+
+// Hit is a single rule that matched a submission
+type Hit struct {
+	Rule   string  `json:"rule"`
+	Score  float64 `json:"score"`
+	Detail string  `json:"detail"`
+}
+
+// Result is the result of the spam scoring of a submission
+type Result struct {
+	Score  float64
+	Hits   []Hit
+	Action Action
+}
+
+// Engine scores submissions against the spam rules of a form
+type Engine struct {
+	config     forms.SpamConfig
+	patterns   []*regexp.Regexp
+	shorteners []string
+	scripts    []*unicode.RangeTable
+}
+
+// New returns a new Engine for the given spam configuration. The patterns that were compiled when
+// the form was loaded are reused.
+func New(config forms.SpamConfig) (*Engine, error) {
+	patterns, err := config.CompiledPatterns()
+	if err != nil {
+		return nil, err
+	}
+	engine := &Engine{
+		config:     config,
+		patterns:   patterns,
+		shorteners: append(slices.Clone(DefaultShorteners), config.Shorteners...),
+	}
+	for _, name := range config.AllowedScripts {
+		table, ok := unicode.Scripts[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownScript, name)
+		}
+		engine.scripts = append(engine.scripts, table)
+	}
+	return engine, nil
+}
+
+// Score scores the submission against all enabled rules and decides the action based on the
+// configured thresholds.
+func (e *Engine) Score(sub Submission) *Result {
+	content := strings.Join(sub.Content, "\n")
+	links := linkPattern.FindAllString(content, -1)
+	hosts := make([]string, 0, len(links))
+	for _, link := range links {
+		if host := linkHost(link); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+
+	result := &Result{}
+	e.scoreLinks(result, links)
+	e.scoreShorteners(result, hosts)
+	e.scoreKeywords(result, content)
+	e.scorePatterns(result, content)
+	e.scoreCaps(result, content)
+	e.scoreScripts(result, content)
+	e.scoreRepeatedChars(result, content)
+	e.scoreDomainMismatch(result, sub, hosts)
+
+	for _, hit := range result.Hits {
+		result.Score += hit.Score
+	}
+	result.Action = e.action(result.Score)
+	return result
+}
+
+// action returns the action for the given score. The most severe action wins.
+func (e *Engine) action(score float64) Action {
+	switch {
+	case e.config.RejectScore > 0 && score >= e.config.RejectScore:
+		return ActionReject
+	case e.config.QuarantineScore > 0 && score >= e.config.QuarantineScore:
+		return ActionQuarantine
+	case e.config.TagScore > 0 && score >= e.config.TagScore:
+		return ActionTag
+	default:
+		return ActionNone
+	}
+}
+
+// scoreLinks adds the link score for every link above the maximum number of links.
+func (e *Engine) scoreLinks(result *Result, links []string) {
+	if e.config.LinkScore == 0 || len(links) <= e.config.MaxLinks {
+		return
+	}
+	excess := len(links) - e.config.MaxLinks
+	result.add("links", e.config.LinkScore*float64(excess),
+		fmt.Sprintf("%d links, %d allowed", len(links), e.config.MaxLinks))
+}
+
+// scoreShorteners adds the shortener score for every link to a URL shortener.
+func (e *Engine) scoreShorteners(result *Result, hosts []string) {
+	if e.config.ShortenerScore == 0 {
+		return
+	}
+	for _, host := range hosts {
+		if slices.ContainsFunc(e.shorteners, func(shortener string) bool { return matchesDomain(host, shortener) }) {
+			result.add("shortener", e.config.ShortenerScore, host)
+		}
+	}
+}
+
+// scoreKeywords adds the keyword score for every configured keyword that is found in the content.
+func (e *Engine) scoreKeywords(result *Result, content string) {
+	if e.config.KeywordScore == 0 {
+		return
+	}
+	lower := strings.ToLower(content)
+	for _, keyword := range e.config.Keywords {
+		if keyword != "" && strings.Contains(lower, strings.ToLower(keyword)) {
+			result.add("keyword", e.config.KeywordScore, keyword)
+		}
+	}
+}
+
+// scorePatterns adds the pattern score for every configured pattern that matches the content.
+func (e *Engine) scorePatterns(result *Result, content string) {
+	if e.config.PatternScore == 0 {
+		return
+	}
+	for _, re := range e.patterns {
+		if re.MatchString(content) {
+			result.add("pattern", e.config.PatternScore, re.String())
+		}
+	}
+}
+
+// scoreCaps adds the caps score if the ratio of upper case letters exceeds the maximum ratio.
+func (e *Engine) scoreCaps(result *Result, content string) {
+	if e.config.CapsScore == 0 || e.config.MaxCapsRatio <= 0 {
+		return
+	}
+	var upper, cased int
+	for _, char := range content {
+		switch {
+		case unicode.IsUpper(char):
+			upper++
+			cased++
+		case unicode.IsLower(char):
+			cased++
+		}
+	}
+	if cased < minCapsLetters {
+		return
+	}
+	if ratio := float64(upper) / float64(cased); ratio > e.config.MaxCapsRatio {
+		result.add("caps", e.config.CapsScore, fmt.Sprintf("%.0f%% upper case letters", ratio*100))
+	}
+}
+
+// scoreScripts adds the script score for every Unicode script that is used in the content, but
+// not in the list of allowed scripts.
+func (e *Engine) scoreScripts(result *Result, content string) {
+	if e.config.ScriptScore == 0 || len(e.scripts) == 0 {
+		return
+	}
+	var foreign []string
+	for _, char := range content {
+		if !unicode.IsLetter(char) || unicode.In(char, e.scripts...) {
+			continue
+		}
+		if script := scriptOf(char); script != "" && !slices.Contains(foreign, script) {
+			foreign = append(foreign, script)
+		}
+	}
+	slices.Sort(foreign)
+	for _, script := range foreign {
+		result.add("script", e.config.ScriptScore, script)
+	}
+}
+
+// scoreRepeatedChars adds the repeat score if a character is repeated more often than allowed.
+func (e *Engine) scoreRepeatedChars(result *Result, content string) {
+	if e.config.RepeatScore == 0 || e.config.MaxRepeatedChars <= 0 {
+		return
+	}
+	var last rune
+	count := 0
+	for _, char := range content {
+		if char == last && !unicode.IsSpace(char) {
+			count++
+		} else {
+			last, count = char, 1
+		}
+		if count > e.config.MaxRepeatedChars {
+			result.add("repeat", e.config.RepeatScore,
+				fmt.Sprintf("%q repeated more than %d times", char, e.config.MaxRepeatedChars))
+			return
+		}
+	}
+}
+
+// scoreDomainMismatch adds the domain mismatch score if the content links to domains that neither
+// match the domain of the email address nor the domains of the form.
+func (e *Engine) scoreDomainMismatch(result *Result, sub Submission, hosts []string) {
+	if e.config.DomainMismatchScore == 0 || sub.Email == "" || len(hosts) == 0 {
+		return
+	}
+	address, err := mail.ParseAddress(sub.Email)
+	if err != nil {
+		return
+	}
+	_, emailDomain, _ := strings.Cut(strings.ToLower(address.Address), "@")
+
+	var mismatches []string
+	for _, host := range hosts {
+		if matchesDomain(host, emailDomain) || matchesDomain(emailDomain, host) {
+			continue
+		}
+		if slices.ContainsFunc(sub.Domains, func(domain string) bool { return matchesDomain(host, domain) }) {
+			continue
+		}
+		if !slices.Contains(mismatches, host) {
+			mismatches = append(mismatches, host)
+		}
+	}
+	if len(mismatches) > 0 {
+		result.add("domain_mismatch", e.config.DomainMismatchScore,
+			fmt.Sprintf("email domain %s, linked domains %s", emailDomain, strings.Join(mismatches, ", ")))
+	}
+}
+
+// add adds a rule hit to the result
+func (r *Result) add(rule string, score float64, detail string) {
+	r.Hits = append(r.Hits, Hit{Rule: rule, Score: score, Detail: detail})
+}
+
+// linkHost returns the lower case hostname of the given link
+func linkHost(link string) string {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+// matchesDomain returns true if the host equals the domain or is a subdomain of it
+func matchesDomain(host, domain string) bool {
+	domain = strings.ToLower(domain)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// scriptOf returns the name of the Unicode script of the given rune
+func scriptOf(char rune) string {
+	for name, table := range unicode.Scripts {
+		if unicode.Is(table, char) {
+			return name
+		}
+	}
+	return ""
+}
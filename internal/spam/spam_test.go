@@ -0,0 +1,198 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package spam
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/wneessen/js-mailer/internal/forms"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("new returns an engine", func(t *testing.T) {
+		engine, err := New(forms.SpamConfig{Patterns: []string{`(?i)casino`}, AllowedScripts: []string{"Latin"}})
+		if err != nil {
+			t.Fatalf("failed to create spam engine: %s", err)
+		}
+		if len(engine.patterns) != 1 {
+			t.Errorf("expected 1 compiled pattern, got %d", len(engine.patterns))
+		}
+		if len(engine.scripts) != 1 {
+			t.Errorf("expected 1 script, got %d", len(engine.scripts))
+		}
+	})
+	t.Run("new fails with invalid pattern", func(t *testing.T) {
+		_, err := New(forms.SpamConfig{Patterns: []string{`(`}})
+		if !errors.Is(err, ErrInvalidPattern) {
+			t.Errorf("expected error to be %s, got %s", ErrInvalidPattern, err)
+		}
+	})
+	t.Run("new fails with unknown script", func(t *testing.T) {
+		_, err := New(forms.SpamConfig{AllowedScripts: []string{"Klingon"}})
+		if !errors.Is(err, ErrUnknownScript) {
+			t.Errorf("expected error to be %s, got %s", ErrUnknownScript, err)
+		}
+	})
+}
+
+func TestEngine_Score(t *testing.T) {
+	tests := []struct {
+		name   string
+		config forms.SpamConfig
+		sub    Submission
+		score  float64
+		rules  []string
+	}{
+		{
+			"clean message",
+			forms.SpamConfig{MaxLinks: 1, LinkScore: 1, ShortenerScore: 2, CapsScore: 1, MaxCapsRatio: 0.7},
+			Submission{Content: []string{"Hello, I would like to know more about your product."}},
+			0,
+			nil,
+		},
+		{
+			"links above maximum",
+			forms.SpamConfig{MaxLinks: 1, LinkScore: 1.5},
+			Submission{Content: []string{"see https://a.example and www.b.example and http://c.example/path"}},
+			3,
+			[]string{"links"},
+		},
+		{
+			"URL shorteners",
+			forms.SpamConfig{MaxLinks: 10, ShortenerScore: 2, Shorteners: []string{"sho.rt"}},
+			Submission{Content: []string{"click https://bit.ly/abc or https://go.sho.rt/x"}},
+			4,
+			[]string{"shortener", "shortener"},
+		},
+		{
+			"keywords are case insensitive",
+			forms.SpamConfig{Keywords: []string{"SEO", "backlinks", "crypto"}, KeywordScore: 1},
+			Submission{Content: []string{"We offer cheap seo services and BACKLINKS"}},
+			2,
+			[]string{"keyword", "keyword"},
+		},
+		{
+			"regex patterns",
+			forms.SpamConfig{Patterns: []string{`(?i)\bcasino\b`, `\d{3}-\d{4}`}, PatternScore: 2.5},
+			Submission{Content: []string{"Best Casino in town"}},
+			2.5,
+			[]string{"pattern"},
+		},
+		{
+			"all caps message",
+			forms.SpamConfig{MaxCapsRatio: 0.6, CapsScore: 1},
+			Submission{Content: []string{"BUY NOW AND GET THE BEST DEALS ON WATCHES"}},
+			1,
+			[]string{"caps"},
+		},
+		{
+			"short all caps message is ignored",
+			forms.SpamConfig{MaxCapsRatio: 0.6, CapsScore: 1},
+			Submission{Content: []string{"OK THANKS"}},
+			0,
+			nil,
+		},
+		{
+			"unexpected scripts",
+			forms.SpamConfig{AllowedScripts: []string{"Latin"}, ScriptScore: 1.5},
+			Submission{Content: []string{"Hello Привет こんにちは 123!"}},
+			3,
+			[]string{"script", "script"},
+		},
+		{
+			"expected scripts",
+			forms.SpamConfig{AllowedScripts: []string{"Latin", "Cyrillic"}, ScriptScore: 1},
+			Submission{Content: []string{"Hello Привет, über 123!"}},
+			0,
+			nil,
+		},
+		{
+			"repeated characters",
+			forms.SpamConfig{MaxRepeatedChars: 4, RepeatScore: 1},
+			Submission{Content: []string{"AMAZING!!!!!!!!"}},
+			1,
+			[]string{"repeat"},
+		},
+		{
+			"repeated whitespace is ignored",
+			forms.SpamConfig{MaxRepeatedChars: 4, RepeatScore: 1},
+			Submission{Content: []string{"Hello          World"}},
+			0,
+			nil,
+		},
+		{
+			"email domain does not match linked domain",
+			forms.SpamConfig{DomainMismatchScore: 2},
+			Submission{
+				Content: []string{"Visit https://seo-agency.example today"},
+				Email:   "john@mail.example",
+				Domains: []string{"example.com"},
+			},
+			2,
+			[]string{"domain_mismatch"},
+		},
+		{
+			"links to email domain and form domain match",
+			forms.SpamConfig{DomainMismatchScore: 2},
+			Submission{
+				Content: []string{"See https://www.mail.example/about and https://example.com/contact"},
+				Email:   "John <john@mail.example>",
+				Domains: []string{"example.com"},
+			},
+			0,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := New(tt.config)
+			if err != nil {
+				t.Fatalf("failed to create spam engine: %s", err)
+			}
+			result := engine.Score(tt.sub)
+			if result.Score != tt.score {
+				t.Errorf("expected score to be %.2f, got %.2f (hits: %+v)", tt.score, result.Score, result.Hits)
+			}
+			if len(result.Hits) != len(tt.rules) {
+				t.Fatalf("expected %d hits, got %d: %+v", len(tt.rules), len(result.Hits), result.Hits)
+			}
+			for i, rule := range tt.rules {
+				if result.Hits[i].Rule != rule {
+					t.Errorf("expected hit %d to be %s, got %s", i, rule, result.Hits[i].Rule)
+				}
+			}
+		})
+	}
+}
+
+func TestEngine_action(t *testing.T) {
+	config := forms.SpamConfig{TagScore: 2, QuarantineScore: 4, RejectScore: 6}
+	tests := []struct {
+		name   string
+		config forms.SpamConfig
+		score  float64
+		action Action
+	}{
+		{"below all thresholds", config, 1.9, ActionNone},
+		{"tag threshold", config, 2, ActionTag},
+		{"quarantine threshold", config, 5, ActionQuarantine},
+		{"reject threshold", config, 6, ActionReject},
+		{"disabled thresholds", forms.SpamConfig{}, 100, ActionNone},
+		{"reject without quarantine", forms.SpamConfig{TagScore: 1, RejectScore: 3}, 2, ActionTag},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := New(tt.config)
+			if err != nil {
+				t.Fatalf("failed to create spam engine: %s", err)
+			}
+			if action := engine.action(tt.score); action != tt.action {
+				t.Errorf("expected action to be %s, got %s", tt.action, action)
+			}
+		})
+	}
+}
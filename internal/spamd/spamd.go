@@ -0,0 +1,173 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+// Package spamd implements a client for the SpamAssassin spamd protocol
+package spamd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// protocolVersion is the version of the spamc protocol that is spoken by the client
+const protocolVersion = "SPAMC/1.5"
+
+// DefaultTimeout is the default timeout for a spamd check
+const DefaultTimeout = time.Second * 10
+
+var (
+	// ErrUnavailable is returned if spamd could not be reached or the connection failed or timed out
+	ErrUnavailable = errors.New("spamd is unavailable")
+
+	// ErrProtocol is returned if spamd responded with an invalid or unsuccessful response
+	ErrProtocol = errors.New("spamd protocol error")
+)
+
+// Client is a spamd client
+type Client struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// Result is the result of a spamd check
+type Result struct {
+	Spam      bool
+	Score     float64
+	Threshold float64
+	Report    string
+}
+
+// New returns a new spamd client for the given network ("tcp" or "unix") and address
+func New(network, address string, timeout time.Duration) *Client {
+	if network == "" {
+		network = "tcp"
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Client{network: network, address: address, timeout: timeout}
+}
+
+// Report sends the message to spamd using the REPORT command and returns the score and the report.
+func (c *Client) Report(ctx context.Context, message []byte) (*Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			return nil, fmt.Errorf("failed to set connection deadline: %w", err)
+		}
+	}
+
+	request := fmt.Sprintf("REPORT %s\r\nContent-length: %d\r\n\r\n", protocolVersion, len(message))
+	if _, err = io.WriteString(conn, request); err != nil {
+		return nil, fmt.Errorf("%w: failed to send request: %w", ErrUnavailable, err)
+	}
+	if _, err = conn.Write(message); err != nil {
+		return nil, fmt.Errorf("%w: failed to send message: %w", ErrUnavailable, err)
+	}
+	if tcpConn, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = tcpConn.CloseWrite()
+	}
+
+	return readResponse(bufio.NewReader(conn))
+}
+
+// readResponse parses the spamd response. A response starts with a status line, followed by the
+// headers and the report as body, e.g.:
+//
+//	SPAMD/1.1 0 EX_OK
+//	Content-length: 512
+//	Spam: True ; 15.3 / 5.0
+//
+//	<report>
+func readResponse(reader *bufio.Reader) (*Result, error) {
+	proto := textproto.NewReader(reader)
+	status, err := proto.ReadLine()
+	if err != nil {
+		return nil, readError("failed to read status line", err)
+	}
+	fields := strings.Fields(status)
+	if len(fields) < 3 || !strings.HasPrefix(fields[0], "SPAMD/") {
+		return nil, fmt.Errorf("%w: invalid status line %q", ErrProtocol, status)
+	}
+	if fields[1] != "0" {
+		return nil, fmt.Errorf("%w: %s", ErrProtocol, strings.Join(fields[1:], " "))
+	}
+
+	header, err := proto.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, readError("failed to read headers", err)
+	}
+	result, err := parseSpamHeader(header.Get("Spam"))
+	if err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	switch length, convErr := strconv.Atoi(header.Get("Content-length")); {
+	case convErr == nil && length >= 0:
+		body = make([]byte, length)
+		_, err = io.ReadFull(reader, body)
+	default:
+		body, err = io.ReadAll(reader)
+	}
+	if err != nil {
+		return nil, readError("failed to read report", err)
+	}
+	result.Report = strings.TrimSpace(string(body))
+
+	return result, nil
+}
+
+// readError wraps an error that occurred while reading the response. Connection errors and
+// timeouts are reported as ErrUnavailable, everything else as ErrProtocol.
+func readError(msg string, err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return fmt.Errorf("%w: %s: %w", ErrUnavailable, msg, err)
+	}
+	return fmt.Errorf("%w: %s: %w", ErrProtocol, msg, err)
+}
+
+// parseSpamHeader parses the value of the Spam header, e.g. "True ; 15.3 / 5.0"
+func parseSpamHeader(value string) (*Result, error) {
+	verdict, scores, found := strings.Cut(value, ";")
+	if !found {
+		return nil, fmt.Errorf("%w: invalid spam header %q", ErrProtocol, value)
+	}
+	score, threshold, found := strings.Cut(scores, "/")
+	if !found {
+		return nil, fmt.Errorf("%w: invalid spam header %q", ErrProtocol, value)
+	}
+
+	result := &Result{}
+	switch strings.ToLower(strings.TrimSpace(verdict)) {
+	case "true", "yes":
+		result.Spam = true
+	}
+	var err error
+	if result.Score, err = strconv.ParseFloat(strings.TrimSpace(score), 64); err != nil {
+		return nil, fmt.Errorf("%w: invalid score: %w", ErrProtocol, err)
+	}
+	if result.Threshold, err = strconv.ParseFloat(strings.TrimSpace(threshold), 64); err != nil {
+		return nil, fmt.Errorf("%w: invalid threshold: %w", ErrProtocol, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package spamd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wneessen/js-mailer/internal/testhelper"
+)
+
+const testMessage = "From: sender@example.com\r\nSubject: Test\r\n\r\nThis is a test message\r\n"
+
+func TestNew(t *testing.T) {
+	t.Run("new sets defaults", func(t *testing.T) {
+		client := New("", "127.0.0.1:783", 0)
+		if client.network != "tcp" {
+			t.Errorf("expected network to be tcp, got %s", client.network)
+		}
+		if client.timeout != DefaultTimeout {
+			t.Errorf("expected timeout to be %s, got %s", DefaultTimeout, client.timeout)
+		}
+	})
+}
+
+func TestClient_Report(t *testing.T) {
+	t.Run("report via TCP", func(t *testing.T) {
+		addr := testhelper.SpamdServer(t, "tcp", "127.0.0.1:0", func(message string) string {
+			if message != testMessage {
+				return "SPAMD/1.1 76 EX_PROTOCOL\r\n\r\n"
+			}
+			return testSpamdResponse("True ; 15.3 / 5.0", "Content analysis details:   (15.3 points, 5.0 required)")
+		})
+		result, err := New("tcp", addr, time.Second).Report(t.Context(), []byte(testMessage))
+		if err != nil {
+			t.Fatalf("failed to check message: %s", err)
+		}
+		if !result.Spam {
+			t.Error("expected message to be spam")
+		}
+		if result.Score != 15.3 {
+			t.Errorf("expected score to be 15.3, got %.1f", result.Score)
+		}
+		if result.Threshold != 5.0 {
+			t.Errorf("expected threshold to be 5.0, got %.1f", result.Threshold)
+		}
+		if result.Report != "Content analysis details:   (15.3 points, 5.0 required)" {
+			t.Errorf("unexpected report: %q", result.Report)
+		}
+	})
+	t.Run("report via unix socket", func(t *testing.T) {
+		socket := filepath.Join(t.TempDir(), "spamd.sock")
+		addr := testhelper.SpamdServer(t, "unix", socket, func(string) string {
+			return testSpamdResponse("False ; 1.2 / 5.0", "")
+		})
+		result, err := New("unix", addr, time.Second).Report(t.Context(), []byte(testMessage))
+		if err != nil {
+			t.Fatalf("failed to check message: %s", err)
+		}
+		if result.Spam {
+			t.Error("expected message not to be spam")
+		}
+		if result.Score != 1.2 {
+			t.Errorf("expected score to be 1.2, got %.1f", result.Score)
+		}
+	})
+	t.Run("spamd is unavailable", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %s", err)
+		}
+		addr := listener.Addr().String()
+		_ = listener.Close()
+		_, err = New("tcp", addr, time.Second).Report(t.Context(), []byte(testMessage))
+		if !errors.Is(err, ErrUnavailable) {
+			t.Errorf("expected error to be %s, got %s", ErrUnavailable, err)
+		}
+	})
+	t.Run("spamd read timeout", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %s", err)
+		}
+		t.Cleanup(func() { _ = listener.Close() })
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = io.Copy(io.Discard, conn)
+			time.Sleep(time.Millisecond * 200)
+			_ = conn.Close()
+		}()
+		_, err = New("tcp", listener.Addr().String(), time.Millisecond*50).Report(t.Context(), []byte(testMessage))
+		if !errors.Is(err, ErrUnavailable) {
+			t.Errorf("expected error to be %s, got %s", ErrUnavailable, err)
+		}
+	})
+	t.Run("spamd responses with errors", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			response string
+		}{
+			{"error status", "SPAMD/1.1 76 EX_PROTOCOL\r\n\r\n"},
+			{"invalid status line", "HTTP/1.1 200 OK\r\n\r\n"},
+			{"missing spam header", "SPAMD/1.1 0 EX_OK\r\nContent-length: 0\r\n\r\n"},
+			{"invalid score", testSpamdResponse("True ; abc / 5.0", "")},
+			{"invalid threshold", testSpamdResponse("True ; 1.0 / abc", "")},
+			{"empty response", ""},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				addr := testhelper.SpamdServer(t, "tcp", "127.0.0.1:0", func(string) string { return tt.response })
+				_, err := New("tcp", addr, time.Second).Report(t.Context(), []byte(testMessage))
+				if !errors.Is(err, ErrProtocol) {
+					t.Errorf("expected error to be %s, got %s", ErrProtocol, err)
+				}
+			})
+		}
+	})
+}
+
+// testSpamdResponse returns a successful spamd REPORT response
+func testSpamdResponse(spam, report string) string {
+	return fmt.Sprintf("SPAMD/1.1 0 EX_OK\r\nContent-length: %d\r\nSpam: %s\r\n\r\n%s", len(report), spam, report)
+}
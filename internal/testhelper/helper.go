@@ -5,10 +5,42 @@
 package testhelper
 
 import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
 	stdhttp "net/http"
+	"net/netip"
+	"net/textproto"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 )
 
 const (
@@ -29,3 +61,736 @@ type MockRoundTripper struct {
 func (m MockRoundTripper) RoundTrip(req *stdhttp.Request) (*stdhttp.Response, error) {
 	return m.Fn(req)
 }
+
+// DNSServFail is a DNSServer record value that makes the server answer with SERVFAIL
+const DNSServFail = "SERVFAIL"
+
+// DNSServer starts a local UDP DNS stand-in and returns its address. A queries for names in the
+// records map (fully qualified, e.g. "2.0.0.127.zen.example.") are answered with the IPv4 address
+// of the record, MX queries for records of the form "MX <host>" with the mail host. All other
+// names are answered with NXDOMAIN.
+func DNSServer(t *testing.T, records map[string]string) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if response := dnsResponse(buf[:n], records); response != nil {
+				_, _ = conn.WriteTo(response, addr)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+// dnsResponse builds the response for a single question DNS query
+func dnsResponse(query []byte, records map[string]string) []byte {
+	const headerLen = 12
+	if len(query) < headerLen {
+		return nil
+	}
+	offset := headerLen
+	labels := make([]string, 0)
+	for offset < len(query) && query[offset] != 0 {
+		length := int(query[offset])
+		if offset+1+length > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[offset+1:offset+1+length]))
+		offset += 1 + length
+	}
+	offset += 5 // terminating zero label, QTYPE and QCLASS
+	if offset > len(query) {
+		return nil
+	}
+	name := strings.ToLower(strings.Join(labels, ".")) + "."
+	qtype := binary.BigEndian.Uint16(query[offset-4 : offset-2])
+
+	var rcode uint16
+	var answer []byte
+	record, ok := records[name]
+	switch {
+	case !ok:
+		rcode = 3
+	case record == DNSServFail:
+		rcode = 2
+	case qtype == 1 && net.ParseIP(record).To4() != nil:
+		answer = append([]byte{0, 1, 0, 1, 0, 0, 0, 60, 0, 4}, net.ParseIP(record).To4()...)
+	case qtype == 15 && strings.HasPrefix(record, "MX "):
+		host := []byte{0, 10}
+		for _, label := range strings.Split(strings.Trim(strings.TrimPrefix(record, "MX "), "."), ".") {
+			if label != "" {
+				host = append(append(host, byte(len(label))), label...)
+			}
+		}
+		host = append(host, 0)
+		answer = append([]byte{0, 15, 0, 1, 0, 0, 0, 60, 0, byte(len(host))}, host...)
+	}
+
+	response := make([]byte, headerLen, offset+64)
+	copy(response, query[:2])
+	binary.BigEndian.PutUint16(response[2:], 0x8180|rcode)
+	binary.BigEndian.PutUint16(response[4:], 1)
+	if answer != nil {
+		binary.BigEndian.PutUint16(response[6:], 1)
+	}
+	response = append(response, query[headerLen:offset]...)
+	if answer != nil {
+		response = append(response, 0xc0, headerLen)
+		response = append(response, answer...)
+	}
+	return response
+}
+
+// MMDBRecord is a record of a MaxMind test database
+type MMDBRecord struct {
+	Country      string
+	ASN          uint32
+	Organization string
+}
+
+// WriteMMDB writes an IPv4 MaxMind database with the records for the given (non-overlapping)
+// networks to path.
+func WriteMMDB(t *testing.T, path, databaseType string, records map[string]MMDBRecord) {
+	t.Helper()
+	type node struct {
+		next [2]int
+		data [2]int
+	}
+	nodes := []*node{{data: [2]int{-1, -1}}}
+	var data []byte
+	for network, record := range records {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil || !prefix.Addr().Is4() || prefix.Bits() == 0 {
+			t.Fatalf("invalid IPv4 network %q: %s", network, err)
+		}
+		offset := len(data)
+		data = append(data, mmdbRecord(record)...)
+
+		current, addr := nodes[0], prefix.Addr().As4()
+		for i := 0; i < prefix.Bits(); i++ {
+			bit := (addr[i/8] >> (7 - i%8)) & 1
+			if i == prefix.Bits()-1 {
+				current.data[bit] = offset
+				break
+			}
+			if current.next[bit] == 0 {
+				nodes = append(nodes, &node{data: [2]int{-1, -1}})
+				current.next[bit] = len(nodes) - 1
+			}
+			current = nodes[current.next[bit]]
+		}
+	}
+
+	const separatorSize = 16
+	buf := make([]byte, 0, len(nodes)*6+separatorSize+len(data)+256)
+	for _, current := range nodes {
+		for bit := range 2 {
+			value := len(nodes)
+			switch {
+			case current.next[bit] != 0:
+				value = current.next[bit]
+			case current.data[bit] >= 0:
+				value = len(nodes) + separatorSize + current.data[bit]
+			}
+			buf = append(buf, byte(value>>16), byte(value>>8), byte(value))
+		}
+	}
+	buf = append(buf, make([]byte, separatorSize)...)
+	buf = append(buf, data...)
+	buf = append(buf, "\xab\xcd\xefMaxMind.com"...)
+	buf = append(buf, mmdbMap(
+		"binary_format_major_version", mmdbUint(5, 2),
+		"binary_format_minor_version", mmdbUint(5, 0),
+		"build_epoch", mmdbUint(9, uint64(time.Now().Unix())), // #nosec G115 -- positive timestamp
+		"database_type", mmdbString(databaseType),
+		"description", mmdbMap("en", mmdbString("js-mailer test database")),
+		"ip_version", mmdbUint(5, 4),
+		"languages", mmdbControl(11, 0),
+		"node_count", mmdbUint(6, uint64(len(nodes))),
+		"record_size", mmdbUint(5, 24),
+	)...)
+
+	if err := os.WriteFile(path, buf, 0o600); err != nil {
+		t.Fatalf("failed to write MaxMind database: %s", err)
+	}
+}
+
+// mmdbRecord encodes the record in the format of the GeoLite2 Country and ASN databases
+func mmdbRecord(record MMDBRecord) []byte {
+	var pairs []any
+	if record.Country != "" {
+		pairs = append(pairs, "country", mmdbMap("iso_code", mmdbString(record.Country)))
+	}
+	if record.ASN != 0 {
+		pairs = append(pairs, "autonomous_system_number", mmdbUint(6, uint64(record.ASN)))
+	}
+	if record.Organization != "" {
+		pairs = append(pairs, "autonomous_system_organization", mmdbString(record.Organization))
+	}
+	return mmdbMap(pairs...)
+}
+
+// mmdbControl returns the control byte(s) for a MaxMind DB data field of the given type and size
+func mmdbControl(typ, size int) []byte {
+	var sizeExt []byte
+	if size >= 29 {
+		sizeExt, size = []byte{byte(size - 29)}, 29
+	}
+	control := []byte{byte(typ<<5 | size)}
+	if typ > 7 {
+		control = []byte{byte(size), byte(typ - 7)}
+	}
+	return append(control, sizeExt...)
+}
+
+// mmdbString encodes a UTF-8 string field
+func mmdbString(value string) []byte {
+	return append(mmdbControl(2, len(value)), value...)
+}
+
+// mmdbUint encodes an unsigned integer field of the given type (5=uint16, 6=uint32, 9=uint64)
+func mmdbUint(typ int, value uint64) []byte {
+	var encoded []byte
+	for ; value > 0; value >>= 8 {
+		encoded = append([]byte{byte(value)}, encoded...)
+	}
+	return append(mmdbControl(typ, len(encoded)), encoded...)
+}
+
+// mmdbMap encodes a map field from alternating keys and encoded values
+func mmdbMap(pairs ...any) []byte {
+	encoded := mmdbControl(7, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		encoded = append(encoded, mmdbString(pairs[i].(string))...)
+		encoded = append(encoded, pairs[i+1].([]byte)...)
+	}
+	return encoded
+}
+
+// VerifyDKIM verifies the DKIM-Signature header of the raw message with the public key. Only the
+// relaxed/relaxed canonicalization and the rsa-sha256 and ed25519-sha256 algorithms are supported.
+func VerifyDKIM(raw []byte, publicKey crypto.PublicKey) error {
+	raw = bytes.ReplaceAll(bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+	rawHeaders, body, found := bytes.Cut(raw, []byte("\r\n\r\n"))
+	if !found {
+		return errors.New("message has no body")
+	}
+
+	var headers []string
+	for _, line := range strings.Split(string(rawHeaders), "\r\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(headers) > 0 {
+			headers[len(headers)-1] += "\r\n" + line
+			continue
+		}
+		headers = append(headers, line)
+	}
+	signatureIndex := -1
+	for i, header := range headers {
+		if name, _, _ := strings.Cut(header, ":"); strings.EqualFold(name, "DKIM-Signature") {
+			signatureIndex = i
+			break
+		}
+	}
+	if signatureIndex < 0 {
+		return errors.New("message has no DKIM-Signature header")
+	}
+	_, signatureValue, _ := strings.Cut(headers[signatureIndex], ":")
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(signatureValue, ";") {
+		key, value, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(key)] = strings.Join(strings.Fields(value), "")
+	}
+	if tags["c"] != "relaxed/relaxed" {
+		return fmt.Errorf("unsupported canonicalization %q", tags["c"])
+	}
+
+	bodyHash := sha256.Sum256(dkimRelaxedBody(body))
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+		return errors.New("body hash does not match")
+	}
+
+	data := bytes.NewBuffer(nil)
+	used := make(map[int]bool)
+	for _, name := range strings.Split(tags["h"], ":") {
+		for i := len(headers) - 1; i >= 0; i-- {
+			headerName, _, _ := strings.Cut(headers[i], ":")
+			if i == signatureIndex || used[i] || !strings.EqualFold(strings.TrimSpace(headerName), name) {
+				continue
+			}
+			used[i] = true
+			data.WriteString(dkimRelaxedHeader(headers[i]) + "\r\n")
+			break
+		}
+	}
+	signatureHeader := regexp.MustCompile(`(b\s*=)[^;]*$`).ReplaceAllString(headers[signatureIndex], "$1")
+	data.WriteString(dkimRelaxedHeader(signatureHeader))
+	hash := sha256.Sum256(data.Bytes())
+
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %w", err)
+	}
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if tags["a"] != "rsa-sha256" {
+			return fmt.Errorf("unexpected algorithm %q for RSA key", tags["a"])
+		}
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+	case ed25519.PublicKey:
+		if tags["a"] != "ed25519-sha256" {
+			return fmt.Errorf("unexpected algorithm %q for Ed25519 key", tags["a"])
+		}
+		if !ed25519.Verify(key, hash[:], signature) {
+			return errors.New("ed25519 signature verification failed")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+}
+
+// dkimRelaxedHeader returns the header in relaxed canonicalization (RFC 6376, section 3.4.2)
+func dkimRelaxedHeader(header string) string {
+	name, value, _ := strings.Cut(header, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Join(strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == '\t' }), " ")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value
+}
+
+// dkimRelaxedBody returns the body in relaxed canonicalization (RFC 6376, section 3.4.4)
+func dkimRelaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		line = strings.Join(strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == '\t' }), " ")
+		if line != "" && (strings.HasPrefix(lines[i], " ") || strings.HasPrefix(lines[i], "\t")) {
+			line = " " + line
+		}
+		lines[i] = line
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// WriteCertificate writes a self-signed RSA certificate for the email address and its private key
+// (PKCS#8) as PEM files to the directory and returns their paths.
+func WriteCertificate(t *testing.T, dir, email string) (string, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %s", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("failed to generate serial number: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        pkix.Name{CommonName: email},
+		EmailAddresses: []string{email},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour * 24),
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal private key: %s", err)
+	}
+
+	certFile := filepath.Join(dir, email+".crt")
+	keyFile := filepath.Join(dir, email+".key")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %s", err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("failed to write private key: %s", err)
+	}
+	return certFile, keyFile
+}
+
+// WritePGPKey writes an Ed25519 OpenPGP key for the email address as ASCII armored public and
+// private key files to the directory and returns their paths. If passphrase is not empty, the
+// private key is protected with it.
+func WritePGPKey(t *testing.T, dir, email, passphrase string) (string, string) {
+	t.Helper()
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	entity, err := openpgp.NewEntity(email, "", email, config)
+	if err != nil {
+		t.Fatalf("failed to generate OpenPGP key: %s", err)
+	}
+	public := bytes.NewBuffer(nil)
+	writer, err := armor.Encode(public, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("failed to create armor encoder: %s", err)
+	}
+	if err = entity.Serialize(writer); err != nil {
+		t.Fatalf("failed to serialize public key: %s", err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("failed to finish armor encoding: %s", err)
+	}
+
+	if passphrase != "" {
+		if err = entity.EncryptPrivateKeys([]byte(passphrase), config); err != nil {
+			t.Fatalf("failed to encrypt private key: %s", err)
+		}
+	}
+	private := bytes.NewBuffer(nil)
+	if writer, err = armor.Encode(private, openpgp.PrivateKeyType, nil); err != nil {
+		t.Fatalf("failed to create armor encoder: %s", err)
+	}
+	if err = entity.SerializePrivateWithoutSigning(writer, config); err != nil {
+		t.Fatalf("failed to serialize private key: %s", err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("failed to finish armor encoding: %s", err)
+	}
+
+	publicFile := filepath.Join(dir, email+".pub.asc")
+	privateFile := filepath.Join(dir, email+".sec.asc")
+	if err = os.WriteFile(publicFile, public.Bytes(), 0o600); err != nil {
+		t.Fatalf("failed to write public key: %s", err)
+	}
+	if err = os.WriteFile(privateFile, private.Bytes(), 0o600); err != nil {
+		t.Fatalf("failed to write private key: %s", err)
+	}
+	return publicFile, privateFile
+}
+
+// SMTPMessage is a mail that was received by an SMTPServer
+type SMTPMessage struct {
+	// Helo is the name the client greeted with, Auth the SASL mechanism it authenticated with
+	Helo       string
+	Auth       string
+	From       string
+	Recipients []string
+	Data       string
+}
+
+// SMTPServerOptions configures an SMTPServer
+type SMTPServerOptions struct {
+	// Reject makes the server reject every mail with a temporary failure
+	Reject bool
+
+	// TLSConfig makes the server expect implicit TLS (SMTPS) with the config
+	TLSConfig *tls.Config
+
+	// RejectRecipients are recipient addresses that the server rejects permanently
+	RejectRecipients []string
+
+	// DataDelay delays the reply to the mail data
+	DataDelay time.Duration
+}
+
+// SMTPServer is a local SMTP stand-in that stores the mails it receives
+type SMTPServer struct {
+	// Host and Port are the address the server listens on
+	Host string
+	Port int
+
+	listener    net.Listener
+	mu          sync.Mutex
+	connections int
+	messages    []SMTPMessage
+	opts        SMTPServerOptions
+}
+
+// NewSMTPServer starts a local SMTP stand-in. It advertises PLAIN, LOGIN and CRAM-MD5
+// authentication and accepts any credentials.
+func NewSMTPServer(t *testing.T, opts SMTPServerOptions) *SMTPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	if opts.TLSConfig != nil {
+		listener = tls.NewListener(listener, opts.TLSConfig)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	addr := listener.Addr().(*net.TCPAddr)
+	server := &SMTPServer{Host: addr.IP.String(), Port: addr.Port, listener: listener, opts: opts}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mu.Lock()
+			server.connections++
+			server.mu.Unlock()
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+// Messages returns the mails that the server received
+func (s *SMTPServer) Messages() []SMTPMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.messages)
+}
+
+// Connections returns the number of connections that the server accepted
+func (s *SMTPServer) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections
+}
+
+// serve handles a single SMTP session
+func (s *SMTPServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	text := textproto.NewConn(conn)
+	_ = text.PrintfLine("220 localhost ESMTP stand-in")
+	var helo, auth string
+	var message SMTPMessage
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command, argument, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(command) {
+		case "EHLO", "HELO":
+			helo = argument
+			_ = text.PrintfLine("250-localhost\r\n250-AUTH PLAIN LOGIN CRAM-MD5\r\n250 8BITMIME")
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(argument, " ")
+			if !smtpAuthenticate(text, strings.ToUpper(mechanism), initial) {
+				return
+			}
+			auth = strings.ToUpper(mechanism)
+			_ = text.PrintfLine("235 2.7.0 authentication successful")
+		case "MAIL":
+			if s.opts.Reject {
+				_ = text.PrintfLine("451 4.3.0 temporary failure")
+				continue
+			}
+			message = SMTPMessage{Helo: helo, Auth: auth, From: smtpPath(argument)}
+			_ = text.PrintfLine("250 2.1.0 ok")
+		case "RCPT":
+			if slices.Contains(s.opts.RejectRecipients, smtpPath(argument)) {
+				_ = text.PrintfLine("550 5.1.1 mailbox unavailable")
+				continue
+			}
+			message.Recipients = append(message.Recipients, smtpPath(argument))
+			_ = text.PrintfLine("250 2.1.5 ok")
+		case "DATA":
+			_ = text.PrintfLine("354 go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			message.Data = string(data)
+			time.Sleep(s.opts.DataDelay)
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			queued := len(s.messages)
+			s.mu.Unlock()
+			_ = text.PrintfLine("250 2.0.0 ok: queued as %d", queued)
+		case "RSET", "NOOP":
+			message = SMTPMessage{}
+			_ = text.PrintfLine("250 2.0.0 ok")
+		case "QUIT":
+			_ = text.PrintfLine("221 2.0.0 bye")
+			return
+		default:
+			_ = text.PrintfLine("502 5.5.2 command not implemented")
+		}
+	}
+}
+
+// smtpAuthenticate runs the challenges of the SASL mechanism and accepts any response. It returns
+// false if the client went away.
+func smtpAuthenticate(text *textproto.Conn, mechanism, initial string) bool {
+	var challenges []string
+	switch mechanism {
+	case "PLAIN":
+		if initial == "" {
+			challenges = []string{""}
+		}
+	case "LOGIN":
+		challenges = []string{"Username:", "Password:"}
+		if initial != "" {
+			challenges = challenges[1:]
+		}
+	case "CRAM-MD5":
+		challenges = []string{"<1.1@localhost>"}
+	}
+	for _, challenge := range challenges {
+		_ = text.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(challenge)))
+		if _, err := text.ReadLine(); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// smtpPath returns the address of a MAIL FROM or RCPT TO argument
+func smtpPath(argument string) string {
+	_, path, _ := strings.Cut(argument, ":")
+	path, _, _ = strings.Cut(strings.TrimSpace(path), " ")
+	return strings.Trim(path, "<>")
+}
+
+// WriteTLSCertificate writes a self-signed ECDSA certificate for the host name or IP address and
+// its private key (PKCS#8) as PEM files to the directory and returns their paths. The certificate
+// can be used as server or client certificate and as its own CA bundle.
+func WriteTLSCertificate(t *testing.T, dir, host string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ECDSA key: %s", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("failed to generate serial number: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal private key: %s", err)
+	}
+
+	certFile := filepath.Join(dir, host+".tls.crt")
+	keyFile := filepath.Join(dir, host+".tls.key")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %s", err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("failed to write private key: %s", err)
+	}
+	return certFile, keyFile
+}
+
+// SpamdServer starts a spamd stand-in on the network ("tcp" or "unix") and address that answers
+// every REPORT request with the response of the handler for the received message. It returns the
+// address the stand-in listens on.
+func SpamdServer(t *testing.T, network, address string, handler func(message string) string) string {
+	t.Helper()
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer func() { _ = conn.Close() }()
+				reader := textproto.NewReader(bufio.NewReader(conn))
+				if _, err := reader.ReadLine(); err != nil {
+					return
+				}
+				header, err := reader.ReadMIMEHeader()
+				if err != nil {
+					return
+				}
+				length, err := strconv.Atoi(header.Get("Content-length"))
+				if err != nil {
+					return
+				}
+				message := make([]byte, length)
+				if _, err = io.ReadFull(reader.R, message); err != nil {
+					return
+				}
+				_, _ = io.WriteString(conn, handler(string(message)))
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// EICAR is the EICAR anti-virus test string
+const EICAR = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// ClamdServer starts a clamd stand-in on the network ("tcp" or "unix") and address that answers
+// every INSTREAM command with the response of the handler for the streamed content. Other
+// commands are answered with "UNKNOWN COMMAND". It returns the address the stand-in listens on.
+func ClamdServer(t *testing.T, network, address string, handler func(content []byte) string) string {
+	t.Helper()
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer func() { _ = conn.Close() }()
+				reader := bufio.NewReader(conn)
+				if command, err := reader.ReadString(0); err != nil || command != "zINSTREAM\x00" {
+					_, _ = io.WriteString(conn, "UNKNOWN COMMAND\x00")
+					return
+				}
+				content := bytes.NewBuffer(nil)
+				size := make([]byte, 4)
+				for {
+					if _, err := io.ReadFull(reader, size); err != nil {
+						return
+					}
+					length := binary.BigEndian.Uint32(size)
+					if length == 0 {
+						break
+					}
+					if _, err := io.CopyN(content, reader, int64(length)); err != nil {
+						return
+					}
+				}
+				_, _ = io.WriteString(conn, handler(content.Bytes())+"\x00")
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
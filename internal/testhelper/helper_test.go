@@ -6,13 +6,33 @@ package testhelper
 
 import (
 	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	stdhttp "net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/wneessen/go-mail"
+
+	"github.com/wneessen/js-mailer/internal/clamav"
 	"github.com/wneessen/js-mailer/internal/httpclient"
 	"github.com/wneessen/js-mailer/internal/logger"
+	"github.com/wneessen/js-mailer/internal/spamd"
 )
 
 func TestPerformIntegrationTests(t *testing.T) {
@@ -47,3 +67,330 @@ func TestMockRoundTripper(t *testing.T) {
 		}
 	})
 }
+
+func TestDNSServer(t *testing.T) {
+	addr := DNSServer(t, map[string]string{
+		"listed.example.":  "127.0.0.2",
+		"failing.example.": DNSServFail,
+		"mail.example.":    "MX mx.mail.example.",
+		"nullmx.example.":  "MX .",
+	})
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			dialer := net.Dialer{}
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+	t.Run("known record is resolved", func(t *testing.T) {
+		ips, err := resolver.LookupIP(t.Context(), "ip4", "listed.example.")
+		if err != nil {
+			t.Fatalf("failed to resolve record: %s", err)
+		}
+		if len(ips) != 1 || !ips[0].Equal(net.ParseIP("127.0.0.2")) {
+			t.Errorf("expected record to resolve to 127.0.0.2, got: %v", ips)
+		}
+	})
+	t.Run("MX record is resolved", func(t *testing.T) {
+		records, err := resolver.LookupMX(t.Context(), "mail.example.")
+		if err != nil {
+			t.Fatalf("failed to resolve MX record: %s", err)
+		}
+		if len(records) != 1 || records[0].Host != "mx.mail.example." {
+			t.Errorf("expected MX record mx.mail.example., got: %v", records)
+		}
+	})
+	t.Run("null MX record is resolved", func(t *testing.T) {
+		records, err := resolver.LookupMX(t.Context(), "nullmx.example.")
+		if err != nil {
+			t.Fatalf("failed to resolve MX record: %s", err)
+		}
+		if len(records) != 1 || records[0].Host != "." {
+			t.Errorf("expected null MX record, got: %v", records)
+		}
+	})
+	t.Run("unknown record is not found", func(t *testing.T) {
+		_, err := resolver.LookupIP(t.Context(), "ip4", "unknown.example.")
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			t.Errorf("expected not found error, got: %s", err)
+		}
+	})
+	t.Run("failing record returns an error", func(t *testing.T) {
+		_, err := resolver.LookupIP(t.Context(), "ip4", "failing.example.")
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || dnsErr.IsNotFound {
+			t.Errorf("expected server failure, got: %s", err)
+		}
+	})
+}
+
+func TestWriteMMDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	WriteMMDB(t, path, "GeoLite2-Country", map[string]MMDBRecord{
+		"192.0.2.0/24": {Country: "DE", ASN: 64500, Organization: "Example Hosting"},
+	})
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	t.Cleanup(func() { _ = reader.Close() })
+	if reader.Metadata.DatabaseType != "GeoLite2-Country" {
+		t.Errorf("expected database type GeoLite2-Country, got %s", reader.Metadata.DatabaseType)
+	}
+	if err = reader.Verify(); err != nil {
+		t.Errorf("failed to verify database: %s", err)
+	}
+
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		ASN          uint   `maxminddb:"autonomous_system_number"`
+		Organization string `maxminddb:"autonomous_system_organization"`
+	}
+	if err = reader.Lookup(net.ParseIP("192.0.2.1"), &record); err != nil {
+		t.Fatalf("lookup failed: %s", err)
+	}
+	if record.Country.ISOCode != "DE" || record.ASN != 64500 || record.Organization != "Example Hosting" {
+		t.Errorf("unexpected record: %+v", record)
+	}
+}
+
+func TestVerifyDKIM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %s", err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %s", err)
+	}
+	tests := []struct {
+		name      string
+		signer    crypto.Signer
+		publicKey crypto.PublicKey
+	}{
+		{"RSA signature", rsaKey, &rsaKey.PublicKey},
+		{"Ed25519 signature", edKey, edPublic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := mail.NewMsg()
+			if err := message.From("sender@example.com"); err != nil {
+				t.Fatalf("failed to set sender: %s", err)
+			}
+			if err := message.To("rcpt@example.com"); err != nil {
+				t.Fatalf("failed to set recipient: %s", err)
+			}
+			message.Subject("A   subject with    whitespace")
+			message.SetBodyString(mail.TypeTextPlain, "line  one \nline two\n\n\n")
+			message.SetDKIM(mail.NewDKIMSigner("example.com", "test", tt.signer))
+			buf := bytes.NewBuffer(nil)
+			if _, err := message.WriteTo(buf); err != nil {
+				t.Fatalf("failed to write message: %s", err)
+			}
+			if err := VerifyDKIM(buf.Bytes(), tt.publicKey); err != nil {
+				t.Errorf("failed to verify DKIM signature: %s", err)
+			}
+			tampered := bytes.Replace(buf.Bytes(), []byte("line two"), []byte("line 2"), 1)
+			if err := VerifyDKIM(tampered, tt.publicKey); err == nil {
+				t.Error("expected verification of a tampered message to fail")
+			}
+		})
+	}
+	t.Run("unsigned message fails", func(t *testing.T) {
+		if err := VerifyDKIM([]byte("From: sender@example.com\r\n\r\nbody\r\n"), &rsaKey.PublicKey); err == nil {
+			t.Error("expected verification of an unsigned message to fail")
+		}
+	})
+}
+
+func TestWriteCertificate(t *testing.T) {
+	certFile, keyFile := WriteCertificate(t, t.TempDir(), "rcpt@example.com")
+	keyPair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("failed to load certificate: %s", err)
+	}
+	if len(keyPair.Leaf.EmailAddresses) != 1 || keyPair.Leaf.EmailAddresses[0] != "rcpt@example.com" {
+		t.Errorf("unexpected certificate email addresses: %v", keyPair.Leaf.EmailAddresses)
+	}
+}
+
+func TestWritePGPKey(t *testing.T) {
+	publicFile, privateFile := WritePGPKey(t, t.TempDir(), "rcpt@example.com", "secret")
+	for _, path := range []string{publicFile, privateFile} {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("failed to open key file: %s", err)
+		}
+		entities, err := openpgp.ReadArmoredKeyRing(file)
+		_ = file.Close()
+		if err != nil {
+			t.Fatalf("failed to read key file: %s", err)
+		}
+		if len(entities) != 1 {
+			t.Fatalf("expected 1 key, got %d", len(entities))
+		}
+		if _, ok := entities[0].Identities["rcpt@example.com <rcpt@example.com>"]; !ok {
+			t.Errorf("expected identity for rcpt@example.com, got %v", entities[0].Identities)
+		}
+		if path == privateFile {
+			if entities[0].PrivateKey == nil || !entities[0].PrivateKey.Encrypted {
+				t.Error("expected an encrypted private key")
+			}
+			if err = entities[0].DecryptPrivateKeys([]byte("secret")); err != nil {
+				t.Errorf("failed to decrypt private key: %s", err)
+			}
+		}
+	}
+}
+
+func TestNewSMTPServer(t *testing.T) {
+	send := func(t *testing.T, server *SMTPServer, opts ...mail.Option) error {
+		t.Helper()
+		message := mail.NewMsg()
+		if err := message.From("no-reply@example.com"); err != nil {
+			t.Fatalf("failed to set sender address: %s", err)
+		}
+		if err := message.To("test@example.com"); err != nil {
+			t.Fatalf("failed to set recipient address: %s", err)
+		}
+		message.Subject("Test mail")
+		message.SetBodyString(mail.TypeTextPlain, "This is a test mail")
+		opts = append([]mail.Option{
+			mail.WithPort(server.Port), mail.WithTLSPolicy(mail.NoTLS), mail.WithHELO("client.example.com"),
+			mail.WithSMTPAuth(mail.SMTPAuthAutoDiscover), mail.WithUsername("user"), mail.WithPassword("pass"),
+		}, opts...)
+		client, err := mail.NewClient(server.Host, opts...)
+		if err != nil {
+			t.Fatalf("failed to create mail client: %s", err)
+		}
+		return client.DialAndSend(message)
+	}
+	t.Run("mails are received", func(t *testing.T) {
+		server := NewSMTPServer(t, SMTPServerOptions{})
+		if err := send(t, server); err != nil {
+			t.Fatalf("failed to send mail: %s", err)
+		}
+		messages := server.Messages()
+		if len(messages) != 1 {
+			t.Fatalf("expected 1 message, got %d", len(messages))
+		}
+		if messages[0].Helo != "client.example.com" || messages[0].Auth != "CRAM-MD5" {
+			t.Errorf("expected HELO client.example.com and CRAM-MD5 auth, got %s and %s", messages[0].Helo,
+				messages[0].Auth)
+		}
+		if messages[0].From != "no-reply@example.com" {
+			t.Errorf("expected sender to be no-reply@example.com, got %s", messages[0].From)
+		}
+		if len(messages[0].Recipients) != 1 || messages[0].Recipients[0] != "test@example.com" {
+			t.Errorf("expected recipient to be test@example.com, got %v", messages[0].Recipients)
+		}
+		if !strings.Contains(messages[0].Data, "This is a test mail") {
+			t.Errorf("expected message body to be received, got: %s", messages[0].Data)
+		}
+	})
+	t.Run("mails are received via implicit TLS", func(t *testing.T) {
+		certFile, keyFile := WriteTLSCertificate(t, t.TempDir(), "127.0.0.1")
+		keyPair, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			t.Fatalf("failed to load certificate: %s", err)
+		}
+		server := NewSMTPServer(t, SMTPServerOptions{TLSConfig: &tls.Config{Certificates: []tls.Certificate{keyPair}}})
+		pool := x509.NewCertPool()
+		pool.AddCert(keyPair.Leaf)
+		tlsConfig := &tls.Config{ServerName: server.Host, RootCAs: pool, MinVersion: tls.VersionTLS12}
+		for _, mechanism := range []mail.SMTPAuthType{mail.SMTPAuthPlain, mail.SMTPAuthLogin} {
+			if err = send(t, server, mail.WithSSL(), mail.WithTLSConfig(tlsConfig), mail.WithSMTPAuth(mechanism)); err != nil {
+				t.Fatalf("failed to send mail: %s", err)
+			}
+		}
+		messages := server.Messages()
+		if len(messages) != 2 || messages[0].Auth != "PLAIN" || messages[1].Auth != "LOGIN" {
+			t.Errorf("expected 2 messages with PLAIN and LOGIN auth, got %+v", messages)
+		}
+	})
+	t.Run("mails are rejected", func(t *testing.T) {
+		server := NewSMTPServer(t, SMTPServerOptions{Reject: true})
+		if err := send(t, server); err == nil {
+			t.Error("expected sending to fail")
+		}
+		if len(server.Messages()) != 0 {
+			t.Errorf("expected no messages, got %d", len(server.Messages()))
+		}
+	})
+	t.Run("recipients are rejected", func(t *testing.T) {
+		server := NewSMTPServer(t, SMTPServerOptions{RejectRecipients: []string{"test@example.com"}})
+		if err := send(t, server); err == nil {
+			t.Error("expected sending to fail")
+		}
+		if len(server.Messages()) != 0 {
+			t.Errorf("expected no messages, got %d", len(server.Messages()))
+		}
+		if server.Connections() != 1 {
+			t.Errorf("expected 1 connection, got %d", server.Connections())
+		}
+	})
+	t.Run("mail data reply is delayed", func(t *testing.T) {
+		server := NewSMTPServer(t, SMTPServerOptions{DataDelay: time.Millisecond * 200})
+		start := time.Now()
+		if err := send(t, server); err != nil {
+			t.Fatalf("failed to send mail: %s", err)
+		}
+		if elapsed := time.Since(start); elapsed < time.Millisecond*200 {
+			t.Errorf("expected reply to be delayed, got reply after %s", elapsed)
+		}
+	})
+}
+
+func TestWriteTLSCertificate(t *testing.T) {
+	for _, host := range []string{"127.0.0.1", "relay.example.com"} {
+		certFile, keyFile := WriteTLSCertificate(t, t.TempDir(), host)
+		keyPair, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			t.Fatalf("failed to load certificate: %s", err)
+		}
+		if err = keyPair.Leaf.VerifyHostname(host); err != nil {
+			t.Errorf("expected certificate to be valid for %s: %s", host, err)
+		}
+	}
+}
+
+func TestSpamdServer(t *testing.T) {
+	addr := SpamdServer(t, "tcp", "127.0.0.1:0", func(message string) string {
+		report := "received " + message
+		return fmt.Sprintf("SPAMD/1.1 0 EX_OK\r\nContent-length: %d\r\nSpam: True ; 7.5 / 5.0\r\n\r\n%s",
+			len(report), report)
+	})
+	result, err := spamd.New("tcp", addr, time.Second).Report(t.Context(), []byte("test message"))
+	if err != nil {
+		t.Fatalf("failed to check message: %s", err)
+	}
+	if !result.Spam || result.Score != 7.5 {
+		t.Errorf("expected spam with a score of 7.5, got %t with %.1f", result.Spam, result.Score)
+	}
+	if result.Report != "received test message" {
+		t.Errorf("expected handler to receive the message, got report: %q", result.Report)
+	}
+}
+
+func TestClamdServer(t *testing.T) {
+	addr := ClamdServer(t, "tcp", "127.0.0.1:0", func(content []byte) string {
+		if bytes.Contains(content, []byte(EICAR)) {
+			return "stream: Eicar-Test-Signature FOUND"
+		}
+		return "stream: OK"
+	})
+	client := clamav.New("tcp", addr, time.Second)
+	result, err := client.Scan(t.Context(), strings.NewReader("prefix "+EICAR))
+	if err != nil {
+		t.Fatalf("failed to scan content: %s", err)
+	}
+	if !result.Infected || result.Signature != "Eicar-Test-Signature" {
+		t.Errorf("expected content to be infected with Eicar-Test-Signature, got %+v", result)
+	}
+	if result, err = client.Scan(t.Context(), strings.NewReader("clean content")); err != nil || result.Infected {
+		t.Errorf("expected clean content not to be infected, got %+v, %v", result, err)
+	}
+}